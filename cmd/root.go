@@ -1,56 +1,186 @@
 package cmd
 
 import (
-	"github.com/bilalcaliskan/nginx-conf-generator/internal/k8s/informers"
-	"github.com/bilalcaliskan/nginx-conf-generator/internal/k8s/types"
 	"github.com/bilalcaliskan/nginx-conf-generator/internal/logging"
-	"github.com/bilalcaliskan/nginx-conf-generator/internal/metrics"
-	"github.com/bilalcaliskan/nginx-conf-generator/internal/options"
 	"github.com/bilalcaliskan/nginx-conf-generator/internal/version"
+	"github.com/bilalcaliskan/nginx-conf-generator/pkg/generator"
 	"github.com/dimiro1/banner"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 
+	"context"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 )
 
 var (
-	logger    *zap.Logger
-	clusters  []*types.Cluster
-	nginxConf = types.NewNginxConf(clusters)
-	opts      *options.NginxConfGeneratorOptions
-	ver       = version.Get()
+	opts = &generator.Config{}
+	ver  = version.Get()
 )
 
 func init() {
-	opts = options.GetNginxConfGeneratorOptions()
-
 	rootCmd.Flags().StringVarP(&opts.KubeConfigPaths, "kubeconfig-paths", "", filepath.Join(os.Getenv("HOME"), ".kube", "config"),
 		"comma separated list of kubeconfig file paths to access with the cluster")
+	rootCmd.Flags().BoolVarP(&opts.InCluster, "in-cluster", "", false,
+		"access the cluster with the service account of the pod, overrides --kubeconfig-paths (default false)")
+	rootCmd.Flags().StringVarP(&opts.ClusterConfigFile, "cluster-config-file", "", "",
+		"path of the yaml file which contains per cluster settings, overrides --kubeconfig-paths when set")
+	rootCmd.Flags().StringVarP(&opts.NodeAddressTypes, "node-address-types", "", "InternalIP,ExternalIP,Hostname",
+		"comma separated, preferred order of node address types which upstream servers are built from")
+	rootCmd.Flags().StringVarP(&opts.IPFamily, "ip-family", "", "",
+		"preferred IP family of the upstream server addresses, either IPv4 or IPv6. No preference when empty")
 	rootCmd.Flags().StringVarP(&opts.WorkerNodeLabel, "worker-node-label", "", "worker",
-		"label to specify worker nodes")
+		"label to specify worker nodes, nodes are selected when its value is true")
+	rootCmd.Flags().StringVarP(&opts.WorkerNodeSelector, "worker-node-selector", "", "",
+		"label selector to specify worker nodes, e.g. 'node-role.kubernetes.io/worker,zone in (a,b),!excluded'. "+
+			"Defaults to --worker-node-label=true")
+	rootCmd.Flags().BoolVarP(&opts.ExcludeUnschedulableNodes, "exclude-unschedulable-nodes", "", true,
+		"take cordoned nodes out of the upstreams, so that nodes leave as soon as a drain starts")
+	rootCmd.Flags().StringVarP(&opts.ExcludeNodeTaints, "exclude-node-taints", "", "ToBeDeletedByClusterAutoscaler",
+		"comma separated list of taints in the form of key[=value][:effect], tainted nodes are taken out of the upstreams")
+	rootCmd.Flags().StringVarP(&opts.ExcludeNodeConditions, "exclude-node-conditions", "", "NetworkUnavailable",
+		"comma separated list of node condition types, nodes which have one of them with True status are taken out "+
+			"of the upstreams")
+	rootCmd.Flags().DurationVarP(&opts.NodeDrainGracePeriod, "node-drain-grace-period", "", 0,
+		"duration which removed nodes are rendered as down before they are removed from the upstreams, so that "+
+			"in-flight connections are not cut. Nodes are removed right away when it is 0")
+	rootCmd.Flags().StringVarP(&opts.IncludeNamespaces, "include-namespaces", "", "",
+		"comma separated list of namespaces which services are discovered from, they are watched through "+
+			"namespaced informers. All namespaces are allowed when it is empty")
+	rootCmd.Flags().StringVarP(&opts.ExcludeNamespaces, "exclude-namespaces", "", "",
+		"comma separated list of namespaces which services are never discovered from")
+	rootCmd.Flags().StringVarP(&opts.NamespaceSelector, "namespace-selector", "", "",
+		"label selector of the namespaces which services are discovered from, e.g. 'edge-exposure=allowed'")
 	rootCmd.Flags().StringVarP(&opts.CustomAnnotation, "custom-annotation", "", "nginx-conf-generator/enabled",
 		"annotation to specify selectable services")
+	rootCmd.Flags().StringVarP(&opts.ListenPortAnnotation, "listen-port-annotation", "",
+		"nginx-conf-generator/listen-port", "annotation to specify the port which Nginx listens on for a service, "+
+			"the NodePort of the service is used when it is not set")
+	rootCmd.Flags().StringVarP(&opts.ReservedListenPorts, "reserved-listen-ports", "", "22",
+		"comma separated list of ports which can not be claimed by services and Gateway listeners, --metrics-port "+
+			"is always reserved")
+	rootCmd.Flags().BoolVarP(&opts.EnableStatusAnnotations, "enable-status-annotations", "", false,
+		"patch the status annotations of services after the configuration is applied (default false)")
 	rootCmd.Flags().StringVarP(&opts.TemplateInputFile, "template-input-file", "", "resources/ncg.conf.tmpl",
 		"path of the template input file to be able to render and print to --template-output-file")
 	rootCmd.Flags().StringVarP(&opts.TemplateOutputFile, "template-output-file", "", "/etc/nginx/conf.d/ncg.conf",
 		"rendered output file path which is a valid Nginx conf file")
+	rootCmd.Flags().StringVarP(&opts.StreamTemplateOutputFile, "stream-template-output-file", "", "",
+		"rendered output file path of the stream template, which should be included in the stream context of Nginx. "+
+			"TCPRoutes are ignored when it is not set")
+	rootCmd.Flags().StringVarP(&opts.NginxBinary, "nginx-binary", "", "nginx",
+		"path of the nginx binary which validates the configuration with -t and reloads it with -s reload")
+	rootCmd.Flags().StringVarP(&opts.HistoryDir, "history-dir", "", "",
+		"directory which the reload history is persisted in, it is kept in memory only when empty")
+	rootCmd.Flags().IntVarP(&opts.HistorySize, "history-size", "", 50,
+		"number of the applies which are kept in the reload history")
+	rootCmd.Flags().StringVarP(&opts.AuditLogFile, "audit-log-file", "", "",
+		"file which each apply record is appended to as a JSON line, disabled when empty")
+	rootCmd.Flags().BoolVarP(&opts.EnableGatewayAPI, "enable-gateway-api", "", false,
+		"watch Gateway API resources and act as a Gateway implementation (default false)")
+	rootCmd.Flags().StringVarP(&opts.GatewayControllerName, "gateway-controller-name", "",
+		"github.com/bilalcaliskan/nginx-conf-generator", "controllerName of the GatewayClasses to manage")
+	rootCmd.Flags().BoolVarP(&opts.EnableLeaderElection, "enable-leader-election", "", false,
+		"elect a leader between replicas with a Lease, only the leader writes Events, statuses and annotations "+
+			"(default false)")
+	rootCmd.Flags().StringVarP(&opts.LeaderElectionCluster, "leader-election-cluster", "", "",
+		"name of the cluster which the Lease is created in, defaults to the first cluster")
+	rootCmd.Flags().StringVarP(&opts.LeaderElectionNamespace, "leader-election-namespace", "", "default",
+		"namespace of the Lease")
+	rootCmd.Flags().StringVarP(&opts.LeaderElectionLeaseName, "leader-election-lease-name", "",
+		"nginx-conf-generator", "name of the Lease")
+	rootCmd.Flags().StringVarP(&opts.LeaderElectionIdentity, "leader-election-identity", "", "",
+		"identity of the replica in leader election, defaults to the hostname")
+	rootCmd.Flags().DurationVarP(&opts.LeaderElectionLeaseDuration, "leader-election-lease-duration", "",
+		15*time.Second, "duration that non-leader replicas wait before taking over the leadership")
+	rootCmd.Flags().DurationVarP(&opts.LeaderElectionRenewDeadline, "leader-election-renew-deadline", "",
+		10*time.Second, "duration that the leader retries renewing the leadership before giving up")
+	rootCmd.Flags().DurationVarP(&opts.LeaderElectionRetryPeriod, "leader-election-retry-period", "",
+		2*time.Second, "duration between leader election attempts")
+	rootCmd.Flags().BoolVarP(&opts.LeaderOnlyRender, "leader-only-render", "", false,
+		"render the configuration and reload Nginx on the leader only, for replicas sharing the same filesystem "+
+			"(default false)")
+	rootCmd.Flags().DurationVarP(&opts.ClusterHealthCheckInterval, "cluster-health-check-interval", "", 10*time.Second,
+		"interval which the API servers of the synced clusters are probed in")
+	rootCmd.Flags().DurationVarP(&opts.DisconnectedClusterTimeout, "disconnected-cluster-timeout", "", 5*time.Minute,
+		"duration which an unreachable cluster stays degraded before it is marked as disconnected")
+	rootCmd.Flags().BoolVarP(&opts.DropDisconnectedUpstreams, "drop-disconnected-upstreams", "", false,
+		"remove the upstreams of disconnected clusters from the configuration until they are reachable again, "+
+			"their last known upstreams are kept otherwise (default false)")
+	rootCmd.Flags().DurationVarP(&opts.StuckRenderTimeout, "stuck-render-timeout", "", 2*time.Minute,
+		"duration which a render and reload of the configuration may take before /healthz fails")
+	rootCmd.Flags().StringVarP(&opts.TracingEndpoint, "tracing-endpoint", "", "",
+		"OTLP/HTTP endpoint URL which the traces are exported to, e.g. http://otel-collector:4318. Tracing is "+
+			"disabled when it is empty")
+	rootCmd.Flags().Float64VarP(&opts.TracingSampleRatio, "tracing-sample-ratio", "", 1,
+		"ratio of the traces which are sampled, between 0 and 1")
+	rootCmd.Flags().DurationVarP(&opts.ShutdownTimeout, "shutdown-timeout", "", 30*time.Second,
+		"deadline of the graceful shutdown after SIGINT or SIGTERM is received, the process exits with a non-zero "+
+			"code when it is exceeded")
+	rootCmd.Flags().BoolVarP(&opts.EnablePprof, "enable-pprof", "", false,
+		"serve the profiles of net/http/pprof on /debug/pprof/ and the goroutines and informer cache sizes on "+
+			"/debug/runtime of the metrics server (default false)")
+	rootCmd.Flags().BoolVarP(&opts.EnableLogLevelChanges, "enable-log-level-changes", "", false,
+		"allow the log levels to be changed with PUT and DELETE on /log/level of the metrics server, they are "+
+			"read-only otherwise (default false)")
 	rootCmd.Flags().IntVarP(&opts.MetricsPort, "metrics-port", "", 5000,
 		"port of the metrics server")
 	rootCmd.Flags().StringVarP(&opts.MetricsEndpoint, "metrics-endpoint", "", "/metrics",
 		"endpoint to provide prometheus metrics")
+	rootCmd.Flags().StringVarP(&opts.MetricsBindAddress, "metrics-bind-address", "", "",
+		"address which the metrics server is bound to, e.g. 127.0.0.1. All interfaces when empty")
+	rootCmd.Flags().StringVarP(&opts.MetricsTLSCertFile, "metrics-tls-cert-file", "", "",
+		"certificate file of the metrics server, which is loaded again when it is changed. Plaintext when empty")
+	rootCmd.Flags().StringVarP(&opts.MetricsTLSKeyFile, "metrics-tls-key-file", "", "",
+		"key file of --metrics-tls-cert-file")
+	rootCmd.Flags().StringVarP(&opts.MetricsTLSClientCAFile, "metrics-tls-client-ca-file", "", "",
+		"CA bundle which the client certificates are verified with, a verified client certificate authenticates "+
+			"the requests")
+	rootCmd.Flags().StringVarP(&opts.MetricsAuthTokenFile, "metrics-auth-token-file", "", "",
+		"file of the bearer token which authenticates the requests to the metrics server")
+	rootCmd.Flags().StringVarP(&opts.MetricsBasicAuthFile, "metrics-basic-auth-file", "", "",
+		"file of the username:password lines which authenticate the requests to the metrics server with basic auth")
+	rootCmd.Flags().StringVarP(&opts.MetricsPublicPaths, "metrics-public-paths", "", "/healthz,/readyz",
+		"comma separated list of the paths of the metrics server which do not require authentication")
 	rootCmd.Flags().StringVarP(&opts.BannerFilePath, "banner-file-path", "", "build/ci/banner.txt",
 		"relative path of the banner file")
 	rootCmd.Flags().BoolVarP(&opts.VerboseLog, "verbose", "v", false, "verbose output of the logging library (default false)")
+	rootCmd.Flags().StringVarP(&opts.LogFormat, "log-format", "", logging.FormatJSON,
+		"format of the logs, either json, console or logfmt")
+	rootCmd.Flags().StringVarP(&opts.LogLevel, "log-level", "", "info",
+		"comma separated list of the root log level and the levels of the components in the form of component=level, "+
+			"e.g. info,informers.service=debug")
+	rootCmd.Flags().StringVarP(&opts.LogFile, "log-file", "", "",
+		"file which the logs are written to and rotated, the logs are written to stdout when empty")
+	rootCmd.Flags().IntVarP(&opts.LogFileMaxSize, "log-file-max-size", "", 100,
+		"size in megabytes which --log-file is rotated at")
+	rootCmd.Flags().IntVarP(&opts.LogFileMaxBackups, "log-file-max-backups", "", 5,
+		"number of the rotated log files which are kept, all are kept when 0")
+	rootCmd.Flags().IntVarP(&opts.LogFileMaxAge, "log-file-max-age", "", 0,
+		"number of days which the rotated log files are kept for, they are not removed by their age when 0")
+	rootCmd.Flags().IntVarP(&opts.LogSamplingInitial, "log-sampling-initial", "", 10,
+		"number of the debug logs with the same message which are written in each second before they are sampled, "+
+			"sampling is disabled when 0")
+	rootCmd.Flags().IntVarP(&opts.LogSamplingThereafter, "log-sampling-thereafter", "", 100,
+		"only every nth debug log with the same message is written after --log-sampling-initial in a second")
 
 	if err := rootCmd.Flags().MarkHidden("banner-file-path"); err != nil {
 		panic("fatal error occured while hiding flag")
 	}
 
-	logger = logging.GetLogger()
+	if err := rootCmd.Flags().MarkDeprecated("worker-node-label", "use --worker-node-selector instead"); err != nil {
+		panic("fatal error occured while deprecating flag")
+	}
+
+	if err := rootCmd.Flags().MarkDeprecated("verbose", "use --log-level=debug instead"); err != nil {
+		panic("fatal error occured while deprecating flag")
+	}
 }
 
 // rootCmd represents the base command when called without any subcommands
@@ -61,10 +191,15 @@ var rootCmd = &cobra.Command{
 	Long: `nginx-conf-generator gets the port of NodePort type services which contains specific annotation. Then modifies
 the Nginx configuration and reloads the Nginx process. nginx-conf-generator can also work with multiple Kubernetes clusters.
 This means you can route traffic to multiple Kubernetes clusters through a Nginx server for your NodePort type services`,
+	// the errors of a running generator are not caused by the usage, so it is not printed with them
+	SilenceUsage: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		if opts.VerboseLog {
-			logging.Atomic.SetLevel(zap.DebugLevel)
+		logger, err := generator.NewLogger(opts)
+		if err != nil {
+			return errors.Wrap(err, "unable to create logger")
 		}
+		// the errors below are logged with the logger, so that they are not printed again by cobra
+		cmd.SilenceErrors = true
 
 		if _, err := os.Stat(opts.BannerFilePath); err == nil {
 			bannerBytes, _ := os.ReadFile(opts.BannerFilePath)
@@ -79,7 +214,6 @@ This means you can route traffic to multiple Kubernetes clusters through a Nginx
 			zap.String("gitCommit", ver.GitCommit),
 			zap.String("buildDate", ver.BuildDate))
 
-		kubeConfigPathArr := strings.Split(opts.KubeConfigPaths, ",")
 		defer func() {
 			err := logger.Sync()
 			if err != nil {
@@ -87,41 +221,47 @@ This means you can route traffic to multiple Kubernetes clusters through a Nginx
 			}
 		}()
 
-		for _, path := range kubeConfigPathArr {
-			restConfig, err := informers.GetConfig(path)
-			if err != nil {
-				logger.Error("an error occurred while getting k8s config", zap.String("error", err.Error()))
-				return errors.Wrap(err, "unable to get rest config from k8s client")
-			}
+		// process and go runtime metrics of the default registry are exposed together with the generator metrics
+		g, err := generator.New(opts, logger, prometheus.DefaultRegisterer)
+		if err != nil {
+			logger.Error("an error occurred while creating generator", zap.String("error", err.Error()))
+			return errors.Wrap(err, "unable to create generator")
+		}
 
-			clientSet, err := informers.GetClientSet(restConfig)
-			if err != nil {
-				logger.Error("an error occurred while getting clientset", zap.String("error", err.Error()))
-				return errors.Wrap(err, "unable to get clientset from k8s client")
-			}
+		// the root context is canceled on SIGINT or SIGTERM, which stops the generator
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
 
-			masterIp := strings.Split(strings.Split(restConfig.Host, "//")[1], ":")[0]
-			cluster := types.NewCluster(masterIp, make([]*types.Worker, 0))
-			nginxConf.Clusters = append(nginxConf.Clusters, cluster)
-			logger.With(zap.String("masterIP", cluster.MasterIP))
+		if err := g.Start(ctx); err != nil {
+			logger.Error("an error occurred while starting generator", zap.String("error", err.Error()))
+			return errors.Wrap(err, "unable to start generator")
+		}
 
-			if err := informers.RunNodeInformer(cluster, clientSet, logger, nginxConf); err != nil {
-				return err
-			}
+		select {
+		case <-ctx.Done():
+			logger.Info("shutdown signal is received, shutting down gracefully",
+				zap.Duration("timeout", opts.ShutdownTimeout))
+		case <-g.Failed():
+			logger.Error("generator is failed, shutting down", zap.String("error", g.Err().Error()),
+				zap.Duration("timeout", opts.ShutdownTimeout))
+		}
+		// a second signal kills the process right away
+		stop()
 
-			if err := informers.RunServiceInformer(cluster, clientSet, logger, nginxConf); err != nil {
-				return err
-			}
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), opts.ShutdownTimeout)
+		defer cancel()
+		if err := g.Stop(shutdownCtx); err != nil {
+			logger.Error("shutdown is not clean", zap.String("error", err.Error()))
+			return err
 		}
 
-		go func() {
-			if err := metrics.RunMetricsServer(); err != nil {
-				logger.Fatal("an error occurred while spinning up metrics server",
-					zap.String("error", err.Error()))
-			}
-		}()
+		// the process exits with a non-zero code when the generator is failed, even if the shutdown is clean
+		if err := g.Err(); err != nil {
+			return err
+		}
 
-		select {}
+		logger.Info("nginx-conf-generator is shut down gracefully")
+		return nil
 	},
 }
 
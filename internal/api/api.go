@@ -0,0 +1,224 @@
+// Package api serves the in-memory state of the generator as read-only JSON endpoints, which are meant for debugging
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/health"
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/k8s/informers"
+	"github.com/bilalcaliskan/nginx-conf-generator/pkg/model"
+
+	"github.com/gorilla/mux"
+)
+
+// Prefix is the path prefix of the endpoints of the API
+const Prefix = "/api/v1"
+
+// Source provides the state which is served by the API, it is implemented by informers.Manager
+type Source interface {
+	// Snapshot returns a consistent copy of the state of the clusters
+	Snapshot() *model.Snapshot
+	// CurrentRender returns the configuration which is applied last, nil if no configuration is applied yet
+	CurrentRender() (*informers.Render, error)
+	// History returns the last applies of the configuration, the newest first
+	History() []informers.ApplyRecord
+}
+
+// Cluster is the summary of a managed cluster
+type Cluster struct {
+	Name     string       `json:"name"`
+	MasterIP string       `json:"masterIP,omitempty"`
+	State    health.State `json:"state,omitempty"`
+	Since    *time.Time   `json:"since,omitempty"`
+	Error    string       `json:"error,omitempty"`
+	// Dropped is true when the upstreams of the disconnected cluster are not rendered
+	Dropped         bool `json:"dropped"`
+	Workers         int  `json:"workers"`
+	ReadyWorkers    int  `json:"readyWorkers"`
+	ExposedServices int  `json:"exposedServices"`
+	SkippedServices int  `json:"skippedServices"`
+}
+
+// Member is a worker in the upstream of an exposed service
+type Member struct {
+	Address  string `json:"address"`
+	NodeName string `json:"nodeName,omitempty"`
+	Ready    bool   `json:"ready"`
+	Draining bool   `json:"draining"`
+}
+
+// Service is a service which is exposed through Nginx
+type Service struct {
+	Cluster    string   `json:"cluster"`
+	Namespace  string   `json:"namespace,omitempty"`
+	Name       string   `json:"name,omitempty"`
+	NodePort   int32    `json:"nodePort"`
+	ListenPort int32    `json:"listenPort"`
+	Upstream   string   `json:"upstream"`
+	Members    []Member `json:"members"`
+}
+
+// SkippedService is a service which is annotated to be exposed but skipped
+type SkippedService struct {
+	Cluster   string `json:"cluster"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Reason    string `json:"reason"`
+}
+
+// Services are the exposed and the skipped services of all clusters
+type Services struct {
+	Services []Service        `json:"services"`
+	Skipped  []SkippedService `json:"skipped"`
+}
+
+type handler struct {
+	source         Source
+	healthRegistry *health.Registry
+}
+
+// Register registers the endpoints of the API on router. Each response is built from a single snapshot of source,
+// so that it is consistent even if the clusters are changed while it is served
+func Register(router *mux.Router, source Source, healthRegistry *health.Registry) {
+	h := &handler{source: source, healthRegistry: healthRegistry}
+	router.HandleFunc(Prefix+"/clusters", h.clusters).Methods(http.MethodGet)
+	router.HandleFunc(Prefix+"/clusters/{name}/workers", h.workers).Methods(http.MethodGet)
+	router.HandleFunc(Prefix+"/services", h.services).Methods(http.MethodGet)
+	router.HandleFunc(Prefix+"/render/current", h.currentRender).Methods(http.MethodGet)
+	router.HandleFunc(Prefix+"/reloads", h.reloads).Methods(http.MethodGet)
+}
+
+// clusters responds with the summaries of the clusters, including the ones which are not connected yet
+func (h *handler) clusters(w http.ResponseWriter, _ *http.Request) {
+	snapshot := h.source.Snapshot()
+	statuses := make(map[string]health.ClusterStatus)
+	for _, status := range h.healthRegistry.List() {
+		statuses[status.Name] = status
+	}
+
+	clusters := make([]Cluster, 0, len(statuses))
+	for _, snapshotCluster := range snapshot.Clusters {
+		cluster := Cluster{
+			Name:            snapshotCluster.Name,
+			MasterIP:        snapshotCluster.MasterIP,
+			Dropped:         snapshotCluster.Dropped,
+			Workers:         len(snapshotCluster.Workers),
+			ExposedServices: len(snapshotCluster.NodePorts),
+			SkippedServices: len(snapshotCluster.Skipped),
+		}
+
+		for _, worker := range snapshotCluster.Workers {
+			if worker.Ready && !worker.Draining {
+				cluster.ReadyWorkers++
+			}
+		}
+
+		if status, ok := statuses[cluster.Name]; ok {
+			setStatus(&cluster, status)
+			delete(statuses, cluster.Name)
+		}
+		clusters = append(clusters, cluster)
+	}
+
+	for _, status := range statuses {
+		cluster := Cluster{Name: status.Name}
+		setStatus(&cluster, status)
+		clusters = append(clusters, cluster)
+	}
+
+	sort.Slice(clusters, func(i, j int) bool {
+		return clusters[i].Name < clusters[j].Name
+	})
+
+	writeJSON(w, http.StatusOK, clusters)
+}
+
+func setStatus(cluster *Cluster, status health.ClusterStatus) {
+	since := status.Since
+	cluster.State, cluster.Since, cluster.Error = status.State, &since, status.Error
+}
+
+// workers responds with the workers of the cluster in the path with their readiness
+func (h *handler) workers(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	for _, cluster := range h.source.Snapshot().Clusters {
+		if cluster.Name == name {
+			writeJSON(w, http.StatusOK, cluster.Workers)
+			return
+		}
+	}
+
+	writeError(w, http.StatusNotFound, fmt.Sprintf("cluster %s is not found", name))
+}
+
+// services responds with the exposed services with their upstream members, and the reasons of the skipped ones
+func (h *handler) services(w http.ResponseWriter, _ *http.Request) {
+	services := Services{Services: make([]Service, 0), Skipped: make([]SkippedService, 0)}
+	for _, cluster := range h.source.Snapshot().Clusters {
+		for _, nodePort := range cluster.NodePorts {
+			service := Service{
+				Cluster:    cluster.Name,
+				Namespace:  nodePort.Namespace,
+				Name:       nodePort.Name,
+				NodePort:   nodePort.Port,
+				ListenPort: nodePort.Listen(),
+				Upstream:   nodePort.UpstreamName(),
+				Members:    make([]Member, 0, len(nodePort.Workers)),
+			}
+
+			for _, worker := range nodePort.Workers {
+				service.Members = append(service.Members, Member{Address: worker.HostPort(nodePort.Port),
+					NodeName: worker.NodeName, Ready: worker.Ready, Draining: worker.Draining})
+			}
+			services.Services = append(services.Services, service)
+		}
+
+		for _, skipped := range cluster.Skipped {
+			services.Skipped = append(services.Skipped, SkippedService{Cluster: cluster.Name,
+				Namespace: skipped.Namespace, Name: skipped.Name, Reason: skipped.Reason})
+		}
+	}
+
+	writeJSON(w, http.StatusOK, services)
+}
+
+// currentRender responds with the configuration which is applied last
+func (h *handler) currentRender(w http.ResponseWriter, _ *http.Request) {
+	render, err := h.source.CurrentRender()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if render == nil {
+		writeError(w, http.StatusNotFound, "configuration is not applied yet")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, render)
+}
+
+// reloads responds with the last applies of the configuration, e.g. validation failures
+func (h *handler) reloads(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, h.source.History())
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	content, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(content)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,171 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/health"
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/k8s/informers"
+	"github.com/bilalcaliskan/nginx-conf-generator/pkg/model"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeSource struct {
+	snapshot *model.Snapshot
+	render   *informers.Render
+	err      error
+	history  []informers.ApplyRecord
+}
+
+func (source *fakeSource) Snapshot() *model.Snapshot {
+	return source.snapshot
+}
+
+func (source *fakeSource) CurrentRender() (*informers.Render, error) {
+	return source.render, source.err
+}
+
+func (source *fakeSource) History() []informers.ApplyRecord {
+	return source.history
+}
+
+func newTestServer(t *testing.T, source Source, healthRegistry *health.Registry) *httptest.Server {
+	router := mux.NewRouter()
+	Register(router, source, healthRegistry)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func get(t *testing.T, url string, v interface{}) int {
+	resp, err := http.Get(url)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(v))
+	return resp.StatusCode
+}
+
+func getSnapshot() *model.Snapshot {
+	ready := &model.Worker{MasterIP: "10.0.0.1", HostIP: "10.0.0.44", NodeName: "node01", Ready: true}
+	draining := &model.Worker{MasterIP: "10.0.0.1", HostIP: "10.0.0.45", NodeName: "node02", Ready: true,
+		Draining: true}
+	return &model.Snapshot{Clusters: []*model.Cluster{{
+		Name:     "prod",
+		MasterIP: "10.0.0.1",
+		Workers:  []*model.Worker{ready, draining},
+		NodePorts: []*model.NodePort{{MasterIP: "10.0.0.1", Namespace: "team-a", Name: "app", Port: 30444,
+			ListenPort: 8080, Workers: []*model.Worker{ready, draining}}},
+		Skipped: []*model.SkippedService{{Namespace: "team-b", Name: "app",
+			Reason: "listen port 8080 is already claimed by 10.0.0.1/team-a/app"}},
+	}}}
+}
+
+func TestClusters(t *testing.T) {
+	healthRegistry := health.NewRegistry()
+	healthRegistry.Set("prod", health.StateSynced, nil)
+	healthRegistry.Set("staging", health.StateDisconnected, errors.New("connection refused"))
+	server := newTestServer(t, &fakeSource{snapshot: getSnapshot()}, healthRegistry)
+
+	var clusters []Cluster
+	assert.Equal(t, http.StatusOK, get(t, server.URL+Prefix+"/clusters", &clusters))
+	assert.Len(t, clusters, 2)
+	assert.Equal(t, "prod", clusters[0].Name)
+	assert.Equal(t, health.StateSynced, clusters[0].State)
+	assert.Equal(t, 2, clusters[0].Workers)
+	assert.Equal(t, 1, clusters[0].ReadyWorkers)
+	assert.Equal(t, 1, clusters[0].ExposedServices)
+	assert.Equal(t, 1, clusters[0].SkippedServices)
+
+	// clusters which are not connected yet are reported with their health state only
+	assert.Equal(t, "staging", clusters[1].Name)
+	assert.Equal(t, health.StateDisconnected, clusters[1].State)
+	assert.Equal(t, "connection refused", clusters[1].Error)
+	assert.Zero(t, clusters[1].Workers)
+
+	resp, err := http.Post(server.URL+Prefix+"/clusters", "application/json", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func TestWorkers(t *testing.T) {
+	server := newTestServer(t, &fakeSource{snapshot: getSnapshot()}, health.NewRegistry())
+
+	var workers []*model.Worker
+	assert.Equal(t, http.StatusOK, get(t, server.URL+Prefix+"/clusters/prod/workers", &workers))
+	assert.Len(t, workers, 2)
+	assert.Equal(t, "node01", workers[0].NodeName)
+	assert.True(t, workers[0].Ready)
+	assert.True(t, workers[1].Draining)
+
+	var body map[string]string
+	assert.Equal(t, http.StatusNotFound, get(t, server.URL+Prefix+"/clusters/missing/workers", &body))
+	assert.Equal(t, "cluster missing is not found", body["error"])
+}
+
+func TestServices(t *testing.T) {
+	server := newTestServer(t, &fakeSource{snapshot: getSnapshot()}, health.NewRegistry())
+
+	var services Services
+	assert.Equal(t, http.StatusOK, get(t, server.URL+Prefix+"/services", &services))
+	assert.Len(t, services.Services, 1)
+	service := services.Services[0]
+	assert.Equal(t, "prod", service.Cluster)
+	assert.Equal(t, "team-a", service.Namespace)
+	assert.Equal(t, "app", service.Name)
+	assert.Equal(t, int32(8080), service.ListenPort)
+	assert.Equal(t, "10.0.0.1_30444", service.Upstream)
+	assert.Equal(t, []Member{{Address: "10.0.0.44:30444", NodeName: "node01", Ready: true},
+		{Address: "10.0.0.45:30444", NodeName: "node02", Ready: true, Draining: true}}, service.Members)
+
+	assert.Len(t, services.Skipped, 1)
+	assert.Equal(t, SkippedService{Cluster: "prod", Namespace: "team-b", Name: "app",
+		Reason: "listen port 8080 is already claimed by 10.0.0.1/team-a/app"}, services.Skipped[0])
+
+	// an empty state is served as empty lists rather than nulls
+	server = newTestServer(t, &fakeSource{snapshot: &model.Snapshot{}}, health.NewRegistry())
+	resp, err := http.Get(server.URL + Prefix + "/services")
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	var raw map[string]json.RawMessage
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&raw))
+	assert.Equal(t, "[]", string(raw["services"]))
+	assert.Equal(t, "[]", string(raw["skipped"]))
+}
+
+func TestCurrentRender(t *testing.T) {
+	source := &fakeSource{snapshot: &model.Snapshot{}}
+	server := newTestServer(t, source, health.NewRegistry())
+
+	var body map[string]string
+	assert.Equal(t, http.StatusNotFound, get(t, server.URL+Prefix+"/render/current", &body))
+	assert.Equal(t, "configuration is not applied yet", body["error"])
+
+	source.err = errors.New("permission denied")
+	assert.Equal(t, http.StatusInternalServerError, get(t, server.URL+Prefix+"/render/current", &body))
+
+	source.err = nil
+	source.render = &informers.Render{Hash: "sha256:abc", AppliedAt: time.Unix(1700000000, 0).UTC(),
+		Files: []informers.RenderedFile{{Path: "/etc/nginx/conf.d/ncg.conf", Content: "upstream {}"}}}
+	var render informers.Render
+	assert.Equal(t, http.StatusOK, get(t, server.URL+Prefix+"/render/current", &render))
+	assert.Equal(t, *source.render, render)
+}
+
+func TestReloads(t *testing.T) {
+	source := &fakeSource{snapshot: &model.Snapshot{}, history: []informers.ApplyRecord{
+		{Time: time.Unix(1700000060, 0).UTC(), Result: informers.ApplyInvalid, Error: "unknown directive"},
+		{Time: time.Unix(1700000000, 0).UTC(), Hash: "sha256:abc", Result: informers.ApplyReloaded},
+	}}
+	server := newTestServer(t, source, health.NewRegistry())
+
+	var history []informers.ApplyRecord
+	assert.Equal(t, http.StatusOK, get(t, server.URL+Prefix+"/reloads", &history))
+	assert.Equal(t, source.history, history)
+}
@@ -0,0 +1,38 @@
+// Package dashboard serves a read-only HTML dashboard of the generator. Its assets are embedded into the binary and it
+// does not load anything from outside of the metrics server, so that it also works in air-gapped environments
+package dashboard
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// Path is the path which the dashboard is served on
+const Path = "/dashboard/"
+
+//go:embed static
+var static embed.FS
+
+// contentSecurityPolicy only allows the embedded assets and the requests to the same server
+const contentSecurityPolicy = "default-src 'self'; img-src 'self' data:; frame-ancestors 'none'; form-action 'none'"
+
+// Register registers the dashboard on router, the root path is redirected to it
+func Register(router *mux.Router) {
+	assets, err := fs.Sub(static, "static")
+	if err != nil {
+		// static is embedded at compile time, so that it can not be missing
+		panic(err)
+	}
+
+	fileServer := http.StripPrefix(Path, http.FileServer(http.FS(assets)))
+	router.PathPrefix(Path).Methods(http.MethodGet, http.MethodHead).Handler(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Security-Policy", contentSecurityPolicy)
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+			fileServer.ServeHTTP(w, r)
+		}))
+	router.Handle("/", http.RedirectHandler(Path, http.StatusFound)).Methods(http.MethodGet, http.MethodHead)
+}
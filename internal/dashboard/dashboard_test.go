@@ -0,0 +1,50 @@
+package dashboard
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegister(t *testing.T) {
+	router := mux.NewRouter()
+	Register(router)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(server.URL + "/")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+	assert.Equal(t, Path, resp.Header.Get("Location"))
+
+	for path, contentType := range map[string]string{
+		"":          "text/html; charset=utf-8",
+		"app.js":    "text/javascript; charset=utf-8",
+		"style.css": "text/css; charset=utf-8",
+	} {
+		resp, err := http.Get(server.URL + Path + path)
+		assert.Nil(t, err)
+		body, err := io.ReadAll(resp.Body)
+		assert.Nil(t, err)
+		_ = resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode, path)
+		assert.Equal(t, contentType, resp.Header.Get("Content-Type"), path)
+		assert.Equal(t, contentSecurityPolicy, resp.Header.Get("Content-Security-Policy"), path)
+		assert.NotEmpty(t, body, path)
+		// the assets never reference anything outside of the metrics server
+		assert.NotContains(t, string(body), "http://", path)
+		assert.NotContains(t, string(body), "https://", path)
+	}
+
+	resp, err = http.Get(server.URL + Path + "missing.js")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
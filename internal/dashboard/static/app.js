@@ -0,0 +1,190 @@
+// The dashboard polls the state API of the metrics server and renders it. Values are always set through textContent,
+// so that nothing which is read from the clusters is interpreted as HTML.
+(function () {
+  "use strict";
+
+  var refreshInterval = 5000;
+
+  function el(tag, text, className) {
+    var element = document.createElement(tag);
+    if (text !== undefined && text !== null) {
+      element.textContent = String(text);
+    }
+    if (className) {
+      element.className = className;
+    }
+    return element;
+  }
+
+  function row(cells) {
+    var tr = el("tr");
+    cells.forEach(function (cell) {
+      var td = el("td");
+      if (cell instanceof Node) {
+        td.appendChild(cell);
+      } else {
+        td.textContent = cell === undefined || cell === null ? "" : String(cell);
+      }
+      tr.appendChild(td);
+    });
+    return tr;
+  }
+
+  function fill(id, rows, columns, empty) {
+    var body = document.getElementById(id);
+    body.replaceChildren();
+    if (rows.length === 0) {
+      var td = el("td", empty, "muted");
+      td.colSpan = columns;
+      var tr = el("tr");
+      tr.appendChild(td);
+      body.appendChild(tr);
+      return;
+    }
+    rows.forEach(function (tr) {
+      body.appendChild(tr);
+    });
+  }
+
+  function badge(text, kind) {
+    return el("span", text, "badge " + kind);
+  }
+
+  function time(value) {
+    return value ? new Date(value).toLocaleString() : "";
+  }
+
+  function getJSON(path) {
+    // readiness responds with 503 and a JSON report when it is not ready, which is still rendered
+    return fetch(path, {cache: "no-store"}).then(function (response) {
+      return response.json();
+    });
+  }
+
+  var stateKinds = {synced: "ok", degraded: "warn", syncing: "warn", disconnected: "error"};
+  var resultKinds = {reloaded: "ok", unchanged: "ok", invalid: "error", failed: "error"};
+
+  function renderClusters(clusters) {
+    fill("clusters", clusters.map(function (cluster) {
+      return row([
+        cluster.name,
+        cluster.masterIP,
+        badge(cluster.state || "unknown", stateKinds[cluster.state] || "warn"),
+        time(cluster.since),
+        cluster.readyWorkers + " / " + cluster.workers,
+        cluster.dropped ? badge("dropped", "error") : cluster.exposedServices,
+        cluster.skippedServices,
+        cluster.error
+      ]);
+    }), 8, "no clusters");
+  }
+
+  function members(service) {
+    var list = el("ul", null, "members");
+    service.members.forEach(function (member) {
+      var kind = member.draining ? "warn" : member.ready ? "ok" : "error";
+      var status = member.draining ? "draining" : member.ready ? "ready" : "not ready";
+      var item = el("li");
+      item.appendChild(badge(status, kind));
+      item.appendChild(el("span", " " + member.address + (member.nodeName ? " (" + member.nodeName + ")" : "")));
+      list.appendChild(item);
+    });
+    return list;
+  }
+
+  function serviceName(service) {
+    return service.namespace ? service.namespace + "/" + service.name : "";
+  }
+
+  function renderServices(services) {
+    fill("services", services.services.map(function (service) {
+      return row([service.cluster, serviceName(service), service.listenPort, service.nodePort, service.upstream,
+        members(service)]);
+    }), 6, "no exposed services");
+
+    fill("skipped", services.skipped.map(function (service) {
+      return row([service.cluster, serviceName(service), service.reason]);
+    }), 3, "no skipped services");
+  }
+
+  function trigger(item) {
+    var name = item.namespace ? item.namespace + "/" + item.name : item.name || "";
+    return [item.cluster, item.kind, name, item.type].filter(function (part) {
+      return part;
+    }).join(" ");
+  }
+
+  function details(record) {
+    var container = el("div");
+    if (record.error) {
+      container.appendChild(el("pre", record.error, "error"));
+    }
+    if (record.triggers && record.triggers.length > 0) {
+      var triggers = el("ul", null, "members");
+      record.triggers.forEach(function (item) {
+        triggers.appendChild(el("li", trigger(item)));
+      });
+      if (record.droppedTriggers) {
+        triggers.appendChild(el("li", "and " + record.droppedTriggers + " more", "muted"));
+      }
+      container.appendChild(triggers);
+    }
+    if (record.diff) {
+      var diff = el("details");
+      diff.appendChild(el("summary", "diff"));
+      var pre = el("pre", null, "diff");
+      record.diff.split("\n").forEach(function (line) {
+        var kind = line.indexOf("+") === 0 ? "added" : line.indexOf("-") === 0 ? "removed" : "";
+        pre.appendChild(el("span", line + "\n", kind));
+      });
+      diff.appendChild(pre);
+      container.appendChild(diff);
+    }
+    return container;
+  }
+
+  function renderReloads(records) {
+    fill("reloads", records.map(function (record) {
+      return row([time(record.time), badge(record.result, resultKinds[record.result] || "warn"), record.hash,
+        details(record)]);
+    }), 4, "no reloads yet");
+
+    var failures = records.filter(function (record) {
+      return record.result === "invalid";
+    });
+    document.getElementById("failures-section").hidden = failures.length === 0;
+    fill("failures", failures.map(function (record) {
+      return row([time(record.time), badge(record.result, "error"), el("pre", record.error, "error")]);
+    }), 3, "");
+  }
+
+  function renderReadiness(report) {
+    var readiness = document.getElementById("readiness");
+    var failed = (report.checks || []).filter(function (check) {
+      return !check.ok;
+    });
+    readiness.textContent = report.ok ? "ready" : "not ready";
+    readiness.className = "badge " + (report.ok ? "ok" : "error");
+    readiness.title = failed.map(function (check) {
+      return check.name + ": " + check.message;
+    }).join("\n");
+  }
+
+  function refresh() {
+    Promise.all([
+      getJSON("../readyz").then(renderReadiness),
+      getJSON("../api/v1/clusters").then(renderClusters),
+      getJSON("../api/v1/services").then(renderServices),
+      getJSON("../api/v1/reloads").then(renderReloads)
+    ]).then(function () {
+      document.getElementById("updated").textContent = "updated " + new Date().toLocaleTimeString();
+    }).catch(function (err) {
+      document.getElementById("updated").textContent = "unable to refresh: " + err;
+    });
+  }
+
+  document.addEventListener("DOMContentLoaded", function () {
+    refresh();
+    setInterval(refresh, refreshInterval);
+  });
+})();
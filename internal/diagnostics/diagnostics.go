@@ -0,0 +1,95 @@
+// Package diagnostics serves the profiles of net/http/pprof and the runtime state of the generator, which are meant
+// for profiling in production. They are only registered with --enable-pprof
+package diagnostics
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	runtimepprof "runtime/pprof"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// PprofPath is the path prefix of the profiles of net/http/pprof
+	PprofPath = "/debug/pprof/"
+	// RuntimePath is the path of the runtime state
+	RuntimePath = "/debug/runtime"
+)
+
+// Source provides the sizes of the informer caches, it is implemented by informers.Manager
+type Source interface {
+	// CacheSizes returns the number of the objects in the informer caches, keyed by cluster and kind
+	CacheSizes() map[string]map[string]int
+}
+
+// Memory is a summary of the memory statistics of the Go runtime
+type Memory struct {
+	HeapAllocBytes uint64 `json:"heapAllocBytes"`
+	HeapObjects    uint64 `json:"heapObjects"`
+	SysBytes       uint64 `json:"sysBytes"`
+	NumGC          uint32 `json:"numGC"`
+}
+
+// Runtime is the runtime state of the generator
+type Runtime struct {
+	Goroutines int    `json:"goroutines"`
+	Memory     Memory `json:"memory"`
+	// Caches are the number of the objects in the informer caches, keyed by cluster and kind
+	Caches map[string]map[string]int `json:"caches"`
+	// Stacks are the stacks of the goroutines, which are grouped by their stacks in the text format of pprof
+	Stacks string `json:"stacks"`
+}
+
+// Register registers the profiles of net/http/pprof and the runtime state of source on router
+func Register(router *mux.Router, source Source) {
+	router.HandleFunc(PprofPath+"cmdline", pprof.Cmdline)
+	router.HandleFunc(PprofPath+"profile", withoutWriteTimeout(pprof.Profile))
+	router.HandleFunc(PprofPath+"symbol", pprof.Symbol)
+	router.HandleFunc(PprofPath+"trace", withoutWriteTimeout(pprof.Trace))
+	// the index also serves the named profiles, e.g. heap and goroutine, which are sampled with the seconds parameter
+	router.PathPrefix(PprofPath).HandlerFunc(withoutWriteTimeout(pprof.Index))
+	router.HandleFunc(RuntimePath, func(w http.ResponseWriter, _ *http.Request) {
+		content, err := json.Marshal(Get(source))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(content)
+	}).Methods(http.MethodGet)
+}
+
+// withoutWriteTimeout lets handler sample for longer than the write timeout of the server, e.g. the 30 seconds of the
+// CPU profile by default. The write deadline is cleared instead of extended, since the older versions of pprof do not
+// extend it
+func withoutWriteTimeout(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+		// pprof also rejects the durations which exceed the WriteTimeout of the server in the request context
+		handler(w, r.WithContext(context.WithValue(r.Context(), http.ServerContextKey, nil)))
+	}
+}
+
+// Get returns the runtime state of the process and the informer caches of source
+func Get(source Source) Runtime {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+
+	var stacks strings.Builder
+	_ = runtimepprof.Lookup("goroutine").WriteTo(&stacks, 1)
+
+	return Runtime{
+		Goroutines: runtime.NumGoroutine(),
+		Memory: Memory{HeapAllocBytes: stats.HeapAlloc, HeapObjects: stats.HeapObjects, SysBytes: stats.Sys,
+			NumGC: stats.NumGC},
+		Caches: source.CacheSizes(),
+		Stacks: stacks.String(),
+	}
+}
@@ -0,0 +1,75 @@
+package diagnostics
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeSource map[string]map[string]int
+
+func (s fakeSource) CacheSizes() map[string]map[string]int {
+	return s
+}
+
+func TestRegister(t *testing.T) {
+	router := mux.NewRouter()
+	Register(router, fakeSource{"cluster-a": {"node": 3, "service": 12}})
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	get := func(path string) (int, string) {
+		resp, err := http.Get(server.URL + path)
+		assert.Nil(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		assert.Nil(t, err)
+		return resp.StatusCode, string(body)
+	}
+
+	status, body := get(RuntimePath)
+	assert.Equal(t, http.StatusOK, status)
+	var state Runtime
+	assert.Nil(t, json.Unmarshal([]byte(body), &state))
+	assert.Equal(t, 12, state.Caches["cluster-a"]["service"])
+	assert.Positive(t, state.Goroutines)
+	assert.Positive(t, state.Memory.HeapAllocBytes)
+	assert.Contains(t, state.Stacks, "goroutine profile:")
+
+	status, body = get(PprofPath)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, "goroutine")
+
+	status, body = get(PprofPath + "goroutine?debug=1")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, "goroutine profile:")
+
+	status, _ = get(PprofPath + "cmdline")
+	assert.Equal(t, http.StatusOK, status)
+}
+
+func TestRegisterProfileWriteTimeout(t *testing.T) {
+	router := mux.NewRouter()
+	Register(router, fakeSource{})
+	server := httptest.NewUnstartedServer(router)
+	// the write timeout of the metrics server
+	server.Config.WriteTimeout = 10 * time.Second
+	server.Start()
+	defer server.Close()
+
+	// older versions of pprof reject the duration instead of extending the write deadline
+	resp, err := http.Get(server.URL + PprofPath + "profile?seconds=11")
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotEmpty(t, body)
+	assert.Equal(t, "application/octet-stream", resp.Header.Get("Content-Type"))
+}
@@ -0,0 +1,103 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// State is the health state of a managed cluster
+type State string
+
+const (
+	// StateSyncing means the cluster is being connected and its informer caches are being synced
+	StateSyncing State = "syncing"
+	// StateSynced means the informer caches of the cluster are synced and its API server is reachable
+	StateSynced State = "synced"
+	// StateDegraded means the API server of a synced cluster is not reachable, its last known state is rendered
+	StateDegraded State = "degraded"
+	// StateDisconnected means the cluster can not be connected or has been degraded for too long
+	StateDisconnected State = "disconnected"
+)
+
+// States contains all of the health states
+var States = []State{StateSyncing, StateSynced, StateDegraded, StateDisconnected}
+
+// ClusterStatus is the health status of a managed cluster
+type ClusterStatus struct {
+	Name  string    `json:"name"`
+	State State     `json:"state"`
+	Since time.Time `json:"since"`
+	Error string    `json:"error,omitempty"`
+}
+
+// Registry keeps track of the health statuses of the managed clusters
+type Registry struct {
+	clusters map[string]*ClusterStatus
+	mu       sync.RWMutex
+}
+
+// NewRegistry creates an empty Registry and returns it
+func NewRegistry() *Registry {
+	return &Registry{clusters: make(map[string]*ClusterStatus)}
+}
+
+// Set sets the health state of the cluster called name, err is the reason of an unhealthy state. Since is only
+// changed when the state is changed
+func (registry *Registry) Set(name string, state State, err error) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	status, ok := registry.clusters[name]
+	if !ok || status.State != state {
+		status = &ClusterStatus{Name: name, State: state, Since: time.Now()}
+		registry.clusters[name] = status
+	}
+
+	status.Error = ""
+	if err != nil {
+		status.Error = err.Error()
+	}
+}
+
+// Get returns the health status of the cluster called name
+func (registry *Registry) Get(name string) (ClusterStatus, bool) {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	status, ok := registry.clusters[name]
+	if !ok {
+		return ClusterStatus{}, false
+	}
+
+	return *status, true
+}
+
+// List returns the health statuses of all clusters, sorted by name
+func (registry *Registry) List() []ClusterStatus {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	statuses := make([]ClusterStatus, 0, len(registry.clusters))
+	for _, status := range registry.clusters {
+		statuses = append(statuses, *status)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+
+	return statuses
+}
+
+// Handler returns a http.Handler which responds with the health statuses of all clusters in JSON
+func (registry *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(registry.List()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
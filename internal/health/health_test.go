@@ -0,0 +1,98 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSet(t *testing.T) {
+	registry := NewRegistry()
+	registry.Set("b", StateSyncing, nil)
+	registry.Set("a", StateSynced, nil)
+
+	status, ok := registry.Get("b")
+	assert.True(t, ok)
+	assert.Equal(t, StateSyncing, status.State)
+	since := status.Since
+
+	// since is kept when the state is not changed
+	time.Sleep(10 * time.Millisecond)
+	registry.Set("b", StateSyncing, errors.New("connection refused"))
+	status, _ = registry.Get("b")
+	assert.Equal(t, since, status.Since)
+	assert.Equal(t, "connection refused", status.Error)
+
+	registry.Set("b", StateSynced, nil)
+	status, _ = registry.Get("b")
+	assert.True(t, status.Since.After(since))
+	assert.Empty(t, status.Error)
+
+	_, ok = registry.Get("missing")
+	assert.False(t, ok)
+
+	statuses := registry.List()
+	assert.Len(t, statuses, 2)
+	assert.Equal(t, "a", statuses[0].Name)
+	assert.Equal(t, "b", statuses[1].Name)
+}
+
+func TestHandler(t *testing.T) {
+	registry := NewRegistry()
+	registry.Set("c", StateDegraded, errors.New("timeout"))
+
+	recorder := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/status", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+
+	var statuses []ClusterStatus
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &statuses))
+	found := false
+	for _, status := range statuses {
+		if status.Name == "c" {
+			found = true
+			assert.Equal(t, StateDegraded, status.State)
+			assert.Equal(t, "timeout", status.Error)
+		}
+	}
+	assert.True(t, found)
+}
+
+func TestReportHandler(t *testing.T) {
+	ready := true
+	handler := ReportHandler(func() Report {
+		return NewReport([]Check{{Name: "render", OK: true}, {Name: "clusters", OK: ready}},
+			[]ClusterCheck{{ClusterStatus: ClusterStatus{Name: "a", State: StateDegraded}, Required: true,
+				Ready: StateDegraded.Synced()}})
+	})
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+
+	var report Report
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &report))
+	assert.True(t, report.OK)
+	assert.Len(t, report.Checks, 2)
+	assert.True(t, report.Clusters[0].Ready)
+
+	ready = false
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `"ok":false`)
+}
+
+func TestStateSynced(t *testing.T) {
+	assert.True(t, StateSynced.Synced())
+	assert.True(t, StateDegraded.Synced())
+	assert.False(t, StateSyncing.Synced())
+	assert.False(t, StateDisconnected.Synced())
+}
@@ -0,0 +1,63 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Check is the result of a single liveness or readiness check
+type Check struct {
+	Name    string `json:"name"`
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+// ClusterCheck is the readiness of a managed cluster, which is ready when its informer caches are synced
+type ClusterCheck struct {
+	ClusterStatus
+	// Required is false for the optional clusters, which are not taken into account for the readiness
+	Required bool `json:"required"`
+	Ready    bool `json:"ready"`
+}
+
+// Report is the result of the checks which are served on a health endpoint
+type Report struct {
+	OK       bool           `json:"ok"`
+	Checks   []Check        `json:"checks"`
+	Clusters []ClusterCheck `json:"clusters"`
+}
+
+// NewReport creates a Report from checks and clusters, which is ok when all of checks are ok
+func NewReport(checks []Check, clusters []ClusterCheck) Report {
+	report := Report{OK: true, Checks: checks, Clusters: clusters}
+	for _, check := range checks {
+		report.OK = report.OK && check.OK
+	}
+
+	return report
+}
+
+// Synced returns true if the informer caches of a cluster in state are synced, the last known state of a degraded
+// cluster is still served from its caches
+func (state State) Synced() bool {
+	return state == StateSynced || state == StateDegraded
+}
+
+// ReportHandler returns a http.Handler which responds with the Report of check in JSON. The status code is 503 when
+// the Report is not ok, so that it can be used by load balancers and watchdogs directly
+func ReportHandler(check func() Report) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := check()
+		content, err := json.Marshal(report)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if !report.OK {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_, _ = w.Write(content)
+	})
+}
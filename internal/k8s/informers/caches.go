@@ -0,0 +1,54 @@
+package informers
+
+import (
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/k8s/types"
+
+	"k8s.io/client-go/tools/cache"
+)
+
+// addEventHandler adds handler to informer through countEvents and tracks the cache of informer, so that its size is
+// reported by CacheSizes until the cluster is stopped
+func (m *Manager) addEventHandler(cluster *types.Cluster, kind string, informer cache.SharedIndexInformer,
+	handler eventHandler) error {
+	if _, err := informer.AddEventHandler(m.countEvents(cluster, kind, handler)); err != nil {
+		return err
+	}
+
+	m.cachesMu.Lock()
+	defer m.cachesMu.Unlock()
+
+	if m.caches[cluster.Name] == nil {
+		m.caches[cluster.Name] = make(map[string][]cache.Store)
+	}
+	// the services are cached by an informer per namespace when the namespaces are filtered
+	m.caches[cluster.Name][kind] = append(m.caches[cluster.Name][kind], informer.GetStore())
+
+	return nil
+}
+
+// untrackCaches stops reporting the caches of the cluster called name, after its informers are stopped
+func (m *Manager) untrackCaches(name string) {
+	m.cachesMu.Lock()
+	defer m.cachesMu.Unlock()
+
+	delete(m.caches, name)
+}
+
+// CacheSizes returns the number of the objects in the informer caches of the running clusters, keyed by cluster
+// and kind
+func (m *Manager) CacheSizes() map[string]map[string]int {
+	m.cachesMu.Lock()
+	defer m.cachesMu.Unlock()
+
+	sizes := make(map[string]map[string]int, len(m.caches))
+	for name, kinds := range m.caches {
+		sizes[name] = make(map[string]int, len(kinds))
+		for kind, stores := range kinds {
+			for _, store := range stores {
+				sizes[name][kind] += len(store.ListKeys())
+			}
+		}
+	}
+
+	return sizes
+}
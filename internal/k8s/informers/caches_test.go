@@ -0,0 +1,48 @@
+package informers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/options"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestCacheSizes(t *testing.T) {
+	m := newTestManager(t, nil)
+	api := getFakeAPI()
+	for _, node := range []string{"node01", "node02"} {
+		_, err := api.createNode(node, "10.0.0.71", v1.ConditionTrue, true)
+		assert.Nil(t, err)
+	}
+	_, err := api.createService("cache-app", 30710, v1.ServiceTypeNodePort, true)
+	assert.Nil(t, err)
+	api.Namespace = "other"
+	_, err = api.createService("other-app", 30711, v1.ServiceTypeNodePort, false)
+	assert.Nil(t, err)
+
+	// the sizes of the namespaced service informers are summed
+	clusterOpts := &options.ClusterOptions{Name: "cluster-caches", NodeAddressTypes: options.DefaultNodeAddressTypes,
+		WorkerNodeSelector: "worker=true", IncludeNamespaces: []string{"default", "other"}}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		m.runCluster(ctx, clusterOpts, func() (*clusterConnection, error) {
+			return &clusterConnection{masterIP: "10.0.0.70", clientSet: api.ClientSet}, nil
+		}, nil)
+	}()
+
+	assert.Eventually(t, func() bool {
+		sizes := m.CacheSizes()["cluster-caches"]
+		return sizes["node"] == 2 && sizes["service"] == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the caches of a stopped cluster are not reported
+	cancel()
+	<-stopped
+	assert.Empty(t, m.CacheSizes())
+}
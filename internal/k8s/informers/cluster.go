@@ -0,0 +1,239 @@
+package informers
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/health"
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/k8s/types"
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/options"
+
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	gatewayclient "sigs.k8s.io/gateway-api/pkg/client/clientset/versioned"
+)
+
+// connectBackoff is the backoff between the connection attempts to a cluster
+var connectBackoff = wait.Backoff{
+	Duration: time.Second,
+	Factor:   2,
+	Jitter:   0.1,
+	Steps:    math.MaxInt32,
+	Cap:      2 * time.Minute,
+}
+
+// clusterConnection contains the clients of a cluster
+type clusterConnection struct {
+	masterIP         string
+	clientSet        kubernetes.Interface
+	gatewayClientSet gatewayclient.Interface
+}
+
+// connectCluster builds the clients of the cluster from clusterOpts
+func connectCluster(clusterOpts *options.ClusterOptions, enableGatewayAPI bool) (*clusterConnection, error) {
+	restConfig, err := GetConfig(clusterOpts)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get rest config from k8s client")
+	}
+
+	clientSet, err := GetClientSet(restConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get clientset from k8s client")
+	}
+
+	conn := &clusterConnection{masterIP: GetMasterIP(restConfig), clientSet: clientSet}
+	if enableGatewayAPI {
+		if conn.gatewayClientSet, err = GetGatewayClientSet(restConfig); err != nil {
+			return nil, errors.Wrap(err, "unable to get gateway clientset from k8s client")
+		}
+	}
+
+	return conn, nil
+}
+
+// RunCluster connects to the cluster and runs its informers with retries, then tracks its health until ctx is done.
+// onConnected is called with the clientset of the cluster once it is connected for the first time
+func (m *Manager) RunCluster(ctx context.Context, clusterOpts *options.ClusterOptions,
+	onConnected func(clientSet kubernetes.Interface)) {
+	m.runCluster(ctx, clusterOpts, func() (*clusterConnection, error) {
+		return connectCluster(clusterOpts, m.ncgo.EnableGatewayAPI)
+	}, onConnected)
+}
+
+func (m *Manager) runCluster(ctx context.Context, clusterOpts *options.ClusterOptions,
+	connect func() (*clusterConnection, error), onConnected func(clientSet kubernetes.Interface)) {
+	logger := m.logger.With(zap.String("cluster", clusterOpts.Name))
+	m.health.Set(clusterOpts.Name, health.StateSyncing, nil)
+
+	connected := false
+	backoff := connectBackoff
+	for {
+		// the informers of a failed attempt are stopped with its context
+		attemptCtx, cancel := context.WithCancel(ctx)
+		cluster, conn, err := m.startCluster(attemptCtx, clusterOpts, connect, logger,
+			func(clientSet kubernetes.Interface) {
+				if !connected && onConnected != nil {
+					onConnected(clientSet)
+				}
+				connected = true
+			})
+		if err == nil {
+			logger.Info("informers of the cluster are synced")
+			m.health.Set(clusterOpts.Name, health.StateSynced, nil)
+			m.watchClusterHealth(attemptCtx, cluster, conn.clientSet, logger)
+			cancel()
+			m.untrackCaches(clusterOpts.Name)
+			return
+		}
+
+		cancel()
+		m.untrackCaches(clusterOpts.Name)
+		// the last rendered upstreams are kept on shutdown
+		if ctx.Err() != nil {
+			return
+		}
+
+		if cluster != nil {
+			m.removeCluster(cluster, logger)
+		}
+
+		m.health.Set(clusterOpts.Name, health.StateDisconnected, err)
+		delay := backoff.Step()
+		logger.Warn("unable to start the cluster, retrying", zap.Duration("delay", delay),
+			zap.String("error", err.Error()))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// startCluster connects to the cluster and runs its informers until ctx is done, it returns after their caches are
+// synced. The cluster is also returned with the error if it is rendered before its informers failed
+func (m *Manager) startCluster(ctx context.Context, clusterOpts *options.ClusterOptions,
+	connect func() (*clusterConnection, error), logger *zap.Logger,
+	onConnected func(clientSet kubernetes.Interface)) (*types.Cluster, *clusterConnection, error) {
+	conn, err := connect()
+	if err == nil {
+		_, err = conn.clientSet.Discovery().ServerVersion()
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	logger.Info("connected to the cluster, syncing informers", zap.String("masterIP", conn.masterIP))
+	m.health.Set(clusterOpts.Name, health.StateSyncing, nil)
+	onConnected(conn.clientSet)
+
+	cluster := types.NewCluster(conn.masterIP, make([]*types.Worker, 0))
+	cluster.Name = clusterOpts.Name
+	m.nginxConf.AddCluster(cluster)
+
+	if err := m.runInformers(ctx, cluster, clusterOpts, conn, logger); err != nil {
+		return cluster, nil, errors.Wrap(err, "unable to run informers of the cluster")
+	}
+
+	return cluster, conn, nil
+}
+
+// removeCluster stops rendering cluster and releases the listen ports of its services, it is used after the informers
+// of cluster are failed and stopped, they are started again with a new cluster
+func (m *Manager) removeCluster(cluster *types.Cluster, logger *zap.Logger) {
+	m.nginxConf.RemoveCluster(cluster)
+	m.listenPorts.releasePrefix(clusterOwnerPrefix(cluster))
+	// the upstreams of the cluster may be rendered by the applies of its informers before they are failed
+	m.applyOrFail(logger)
+}
+
+// runInformers runs the informers of cluster until ctx is done and returns after their caches are synced
+func (m *Manager) runInformers(ctx context.Context, cluster *types.Cluster, clusterOpts *options.ClusterOptions,
+	conn *clusterConnection, logger *zap.Logger) error {
+	recorder := NewEventRecorder(conn.clientSet, logger, m.elector.IsLeader)
+	if err := m.RunNodeInformer(ctx, cluster, clusterOpts, conn.clientSet, recorder, logger); err != nil {
+		return err
+	}
+
+	serviceInformers, err := m.RunServiceInformer(ctx, cluster, clusterOpts, conn.clientSet, recorder, logger)
+	if err != nil {
+		return err
+	}
+
+	if conn.gatewayClientSet != nil {
+		if err := m.RunGatewayInformer(ctx, cluster, serviceInformers, conn.gatewayClientSet, logger); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// watchClusterHealth probes the API server of a synced cluster until ctx is done. The cluster is degraded while it
+// is unreachable and disconnected after DisconnectedClusterTimeout, which drops its upstreams if
+// DropDisconnectedUpstreams is set
+func (m *Manager) watchClusterHealth(ctx context.Context, cluster *types.Cluster, clientSet kubernetes.Interface,
+	logger *zap.Logger) {
+	ncgo := m.ncgo
+	ticker := time.NewTicker(ncgo.ClusterHealthCheckInterval)
+	defer ticker.Stop()
+
+	setDropped := func(dropped bool) {
+		cluster.Mu.Lock()
+		changed := cluster.Dropped != dropped
+		cluster.Dropped = dropped
+		cluster.Mu.Unlock()
+
+		if !changed {
+			return
+		}
+
+		logger.Info("upstreams of the cluster are toggled", zap.Bool("dropped", dropped))
+		trigger := Trigger{Cluster: cluster.Name, Kind: "cluster", Name: cluster.Name, Type: TriggerRestored}
+		if dropped {
+			trigger.Type = TriggerDropped
+		}
+		m.addTrigger(context.Background(), trigger, time.Time{})
+		m.applyOrFail(logger)
+	}
+
+	var unreachableSince time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := clientSet.Discovery().ServerVersion(); err != nil {
+			if unreachableSince.IsZero() {
+				unreachableSince = time.Now()
+				logger.Warn("cluster is unreachable", zap.String("error", err.Error()))
+			}
+
+			if time.Since(unreachableSince) < ncgo.DisconnectedClusterTimeout {
+				m.health.Set(cluster.Name, health.StateDegraded, err)
+				continue
+			}
+
+			m.health.Set(cluster.Name, health.StateDisconnected, err)
+			if ncgo.DropDisconnectedUpstreams {
+				setDropped(true)
+			}
+			continue
+		}
+
+		if !unreachableSince.IsZero() {
+			logger.Info("cluster is reachable again")
+			unreachableSince = time.Time{}
+		}
+
+		m.health.Set(cluster.Name, health.StateSynced, nil)
+		setDropped(false)
+	}
+}
@@ -0,0 +1,193 @@
+package informers
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/health"
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/options"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestRunCluster(t *testing.T) {
+	m := newTestManager(t, func(ncgo *options.NginxConfGeneratorOptions) {
+		ncgo.ClusterHealthCheckInterval = 50 * time.Millisecond
+		ncgo.DisconnectedClusterTimeout = 300 * time.Millisecond
+		ncgo.DropDisconnectedUpstreams = true
+	})
+
+	defaultBackoff := connectBackoff
+	connectBackoff = wait.Backoff{Duration: 10 * time.Millisecond, Factor: 2, Steps: 10, Cap: 50 * time.Millisecond}
+	defer func() {
+		connectBackoff = defaultBackoff
+	}()
+
+	api := getFakeAPI()
+	_, err := api.createNode("node01", "10.0.0.61", v1.ConditionTrue, true)
+	assert.Nil(t, err)
+	_, err = api.createService("cluster-app", 30600, v1.ServiceTypeNodePort, true)
+	assert.Nil(t, err)
+
+	// the API server is unreachable while unreachable is set
+	var unreachable atomic.Bool
+	unreachable.Store(true)
+	api.ClientSet.(k8stesting.FakeClient).PrependReactor("get", "version",
+		func(action k8stesting.Action) (bool, runtime.Object, error) {
+			if unreachable.Load() {
+				return true, nil, errors.New("connection refused")
+			}
+			return false, nil, nil
+		})
+
+	var attempts, connected atomic.Int32
+	connect := func() (*clusterConnection, error) {
+		if attempts.Add(1) < 3 {
+			return nil, errors.New("unable to read kubeconfig")
+		}
+		return &clusterConnection{masterIP: "10.0.0.60", clientSet: api.ClientSet}, nil
+	}
+
+	clusterOpts := &options.ClusterOptions{Name: "cluster-health", NodeAddressTypes: options.DefaultNodeAddressTypes,
+		WorkerNodeSelector: "worker=true"}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.runCluster(ctx, clusterOpts, connect, func(clientSet kubernetes.Interface) {
+		connected.Add(1)
+	})
+
+	state := func() health.State {
+		status, _ := m.health.Get(clusterOpts.Name)
+		return status.State
+	}
+
+	rendered := func() string {
+		content, _ := os.ReadFile(m.ncgo.TemplateOutputFile)
+		return string(content)
+	}
+
+	assert.Eventually(t, func() bool {
+		return state() == health.StateDisconnected && attempts.Load() > 3
+	}, 5*time.Second, 10*time.Millisecond)
+	status, _ := m.health.Get(clusterOpts.Name)
+	assert.Equal(t, "connection refused", status.Error)
+	assert.Equal(t, int32(0), connected.Load())
+
+	unreachable.Store(false)
+	assert.Eventually(t, func() bool {
+		return state() == health.StateSynced
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(1), connected.Load())
+	assert.Eventually(t, func() bool {
+		return containsAll(rendered(), "upstream 10.0.0.60_30600", "server 10.0.0.61:30600")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	unreachable.Store(true)
+	assert.Eventually(t, func() bool {
+		return state() == health.StateDegraded
+	}, 5*time.Second, 10*time.Millisecond)
+	// last known upstreams are kept while the cluster is degraded
+	assert.Contains(t, rendered(), "upstream 10.0.0.60_30600")
+
+	assert.Eventually(t, func() bool {
+		return state() == health.StateDisconnected
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		return !containsAll(rendered(), "upstream 10.0.0.60_30600")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	unreachable.Store(false)
+	assert.Eventually(t, func() bool {
+		return state() == health.StateSynced && containsAll(rendered(), "upstream 10.0.0.60_30600")
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(1), connected.Load())
+}
+
+func containsAll(s string, substrings ...string) bool {
+	for _, substring := range substrings {
+		if !strings.Contains(s, substring) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRunClusterInformersRetried(t *testing.T) {
+	m := newTestManager(t, func(ncgo *options.NginxConfGeneratorOptions) {
+		ncgo.ClusterHealthCheckInterval = 50 * time.Millisecond
+		ncgo.DisconnectedClusterTimeout = time.Minute
+	})
+
+	defaultBackoff, defaultTimeout := connectBackoff, cacheSyncTimeout
+	connectBackoff = wait.Backoff{Duration: 10 * time.Millisecond, Factor: 2, Steps: 10, Cap: 50 * time.Millisecond}
+	cacheSyncTimeout = 200 * time.Millisecond
+	defer func() {
+		connectBackoff, cacheSyncTimeout = defaultBackoff, defaultTimeout
+	}()
+
+	api := getFakeAPI()
+	_, err := api.createNode("node01", "10.0.0.71", v1.ConditionTrue, true)
+	assert.Nil(t, err)
+	_, err = api.createService("retried-app", 30700, v1.ServiceTypeNodePort, true)
+	assert.Nil(t, err)
+
+	// the services can not be listed while forbidden is set, e.g. before the RBAC rules are applied
+	var forbidden atomic.Bool
+	forbidden.Store(true)
+	api.ClientSet.(k8stesting.FakeClient).PrependReactor("list", "services",
+		func(action k8stesting.Action) (bool, runtime.Object, error) {
+			if forbidden.Load() {
+				return true, nil, errors.New("services is forbidden")
+			}
+			return false, nil, nil
+		})
+
+	var attempts, connected atomic.Int32
+	connect := func() (*clusterConnection, error) {
+		attempts.Add(1)
+		return &clusterConnection{masterIP: "10.0.0.70", clientSet: api.ClientSet}, nil
+	}
+
+	clusterOpts := &options.ClusterOptions{Name: "cluster-retried", NodeAddressTypes: options.DefaultNodeAddressTypes,
+		WorkerNodeSelector: "worker=true"}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.runCluster(ctx, clusterOpts, connect, func(clientSet kubernetes.Interface) {
+		connected.Add(1)
+	})
+
+	clusters := func() int {
+		return len(m.nginxConf.ListClusters())
+	}
+
+	assert.Eventually(t, func() bool {
+		status, _ := m.health.Get(clusterOpts.Name)
+		return status.State == health.StateDisconnected && attempts.Load() > 1
+	}, 5*time.Second, 10*time.Millisecond)
+	status, _ := m.health.Get(clusterOpts.Name)
+	assert.Contains(t, status.Error, "unable to run informers of the cluster")
+	assert.LessOrEqual(t, clusters(), 1)
+
+	// the cluster is started again once its informers can be synced
+	forbidden.Store(false)
+	assert.Eventually(t, func() bool {
+		status, _ := m.health.Get(clusterOpts.Name)
+		return status.State == health.StateSynced
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		content, _ := os.ReadFile(m.ncgo.TemplateOutputFile)
+		return containsAll(string(content), "upstream 10.0.0.70_30700", "server 10.0.0.71:30700")
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1, clusters())
+	assert.Equal(t, int32(1), connected.Load())
+}
@@ -0,0 +1,77 @@
+package informers
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/options"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// GetConfig creates a rest.Config for the cluster and returns it. The cluster is accessed either with the service
+// account of the pod, a context of a kubeconfig file or a raw API server URL. Server, certificate and token settings
+// of clusterOpts override the ones which are loaded
+func GetConfig(clusterOpts *options.ClusterOptions) (*rest.Config, error) {
+	switch {
+	case clusterOpts.InCluster:
+		config, err := rest.InClusterConfig()
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to load in-cluster config")
+		}
+
+		return overrideConfig(config, clusterOpts), nil
+	case clusterOpts.KubeConfigPath != "":
+		// exec and auth provider plugins of the kubeconfig file are kept by clientcmd
+		overrides := &clientcmd.ConfigOverrides{
+			CurrentContext: clusterOpts.Context,
+			ClusterInfo: clientcmdapi.Cluster{
+				Server:               clusterOpts.Server,
+				CertificateAuthority: clusterOpts.CertificateAuthority,
+			},
+			AuthInfo: clientcmdapi.AuthInfo{
+				ClientCertificate: clusterOpts.ClientCertificate,
+				ClientKey:         clusterOpts.ClientKey,
+				TokenFile:         clusterOpts.TokenFile,
+			},
+		}
+
+		config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+			&clientcmd.ClientConfigLoadingRules{ExplicitPath: clusterOpts.KubeConfigPath}, overrides).ClientConfig()
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to load kubeconfig %s", clusterOpts.KubeConfigPath)
+		}
+
+		return config, nil
+	case clusterOpts.Server != "":
+		return overrideConfig(&rest.Config{}, clusterOpts), nil
+	default:
+		return nil, errors.New("none of inCluster, kubeConfigPath or server is set")
+	}
+}
+
+// overrideConfig overrides the server, certificate and token settings of config with the ones in clusterOpts
+func overrideConfig(config *rest.Config, clusterOpts *options.ClusterOptions) *rest.Config {
+	if clusterOpts.Server != "" {
+		config.Host = clusterOpts.Server
+	}
+
+	if clusterOpts.CertificateAuthority != "" {
+		config.TLSClientConfig.CAFile = clusterOpts.CertificateAuthority
+		config.TLSClientConfig.CAData = nil
+	}
+
+	if clusterOpts.ClientCertificate != "" {
+		config.TLSClientConfig.CertFile = clusterOpts.ClientCertificate
+		config.TLSClientConfig.KeyFile = clusterOpts.ClientKey
+	}
+
+	if clusterOpts.TokenFile != "" {
+		// the token is read from the file periodically, so rotated tokens are picked up
+		config.BearerTokenFile = clusterOpts.TokenFile
+		config.BearerToken = ""
+	}
+
+	return config
+}
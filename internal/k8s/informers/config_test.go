@@ -0,0 +1,105 @@
+package informers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/options"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const multiContextKubeConfig = `apiVersion: v1
+kind: Config
+clusters:
+- cluster:
+    server: https://10.0.0.1:6443
+  name: a
+- cluster:
+    server: https://10.0.0.2:6443
+  name: b
+contexts:
+- context:
+    cluster: a
+    user: static
+  name: a
+- context:
+    cluster: b
+    user: plugin
+  name: b
+current-context: a
+users:
+- name: static
+  user:
+    token: static-token
+- name: plugin
+  user:
+    exec:
+      apiVersion: client.authentication.k8s.io/v1
+      command: get-token
+      interactiveMode: Never
+`
+
+func TestGetConfig(t *testing.T) {
+	kubeConfigPath := filepath.Join(t.TempDir(), "kubeconfig")
+	assert.Nil(t, os.WriteFile(kubeConfigPath, []byte(multiContextKubeConfig), 0600))
+
+	config, err := GetConfig(&options.ClusterOptions{KubeConfigPath: kubeConfigPath})
+	assert.Nil(t, err)
+	assert.Equal(t, "https://10.0.0.1:6443", config.Host)
+	assert.Equal(t, "static-token", config.BearerToken)
+
+	// exec plugins are kept
+	config, err = GetConfig(&options.ClusterOptions{KubeConfigPath: kubeConfigPath, Context: "b"})
+	assert.Nil(t, err)
+	assert.Equal(t, "https://10.0.0.2:6443", config.Host)
+	assert.NotNil(t, config.ExecProvider)
+	assert.Equal(t, "get-token", config.ExecProvider.Command)
+
+	config, err = GetConfig(&options.ClusterOptions{KubeConfigPath: kubeConfigPath, Context: "a",
+		Server: "https://10.0.0.3:6443", TokenFile: "/var/run/token"})
+	assert.Nil(t, err)
+	assert.Equal(t, "https://10.0.0.3:6443", config.Host)
+	assert.Equal(t, "/var/run/token", config.BearerTokenFile)
+
+	config, err = GetConfig(&options.ClusterOptions{KubeConfigPath: kubeConfigPath, Context: "missing"})
+	assert.NotNil(t, err)
+	assert.Nil(t, config)
+}
+
+func TestGetConfigServer(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	assert.Nil(t, os.WriteFile(tokenFile, []byte("token"), 0600))
+
+	config, err := GetConfig(&options.ClusterOptions{
+		Server:               "https://10.0.0.1:6443",
+		CertificateAuthority: "../../../test/ca.crt",
+		ClientCertificate:    "../../../test/client.crt",
+		ClientKey:            "../../../test/client.key",
+		TokenFile:            tokenFile,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "https://10.0.0.1:6443", config.Host)
+	assert.Equal(t, "../../../test/ca.crt", config.CAFile)
+	assert.Equal(t, "../../../test/client.crt", config.CertFile)
+	assert.Equal(t, "../../../test/client.key", config.KeyFile)
+	assert.Equal(t, tokenFile, config.BearerTokenFile)
+
+	clientSet, err := GetClientSet(config)
+	assert.Nil(t, err)
+	assert.NotNil(t, clientSet)
+}
+
+func TestGetConfigInvalid(t *testing.T) {
+	// not running in a pod
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	t.Setenv("KUBERNETES_SERVICE_PORT", "")
+	config, err := GetConfig(&options.ClusterOptions{InCluster: true})
+	assert.NotNil(t, err)
+	assert.Nil(t, config)
+
+	config, err = GetConfig(&options.ClusterOptions{})
+	assert.NotNil(t, err)
+	assert.Nil(t, config)
+}
@@ -3,6 +3,7 @@ package informers
 const (
 	ErrRenderTemplate = "an error occurred while rendering template"
 	ErrReloadNginx    = "an error occurred while reloading Nginx service"
+	ErrValidateNginx  = "an error occurred while validating Nginx configuration"
 	ErrApplyChanges   = "fatal error occured while applying changes"
 	WarnWorkerLength  = "length of cluster.Workers is 0, can not add a server without any upstream server"
 )
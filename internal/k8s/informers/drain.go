@@ -0,0 +1,137 @@
+package informers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/k8s/types"
+
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// workerDrainer takes workers out of the upstreams of a cluster. Workers are rendered as down during the grace
+// period and removed after that, so that in-flight connections are not cut. Workers are removed right away when
+// the grace period is 0
+type workerDrainer struct {
+	cluster     *types.Cluster
+	gracePeriod time.Duration
+	recorder    record.EventRecorder
+	logger      *zap.Logger
+	// apply is called with the worker which is removed when its drain window expires
+	apply func(worker *types.Worker)
+	// windows are the drain windows of the workers by their host IPs, they are guarded by mu
+	windows map[string]*drainWindow
+	mu      sync.Mutex
+}
+
+// drainWindow is the drain window of a worker, a worker which is restored and drained again gets a new one
+type drainWindow struct {
+	timer *time.Timer
+}
+
+func newWorkerDrainer(cluster *types.Cluster, gracePeriod time.Duration, recorder record.EventRecorder,
+	logger *zap.Logger, apply func(worker *types.Worker)) *workerDrainer {
+	return &workerDrainer{
+		cluster:     cluster,
+		gracePeriod: gracePeriod,
+		recorder:    recorder,
+		logger:      logger,
+		apply:       apply,
+		windows:     make(map[string]*drainWindow),
+	}
+}
+
+// remove starts draining worker, returns true if the changes need to be applied
+func (d *workerDrainer) remove(worker *types.Worker) bool {
+	d.cluster.Mu.Lock()
+	defer d.cluster.Mu.Unlock()
+
+	index, found := findWorker(d.cluster.Workers, worker)
+	if !found {
+		return false
+	}
+
+	if d.gracePeriod == 0 {
+		d.removeLocked(index, worker)
+		return true
+	}
+
+	existing := d.cluster.Workers[index]
+	if existing.Draining {
+		return false
+	}
+
+	d.logger.Info("draining node, it is rendered as down until the grace period expires",
+		zap.String("node", existing.HostIP), zap.Duration("gracePeriod", d.gracePeriod))
+	existing.Draining = true
+	recordNodeEvent(d.recorder, worker, v1.EventTypeNormal, EventReasonNodeDraining,
+		"rendered as down in the upstreams for %s before it is removed", d.gracePeriod)
+	window := &drainWindow{}
+	d.mu.Lock()
+	// the timer can not fire before it is assigned, since expire waits for cluster.Mu which is held here
+	window.timer = time.AfterFunc(d.gracePeriod, func() {
+		d.expire(existing, window)
+	})
+	d.windows[existing.HostIP] = window
+	d.mu.Unlock()
+	return true
+}
+
+// restore cancels the drain of worker, returns true if the worker was draining and the changes need to be applied.
+// The worker is not rendered as down anymore, so that restoring it reloads Nginx once without removing it
+func (d *workerDrainer) restore(worker *types.Worker) bool {
+	d.cluster.Mu.Lock()
+	defer d.cluster.Mu.Unlock()
+
+	index, found := findWorker(d.cluster.Workers, worker)
+	if !found || !d.cluster.Workers[index].Draining {
+		return false
+	}
+
+	d.logger.Info("node is recovered during the drain window, restoring", zap.String("node", worker.HostIP))
+	d.cluster.Workers[index].Draining = false
+	recordNodeEvent(d.recorder, worker, v1.EventTypeNormal, EventReasonNodeAdded,
+		"restored in the upstreams during the drain grace period")
+	d.mu.Lock()
+	if window, ok := d.windows[worker.HostIP]; ok {
+		window.timer.Stop()
+		delete(d.windows, worker.HostIP)
+	}
+	d.mu.Unlock()
+	return true
+}
+
+// expire removes worker when window is over, unless it is restored in the meantime. A window which is replaced by
+// a newer drain of the worker is ignored, so that the newer drain is not cut short
+func (d *workerDrainer) expire(worker *types.Worker, window *drainWindow) {
+	d.cluster.Mu.Lock()
+	d.mu.Lock()
+	current := d.windows[worker.HostIP] == window
+	if current {
+		delete(d.windows, worker.HostIP)
+	}
+	d.mu.Unlock()
+
+	index, found := findWorker(d.cluster.Workers, worker)
+	removed := current && found && d.cluster.Workers[index].Draining
+	if removed {
+		d.logger.Info("drain window of the node is expired, removing from cluster.Workers",
+			zap.String("node", worker.HostIP))
+		d.removeLocked(index, worker)
+	}
+	d.cluster.Mu.Unlock()
+
+	if removed {
+		d.apply(worker)
+	}
+}
+
+// removeLocked removes worker from the cluster and its nodePorts, cluster.Mu must be held by the caller
+func (d *workerDrainer) removeLocked(index int, worker *types.Worker) {
+	d.cluster.Workers = append(d.cluster.Workers[:index], d.cluster.Workers[index+1:]...)
+	removeWorkerFromNodePorts(d.cluster.NodePorts, worker)
+	recordNodeEvent(d.recorder, worker, v1.EventTypeNormal, EventReasonNodeRemoved,
+		"removed from the upstreams")
+}
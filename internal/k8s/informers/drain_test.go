@@ -0,0 +1,106 @@
+package informers
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/k8s/types"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func getDrainCluster() (*types.Cluster, *types.Worker) {
+	worker := types.NewWorker("10.0.0.1", "10.0.0.44", v1.ConditionTrue)
+	worker.NodeName = "node01"
+	cluster := types.NewCluster("10.0.0.1", []*types.Worker{worker})
+	cluster.NodePorts = []*types.NodePort{{MasterIP: "10.0.0.1", Port: 30444, Workers: []*types.Worker{worker}}}
+	return cluster, worker
+}
+
+func TestWorkerDrainerExpire(t *testing.T) {
+	cluster, worker := getDrainCluster()
+	recorder := record.NewFakeRecorder(10)
+	var applied int32
+	drainer := newWorkerDrainer(cluster, 50*time.Millisecond, recorder, testLogger, func(*types.Worker) {
+		atomic.AddInt32(&applied, 1)
+	})
+
+	assert.True(t, drainer.remove(worker))
+	assert.True(t, cluster.Workers[0].Draining)
+	assert.False(t, drainer.remove(worker))
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&applied) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	cluster.Mu.Lock()
+	defer cluster.Mu.Unlock()
+	assert.Len(t, cluster.Workers, 0)
+	assert.Len(t, cluster.NodePorts[0].Workers, 0)
+	assert.Contains(t, <-recorder.Events, EventReasonNodeDraining)
+	assert.Contains(t, <-recorder.Events, EventReasonNodeRemoved)
+}
+
+func TestWorkerDrainerRestore(t *testing.T) {
+	cluster, worker := getDrainCluster()
+	recorder := record.NewFakeRecorder(10)
+	var applied int32
+	drainer := newWorkerDrainer(cluster, 50*time.Millisecond, recorder, testLogger, func(*types.Worker) {
+		atomic.AddInt32(&applied, 1)
+	})
+
+	assert.False(t, drainer.restore(worker))
+	assert.True(t, drainer.remove(worker))
+	assert.True(t, drainer.restore(worker))
+	assert.False(t, cluster.Workers[0].Draining)
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&applied))
+	assert.Len(t, cluster.Workers, 1)
+	assert.Contains(t, <-recorder.Events, EventReasonNodeDraining)
+	assert.Contains(t, <-recorder.Events, EventReasonNodeAdded)
+	assert.Len(t, recorder.Events, 0)
+}
+
+func TestWorkerDrainerStaleWindow(t *testing.T) {
+	cluster, worker := getDrainCluster()
+	recorder := record.NewFakeRecorder(10)
+	var applied int32
+	drainer := newWorkerDrainer(cluster, time.Hour, recorder, testLogger, func(*types.Worker) {
+		atomic.AddInt32(&applied, 1)
+	})
+
+	assert.True(t, drainer.remove(worker))
+	stale := drainer.windows[worker.HostIP]
+	assert.True(t, drainer.restore(worker))
+	assert.True(t, drainer.remove(worker))
+	current := drainer.windows[worker.HostIP]
+	defer current.timer.Stop()
+
+	// the expiry of the previous window, which was waiting for the lock, does not cut the new drain short
+	drainer.expire(worker, stale)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&applied))
+	assert.Len(t, cluster.Workers, 1)
+	assert.True(t, cluster.Workers[0].Draining)
+	assert.Equal(t, current, drainer.windows[worker.HostIP])
+
+	drainer.expire(worker, current)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&applied))
+	assert.Len(t, cluster.Workers, 0)
+	assert.Empty(t, drainer.windows)
+}
+
+func TestWorkerDrainerImmediate(t *testing.T) {
+	cluster, worker := getDrainCluster()
+	recorder := record.NewFakeRecorder(10)
+	drainer := newWorkerDrainer(cluster, 0, recorder, testLogger, func(*types.Worker) {})
+
+	assert.True(t, drainer.remove(worker))
+	assert.Len(t, cluster.Workers, 0)
+	assert.Len(t, cluster.NodePorts[0].Workers, 0)
+	assert.False(t, drainer.remove(worker))
+	assert.Contains(t, <-recorder.Events, EventReasonNodeRemoved)
+}
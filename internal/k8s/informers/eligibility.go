@@ -0,0 +1,90 @@
+package informers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/options"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// ExcludeFromExternalLoadBalancersLabel is the well known label which takes nodes out of external load balancers
+const ExcludeFromExternalLoadBalancersLabel = "node.kubernetes.io/exclude-from-external-load-balancers"
+
+// nodeEligibility contains the rules which decide if a node can receive traffic from Nginx
+type nodeEligibility struct {
+	excludeUnschedulable bool
+	taints               []v1.Taint
+	conditions           []v1.NodeConditionType
+}
+
+// newNodeEligibility parses the node eligibility rules from the command line options. Taints are in the form of
+// key[=value][:effect], value and effect match any taint when they are omitted
+func newNodeEligibility(ncgo *options.NginxConfGeneratorOptions) (*nodeEligibility, error) {
+	eligibility := &nodeEligibility{excludeUnschedulable: ncgo.ExcludeUnschedulableNodes}
+	for _, item := range strings.Split(ncgo.ExcludeNodeTaints, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+
+		var taint v1.Taint
+		if i := strings.LastIndex(item, ":"); i != -1 {
+			taint.Effect = v1.TaintEffect(item[i+1:])
+			item = item[:i]
+			switch taint.Effect {
+			case v1.TaintEffectNoSchedule, v1.TaintEffectPreferNoSchedule, v1.TaintEffectNoExecute:
+			default:
+				return nil, fmt.Errorf("invalid effect %q of excluded node taint %s", taint.Effect, item)
+			}
+		}
+
+		taint.Key, taint.Value, _ = strings.Cut(item, "=")
+		if taint.Key == "" {
+			return nil, fmt.Errorf("excluded node taint %q has no key", item)
+		}
+		eligibility.taints = append(eligibility.taints, taint)
+	}
+
+	for _, item := range strings.Split(ncgo.ExcludeNodeConditions, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			eligibility.conditions = append(eligibility.conditions, v1.NodeConditionType(item))
+		}
+	}
+
+	return eligibility, nil
+}
+
+// check returns true if node can receive traffic, otherwise the reason why it is excluded
+func (eligibility *nodeEligibility) check(node *v1.Node) (bool, string) {
+	if isNodeReady(node) != v1.ConditionTrue {
+		return false, "node is not in Ready status"
+	}
+
+	if eligibility.excludeUnschedulable && node.Spec.Unschedulable {
+		return false, "node is cordoned"
+	}
+
+	if _, ok := node.Labels[ExcludeFromExternalLoadBalancersLabel]; ok {
+		return false, fmt.Sprintf("node is labelled with %s", ExcludeFromExternalLoadBalancersLabel)
+	}
+
+	for _, excluded := range eligibility.taints {
+		for _, taint := range node.Spec.Taints {
+			if taint.Key == excluded.Key && (excluded.Value == "" || taint.Value == excluded.Value) &&
+				(excluded.Effect == "" || taint.Effect == excluded.Effect) {
+				return false, fmt.Sprintf("node is tainted with %s", taint.ToString())
+			}
+		}
+	}
+
+	for _, conditionType := range eligibility.conditions {
+		for _, condition := range node.Status.Conditions {
+			if condition.Type == conditionType && condition.Status == v1.ConditionTrue {
+				return false, fmt.Sprintf("node has %s condition", conditionType)
+			}
+		}
+	}
+
+	return true, ""
+}
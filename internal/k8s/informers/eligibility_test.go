@@ -0,0 +1,92 @@
+package informers
+
+import (
+	"testing"
+
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/options"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func getEligibilityNode(modify func(node *v1.Node)) *v1.Node {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node01", Labels: map[string]string{}},
+		Status: v1.NodeStatus{Conditions: []v1.NodeCondition{
+			{Type: v1.NodeReady, Status: v1.ConditionTrue},
+			{Type: v1.NodeNetworkUnavailable, Status: v1.ConditionFalse},
+		}},
+	}
+
+	if modify != nil {
+		modify(node)
+	}
+
+	return node
+}
+
+func TestNodeEligibility(t *testing.T) {
+	eligibility, err := newNodeEligibility(&options.NginxConfGeneratorOptions{
+		ExcludeUnschedulableNodes: true,
+		ExcludeNodeTaints:         "ToBeDeletedByClusterAutoscaler, dedicated=edge:NoSchedule",
+		ExcludeNodeConditions:     "NetworkUnavailable",
+	})
+	assert.Nil(t, err)
+
+	cases := []struct {
+		caseName         string
+		modify           func(node *v1.Node)
+		expectedEligible bool
+	}{
+		{"eligible", nil, true},
+		{"notReady", func(node *v1.Node) {
+			node.Status.Conditions[0].Status = v1.ConditionUnknown
+		}, false},
+		{"cordoned", func(node *v1.Node) {
+			node.Spec.Unschedulable = true
+		}, false},
+		{"excludeLabel", func(node *v1.Node) {
+			node.Labels[ExcludeFromExternalLoadBalancersLabel] = ""
+		}, false},
+		{"taintAnyValue", func(node *v1.Node) {
+			node.Spec.Taints = []v1.Taint{{Key: "ToBeDeletedByClusterAutoscaler", Value: "1700000000",
+				Effect: v1.TaintEffectNoSchedule}}
+		}, false},
+		{"taintValueAndEffect", func(node *v1.Node) {
+			node.Spec.Taints = []v1.Taint{{Key: "dedicated", Value: "edge", Effect: v1.TaintEffectNoSchedule}}
+		}, false},
+		{"taintOtherEffect", func(node *v1.Node) {
+			node.Spec.Taints = []v1.Taint{{Key: "dedicated", Value: "edge", Effect: v1.TaintEffectNoExecute}}
+		}, true},
+		{"networkUnavailable", func(node *v1.Node) {
+			node.Status.Conditions[1].Status = v1.ConditionTrue
+		}, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.caseName, func(t *testing.T) {
+			eligible, reason := eligibility.check(getEligibilityNode(tc.modify))
+			assert.Equal(t, tc.expectedEligible, eligible)
+			assert.Equal(t, tc.expectedEligible, reason == "")
+		})
+	}
+}
+
+func TestNodeEligibilityCordonAllowed(t *testing.T) {
+	eligibility, err := newNodeEligibility(&options.NginxConfGeneratorOptions{})
+	assert.Nil(t, err)
+
+	eligible, _ := eligibility.check(getEligibilityNode(func(node *v1.Node) {
+		node.Spec.Unschedulable = true
+	}))
+	assert.True(t, eligible)
+}
+
+func TestNewNodeEligibilityInvalid(t *testing.T) {
+	for _, taints := range []string{"key:Sometimes", "=value"} {
+		eligibility, err := newNodeEligibility(&options.NginxConfGeneratorOptions{ExcludeNodeTaints: taints})
+		assert.NotNil(t, err)
+		assert.Nil(t, eligibility)
+	}
+}
@@ -0,0 +1,97 @@
+package informers
+
+import (
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/k8s/types"
+
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	// EventComponent is the source component of the Kubernetes Events which are emitted by nginx-conf-generator
+	EventComponent = "nginx-conf-generator"
+	// EventReasonAccepted is emitted on services which are exposed through Nginx
+	EventReasonAccepted = "Accepted"
+	// EventReasonRejected is emitted on annotated services which can not be exposed through Nginx
+	EventReasonRejected = "Rejected"
+	// EventReasonInvalidAnnotation is emitted on services which have an annotation with an invalid value
+	EventReasonInvalidAnnotation = "InvalidAnnotation"
+	// EventReasonListenPortConflict is emitted on services which claim a listen port of another service
+	EventReasonListenPortConflict = "ListenPortConflict"
+	// EventReasonReservedListenPort is emitted on services which claim a reserved listen port
+	EventReasonReservedListenPort = "ReservedListenPort"
+	// EventReasonNodeAdded is emitted on nodes which are added to the upstreams
+	EventReasonNodeAdded = "AddedToUpstreams"
+	// EventReasonNodeDraining is emitted on nodes which are rendered as down during the drain grace period
+	EventReasonNodeDraining = "DrainingFromUpstreams"
+	// EventReasonNodeRemoved is emitted on nodes which are removed from the upstreams
+	EventReasonNodeRemoved = "RemovedFromUpstreams"
+)
+
+const (
+	// eventBurst is the number of Events which can be emitted at once for a single object
+	eventBurst = 25
+	// eventQPS is the rate which the Events of a single object are refilled after eventBurst is used up
+	eventQPS = 1. / 60.
+)
+
+// NewEventRecorder returns a record.EventRecorder which emits Kubernetes Events through clientSet. Events are
+// rate limited per object, so that flapping services and nodes do not flood the API server. Events are emitted only
+// while isLeader returns true
+func NewEventRecorder(clientSet kubernetes.Interface, logger *zap.Logger, isLeader func() bool) record.EventRecorder {
+	broadcaster := record.NewBroadcaster(record.WithCorrelatorOptions(record.CorrelatorOptions{
+		QPS:       eventQPS,
+		BurstSize: eventBurst,
+	}))
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientSet.CoreV1().Events("")})
+	broadcaster.StartLogging(func(format string, args ...interface{}) {
+		logger.Sugar().Debugf(format, args...)
+	})
+
+	return &leaderRecorder{EventRecorder: broadcaster.NewRecorder(scheme.Scheme,
+		v1.EventSource{Component: EventComponent}), isLeader: isLeader}
+}
+
+// leaderRecorder drops the Events when the replica is not the leader, so that each Event is emitted once
+type leaderRecorder struct {
+	record.EventRecorder
+	isLeader func() bool
+}
+
+func (recorder *leaderRecorder) Event(object runtime.Object, eventType, reason, message string) {
+	if recorder.isLeader() {
+		recorder.EventRecorder.Event(object, eventType, reason, message)
+	}
+}
+
+func (recorder *leaderRecorder) Eventf(object runtime.Object, eventType, reason, messageFmt string,
+	args ...interface{}) {
+	if recorder.isLeader() {
+		recorder.EventRecorder.Eventf(object, eventType, reason, messageFmt, args...)
+	}
+}
+
+func (recorder *leaderRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventType,
+	reason, messageFmt string, args ...interface{}) {
+	if recorder.isLeader() {
+		recorder.EventRecorder.AnnotatedEventf(object, annotations, eventType, reason, messageFmt, args...)
+	}
+}
+
+// recordNodeEvent emits an Event on the node of worker, workers which are not built from a node are skipped
+func recordNodeEvent(recorder record.EventRecorder, worker *types.Worker, eventType, reason, messageFmt string,
+	args ...interface{}) {
+	if worker.NodeName == "" {
+		return
+	}
+
+	// nodes are referenced by their names as UIDs, the same as kubelet does
+	reference := &v1.ObjectReference{Kind: "Node", Name: worker.NodeName, UID: k8stypes.UID(worker.NodeName)}
+	recorder.Eventf(reference, eventType, reason, messageFmt, args...)
+}
@@ -0,0 +1,96 @@
+package informers
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/k8s/types"
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/options"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func listEvents(t *testing.T, clientSet *fake.Clientset, namespace string) []v1.Event {
+	events, err := clientSet.CoreV1().Events(namespace).List(context.Background(), metav1.ListOptions{})
+	assert.Nil(t, err)
+	return events.Items
+}
+
+func TestRecordNodeEvent(t *testing.T) {
+	clientSet := fake.NewSimpleClientset()
+	recorder := NewEventRecorder(clientSet, testLogger, func() bool { return true })
+
+	worker := types.NewWorker("10.0.0.1", "10.0.0.44", v1.ConditionTrue)
+	recordNodeEvent(recorder, worker, v1.EventTypeNormal, EventReasonNodeAdded, "added")
+	worker.NodeName = "node01"
+	recordNodeEvent(recorder, worker, v1.EventTypeNormal, EventReasonNodeAdded, "added with address %s",
+		worker.HostIP)
+
+	assert.Eventually(t, func() bool {
+		return len(listEvents(t, clientSet, "default")) == 1
+	}, 5*time.Second, 100*time.Millisecond)
+
+	event := listEvents(t, clientSet, "default")[0]
+	assert.Equal(t, "Node", event.InvolvedObject.Kind)
+	assert.Equal(t, "node01", event.InvolvedObject.Name)
+	assert.Equal(t, EventReasonNodeAdded, event.Reason)
+	assert.Equal(t, "added with address 10.0.0.44", event.Message)
+	assert.Equal(t, EventComponent, event.Source.Component)
+}
+
+func TestEventRecorderRateLimit(t *testing.T) {
+	clientSet := fake.NewSimpleClientset()
+	recorder := NewEventRecorder(clientSet, testLogger, func() bool { return true })
+	service := getNodePortService("app", 8080, 30100)
+
+	for i := 0; i < eventBurst*2; i++ {
+		recorder.Eventf(service, v1.EventTypeWarning, fmt.Sprintf("Reason%d", i), "message %d", i)
+	}
+
+	assert.Eventually(t, func() bool {
+		return len(listEvents(t, clientSet, service.Namespace)) == eventBurst
+	}, 5*time.Second, 100*time.Millisecond)
+	time.Sleep(500 * time.Millisecond)
+	assert.Len(t, listEvents(t, clientSet, service.Namespace), eventBurst)
+}
+
+func TestRunServiceInformerEvents(t *testing.T) {
+	m := newTestManager(t, nil)
+
+	ctx := context.Background()
+	clientSet := fake.NewSimpleClientset()
+	worker := types.NewWorker("10.0.0.3", "10.0.0.44", v1.ConditionTrue)
+	cluster := types.NewCluster("10.0.0.3", []*types.Worker{worker})
+	m.nginxConf.Clusters = []*types.Cluster{cluster}
+	_, err := m.RunServiceInformer(parentCtx, cluster, &options.ClusterOptions{ExcludeNamespaces: []string{"team-x"}},
+		clientSet, NewEventRecorder(clientSet, testLogger, m.elector.IsLeader), testLogger)
+	assert.Nil(t, err)
+
+	accepted := getListenPortService("team-c", "accepted", 30300, "30300")
+	clusterIP := getListenPortService("team-c", "cluster-ip", 0, "")
+	clusterIP.Spec.Type = v1.ServiceTypeClusterIP
+	excluded := getListenPortService("team-x", "excluded", 30301, "30301")
+	for _, service := range []*v1.Service{accepted, clusterIP, excluded} {
+		_, err := clientSet.CoreV1().Services(service.Namespace).Create(ctx, service, metav1.CreateOptions{})
+		assert.Nil(t, err)
+	}
+
+	reasons := func(namespace string) map[string]string {
+		result := make(map[string]string)
+		for _, event := range listEvents(t, clientSet, namespace) {
+			result[event.InvolvedObject.Name] = event.Reason
+		}
+		return result
+	}
+
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual(map[string]string{"accepted": EventReasonAccepted,
+			"cluster-ip": EventReasonRejected}, reasons("team-c")) &&
+			assert.ObjectsAreEqual(map[string]string{"excluded": EventReasonRejected}, reasons("team-x"))
+	}, 5*time.Second, 100*time.Millisecond)
+}
@@ -0,0 +1,717 @@
+package informers
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/k8s/types"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+	gatewayv1alpha2 "sigs.k8s.io/gateway-api/apis/v1alpha2"
+)
+
+const (
+	gatewayGroup = gatewayv1.Group(gatewayv1.GroupName)
+	gatewayKind  = gatewayv1.Kind("Gateway")
+	serviceKind  = gatewayv1.Kind("Service")
+
+	// RouteConditionProgrammed is set on the route parents once the route is rendered and Nginx is reloaded
+	RouteConditionProgrammed gatewayv1.RouteConditionType = "Programmed"
+	// RouteReasonProgrammed is the reason of the RouteConditionProgrammed condition
+	RouteReasonProgrammed gatewayv1.RouteConditionReason = "Programmed"
+	// RouteReasonInvalid is the reason of the RouteConditionProgrammed condition when the rendered configuration is
+	// rejected by Nginx
+	RouteReasonInvalid gatewayv1.RouteConditionReason = "Invalid"
+)
+
+var invalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// routeParentResult is the outcome of attaching a route to one of its parentRefs
+type routeParentResult struct {
+	parentRef       gatewayv1.ParentReference
+	acceptedReason  gatewayv1.RouteConditionReason
+	acceptedMessage string
+	refsReason      gatewayv1.RouteConditionReason
+	refsMessage     string
+}
+
+// routeResult is the outcome of attaching a route to all of its parentRefs owned by this controller
+type routeResult struct {
+	kind            string
+	namespace, name string
+	generation      int64
+	parents         []*routeParentResult
+}
+
+// gatewayListenPort is a port which the listeners of the Gateways are rendered on with protocol
+type gatewayListenPort struct {
+	protocol gatewayv1.ProtocolType
+	port     int32
+}
+
+// gatewayBuilder builds a types.GatewayConf from the Gateway API resources of a single cluster
+type gatewayBuilder struct {
+	controllerName string
+	prefix         string
+	services       []corelisters.ServiceLister
+	conf           *types.GatewayConf
+	servers        map[string]*types.GatewayServer
+	locations      map[*types.GatewayServer]map[string]*types.GatewayLocation
+	upstreams      map[string]*types.GatewayUpstream
+	gateways       map[string]*gatewayv1.Gateway
+	results        []*routeResult
+	// rejectedPorts are the listen ports which are not rendered with the reasons, e.g. the ports of the services
+	rejectedPorts map[gatewayListenPort]string
+}
+
+// newGatewayBuilder creates a gatewayBuilder which resolves the backends through services, e.g. the listers of the
+// namespaced service informers
+func newGatewayBuilder(controllerName, masterIP string, services ...corelisters.ServiceLister) *gatewayBuilder {
+	return &gatewayBuilder{
+		controllerName: controllerName,
+		prefix:         sanitizeName(masterIP),
+		services:       services,
+		conf:           types.NewGatewayConf(),
+		servers:        make(map[string]*types.GatewayServer),
+		locations:      make(map[*types.GatewayServer]map[string]*types.GatewayLocation),
+		upstreams:      make(map[string]*types.GatewayUpstream),
+		gateways:       make(map[string]*gatewayv1.Gateway),
+	}
+}
+
+// sanitizeName converts the given string to something which is safe to use in nginx upstream and variable names
+func sanitizeName(name string) string {
+	return invalidNameChars.ReplaceAllString(name, "_")
+}
+
+// setGateways keeps the Gateways whose GatewayClass is managed by this controller
+func (b *gatewayBuilder) setGateways(classes []*gatewayv1.GatewayClass, gateways []*gatewayv1.Gateway) {
+	owned := make(map[string]bool)
+	for _, class := range classes {
+		if string(class.Spec.ControllerName) == b.controllerName {
+			owned[class.Name] = true
+		}
+	}
+
+	for _, gateway := range gateways {
+		if owned[string(gateway.Spec.GatewayClassName)] {
+			b.gateways[gateway.Namespace+"/"+gateway.Name] = gateway
+		}
+	}
+}
+
+// listenPorts returns the ports of the HTTP listeners and the TCP listeners if tcp is set of the owned Gateways, the
+// ports of the older Gateways first
+func (b *gatewayBuilder) listenPorts(tcp bool) []gatewayListenPort {
+	gateways := make([]*gatewayv1.Gateway, 0, len(b.gateways))
+	for _, gateway := range b.gateways {
+		gateways = append(gateways, gateway)
+	}
+	sort.Slice(gateways, func(i, j int) bool {
+		return olderThan(&gateways[i].ObjectMeta, &gateways[j].ObjectMeta)
+	})
+
+	var ports []gatewayListenPort
+	seen := make(map[gatewayListenPort]bool)
+	for _, gateway := range gateways {
+		for _, listener := range gateway.Spec.Listeners {
+			port := gatewayListenPort{protocol: listener.Protocol, port: int32(listener.Port)}
+			if (port.protocol != gatewayv1.HTTPProtocolType && (!tcp || port.protocol != gatewayv1.TCPProtocolType)) ||
+				seen[port] {
+				continue
+			}
+
+			seen[port] = true
+			ports = append(ports, port)
+		}
+	}
+
+	return ports
+}
+
+// rejectListenPorts makes the listeners on the ports of rejected unavailable to the routes with the reasons in it
+func (b *gatewayBuilder) rejectListenPorts(rejected map[gatewayListenPort]string) {
+	b.rejectedPorts = rejected
+}
+
+// addHTTPRoutes attaches the given HTTPRoutes to the listeners of owned Gateways
+func (b *gatewayBuilder) addHTTPRoutes(routes []*gatewayv1.HTTPRoute) {
+	sort.SliceStable(routes, func(i, j int) bool {
+		return olderThan(&routes[i].ObjectMeta, &routes[j].ObjectMeta)
+	})
+
+	for _, route := range routes {
+		result := &routeResult{kind: "HTTPRoute", namespace: route.Namespace, name: route.Name, generation: route.Generation}
+		for _, parentRef := range route.Spec.ParentRefs {
+			gateway, ok := b.parentGateway(route.Namespace, parentRef)
+			if !ok {
+				continue
+			}
+
+			parent := &routeParentResult{parentRef: parentRef}
+			result.parents = append(result.parents, parent)
+			listeners, reason, message := b.matchListeners(gateway, route.Namespace, parentRef, gatewayv1.HTTPProtocolType,
+				route.Spec.Hostnames)
+			if len(listeners) == 0 {
+				parent.acceptedReason, parent.acceptedMessage = reason, message
+				continue
+			}
+			parent.acceptedReason = gatewayv1.RouteReasonAccepted
+
+			parent.refsReason = gatewayv1.RouteReasonResolvedRefs
+			for i, rule := range route.Spec.Rules {
+				refs := make([]gatewayv1.BackendRef, 0, len(rule.BackendRefs))
+				for _, ref := range rule.BackendRefs {
+					refs = append(refs, ref.BackendRef)
+				}
+
+				upstream := fmt.Sprintf("%s_gw_%s_%s_%d", b.prefix, sanitizeName(route.Namespace),
+					sanitizeName(route.Name), i)
+				if reason, message := b.addUpstream(b.upstreams, &b.conf.Upstreams, upstream, route.Namespace,
+					refs); reason != gatewayv1.RouteReasonResolvedRefs {
+					parent.refsReason, parent.refsMessage = reason, message
+				}
+
+				if _, ok := b.upstreams[upstream]; !ok {
+					upstream = ""
+				}
+
+				for _, listener := range listeners {
+					for _, hostname := range listener.hostnames {
+						b.addRule(b.httpServer(listener.port, hostname), rule.Matches, upstream)
+					}
+				}
+			}
+		}
+
+		if len(result.parents) > 0 {
+			b.results = append(b.results, result)
+		}
+	}
+}
+
+// addTCPRoutes attaches the given TCPRoutes to the listeners of owned Gateways, first route wins on each listener
+func (b *gatewayBuilder) addTCPRoutes(routes []*gatewayv1alpha2.TCPRoute) {
+	sort.SliceStable(routes, func(i, j int) bool {
+		return olderThan(&routes[i].ObjectMeta, &routes[j].ObjectMeta)
+	})
+
+	streamUpstreams := make(map[string]*types.GatewayUpstream)
+	for _, route := range routes {
+		result := &routeResult{kind: "TCPRoute", namespace: route.Namespace, name: route.Name, generation: route.Generation}
+		for _, parentRef := range route.Spec.ParentRefs {
+			gateway, ok := b.parentGateway(route.Namespace, parentRef)
+			if !ok {
+				continue
+			}
+
+			parent := &routeParentResult{parentRef: parentRef}
+			result.parents = append(result.parents, parent)
+			listeners, reason, message := b.matchListeners(gateway, route.Namespace, parentRef, gatewayv1.TCPProtocolType,
+				nil)
+			if len(listeners) == 0 {
+				parent.acceptedReason, parent.acceptedMessage = reason, message
+				continue
+			}
+
+			var refs []gatewayv1.BackendRef
+			for _, rule := range route.Spec.Rules {
+				refs = append(refs, rule.BackendRefs...)
+			}
+
+			upstream := fmt.Sprintf("%s_tcp_%s_%s", b.prefix, sanitizeName(route.Namespace), sanitizeName(route.Name))
+			parent.refsReason, parent.refsMessage = b.addUpstream(streamUpstreams, &b.conf.StreamUpstreams, upstream,
+				route.Namespace, refs)
+
+			parent.acceptedReason = gatewayv1.RouteReasonAccepted
+			for _, listener := range listeners {
+				if b.tcpServer(listener.port) != nil {
+					parent.acceptedReason = gatewayv1.RouteReasonNotAllowedByListeners
+					parent.acceptedMessage = fmt.Sprintf("port %d is already claimed by another TCPRoute", listener.port)
+					continue
+				}
+
+				if _, ok := streamUpstreams[upstream]; ok {
+					b.conf.TCPServers = append(b.conf.TCPServers, &types.GatewayServer{Port: listener.port, Upstream: upstream})
+				}
+			}
+		}
+
+		if len(result.parents) > 0 {
+			b.results = append(b.results, result)
+		}
+	}
+}
+
+// parentGateway returns the owned Gateway which is referenced by parentRef
+func (b *gatewayBuilder) parentGateway(routeNamespace string, parentRef gatewayv1.ParentReference) (*gatewayv1.Gateway, bool) {
+	if parentRef.Group != nil && *parentRef.Group != gatewayGroup {
+		return nil, false
+	}
+
+	if parentRef.Kind != nil && *parentRef.Kind != gatewayKind {
+		return nil, false
+	}
+
+	namespace := routeNamespace
+	if parentRef.Namespace != nil {
+		namespace = string(*parentRef.Namespace)
+	}
+
+	gateway, ok := b.gateways[namespace+"/"+string(parentRef.Name)]
+	return gateway, ok
+}
+
+type matchedListener struct {
+	port      int32
+	hostnames []string
+}
+
+// matchListeners returns the listeners of gateway which the route can attach to, with the effective hostnames
+func (b *gatewayBuilder) matchListeners(gateway *gatewayv1.Gateway, routeNamespace string,
+	parentRef gatewayv1.ParentReference, protocol gatewayv1.ProtocolType,
+	routeHostnames []gatewayv1.Hostname) ([]*matchedListener, gatewayv1.RouteConditionReason, string) {
+	var matched []*matchedListener
+	reason, message := gatewayv1.RouteReasonNoMatchingParent, "no listener of the Gateway matches the parentRef"
+	for _, listener := range gateway.Spec.Listeners {
+		if parentRef.SectionName != nil && *parentRef.SectionName != listener.Name {
+			continue
+		}
+
+		if parentRef.Port != nil && *parentRef.Port != listener.Port {
+			continue
+		}
+
+		if listener.Protocol != protocol {
+			reason, message = gatewayv1.RouteReasonNotAllowedByListeners,
+				fmt.Sprintf("only %s listeners are supported for this route", protocol)
+			continue
+		}
+
+		if rejected, ok := b.rejectedPorts[gatewayListenPort{protocol: protocol, port: int32(listener.Port)}]; ok {
+			reason, message = gatewayv1.RouteReasonNotAllowedByListeners, rejected
+			continue
+		}
+
+		if !namespaceAllowed(listener.AllowedRoutes, gateway.Namespace, routeNamespace) {
+			reason, message = gatewayv1.RouteReasonNotAllowedByListeners,
+				"route namespace is not allowed by the listener"
+			continue
+		}
+
+		hostnames := intersectHostnames(listener.Hostname, routeHostnames)
+		if len(hostnames) == 0 {
+			reason, message = gatewayv1.RouteReasonNoMatchingListenerHostname,
+				"none of the route hostnames match the listener hostname"
+			continue
+		}
+
+		matched = append(matched, &matchedListener{port: int32(listener.Port), hostnames: hostnames})
+	}
+
+	return matched, reason, message
+}
+
+// namespaceAllowed checks the allowedRoutes of a listener, Selector is not supported and denies every namespace
+func namespaceAllowed(allowedRoutes *gatewayv1.AllowedRoutes, gatewayNamespace, routeNamespace string) bool {
+	from := gatewayv1.NamespacesFromSame
+	if allowedRoutes != nil && allowedRoutes.Namespaces != nil && allowedRoutes.Namespaces.From != nil {
+		from = *allowedRoutes.Namespaces.From
+	}
+
+	switch from {
+	case gatewayv1.NamespacesFromAll:
+		return true
+	case gatewayv1.NamespacesFromSame:
+		return gatewayNamespace == routeNamespace
+	default:
+		return false
+	}
+}
+
+// intersectHostnames returns the nginx server names which are served by both the listener and the route
+func intersectHostnames(listenerHostname *gatewayv1.Hostname, routeHostnames []gatewayv1.Hostname) []string {
+	if listenerHostname == nil || *listenerHostname == "" {
+		if len(routeHostnames) == 0 {
+			return []string{"_"}
+		}
+
+		hostnames := make([]string, 0, len(routeHostnames))
+		for _, hostname := range routeHostnames {
+			hostnames = append(hostnames, string(hostname))
+		}
+		return hostnames
+	}
+
+	if len(routeHostnames) == 0 {
+		return []string{string(*listenerHostname)}
+	}
+
+	var hostnames []string
+	for _, hostname := range routeHostnames {
+		switch {
+		case hostnameMatches(string(*listenerHostname), string(hostname)):
+			hostnames = append(hostnames, string(hostname))
+		case hostnameMatches(string(hostname), string(*listenerHostname)):
+			hostnames = append(hostnames, string(*listenerHostname))
+		}
+	}
+	return hostnames
+}
+
+// hostnameMatches checks if hostname is covered by pattern, which may contain a leading wildcard label
+func hostnameMatches(pattern, hostname string) bool {
+	if pattern == hostname {
+		return true
+	}
+
+	if !strings.HasPrefix(pattern, "*.") {
+		return false
+	}
+
+	return strings.HasSuffix(hostname, pattern[1:]) && !strings.HasPrefix(hostname, "*.")
+}
+
+// addUpstream resolves refs to NodePort backends and adds them as an upstream named name
+func (b *gatewayBuilder) addUpstream(upstreams map[string]*types.GatewayUpstream, list *[]*types.GatewayUpstream,
+	name, routeNamespace string, refs []gatewayv1.BackendRef) (gatewayv1.RouteConditionReason, string) {
+	upstream := &types.GatewayUpstream{Name: name}
+	reason, message := gatewayv1.RouteReasonResolvedRefs, ""
+	for _, ref := range refs {
+		nodePort, refReason, refMessage := b.resolveBackend(routeNamespace, ref.BackendObjectReference)
+		if refReason != gatewayv1.RouteReasonResolvedRefs {
+			reason, message = refReason, refMessage
+			continue
+		}
+
+		weight := int32(1)
+		if ref.Weight != nil {
+			weight = *ref.Weight
+		}
+
+		if weight == 0 {
+			continue
+		}
+
+		upstream.Backends = append(upstream.Backends, &types.GatewayBackend{NodePort: nodePort, Weight: weight})
+	}
+
+	if len(upstream.Backends) > 0 {
+		if _, ok := upstreams[name]; !ok {
+			upstreams[name] = upstream
+			*list = append(*list, upstream)
+		}
+	}
+
+	return reason, message
+}
+
+// resolveBackend returns the NodePort of the Service port which is referenced by ref
+func (b *gatewayBuilder) resolveBackend(routeNamespace string,
+	ref gatewayv1.BackendObjectReference) (int32, gatewayv1.RouteConditionReason, string) {
+	if (ref.Group != nil && *ref.Group != "") || (ref.Kind != nil && *ref.Kind != serviceKind) {
+		return 0, gatewayv1.RouteReasonInvalidKind, "only core Service backends are supported"
+	}
+
+	if ref.Namespace != nil && string(*ref.Namespace) != routeNamespace {
+		return 0, gatewayv1.RouteReasonRefNotPermitted, "cross namespace backendRefs are not supported"
+	}
+
+	if ref.Port == nil {
+		return 0, gatewayv1.RouteReasonUnsupportedValue, fmt.Sprintf("port of backend %s is not set", ref.Name)
+	}
+
+	var service *v1.Service
+	for _, services := range b.services {
+		if found, err := services.Services(routeNamespace).Get(string(ref.Name)); err == nil {
+			service = found
+			break
+		}
+	}
+
+	if service == nil {
+		return 0, gatewayv1.RouteReasonBackendNotFound, fmt.Sprintf("service %s not found", ref.Name)
+	}
+
+	for _, port := range service.Spec.Ports {
+		if port.Port == int32(*ref.Port) && port.NodePort != 0 {
+			return port.NodePort, gatewayv1.RouteReasonResolvedRefs, ""
+		}
+	}
+
+	return 0, gatewayv1.RouteReasonBackendNotFound, fmt.Sprintf("service %s has no NodePort for port %d",
+		ref.Name, *ref.Port)
+}
+
+// httpServer returns the http server which listens on port for hostname, creating it when necessary
+func (b *gatewayBuilder) httpServer(port int32, hostname string) *types.GatewayServer {
+	key := fmt.Sprintf("%d/%s", port, hostname)
+	server, ok := b.servers[key]
+	if !ok {
+		server = &types.GatewayServer{Port: port, ServerName: hostname}
+		b.servers[key] = server
+		b.locations[server] = make(map[string]*types.GatewayLocation)
+		b.conf.HTTPServers = append(b.conf.HTTPServers, server)
+	}
+
+	return server
+}
+
+// tcpServer returns the stream server which listens on port, nil if there is none
+func (b *gatewayBuilder) tcpServer(port int32) *types.GatewayServer {
+	for _, server := range b.conf.TCPServers {
+		if server.Port == port {
+			return server
+		}
+	}
+
+	return nil
+}
+
+// addRule adds the matches of a HTTPRoute rule to the locations of server
+func (b *gatewayBuilder) addRule(server *types.GatewayServer, matches []gatewayv1.HTTPRouteMatch, upstream string) {
+	if len(matches) == 0 {
+		matches = []gatewayv1.HTTPRouteMatch{{}}
+	}
+
+	for _, match := range matches {
+		for _, path := range locationPaths(match.Path) {
+			location, ok := b.locations[server][path]
+			if !ok {
+				location = &types.GatewayLocation{Path: path}
+				b.locations[server][path] = location
+				server.Locations = append(server.Locations, location)
+			}
+
+			// an empty upstream means none of the backends are resolved, the requests are answered with 500
+			source, pattern := matchExpression(match)
+			if source == "" {
+				// first rule wins, same as routes are sorted by creation timestamp
+				if location.Upstream == "" && !location.InvalidBackends {
+					location.Upstream = upstream
+					location.InvalidBackends = upstream == ""
+				}
+				continue
+			}
+
+			gatewayMatch := &types.GatewayMatch{
+				Variable: fmt.Sprintf("$ncg_%s_match_%d", b.prefix, len(b.conf.Matches)),
+				Source:   source,
+				Pattern:  pattern,
+				Upstream: upstream,
+			}
+			b.conf.Matches = append(b.conf.Matches, gatewayMatch)
+			location.Matches = append(location.Matches, gatewayMatch)
+		}
+	}
+}
+
+// locationPaths converts a HTTPPathMatch to nginx location parameters
+func locationPaths(path *gatewayv1.HTTPPathMatch) []string {
+	matchType, value := gatewayv1.PathMatchPathPrefix, "/"
+	if path != nil {
+		if path.Type != nil {
+			matchType = *path.Type
+		}
+
+		if path.Value != nil {
+			value = *path.Value
+		}
+	}
+
+	switch matchType {
+	case gatewayv1.PathMatchExact:
+		return []string{"= " + value}
+	case gatewayv1.PathMatchRegularExpression:
+		return []string{"~ " + quoteNginx(value)}
+	default:
+		trimmed := strings.TrimSuffix(value, "/")
+		if trimmed == "" {
+			return []string{"/"}
+		}
+
+		// Gateway API prefixes match on path elements, "/foo" must not match "/foobar"
+		return []string{"= " + trimmed, trimmed + "/"}
+	}
+}
+
+// matchExpression combines the method, header and query param matches into a nginx map source and pattern
+func matchExpression(match gatewayv1.HTTPRouteMatch) (string, string) {
+	var sources, patterns []string
+	if match.Method != nil {
+		sources = append(sources, "$request_method")
+		patterns = append(patterns, regexp.QuoteMeta(string(*match.Method)))
+	}
+
+	for _, header := range match.Headers {
+		sources = append(sources, "$http_"+strings.ToLower(sanitizeName(string(header.Name))))
+		patterns = append(patterns, matchPattern(header.Type != nil &&
+			*header.Type == gatewayv1.HeaderMatchRegularExpression, header.Value))
+	}
+
+	for _, param := range match.QueryParams {
+		sources = append(sources, "$arg_"+sanitizeName(string(param.Name)))
+		patterns = append(patterns, matchPattern(param.Type != nil &&
+			*param.Type == gatewayv1.QueryParamMatchRegularExpression, param.Value))
+	}
+
+	if len(sources) == 0 {
+		return "", ""
+	}
+
+	return quoteNginx(strings.Join(sources, "|")), quoteNginx("~^" + strings.Join(patterns, `\|`) + "$")
+}
+
+func matchPattern(isRegex bool, value string) string {
+	if isRegex {
+		return "(?:" + value + ")"
+	}
+
+	return regexp.QuoteMeta(value)
+}
+
+// quoteNginx wraps value in double quotes so that nginx reads it back as is
+func quoteNginx(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+func olderThan(a, b *metav1.ObjectMeta) bool {
+	if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+		return a.CreationTimestamp.Before(&b.CreationTimestamp)
+	}
+
+	return a.Namespace+"/"+a.Name < b.Namespace+"/"+b.Name
+}
+
+// build sorts the generated servers and locations for a stable output and returns the types.GatewayConf
+func (b *gatewayBuilder) build() *types.GatewayConf {
+	sort.SliceStable(b.conf.HTTPServers, func(i, j int) bool {
+		if b.conf.HTTPServers[i].Port != b.conf.HTTPServers[j].Port {
+			return b.conf.HTTPServers[i].Port < b.conf.HTTPServers[j].Port
+		}
+		return b.conf.HTTPServers[i].ServerName < b.conf.HTTPServers[j].ServerName
+	})
+
+	sort.SliceStable(b.conf.TCPServers, func(i, j int) bool {
+		return b.conf.TCPServers[i].Port < b.conf.TCPServers[j].Port
+	})
+
+	for _, server := range b.conf.HTTPServers {
+		for _, location := range server.Locations {
+			// matches with more conditions take precedence
+			sort.SliceStable(location.Matches, func(i, j int) bool {
+				return strings.Count(location.Matches[i].Source, "|") > strings.Count(location.Matches[j].Source, "|")
+			})
+		}
+	}
+
+	return b.conf
+}
+
+// routeParentStatuses merges the results of this controller into the existing parent statuses of a route
+func routeParentStatuses(existing []gatewayv1.RouteParentStatus, result *routeResult, controllerName string,
+	applyResult string) []gatewayv1.RouteParentStatus {
+	statuses := make([]gatewayv1.RouteParentStatus, 0, len(existing)+len(result.parents))
+	for _, status := range existing {
+		if string(status.ControllerName) != controllerName {
+			statuses = append(statuses, status)
+		}
+	}
+
+	for _, parent := range result.parents {
+		var conditions []metav1.Condition
+		for _, status := range existing {
+			if string(status.ControllerName) == controllerName && parentRefEquals(status.ParentRef, parent.parentRef) {
+				conditions = status.Conditions
+			}
+		}
+
+		accepted := parent.acceptedReason == gatewayv1.RouteReasonAccepted
+		setRouteCondition(&conditions, gatewayv1.RouteConditionAccepted, accepted, parent.acceptedReason,
+			parent.acceptedMessage, result.generation)
+		if accepted {
+			setRouteCondition(&conditions, gatewayv1.RouteConditionResolvedRefs,
+				parent.refsReason == gatewayv1.RouteReasonResolvedRefs, parent.refsReason, parent.refsMessage,
+				result.generation)
+		} else {
+			meta.RemoveStatusCondition(&conditions, string(gatewayv1.RouteConditionResolvedRefs))
+		}
+
+		programmed, programmedReason, programmedMessage := programmedCondition(applyResult)
+		if !accepted {
+			programmed, programmedReason = false, gatewayv1.RouteReasonPending
+			programmedMessage = "route is not rendered into the Nginx configuration"
+		}
+		setRouteCondition(&conditions, RouteConditionProgrammed, programmed, programmedReason, programmedMessage,
+			result.generation)
+
+		statuses = append(statuses, gatewayv1.RouteParentStatus{
+			ParentRef:      parent.parentRef,
+			ControllerName: gatewayv1.GatewayController(controllerName),
+			Conditions:     conditions,
+		})
+	}
+
+	return statuses
+}
+
+// programmedCondition returns the status, the reason and the message of the RouteConditionProgrammed condition of the
+// accepted routes which are rendered by the apply with applyResult
+func programmedCondition(applyResult string) (bool, gatewayv1.RouteConditionReason, string) {
+	switch applyResult {
+	case ApplyReloaded, ApplyUnchanged:
+		return true, RouteReasonProgrammed, ""
+	case ApplyInvalid:
+		return false, RouteReasonInvalid, "rendered Nginx configuration is rejected by nginx -t, the previous one is served"
+	case ApplyFailed:
+		return false, gatewayv1.RouteReasonPending, "Nginx configuration is not rendered or reloaded, it will be retried"
+	default:
+		return false, gatewayv1.RouteReasonPending, "route is not rendered into the Nginx configuration"
+	}
+}
+
+func setRouteCondition(conditions *[]metav1.Condition, conditionType gatewayv1.RouteConditionType, status bool,
+	reason gatewayv1.RouteConditionReason, message string, generation int64) {
+	conditionStatus := metav1.ConditionFalse
+	if status {
+		conditionStatus = metav1.ConditionTrue
+	}
+
+	meta.SetStatusCondition(conditions, metav1.Condition{
+		Type:               string(conditionType),
+		Status:             conditionStatus,
+		Reason:             string(reason),
+		Message:            message,
+		ObservedGeneration: generation,
+	})
+}
+
+func parentRefEquals(a, b gatewayv1.ParentReference) bool {
+	return a.Name == b.Name && equalPtr(a.Group, b.Group) && equalPtr(a.Kind, b.Kind) &&
+		equalPtr(a.Namespace, b.Namespace) && equalPtr(a.SectionName, b.SectionName) && equalPtr(a.Port, b.Port)
+}
+
+func equalPtr[T comparable](a, b *T) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return *a == *b
+}
+
+// serviceHasNodePort checks if the service exposes any NodePort which can be used as a Gateway backend
+func serviceHasNodePort(service *v1.Service) bool {
+	for _, port := range service.Spec.Ports {
+		if port.NodePort != 0 {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,351 @@
+package informers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/k8s/types"
+	"github.com/bilalcaliskan/nginx-conf-generator/pkg/model"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+	gatewayv1alpha2 "sigs.k8s.io/gateway-api/apis/v1alpha2"
+)
+
+const testControllerName = "github.com/bilalcaliskan/nginx-conf-generator"
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func getServiceLister(t *testing.T, services ...*v1.Service) corelisters.ServiceLister {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, service := range services {
+		assert.Nil(t, indexer.Add(service))
+	}
+	return corelisters.NewServiceLister(indexer)
+}
+
+func getNodePortService(name string, port, nodePort int32) *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: v1.ServiceSpec{
+			Type:  v1.ServiceTypeNodePort,
+			Ports: []v1.ServicePort{{Port: port, NodePort: nodePort}},
+		},
+	}
+}
+
+func getGateway(listeners ...gatewayv1.Listener) ([]*gatewayv1.GatewayClass, []*gatewayv1.Gateway) {
+	classes := []*gatewayv1.GatewayClass{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "ncg"},
+			Spec:       gatewayv1.GatewayClassSpec{ControllerName: testControllerName},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "other"},
+			Spec:       gatewayv1.GatewayClassSpec{ControllerName: "example.com/other"},
+		},
+	}
+
+	gateways := []*gatewayv1.Gateway{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "edge", Namespace: "default"},
+			Spec:       gatewayv1.GatewaySpec{GatewayClassName: "ncg", Listeners: listeners},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "foreign", Namespace: "default"},
+			Spec: gatewayv1.GatewaySpec{GatewayClassName: "other", Listeners: []gatewayv1.Listener{
+				{Name: "http", Port: 80, Protocol: gatewayv1.HTTPProtocolType},
+			}},
+		},
+	}
+
+	return classes, gateways
+}
+
+func getHTTPRoute(name string, hostnames []gatewayv1.Hostname, rules ...gatewayv1.HTTPRouteRule) *gatewayv1.HTTPRoute {
+	return &gatewayv1.HTTPRoute{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Generation: 1},
+		Spec: gatewayv1.HTTPRouteSpec{
+			CommonRouteSpec: gatewayv1.CommonRouteSpec{ParentRefs: []gatewayv1.ParentReference{{Name: "edge"}}},
+			Hostnames:       hostnames,
+			Rules:           rules,
+		},
+	}
+}
+
+func getBackendRef(name string, port int32, weight int32) gatewayv1.HTTPBackendRef {
+	return gatewayv1.HTTPBackendRef{BackendRef: gatewayv1.BackendRef{
+		BackendObjectReference: gatewayv1.BackendObjectReference{
+			Name: gatewayv1.ObjectName(name),
+			Port: ptr(gatewayv1.PortNumber(port)),
+		},
+		Weight: ptr(weight),
+	}}
+}
+
+func TestGatewayBuilderHTTPRoutes(t *testing.T) {
+	services := getServiceLister(t, getNodePortService("app-v1", 8080, 30100),
+		getNodePortService("app-v2", 8080, 30101))
+	classes, gateways := getGateway(gatewayv1.Listener{Name: "http", Port: 80, Protocol: gatewayv1.HTTPProtocolType,
+		Hostname: ptr(gatewayv1.Hostname("*.example.com"))})
+
+	route := getHTTPRoute("app", []gatewayv1.Hostname{"app.example.com", "app.other.com"},
+		gatewayv1.HTTPRouteRule{
+			Matches: []gatewayv1.HTTPRouteMatch{{
+				Path: &gatewayv1.HTTPPathMatch{Type: ptr(gatewayv1.PathMatchPathPrefix), Value: ptr("/api")},
+				Headers: []gatewayv1.HTTPHeaderMatch{
+					{Name: "X-Canary", Value: "true"},
+				},
+			}},
+			BackendRefs: []gatewayv1.HTTPBackendRef{getBackendRef("app-v2", 8080, 1)},
+		},
+		gatewayv1.HTTPRouteRule{
+			Matches: []gatewayv1.HTTPRouteMatch{{
+				Path: &gatewayv1.HTTPPathMatch{Type: ptr(gatewayv1.PathMatchPathPrefix), Value: ptr("/api")},
+			}},
+			BackendRefs: []gatewayv1.HTTPBackendRef{getBackendRef("app-v1", 8080, 90),
+				getBackendRef("app-v2", 8080, 10)},
+		})
+
+	builder := newGatewayBuilder(testControllerName, "10.0.0.1", services)
+	builder.setGateways(classes, gateways)
+	builder.addHTTPRoutes([]*gatewayv1.HTTPRoute{route})
+	conf := builder.build()
+
+	assert.Len(t, conf.HTTPServers, 1)
+	server := conf.HTTPServers[0]
+	assert.Equal(t, int32(80), server.Port)
+	assert.Equal(t, "app.example.com", server.ServerName)
+	assert.Len(t, server.Locations, 2)
+	assert.Equal(t, "= /api", server.Locations[0].Path)
+	assert.Equal(t, "/api/", server.Locations[1].Path)
+
+	location := server.Locations[1]
+	assert.Equal(t, "10_0_0_1_gw_default_app_1", location.Upstream)
+	assert.Len(t, location.Matches, 1)
+	assert.Equal(t, "10_0_0_1_gw_default_app_0", location.Matches[0].Upstream)
+	assert.Equal(t, `"$http_x_canary"`, location.Matches[0].Source)
+	assert.Equal(t, `"~^true$"`, location.Matches[0].Pattern)
+
+	assert.Len(t, conf.Upstreams, 2)
+	assert.Equal(t, []*types.GatewayBackend{{NodePort: 30100, Weight: 90}, {NodePort: 30101, Weight: 10}},
+		conf.Upstreams[1].Backends)
+
+	assert.Len(t, builder.results, 1)
+	assert.Len(t, builder.results[0].parents, 1)
+	assert.Equal(t, gatewayv1.RouteReasonAccepted, builder.results[0].parents[0].acceptedReason)
+	assert.Equal(t, gatewayv1.RouteReasonResolvedRefs, builder.results[0].parents[0].refsReason)
+}
+
+func TestGatewayBuilderRejectedRoutes(t *testing.T) {
+	services := getServiceLister(t, getNodePortService("app", 8080, 30100))
+	classes, gateways := getGateway(gatewayv1.Listener{Name: "http", Port: 80, Protocol: gatewayv1.HTTPProtocolType,
+		Hostname: ptr(gatewayv1.Hostname("app.example.com"))})
+
+	cases := []struct {
+		caseName       string
+		route          *gatewayv1.HTTPRoute
+		acceptedReason gatewayv1.RouteConditionReason
+		refsReason     gatewayv1.RouteConditionReason
+	}{
+		{"hostnameMismatch", getHTTPRoute("a", []gatewayv1.Hostname{"other.example.com"}),
+			gatewayv1.RouteReasonNoMatchingListenerHostname, ""},
+		{"missingBackend", getHTTPRoute("b", nil, gatewayv1.HTTPRouteRule{
+			BackendRefs: []gatewayv1.HTTPBackendRef{getBackendRef("missing", 8080, 1)},
+		}), gatewayv1.RouteReasonAccepted, gatewayv1.RouteReasonBackendNotFound},
+		{"missingNodePort", getHTTPRoute("c", nil, gatewayv1.HTTPRouteRule{
+			BackendRefs: []gatewayv1.HTTPBackendRef{getBackendRef("app", 9090, 1)},
+		}), gatewayv1.RouteReasonAccepted, gatewayv1.RouteReasonBackendNotFound},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.caseName, func(t *testing.T) {
+			builder := newGatewayBuilder(testControllerName, "", services)
+			builder.setGateways(classes, gateways)
+			builder.addHTTPRoutes([]*gatewayv1.HTTPRoute{tc.route})
+			builder.build()
+
+			assert.Len(t, builder.results, 1)
+			parent := builder.results[0].parents[0]
+			assert.Equal(t, tc.acceptedReason, parent.acceptedReason)
+			assert.Equal(t, tc.refsReason, parent.refsReason)
+
+			statuses := routeParentStatuses(nil, builder.results[0], testControllerName, ApplyReloaded)
+			assert.Len(t, statuses, 1)
+			accepted := meta.FindStatusCondition(statuses[0].Conditions, string(gatewayv1.RouteConditionAccepted))
+			assert.NotNil(t, accepted)
+			assert.Equal(t, tc.acceptedReason == gatewayv1.RouteReasonAccepted,
+				accepted.Status == metav1.ConditionTrue)
+			assert.True(t, meta.IsStatusConditionTrue(statuses[0].Conditions,
+				string(RouteConditionProgrammed)) == (tc.acceptedReason == gatewayv1.RouteReasonAccepted))
+		})
+	}
+
+	// the requests of the rules without valid backends are answered with 500
+	builder := newGatewayBuilder(testControllerName, "", services)
+	builder.setGateways(classes, gateways)
+	builder.addHTTPRoutes([]*gatewayv1.HTTPRoute{getHTTPRoute("b", nil,
+		gatewayv1.HTTPRouteRule{
+			Matches:     []gatewayv1.HTTPRouteMatch{{Headers: []gatewayv1.HTTPHeaderMatch{{Name: "X-Canary", Value: "true"}}}},
+			BackendRefs: []gatewayv1.HTTPBackendRef{getBackendRef("missing", 8080, 1)},
+		},
+		gatewayv1.HTTPRouteRule{BackendRefs: []gatewayv1.HTTPBackendRef{getBackendRef("missing", 8080, 1)}},
+		gatewayv1.HTTPRouteRule{BackendRefs: []gatewayv1.HTTPBackendRef{getBackendRef("app", 8080, 1)}},
+	)})
+	location := builder.build().HTTPServers[0].Locations[0]
+	assert.Empty(t, location.Upstream)
+	assert.True(t, location.InvalidBackends)
+	assert.Len(t, location.Matches, 1)
+	assert.Empty(t, location.Matches[0].Upstream)
+
+	// the listeners on the rejected listen ports are not matched
+	builder = newGatewayBuilder(testControllerName, "", services)
+	builder.setGateways(classes, gateways)
+	assert.Equal(t, []gatewayListenPort{{protocol: gatewayv1.HTTPProtocolType, port: 80}}, builder.listenPorts(true))
+	builder.rejectListenPorts(map[gatewayListenPort]string{
+		{protocol: gatewayv1.HTTPProtocolType, port: 80}: "listen port 80 is already claimed by 10.0.0.1/default/app",
+	})
+	builder.addHTTPRoutes([]*gatewayv1.HTTPRoute{getHTTPRoute("d", nil, gatewayv1.HTTPRouteRule{
+		BackendRefs: []gatewayv1.HTTPBackendRef{getBackendRef("app", 8080, 1)},
+	})})
+	assert.Empty(t, builder.build().HTTPServers)
+	assert.Equal(t, gatewayv1.RouteReasonNotAllowedByListeners, builder.results[0].parents[0].acceptedReason)
+	assert.Equal(t, "listen port 80 is already claimed by 10.0.0.1/default/app",
+		builder.results[0].parents[0].acceptedMessage)
+
+	// routes of other controllers must be left untouched
+	builder = newGatewayBuilder(testControllerName, "", services)
+	builder.setGateways(classes, gateways)
+	foreign := getHTTPRoute("foreign", nil)
+	foreign.Spec.ParentRefs[0].Name = "foreign"
+	builder.addHTTPRoutes([]*gatewayv1.HTTPRoute{foreign})
+	assert.Empty(t, builder.results)
+}
+
+func TestRouteParentStatusesProgrammed(t *testing.T) {
+	services := getServiceLister(t, getNodePortService("app", 8080, 30100))
+	classes, gateways := getGateway(gatewayv1.Listener{Name: "http", Port: 80, Protocol: gatewayv1.HTTPProtocolType})
+	builder := newGatewayBuilder(testControllerName, "", services)
+	builder.setGateways(classes, gateways)
+	builder.addHTTPRoutes([]*gatewayv1.HTTPRoute{getHTTPRoute("app", nil, gatewayv1.HTTPRouteRule{
+		BackendRefs: []gatewayv1.HTTPBackendRef{getBackendRef("app", 8080, 1)},
+	})})
+	builder.build()
+
+	cases := []struct {
+		applyResult string
+		status      metav1.ConditionStatus
+		reason      gatewayv1.RouteConditionReason
+	}{
+		{ApplyReloaded, metav1.ConditionTrue, RouteReasonProgrammed},
+		{ApplyUnchanged, metav1.ConditionTrue, RouteReasonProgrammed},
+		{ApplyInvalid, metav1.ConditionFalse, RouteReasonInvalid},
+		{ApplyFailed, metav1.ConditionFalse, gatewayv1.RouteReasonPending},
+		{ApplySkipped, metav1.ConditionFalse, gatewayv1.RouteReasonPending},
+	}
+
+	var existing []gatewayv1.RouteParentStatus
+	for _, tc := range cases {
+		// the condition follows the result of each apply, e.g. it is not programmed anymore after an invalid one
+		existing = routeParentStatuses(existing, builder.results[0], testControllerName, tc.applyResult)
+		assert.Len(t, existing, 1)
+		programmed := meta.FindStatusCondition(existing[0].Conditions, string(RouteConditionProgrammed))
+		assert.NotNil(t, programmed, tc.applyResult)
+		assert.Equal(t, tc.status, programmed.Status, tc.applyResult)
+		assert.Equal(t, string(tc.reason), programmed.Reason, tc.applyResult)
+	}
+}
+
+func TestGatewayBuilderTCPRoutes(t *testing.T) {
+	services := getServiceLister(t, getNodePortService("db", 5432, 30200))
+	classes, gateways := getGateway(gatewayv1.Listener{Name: "db", Port: 5432, Protocol: gatewayv1.TCPProtocolType})
+
+	routes := []*gatewayv1alpha2.TCPRoute{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default"},
+			Spec: gatewayv1alpha2.TCPRouteSpec{
+				CommonRouteSpec: gatewayv1.CommonRouteSpec{ParentRefs: []gatewayv1.ParentReference{{Name: "edge"}}},
+				Rules: []gatewayv1alpha2.TCPRouteRule{{
+					BackendRefs: []gatewayv1.BackendRef{getBackendRef("db", 5432, 1).BackendRef},
+				}},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "db2", Namespace: "default"},
+			Spec: gatewayv1alpha2.TCPRouteSpec{
+				CommonRouteSpec: gatewayv1.CommonRouteSpec{ParentRefs: []gatewayv1.ParentReference{{Name: "edge"}}},
+				Rules: []gatewayv1alpha2.TCPRouteRule{{
+					BackendRefs: []gatewayv1.BackendRef{getBackendRef("db", 5432, 1).BackendRef},
+				}},
+			},
+		},
+	}
+
+	builder := newGatewayBuilder(testControllerName, "10.0.0.1", services)
+	builder.setGateways(classes, gateways)
+	// the ports of the TCP listeners are only claimed when the TCPRoutes are watched
+	assert.Empty(t, builder.listenPorts(false))
+	assert.Equal(t, []gatewayListenPort{{protocol: gatewayv1.TCPProtocolType, port: 5432}}, builder.listenPorts(true))
+	builder.addTCPRoutes(routes)
+	conf := builder.build()
+
+	assert.Len(t, conf.TCPServers, 1)
+	assert.Equal(t, int32(5432), conf.TCPServers[0].Port)
+	assert.Equal(t, "10_0_0_1_tcp_default_db", conf.TCPServers[0].Upstream)
+	assert.Len(t, conf.StreamUpstreams, 2)
+	assert.Len(t, builder.results, 2)
+	assert.Equal(t, gatewayv1.RouteReasonAccepted, builder.results[0].parents[0].acceptedReason)
+	assert.Equal(t, gatewayv1.RouteReasonNotAllowedByListeners, builder.results[1].parents[0].acceptedReason)
+}
+
+func TestRenderGatewayTemplate(t *testing.T) {
+	cluster := types.NewCluster("10.0.0.1", []*types.Worker{
+		types.NewWorker("10.0.0.1", "10.0.0.44", v1.ConditionTrue),
+	})
+	cluster.Gateway = &types.GatewayConf{
+		HTTPServers: []*types.GatewayServer{{Port: 80, ServerName: "app.example.com", Locations: []*types.GatewayLocation{
+			{Path: "/", Upstream: "up_1"},
+			{Path: "/invalid/", InvalidBackends: true, Matches: []*types.GatewayMatch{{Variable: "$ncg_match_0",
+				Source: `"$http_x_canary"`, Pattern: `"~^true$"`}}},
+			{Path: "/unmatched/"},
+		}}},
+		TCPServers: []*types.GatewayServer{{Port: 5432, Upstream: "up_2"}},
+		Upstreams: []*types.GatewayUpstream{{Name: "up_1", Backends: []*types.GatewayBackend{
+			{NodePort: 30100, Weight: 3},
+		}}},
+		StreamUpstreams: []*types.GatewayUpstream{{Name: "up_2", Backends: []*types.GatewayBackend{
+			{NodePort: 30200, Weight: 1},
+		}}},
+	}
+	snapshot := types.NewNginxConf([]*types.Cluster{cluster}).Snapshot()
+
+	httpFile := filepath.Join(t.TempDir(), "ncg.conf")
+	streamFile := filepath.Join(t.TempDir(), "ncg-stream.conf")
+	_, err := renderTemplate("../../../resources/ncg.conf.tmpl", httpFile, model.TemplateMain, snapshot)
+	assert.Nil(t, err)
+	_, err = renderTemplate("../../../resources/ncg.conf.tmpl", streamFile, model.TemplateStream, snapshot)
+	assert.Nil(t, err)
+
+	httpContent, err := os.ReadFile(httpFile)
+	assert.Nil(t, err)
+	assert.Contains(t, string(httpContent), "server_name app.example.com;")
+	assert.Contains(t, string(httpContent), "proxy_pass http://up_1;")
+	assert.Contains(t, string(httpContent), "server 10.0.0.44:30100 weight=3;")
+	assert.Regexp(t, `location /invalid/ \{\s+if \(\$ncg_match_0\) \{\s+return 500;\s+\}\s+return 500;`,
+		string(httpContent))
+	assert.Regexp(t, `location /unmatched/ \{\s+return 404;`, string(httpContent))
+	assert.NotContains(t, string(httpContent), "up_2")
+
+	streamContent, err := os.ReadFile(streamFile)
+	assert.Nil(t, err)
+	assert.Contains(t, string(streamContent), "proxy_pass up_2;")
+	assert.Contains(t, string(streamContent), "server 10.0.0.44:30200 weight=1;")
+}
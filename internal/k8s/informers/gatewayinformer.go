@@ -0,0 +1,373 @@
+package informers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/k8s/types"
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/options"
+
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+	gatewayv1alpha2 "sigs.k8s.io/gateway-api/apis/v1alpha2"
+	gatewayclient "sigs.k8s.io/gateway-api/pkg/client/clientset/versioned"
+	gatewayinformers "sigs.k8s.io/gateway-api/pkg/client/informers/externalversions"
+	gatewaylisters "sigs.k8s.io/gateway-api/pkg/client/listers/apis/v1"
+	gatewayv1alpha2listers "sigs.k8s.io/gateway-api/pkg/client/listers/apis/v1alpha2"
+)
+
+// gatewayController rebuilds the Gateway API part of a cluster whenever one of the watched resources changes
+type gatewayController struct {
+	cluster          *types.Cluster
+	manager          *Manager
+	ncgo             *options.NginxConfGeneratorOptions
+	logger           *zap.Logger
+	gatewayClientSet gatewayclient.Interface
+	classLister      gatewaylisters.GatewayClassLister
+	gatewayLister    gatewaylisters.GatewayLister
+	httpRouteLister  gatewaylisters.HTTPRouteLister
+	tcpRouteLister   gatewayv1alpha2listers.TCPRouteLister
+	serviceListers   []corelisters.ServiceLister
+	trigger          chan struct{}
+	// listenPorts are the owners of the listen ports which are claimed by the controller by their holders, they are
+	// only accessed by the rebuilds
+	listenPorts map[string]string
+}
+
+// RunGatewayInformer spins up a shared informer factory and fetch GatewayClass, Gateway, HTTPRoute and TCPRoute
+// events until ctx is done. TCPRoutes are only watched when a stream output file is configured. The backends are
+// resolved through serviceInformers, which are the synced informers of the service informer of the cluster
+func (m *Manager) RunGatewayInformer(ctx context.Context, cluster *types.Cluster,
+	serviceInformers []cache.SharedIndexInformer, gatewayClientSet gatewayclient.Interface, logger *zap.Logger) error {
+	logger = logger.Named("gateway")
+	ncgo := m.ncgo
+	gatewayInformerFactory := gatewayinformers.NewSharedInformerFactory(gatewayClientSet, time.Second*30)
+	controller := &gatewayController{
+		cluster:          cluster,
+		manager:          m,
+		ncgo:             ncgo,
+		logger:           logger,
+		gatewayClientSet: gatewayClientSet,
+		trigger:          make(chan struct{}, 1),
+		listenPorts:      make(map[string]string),
+	}
+
+	// only the spec changes, which increment the generation, are rebuilt and added to the triggers. The status
+	// updates, e.g. the ones which are written by the rebuild itself, would rebuild again otherwise
+	handler := eventHandler{
+		add: func(event *informerEvent, obj interface{}) {
+			event.changed()
+			controller.enqueue()
+		},
+		update: func(event *informerEvent, oldObj interface{}, newObj interface{}) {
+			oldMeta, oldErr := meta.Accessor(oldObj)
+			newMeta, newErr := meta.Accessor(newObj)
+			if oldErr == nil && newErr == nil && oldMeta.GetGeneration() == newMeta.GetGeneration() {
+				logger.Debug("generation is not changed, skipping")
+				return
+			}
+			event.changed()
+			controller.enqueue()
+		},
+		delete: func(event *informerEvent, obj interface{}) {
+			event.changed()
+			controller.enqueue()
+		},
+	}
+
+	serviceHandler := cache.FilteringResourceEventHandler{
+		FilterFunc: func(obj interface{}) bool {
+			service, ok := obj.(*v1.Service)
+			if !ok {
+				// tombstones of deleted services can not be checked, rebuild to be on the safe side
+				return true
+			}
+			return serviceHasNodePort(service)
+		},
+		// the service events are counted and added to the triggers by the service informer
+		Handler: cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				handler.add(nil, obj)
+			},
+			UpdateFunc: func(oldObj interface{}, newObj interface{}) {
+				// services have no generation, only their ports are used to resolve the backends
+				if equality.Semantic.DeepEqual(oldObj.(*v1.Service).Spec.Ports, newObj.(*v1.Service).Spec.Ports) {
+					return
+				}
+				controller.enqueue()
+			},
+			DeleteFunc: func(obj interface{}) {
+				handler.delete(nil, obj)
+			},
+		},
+	}
+
+	classInformer := gatewayInformerFactory.Gateway().V1().GatewayClasses()
+	gatewayInformer := gatewayInformerFactory.Gateway().V1().Gateways()
+	httpRouteInformer := gatewayInformerFactory.Gateway().V1().HTTPRoutes()
+	// registrations are the informers of the Gateway API resources by their kinds
+	registrations := map[string]cache.SharedIndexInformer{
+		"gatewayclass": classInformer.Informer(),
+		"gateway":      gatewayInformer.Informer(),
+		"httproute":    httpRouteInformer.Informer(),
+	}
+	if ncgo.StreamTemplateOutputFile != "" {
+		tcpRouteInformer := gatewayInformerFactory.Gateway().V1alpha2().TCPRoutes()
+		controller.tcpRouteLister = tcpRouteInformer.Lister()
+		registrations["tcproute"] = tcpRouteInformer.Informer()
+	}
+
+	for kind, informer := range registrations {
+		if err := m.addEventHandler(cluster, kind, informer, handler); err != nil {
+			return errors.Wrap(err, "unable to run gateway informer")
+		}
+	}
+
+	// the service informers are stopped with the cluster, so the handler does not outlive the controller
+	for _, serviceInformer := range serviceInformers {
+		if _, err := serviceInformer.AddEventHandler(serviceHandler); err != nil {
+			return errors.Wrap(err, "unable to run gateway informer")
+		}
+		controller.serviceListers = append(controller.serviceListers,
+			corelisters.NewServiceLister(serviceInformer.GetIndexer()))
+	}
+
+	controller.classLister = classInformer.Lister()
+	controller.gatewayLister = gatewayInformer.Lister()
+	controller.httpRouteLister = httpRouteInformer.Lister()
+
+	if err := m.startInformerFactory(ctx, gatewayInformerFactory); err != nil {
+		return errors.Wrap(err, "unable to run gateway informer")
+	}
+
+	wg := m.track()
+	if wg == nil {
+		return errors.New("unable to run gateway controller, shutting down")
+	}
+	// the controller is started again with a new cluster after a failure, so it stops rebuilding on leadership
+	unregister := m.elector.OnStartedLeading(controller.enqueue)
+	go func() {
+		defer unregister()
+		controller.run(ctx, wg)
+	}()
+	controller.enqueue()
+
+	return nil
+}
+
+// run rebuilds on each trigger until ctx is done, a pending rebuild is drained before it returns
+func (c *gatewayController) run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for {
+		select {
+		case <-c.trigger:
+			c.reconcile()
+		case <-ctx.Done():
+			select {
+			case <-c.trigger:
+				c.reconcile()
+			default:
+			}
+			return
+		}
+	}
+}
+
+// enqueue schedules a rebuild, bursts of events are coalesced into a single rebuild
+func (c *gatewayController) enqueue() {
+	select {
+	case c.trigger <- struct{}{}:
+	default:
+	}
+}
+
+func (c *gatewayController) reconcile() {
+	builder := newGatewayBuilder(c.ncgo.GatewayControllerName, c.cluster.MasterIP, c.serviceListers...)
+
+	classes, err := c.classLister.List(labels.Everything())
+	if err != nil {
+		c.logger.Error("an error occurred while listing gateway classes", zap.String("error", err.Error()))
+		return
+	}
+
+	gateways, err := c.gatewayLister.List(labels.Everything())
+	if err != nil {
+		c.logger.Error("an error occurred while listing gateways", zap.String("error", err.Error()))
+		return
+	}
+
+	httpRoutes, err := c.httpRouteLister.List(labels.Everything())
+	if err != nil {
+		c.logger.Error("an error occurred while listing http routes", zap.String("error", err.Error()))
+		return
+	}
+
+	var tcpRoutes []*gatewayv1alpha2.TCPRoute
+	if c.tcpRouteLister != nil {
+		if tcpRoutes, err = c.tcpRouteLister.List(labels.Everything()); err != nil {
+			c.logger.Error("an error occurred while listing tcp routes", zap.String("error", err.Error()))
+			return
+		}
+	}
+
+	builder.setGateways(classes, gateways)
+	builder.rejectListenPorts(c.claimListenPorts(builder.listenPorts(c.tcpRouteLister != nil)))
+	builder.addHTTPRoutes(httpRoutes)
+	builder.addTCPRoutes(tcpRoutes)
+	gatewayConf := builder.build()
+
+	c.cluster.Mu.Lock()
+	c.cluster.Gateway = gatewayConf
+	c.cluster.Mu.Unlock()
+
+	c.logger.Info("gateway configuration is rebuilt", zap.String("masterIP", c.cluster.MasterIP),
+		zap.Int("httpServers", len(gatewayConf.HTTPServers)), zap.Int("tcpServers", len(gatewayConf.TCPServers)))
+
+	applyResult, err := c.manager.apply()
+	if err != nil {
+		c.manager.fail(err, c.logger)
+	}
+
+	// statuses are written by the leader only, they are written again when the replica becomes the leader
+	if c.manager.elector.IsLeader() {
+		c.updateGatewayClassStatuses(classes)
+		c.updateRouteStatuses(builder.results, httpRoutes, tcpRoutes, applyResult)
+	}
+}
+
+// claimListenPorts claims ports in the listen port registry, so that they are not rendered by the services too, and
+// releases the ports of the removed listeners. The HTTP ports are shared by the Gateways of all clusters, since their
+// servers are distinguished by the hostnames. The ports which can not be claimed are returned with the reasons, they
+// are claimed again by a rebuild when they are released
+func (c *gatewayController) claimListenPorts(ports []gatewayListenPort) map[gatewayListenPort]string {
+	rejected := make(map[gatewayListenPort]string)
+	claimed := make(map[string]string, len(ports))
+	for _, port := range ports {
+		protocol := strings.ToLower(string(port.protocol))
+		holder := fmt.Sprintf("%sgateway/%s/%d", clusterOwnerPrefix(c.cluster), protocol, port.port)
+		owner := holder
+		if port.protocol == gatewayv1.HTTPProtocolType {
+			owner = fmt.Sprintf("gateway/%s/%d", protocol, port.port)
+		}
+
+		if isReservedListenPort(c.ncgo, port.port) {
+			rejected[port] = fmt.Sprintf("listen port %d is reserved", port.port)
+			continue
+		}
+
+		if current, ok := c.manager.listenPorts.claimShared(port.port, owner, holder, c.enqueue); !ok {
+			c.logger.Warn("listen port of the gateway listener is already claimed, skipping...",
+				zap.Int32("listenPort", port.port), zap.String("protocol", string(port.protocol)),
+				zap.String("owner", current))
+			rejected[port] = fmt.Sprintf("listen port %d is already claimed by %s, it will be retried when the "+
+				"port is released", port.port, current)
+			continue
+		}
+		claimed[holder] = owner
+	}
+
+	for holder, owner := range c.listenPorts {
+		if _, ok := claimed[holder]; !ok {
+			c.manager.listenPorts.releaseShared(owner, holder)
+		}
+	}
+	c.listenPorts = claimed
+
+	return rejected
+}
+
+// updateGatewayClassStatuses marks the GatewayClasses of this controller as Accepted
+func (c *gatewayController) updateGatewayClassStatuses(classes []*gatewayv1.GatewayClass) {
+	for _, class := range classes {
+		if string(class.Spec.ControllerName) != c.ncgo.GatewayControllerName {
+			continue
+		}
+
+		updated := class.DeepCopy()
+		meta.SetStatusCondition(&updated.Status.Conditions, metav1.Condition{
+			Type:               string(gatewayv1.GatewayClassConditionStatusAccepted),
+			Status:             metav1.ConditionTrue,
+			Reason:             string(gatewayv1.GatewayClassReasonAccepted),
+			ObservedGeneration: class.Generation,
+		})
+		if equality.Semantic.DeepEqual(class.Status, updated.Status) {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if _, err := c.gatewayClientSet.GatewayV1().GatewayClasses().UpdateStatus(ctx, updated,
+			metav1.UpdateOptions{}); err != nil {
+			c.logger.Error("an error occurred while updating gateway class status", zap.String("name", class.Name),
+				zap.String("error", err.Error()))
+		}
+		cancel()
+	}
+}
+
+// updateRouteStatuses writes the Accepted, ResolvedRefs and Programmed conditions of this controller to the routes,
+// the routes are programmed if the apply which rendered them has applyResult ApplyReloaded or ApplyUnchanged
+func (c *gatewayController) updateRouteStatuses(results []*routeResult, httpRoutes []*gatewayv1.HTTPRoute,
+	tcpRoutes []*gatewayv1alpha2.TCPRoute, applyResult string) {
+	byKey := make(map[string]*routeResult)
+	for _, result := range results {
+		byKey[result.kind+"/"+result.namespace+"/"+result.name] = result
+	}
+
+	resultFor := func(kind string, route metav1.Object) *routeResult {
+		if result, ok := byKey[kind+"/"+route.GetNamespace()+"/"+route.GetName()]; ok {
+			return result
+		}
+		// routes which are not attached anymore get the parent statuses of this controller removed
+		return &routeResult{kind: kind, namespace: route.GetNamespace(), name: route.GetName()}
+	}
+
+	for _, route := range httpRoutes {
+		parents := routeParentStatuses(route.Status.Parents, resultFor("HTTPRoute", route),
+			c.ncgo.GatewayControllerName, applyResult)
+		if equality.Semantic.DeepEqual(route.Status.Parents, parents) ||
+			(len(route.Status.Parents) == 0 && len(parents) == 0) {
+			continue
+		}
+
+		updated := route.DeepCopy()
+		updated.Status.Parents = parents
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if _, err := c.gatewayClientSet.GatewayV1().HTTPRoutes(route.Namespace).UpdateStatus(ctx, updated,
+			metav1.UpdateOptions{}); err != nil {
+			c.logger.Error("an error occurred while updating http route status", zap.String("name", route.Name),
+				zap.String("namespace", route.Namespace), zap.String("error", err.Error()))
+		}
+		cancel()
+	}
+
+	for _, route := range tcpRoutes {
+		parents := routeParentStatuses(route.Status.Parents, resultFor("TCPRoute", route),
+			c.ncgo.GatewayControllerName, applyResult)
+		if equality.Semantic.DeepEqual(route.Status.Parents, parents) ||
+			(len(route.Status.Parents) == 0 && len(parents) == 0) {
+			continue
+		}
+
+		updated := route.DeepCopy()
+		updated.Status.Parents = parents
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if _, err := c.gatewayClientSet.GatewayV1alpha2().TCPRoutes(route.Namespace).UpdateStatus(ctx, updated,
+			metav1.UpdateOptions{}); err != nil {
+			c.logger.Error("an error occurred while updating tcp route status", zap.String("name", route.Name),
+				zap.String("namespace", route.Namespace), zap.String("error", err.Error()))
+		}
+		cancel()
+	}
+}
@@ -0,0 +1,151 @@
+package informers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/k8s/types"
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/options"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+	gatewayfake "sigs.k8s.io/gateway-api/pkg/client/clientset/versioned/fake"
+)
+
+func TestRunGatewayInformer(t *testing.T) {
+	m := newTestManager(t, func(ncgo *options.NginxConfGeneratorOptions) {
+		ncgo.GatewayControllerName = testControllerName
+	})
+
+	classes, gateways := getGateway(gatewayv1.Listener{Name: "http", Port: 80, Protocol: gatewayv1.HTTPProtocolType})
+	route := getHTTPRoute("app", nil, gatewayv1.HTTPRouteRule{
+		BackendRefs: []gatewayv1.HTTPBackendRef{getBackendRef("app", 8080, 1)},
+	})
+
+	clientSet := fake.NewSimpleClientset(getNodePortService("app", 8080, 30100))
+	// objects are created through the typed client, the object tracker guesses the plural of Gateway wrong
+	gatewayClientSet := gatewayfake.NewSimpleClientset()
+	ctx := context.Background()
+	_, err := gatewayClientSet.GatewayV1().GatewayClasses().Create(ctx, classes[0], metav1.CreateOptions{})
+	assert.Nil(t, err)
+	_, err = gatewayClientSet.GatewayV1().Gateways("default").Create(ctx, gateways[0], metav1.CreateOptions{})
+	assert.Nil(t, err)
+	_, err = gatewayClientSet.GatewayV1().HTTPRoutes("default").Create(ctx, route, metav1.CreateOptions{})
+	assert.Nil(t, err)
+
+	cluster := types.NewCluster("10.0.0.1", []*types.Worker{
+		types.NewWorker("10.0.0.1", "10.0.0.44", v1.ConditionTrue),
+	})
+	m.nginxConf.Clusters = []*types.Cluster{cluster}
+
+	serviceInformers, err := m.RunServiceInformer(parentCtx, cluster, &options.ClusterOptions{}, clientSet,
+		NewEventRecorder(clientSet, testLogger, m.elector.IsLeader), testLogger)
+	assert.Nil(t, err)
+	err = m.RunGatewayInformer(parentCtx, cluster, serviceInformers, gatewayClientSet, testLogger)
+	assert.Nil(t, err)
+
+	assert.Eventually(t, func() bool {
+		updated, err := gatewayClientSet.GatewayV1().HTTPRoutes("default").Get(ctx, "app",
+			metav1.GetOptions{})
+		if err != nil || len(updated.Status.Parents) != 1 {
+			return false
+		}
+
+		conditions := updated.Status.Parents[0].Conditions
+		return meta.IsStatusConditionTrue(conditions, string(gatewayv1.RouteConditionAccepted)) &&
+			meta.IsStatusConditionTrue(conditions, string(gatewayv1.RouteConditionResolvedRefs)) &&
+			meta.IsStatusConditionTrue(conditions, string(RouteConditionProgrammed))
+	}, 10*time.Second, 100*time.Millisecond)
+
+	assert.Eventually(t, func() bool {
+		class, err := gatewayClientSet.GatewayV1().GatewayClasses().Get(ctx, "ncg", metav1.GetOptions{})
+		return err == nil && meta.IsStatusConditionTrue(class.Status.Conditions,
+			string(gatewayv1.GatewayClassConditionStatusAccepted))
+	}, 10*time.Second, 100*time.Millisecond)
+
+	cluster.Mu.Lock()
+	assert.NotNil(t, cluster.Gateway)
+	assert.Len(t, cluster.Gateway.HTTPServers, 1)
+	cluster.Mu.Unlock()
+
+	// the services are listed and watched once, by the informer which is shared with the service informer
+	var serviceLists int
+	for _, action := range clientSet.Actions() {
+		if action.GetResource().Resource == "services" && action.GetVerb() == "list" {
+			serviceLists++
+		}
+	}
+	assert.Equal(t, 1, serviceLists)
+
+	// the status and metadata updates do not change the generation, they are not rebuilt
+	applies := len(m.History())
+	updated, err := gatewayClientSet.GatewayV1().HTTPRoutes("default").Get(ctx, "app", metav1.GetOptions{})
+	assert.Nil(t, err)
+	updated.Labels = map[string]string{"team": "a"}
+	updated.Status.Parents[0].Conditions[0].Message = "written by another replica"
+	_, err = gatewayClientSet.GatewayV1().HTTPRoutes("default").UpdateStatus(ctx, updated, metav1.UpdateOptions{})
+	assert.Nil(t, err)
+	assert.Never(t, func() bool {
+		return len(m.History()) > applies
+	}, time.Second, 50*time.Millisecond)
+
+	// the fake clientset does not increment the generation on the spec changes
+	updated, err = gatewayClientSet.GatewayV1().HTTPRoutes("default").Get(ctx, "app", metav1.GetOptions{})
+	assert.Nil(t, err)
+	updated.Generation = 2
+	updated.Spec.Hostnames = []gatewayv1.Hostname{"app.example.com"}
+	_, err = gatewayClientSet.GatewayV1().HTTPRoutes("default").Update(ctx, updated, metav1.UpdateOptions{})
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		cluster.Mu.Lock()
+		defer cluster.Mu.Unlock()
+		return len(cluster.Gateway.HTTPServers) == 1 && cluster.Gateway.HTTPServers[0].ServerName == "app.example.com"
+	}, 10*time.Second, 100*time.Millisecond)
+	assert.Greater(t, len(m.History()), applies)
+}
+
+func TestGatewayControllerClaimListenPorts(t *testing.T) {
+	m := newTestManager(t, func(ncgo *options.NginxConfGeneratorOptions) {
+		ncgo.ReservedListenPorts = "22"
+	})
+	newController := func(masterIP string) *gatewayController {
+		return &gatewayController{cluster: types.NewCluster(masterIP, nil), manager: m, ncgo: m.ncgo,
+			logger: testLogger, trigger: make(chan struct{}, 1), listenPorts: make(map[string]string)}
+	}
+	first, second := newController("10.0.0.1"), newController("10.0.0.2")
+
+	http80 := gatewayListenPort{protocol: gatewayv1.HTTPProtocolType, port: 80}
+	http8080 := gatewayListenPort{protocol: gatewayv1.HTTPProtocolType, port: 8080}
+	tcp80 := gatewayListenPort{protocol: gatewayv1.TCPProtocolType, port: 80}
+	ssh := gatewayListenPort{protocol: gatewayv1.TCPProtocolType, port: 22}
+	_, ok := m.listenPorts.claim(8080, "10.0.0.1/default/app", nil)
+	assert.True(t, ok)
+
+	rejected := first.claimListenPorts([]gatewayListenPort{http80, http8080, ssh})
+	assert.Len(t, rejected, 2)
+	assert.Contains(t, rejected[http8080], "already claimed by 10.0.0.1/default/app")
+	assert.Equal(t, "listen port 22 is reserved", rejected[ssh])
+
+	// the HTTP listeners of the clusters share the port, the TCP listeners conflict with them
+	assert.Empty(t, second.claimListenPorts([]gatewayListenPort{http80}))
+	assert.Contains(t, second.claimListenPorts([]gatewayListenPort{tcp80})[tcp80], "already claimed by gateway/http/80")
+
+	// the port is released with the last HTTP listener on it, the rejected listeners are rebuilt then
+	assert.Empty(t, second.trigger)
+	assert.Len(t, first.claimListenPorts([]gatewayListenPort{http8080}), 1)
+	assert.Len(t, second.trigger, 1)
+	assert.Empty(t, second.claimListenPorts([]gatewayListenPort{tcp80}))
+
+	assert.Empty(t, first.trigger)
+	m.listenPorts.release("10.0.0.1/default/app")
+	assert.Len(t, first.trigger, 1)
+	assert.Empty(t, first.claimListenPorts([]gatewayListenPort{http8080}))
+	owner, ok := m.listenPorts.claim(8080, "10.0.0.1/default/app", func() {})
+	assert.False(t, ok)
+	assert.Equal(t, "gateway/http/8080", owner)
+}
@@ -0,0 +1,404 @@
+package informers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/k8s/types"
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/tracing"
+
+	"github.com/pkg/errors"
+	"github.com/pmezard/go-difflib/difflib"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// ApplyReloaded means the changed configuration is validated and Nginx is reloaded
+	ApplyReloaded = "reloaded"
+	// ApplyInvalid means the rendered configuration is rejected by nginx -t, the previous one is restored
+	ApplyInvalid = "invalid"
+	// ApplyFailed means the configuration is not rendered or the reload of Nginx failed
+	ApplyFailed = "failed"
+	// ApplyUnchanged means the rendered configuration is the same as the one which Nginx serves
+	ApplyUnchanged = "unchanged"
+	// ApplySkipped means the configuration is not rendered, e.g. by a follower of --leader-only-render
+	ApplySkipped = "skipped"
+	// defaultHistorySize is the number of the applies which are kept in the history when no size is configured
+	defaultHistorySize = 50
+	// maxTriggers is the number of the pending triggers which are kept until the next apply, the oldest are dropped
+	maxTriggers = 100
+	// maxDiffSize is the size in bytes which the diff of an apply is truncated to
+	maxDiffSize = 64 << 10
+)
+
+const (
+	// TriggerAdd is the trigger type of the informer add events
+	TriggerAdd = "add"
+	// TriggerUpdate is the trigger type of the informer update events
+	TriggerUpdate = "update"
+	// TriggerDelete is the trigger type of the informer delete events
+	TriggerDelete = "delete"
+	// TriggerDrained is the trigger type of the nodes which are removed when their drain window expires
+	TriggerDrained = "drained"
+	// TriggerDropped is the trigger type of the clusters whose upstreams are dropped while they are disconnected
+	TriggerDropped = "dropped"
+	// TriggerRestored is the trigger type of the clusters whose upstreams are restored after they are reconnected
+	TriggerRestored = "restored"
+	// TriggerElected is the trigger type of the reconciles after the replica becomes the leader
+	TriggerElected = "elected"
+)
+
+// Trigger is a change which is received since the previous apply, e.g. an informer event
+type Trigger struct {
+	Cluster   string `json:"cluster,omitempty"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Type      string `json:"type"`
+}
+
+// newTrigger returns the trigger of the informer event of eventType on obj, which may also be a tombstone
+func newTrigger(cluster *types.Cluster, kind, eventType string, obj interface{}) Trigger {
+	trigger := Trigger{Cluster: cluster.Name, Kind: kind, Type: eventType}
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		trigger.Namespace, trigger.Name, _ = cache.SplitMetaNamespaceKey(tombstone.Key)
+		return trigger
+	}
+
+	if object, err := meta.Accessor(obj); err == nil {
+		trigger.Namespace, trigger.Name = object.GetNamespace(), object.GetName()
+	}
+
+	return trigger
+}
+
+// isResync returns true if newObj is the same version of oldObj, which is the case on the periodic resyncs
+func isResync(oldObj, newObj interface{}) bool {
+	oldObject, err := meta.Accessor(oldObj)
+	if err != nil {
+		return false
+	}
+
+	newObject, err := meta.Accessor(newObj)
+	if err != nil {
+		return false
+	}
+
+	return oldObject.GetResourceVersion() != "" && oldObject.GetResourceVersion() == newObject.GetResourceVersion()
+}
+
+// ApplyRecord is an apply of the configuration, which is either reloaded, unchanged, rejected or failed
+type ApplyRecord struct {
+	ID   uint64    `json:"id"`
+	Time time.Time `json:"time"`
+	// Triggers are the changes which are received since the previous apply, the newest maxTriggers of them
+	Triggers        []Trigger `json:"triggers,omitempty"`
+	DroppedTriggers int       `json:"droppedTriggers,omitempty"`
+	// PreviousHash is the sha256 hash of the configuration before the apply, empty if it did not exist
+	PreviousHash string `json:"previousHash,omitempty"`
+	// Hash is the sha256 hash of the rendered configuration, which is not kept if it is invalid
+	Hash string `json:"hash"`
+	// Diff is the unified diff of the configuration files, it is truncated to maxDiffSize
+	Diff string `json:"diff,omitempty"`
+	// Validation is the output of nginx -t
+	Validation            string  `json:"validation,omitempty"`
+	Result                string  `json:"result"`
+	Error                 string  `json:"error,omitempty"`
+	ReloadDurationSeconds float64 `json:"reloadDurationSeconds,omitempty"`
+}
+
+// historyStore keeps the last applies in a ring, which is also persisted as one file per record in dir when it is
+// set. Each record is also appended to auditLogFile as a JSON line when it is set
+type historyStore struct {
+	size         int
+	dir          string
+	auditLogFile string
+	// records are the last size records, the oldest first
+	records []ApplyRecord
+	lastID  uint64
+	mu      sync.Mutex
+}
+
+func newHistoryStore(size int) *historyStore {
+	if size <= 0 {
+		size = defaultHistorySize
+	}
+
+	return &historyStore{size: size}
+}
+
+// open loads the records which are persisted in dir and checks that auditLogFile can be appended to
+func (s *historyStore) open(dir, auditLogFile string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if auditLogFile != "" {
+		file, err := os.OpenFile(auditLogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+		if err != nil {
+			return errors.Wrap(err, "unable to open audit log file")
+		}
+		_ = file.Close()
+	}
+	s.auditLogFile = auditLogFile
+
+	if dir == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return errors.Wrap(err, "unable to create history directory")
+	}
+
+	// entries are sorted by their names, which are the zero padded ids of the records
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return errors.Wrap(err, "unable to read history directory")
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if _, ok := recordID(entry.Name()); ok && entry.Type().IsRegular() {
+			files = append(files, entry.Name())
+		}
+	}
+
+	if len(files) > s.size {
+		for _, file := range files[:len(files)-s.size] {
+			if err := os.Remove(filepath.Join(dir, file)); err != nil && !os.IsNotExist(err) {
+				return errors.Wrap(err, "unable to remove history record")
+			}
+		}
+		files = files[len(files)-s.size:]
+	}
+
+	records := make([]ApplyRecord, 0, len(files))
+	for _, file := range files {
+		content, err := os.ReadFile(filepath.Join(dir, file))
+		if err != nil {
+			return errors.Wrap(err, "unable to read history record")
+		}
+
+		var record ApplyRecord
+		if err := json.Unmarshal(content, &record); err != nil {
+			return errors.Wrapf(err, "unable to parse history record %s", file)
+		}
+		records = append(records, record)
+	}
+
+	s.dir, s.records = dir, records
+	if len(records) > 0 {
+		s.lastID = records[len(records)-1].ID
+	}
+
+	return nil
+}
+
+// recordID returns the id of the record which is persisted in the file called name
+func recordID(name string) (uint64, bool) {
+	if !strings.HasSuffix(name, ".json") {
+		return 0, false
+	}
+
+	id, err := strconv.ParseUint(strings.TrimSuffix(name, ".json"), 10, 64)
+	return id, err == nil
+}
+
+func recordFile(id uint64) string {
+	return fmt.Sprintf("%020d.json", id)
+}
+
+// add assigns the next id to record and adds it to the ring, the oldest record is dropped when the ring is full. The
+// record is kept in memory even if it can not be persisted
+func (s *historyStore) add(record ApplyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastID++
+	record.ID = s.lastID
+	s.records = append(s.records, record)
+	var dropped []ApplyRecord
+	if len(s.records) > s.size {
+		dropped = s.records[:len(s.records)-s.size]
+		s.records = s.records[len(s.records)-s.size:]
+	}
+
+	content, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	if s.dir != "" {
+		if err := writeFileAtomic(filepath.Join(s.dir, recordFile(record.ID)), content); err != nil {
+			return errors.Wrap(err, "unable to write history record")
+		}
+
+		for _, old := range dropped {
+			if err := os.Remove(filepath.Join(s.dir, recordFile(old.ID))); err != nil && !os.IsNotExist(err) {
+				return errors.Wrap(err, "unable to remove history record")
+			}
+		}
+	}
+
+	if s.auditLogFile != "" {
+		if err := appendLine(s.auditLogFile, content); err != nil {
+			return errors.Wrap(err, "unable to write audit log")
+		}
+	}
+
+	return nil
+}
+
+// list returns the records in the ring, the newest first
+func (s *historyStore) list() []ApplyRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := make([]ApplyRecord, 0, len(s.records))
+	for i := len(s.records) - 1; i >= 0; i-- {
+		records = append(records, s.records[i])
+	}
+
+	return records
+}
+
+// appendLine appends content and a new line to the file called name, the file is opened on each call so that it can
+// be rotated by external tools
+func appendLine(name string, content []byte) error {
+	file, err := os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(append(content, '\n')); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// OpenHistory loads the reload history which is persisted in the history directory, it must be called before the
+// informers are started
+func (m *Manager) OpenHistory() error {
+	return m.history.open(m.ncgo.HistoryDir, m.ncgo.AuditLogFile)
+}
+
+// History returns the last applies of the configuration, the newest first
+func (m *Manager) History() []ApplyRecord {
+	return m.history.list()
+}
+
+// recordApply adds record to the history, a record which can not be persisted is only logged
+func (m *Manager) recordApply(record ApplyRecord) {
+	if err := m.history.add(record); err != nil {
+		m.logger.Error("unable to persist the apply record", zap.String("error", err.Error()))
+	}
+}
+
+// pendingTrigger is a trigger which waits for the next apply, queued is its span until it is taken by the apply.
+// changedAt is the time which the object of an informer event is changed at, it is zero for the other triggers
+type pendingTrigger struct {
+	Trigger
+	queued    trace.Span
+	changedAt time.Time
+}
+
+// addTrigger adds trigger to the pending triggers, which are recorded with the next apply. Its queue span is started
+// as a child of the span in ctx
+func (m *Manager) addTrigger(ctx context.Context, trigger Trigger, changedAt time.Time) {
+	_, queued := m.tracer.Start(ctx, tracing.SpanQueue, trace.WithAttributes(
+		tracing.ClusterKey.String(trigger.Cluster), tracing.KindKey.String(trigger.Kind),
+		tracing.EventTypeKey.String(trigger.Type)))
+
+	m.triggersMu.Lock()
+	defer m.triggersMu.Unlock()
+	m.triggers = append(m.triggers, pendingTrigger{Trigger: trigger, queued: queued, changedAt: changedAt})
+	if len(m.triggers) > maxTriggers {
+		dropped := m.triggers[:len(m.triggers)-maxTriggers]
+		for _, pending := range dropped {
+			pending.queued.End()
+		}
+		m.droppedTriggers += len(dropped)
+		m.triggers = m.triggers[len(m.triggers)-maxTriggers:]
+	}
+}
+
+// takeTriggers returns the pending triggers and the number of the dropped ones, and resets them. The queue spans of
+// the triggers are ended
+func (m *Manager) takeTriggers() ([]pendingTrigger, int) {
+	m.triggersMu.Lock()
+	defer m.triggersMu.Unlock()
+
+	for _, pending := range m.triggers {
+		pending.queued.End()
+	}
+
+	pending, dropped := m.triggers, m.droppedTriggers
+	m.triggers, m.droppedTriggers = nil, 0
+	return pending, dropped
+}
+
+// triggerList returns the triggers of pending
+func triggerList(pending []pendingTrigger) []Trigger {
+	var triggers []Trigger
+	for _, trigger := range pending {
+		triggers = append(triggers, trigger.Trigger)
+	}
+
+	return triggers
+}
+
+// queueLinks returns the links to the queue spans of pending
+func queueLinks(pending []pendingTrigger) []trace.Link {
+	var links []trace.Link
+	for _, trigger := range pending {
+		links = append(links, trace.Link{SpanContext: trigger.queued.SpanContext()})
+	}
+
+	return links
+}
+
+// diffOutputFiles returns the unified diff of the contents of files from previous to current, which are returned by
+// readOutputFiles. The diff is truncated to maxDiffSize
+func diffOutputFiles(files []string, previous, current map[string][]byte) string {
+	var diff strings.Builder
+	for _, file := range files {
+		fileDiff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        splitLines(previous[file]),
+			B:        splitLines(current[file]),
+			FromFile: file,
+			ToFile:   file,
+			Context:  3,
+		})
+		if err != nil {
+			continue
+		}
+		diff.WriteString(fileDiff)
+	}
+
+	if diff.Len() > maxDiffSize {
+		return diff.String()[:maxDiffSize] + "\n... diff is truncated\n"
+	}
+
+	return diff.String()
+}
+
+// splitLines splits content into its lines keeping their line endings, a missing or an empty file has no lines
+func splitLines(content []byte) []string {
+	lines := strings.SplitAfter(string(content), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines
+}
@@ -0,0 +1,123 @@
+package informers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/k8s/types"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestHistoryStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "history")
+	auditLogFile := filepath.Join(t.TempDir(), "audit.log")
+	store := newHistoryStore(3)
+	assert.Nil(t, store.open(dir, auditLogFile))
+
+	for _, result := range []string{ApplyReloaded, ApplyInvalid, ApplyReloaded, ApplyFailed} {
+		assert.Nil(t, store.add(ApplyRecord{Result: result}))
+	}
+
+	// the ring is bounded, the oldest record is dropped from the memory and the directory
+	records := store.list()
+	assert.Len(t, records, 3)
+	assert.Equal(t, uint64(4), records[0].ID)
+	assert.Equal(t, ApplyFailed, records[0].Result)
+	assert.Equal(t, uint64(2), records[2].ID)
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 3)
+
+	// each record is appended to the audit log
+	file, err := os.Open(auditLogFile)
+	assert.Nil(t, err)
+	defer file.Close()
+	var ids []uint64
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record ApplyRecord
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &record))
+		ids = append(ids, record.ID)
+	}
+	assert.Equal(t, []uint64{1, 2, 3, 4}, ids)
+
+	// the records are loaded again and the ids continue, a smaller ring drops the oldest records
+	reopened := newHistoryStore(2)
+	assert.Nil(t, reopened.open(dir, ""))
+	records = reopened.list()
+	assert.Len(t, records, 2)
+	assert.Equal(t, uint64(4), records[0].ID)
+	assert.Nil(t, reopened.add(ApplyRecord{Result: ApplyReloaded}))
+	assert.Equal(t, uint64(5), reopened.list()[0].ID)
+	entries, err = os.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 2)
+}
+
+func TestHistoryStoreInMemory(t *testing.T) {
+	store := newHistoryStore(0)
+	assert.Nil(t, store.open("", ""))
+	for i := 0; i < defaultHistorySize+1; i++ {
+		assert.Nil(t, store.add(ApplyRecord{Result: ApplyReloaded}))
+	}
+	assert.Len(t, store.list(), defaultHistorySize)
+}
+
+func TestHistoryStoreOpenErrors(t *testing.T) {
+	dir := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, recordFile(1)), []byte("{"), 0644))
+	assert.NotNil(t, newHistoryStore(0).open(dir, ""))
+
+	assert.NotNil(t, newHistoryStore(0).open("", filepath.Join(t.TempDir(), "missing", "audit.log")))
+}
+
+func TestAddTrigger(t *testing.T) {
+	m := newTestManager(t, nil)
+	for i := 0; i < maxTriggers+2; i++ {
+		m.addTrigger(context.Background(), Trigger{Kind: "node", Type: TriggerAdd}, time.Time{})
+	}
+
+	pending, dropped := m.takeTriggers()
+	assert.Len(t, triggerList(pending), maxTriggers)
+	assert.Len(t, queueLinks(pending), maxTriggers)
+	assert.Equal(t, 2, dropped)
+
+	pending, dropped = m.takeTriggers()
+	assert.Empty(t, pending)
+	assert.Zero(t, dropped)
+}
+
+func TestNewTrigger(t *testing.T) {
+	cluster := types.NewCluster("10.0.0.1", nil)
+	cluster.Name = "cluster-a"
+
+	service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "nginx"}}
+	assert.Equal(t, Trigger{Cluster: "cluster-a", Kind: "service", Namespace: "default", Name: "nginx",
+		Type: TriggerAdd}, newTrigger(cluster, "service", TriggerAdd, service))
+}
+
+func TestDiffOutputFiles(t *testing.T) {
+	files := []string{"ncg.conf", "stream.conf"}
+	previous := map[string][]byte{"ncg.conf": []byte("a\nb\n"), "stream.conf": nil}
+	current := map[string][]byte{"ncg.conf": []byte("a\nc\n"), "stream.conf": []byte("d\n")}
+
+	diff := diffOutputFiles(files, previous, current)
+	assert.Contains(t, diff, "--- ncg.conf\n+++ ncg.conf\n")
+	assert.Contains(t, diff, "-b\n+c\n")
+	assert.Contains(t, diff, "--- stream.conf\n+++ stream.conf\n@@ -0,0 +1 @@\n+d\n")
+
+	// unchanged files are not included
+	assert.Empty(t, diffOutputFiles(files, current, current))
+
+	large := map[string][]byte{"ncg.conf": []byte(strings.Repeat("line\n", maxDiffSize))}
+	assert.Contains(t, diffOutputFiles(files, previous, large), "diff is truncated")
+}
@@ -0,0 +1,211 @@
+package informers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/options"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// listenPortRegistry keeps track of the ports which Nginx listens on and assigns them to services and Gateway
+// listeners, a port is owned by the one which claims it first
+type listenPortRegistry struct {
+	owners  map[int32]string
+	claimed map[string]int32
+	// waiting contains the retry functions of the services which claimed an owned port
+	waiting map[int32]map[string]func()
+	// holders are the holders of the owners which are shared, e.g. the HTTP listeners of the Gateways of all
+	// clusters on the same port. A shared owner is released with its last holder
+	holders map[string]map[string]bool
+	mu      sync.Mutex
+}
+
+func newListenPortRegistry() *listenPortRegistry {
+	return &listenPortRegistry{
+		owners:  make(map[int32]string),
+		claimed: make(map[string]int32),
+		waiting: make(map[int32]map[string]func()),
+		holders: make(map[string]map[string]bool),
+	}
+}
+
+// claim assigns port to owner and releases the previous port of owner. If port is owned by someone else, the owner
+// of it is returned and retry is called when it is released
+func (registry *listenPortRegistry) claim(port int32, owner string, retry func()) (string, bool) {
+	registry.mu.Lock()
+	if current, ok := registry.owners[port]; ok && current != owner {
+		if registry.waiting[port] == nil {
+			registry.waiting[port] = make(map[string]func())
+		}
+		registry.waiting[port][owner] = retry
+		retries := registry.releaseLocked(owner)
+		registry.mu.Unlock()
+		runRetries(retries)
+		return current, false
+	}
+
+	var retries []func()
+	if previous, ok := registry.claimed[owner]; ok && previous != port {
+		retries = registry.releaseLocked(owner)
+	}
+	registry.owners[port] = owner
+	registry.claimed[owner] = port
+	delete(registry.waiting[port], owner)
+	registry.mu.Unlock()
+
+	runRetries(retries)
+	return owner, true
+}
+
+// claimShared assigns port to owner on behalf of holder, the port is owned until all of the holders of owner
+// release it. If port is owned by someone else, the owner of it is returned and retry is called when it is released
+func (registry *listenPortRegistry) claimShared(port int32, owner, holder string, retry func()) (string, bool) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	if current, ok := registry.owners[port]; ok && current != owner {
+		if registry.waiting[port] == nil {
+			registry.waiting[port] = make(map[string]func())
+		}
+		registry.waiting[port][holder] = retry
+		return current, false
+	}
+
+	registry.owners[port] = owner
+	registry.claimed[owner] = port
+	if registry.holders[owner] == nil {
+		registry.holders[owner] = make(map[string]bool)
+	}
+	registry.holders[owner][holder] = true
+	delete(registry.waiting[port], holder)
+
+	return owner, true
+}
+
+// releaseShared releases the claim of holder on owner, the port of owner is released with its last holder
+func (registry *listenPortRegistry) releaseShared(owner, holder string) {
+	registry.mu.Lock()
+	for _, waiting := range registry.waiting {
+		delete(waiting, holder)
+	}
+
+	var retries []func()
+	if holders, ok := registry.holders[owner]; ok && holders[holder] {
+		delete(holders, holder)
+		if len(holders) == 0 {
+			delete(registry.holders, owner)
+			retries = registry.releaseLocked(owner)
+		}
+	}
+	registry.mu.Unlock()
+
+	runRetries(retries)
+}
+
+// release releases the port of owner and retries the services which are waiting for it
+func (registry *listenPortRegistry) release(owner string) {
+	registry.mu.Lock()
+	for _, waiting := range registry.waiting {
+		delete(waiting, owner)
+	}
+	retries := registry.releaseLocked(owner)
+	registry.mu.Unlock()
+
+	runRetries(retries)
+}
+
+// releasePrefix releases the ports of the owners and the claims of the holders which start with prefix, e.g. the
+// services and the Gateway listeners of a cluster which is restarted, and retries the ones which are waiting for them
+func (registry *listenPortRegistry) releasePrefix(prefix string) {
+	registry.mu.Lock()
+	var retries []func()
+	for _, waiting := range registry.waiting {
+		for owner := range waiting {
+			if strings.HasPrefix(owner, prefix) {
+				delete(waiting, owner)
+			}
+		}
+	}
+	for owner, holders := range registry.holders {
+		for holder := range holders {
+			if strings.HasPrefix(holder, prefix) {
+				delete(holders, holder)
+			}
+		}
+
+		if len(holders) == 0 {
+			delete(registry.holders, owner)
+			retries = append(retries, registry.releaseLocked(owner)...)
+		}
+	}
+	for owner := range registry.claimed {
+		if strings.HasPrefix(owner, prefix) && registry.holders[owner] == nil {
+			retries = append(retries, registry.releaseLocked(owner)...)
+		}
+	}
+	registry.mu.Unlock()
+
+	runRetries(retries)
+}
+
+// releaseLocked releases the port of owner and returns the retry functions of the services waiting for it
+func (registry *listenPortRegistry) releaseLocked(owner string) []func() {
+	port, ok := registry.claimed[owner]
+	if !ok {
+		return nil
+	}
+
+	delete(registry.claimed, owner)
+	delete(registry.owners, port)
+
+	var retries []func()
+	for _, retry := range registry.waiting[port] {
+		retries = append(retries, retry)
+	}
+	delete(registry.waiting, port)
+
+	return retries
+}
+
+func runRetries(retries []func()) {
+	for _, retry := range retries {
+		retry()
+	}
+}
+
+// serviceListenPort returns the port which Nginx listens on for service, which is the NodePort unless it is
+// overridden with the listen port annotation
+func serviceListenPort(ncgo *options.NginxConfGeneratorOptions, service *v1.Service) (int32, error) {
+	value, ok := service.Annotations[ncgo.ListenPortAnnotation]
+	if !ok {
+		return service.Spec.Ports[0].NodePort, nil
+	}
+
+	port, err := strconv.ParseInt(value, 10, 32)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("value %q of annotation %s is not a valid port", value, ncgo.ListenPortAnnotation)
+	}
+
+	return int32(port), nil
+}
+
+// isReservedListenPort returns true if Nginx must not listen on port for services
+func isReservedListenPort(ncgo *options.NginxConfGeneratorOptions, port int32) bool {
+	if int(port) == ncgo.MetricsPort {
+		return true
+	}
+
+	// ReservedListenPorts is validated on startup
+	reserved, _ := options.ParsePorts(ncgo.ReservedListenPorts)
+	for _, item := range reserved {
+		if item == port {
+			return true
+		}
+	}
+
+	return false
+}
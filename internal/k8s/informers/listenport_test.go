@@ -0,0 +1,262 @@
+package informers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/k8s/types"
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/options"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestListenPortRegistry(t *testing.T) {
+	registry := newListenPortRegistry()
+	var retried []string
+
+	owner, ok := registry.claim(8080, "a", func() { retried = append(retried, "a") })
+	assert.True(t, ok)
+	assert.Equal(t, "a", owner)
+
+	owner, ok = registry.claim(8080, "b", func() { retried = append(retried, "b") })
+	assert.False(t, ok)
+	assert.Equal(t, "a", owner)
+
+	// claiming the same port again is a no-op
+	_, ok = registry.claim(8080, "a", nil)
+	assert.True(t, ok)
+	assert.Empty(t, retried)
+
+	// moving to another port releases the previous one
+	_, ok = registry.claim(8081, "a", nil)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"b"}, retried)
+
+	_, ok = registry.claim(8080, "b", nil)
+	assert.True(t, ok)
+
+	_, ok = registry.claim(8081, "c", func() { retried = append(retried, "c") })
+	assert.False(t, ok)
+	registry.release("a")
+	assert.Equal(t, []string{"b", "c"}, retried)
+	registry.release("a")
+	assert.Equal(t, []string{"b", "c"}, retried)
+}
+
+func TestListenPortRegistryShared(t *testing.T) {
+	registry := newListenPortRegistry()
+	var retried []string
+
+	// the holders of the same owner share the port
+	_, ok := registry.claimShared(80, "gateway/http/80", "10.0.0.1/gateway/http/80", nil)
+	assert.True(t, ok)
+	_, ok = registry.claimShared(80, "gateway/http/80", "10.0.0.2/gateway/http/80", nil)
+	assert.True(t, ok)
+
+	owner, ok := registry.claim(80, "10.0.0.1/default/app", func() { retried = append(retried, "app") })
+	assert.False(t, ok)
+	assert.Equal(t, "gateway/http/80", owner)
+	owner, ok = registry.claimShared(80, "10.0.0.1/gateway/tcp/80", "10.0.0.1/gateway/tcp/80",
+		func() { retried = append(retried, "tcp") })
+	assert.False(t, ok)
+	assert.Equal(t, "gateway/http/80", owner)
+
+	// the port is released with the last holder, releasing a holder twice is a no-op
+	registry.releaseShared("gateway/http/80", "10.0.0.1/gateway/http/80")
+	registry.releaseShared("gateway/http/80", "10.0.0.1/gateway/http/80")
+	assert.Empty(t, retried)
+	registry.releasePrefix("10.0.0.2/")
+	assert.ElementsMatch(t, []string{"app", "tcp"}, retried)
+
+	_, ok = registry.claimShared(80, "10.0.0.1/gateway/tcp/80", "10.0.0.1/gateway/tcp/80", nil)
+	assert.True(t, ok)
+	_, ok = registry.claim(80, "10.0.0.2/default/app", func() { retried = append(retried, "app") })
+	assert.False(t, ok)
+	registry.releasePrefix("10.0.0.1/")
+	assert.ElementsMatch(t, []string{"app", "tcp", "app"}, retried)
+	_, ok = registry.claim(80, "10.0.0.2/default/app", nil)
+	assert.True(t, ok)
+}
+
+func TestServiceListenPort(t *testing.T) {
+	ncgo := &options.NginxConfGeneratorOptions{ListenPortAnnotation: "nginx-conf-generator/listen-port",
+		ReservedListenPorts: "22, 443", MetricsPort: 5000}
+
+	cases := []struct {
+		caseName     string
+		annotations  map[string]string
+		expectedPort int32
+		expectedErr  bool
+	}{
+		{"nodePort", nil, 30100, false},
+		{"annotation", map[string]string{ncgo.ListenPortAnnotation: "8080"}, 8080, false},
+		{"notNumber", map[string]string{ncgo.ListenPortAnnotation: "http"}, 0, true},
+		{"outOfRange", map[string]string{ncgo.ListenPortAnnotation: "70000"}, 0, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.caseName, func(t *testing.T) {
+			service := getNodePortService("app", 8080, 30100)
+			service.Annotations = tc.annotations
+			port, err := serviceListenPort(ncgo, service)
+			assert.Equal(t, tc.expectedErr, err != nil)
+			assert.Equal(t, tc.expectedPort, port)
+		})
+	}
+
+	assert.True(t, isReservedListenPort(ncgo, 443))
+	assert.True(t, isReservedListenPort(ncgo, 5000))
+	assert.False(t, isReservedListenPort(ncgo, 8080))
+}
+
+func getListenPortService(namespace, name string, nodePort int32, listenPort string) *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Annotations: map[string]string{
+			opts.CustomAnnotation:     "true",
+			opts.ListenPortAnnotation: listenPort,
+		}},
+		Spec: v1.ServiceSpec{Type: v1.ServiceTypeNodePort, Ports: []v1.ServicePort{{Port: 8080, NodePort: nodePort}}},
+	}
+}
+
+func TestRunServiceInformerListenPort(t *testing.T) {
+	m := newTestManager(t, func(ncgo *options.NginxConfGeneratorOptions) {
+		ncgo.ReservedListenPorts = "22"
+	})
+
+	ctx := context.Background()
+	clientSet := fake.NewSimpleClientset()
+	worker := types.NewWorker("10.0.0.2", "10.0.0.44", v1.ConditionTrue)
+	cluster := types.NewCluster("10.0.0.2", []*types.Worker{worker})
+	m.nginxConf.Clusters = []*types.Cluster{cluster}
+	_, err := m.RunServiceInformer(parentCtx, cluster, &options.ClusterOptions{}, clientSet,
+		NewEventRecorder(clientSet, testLogger, m.elector.IsLeader), testLogger)
+	assert.Nil(t, err)
+
+	for _, service := range []*v1.Service{
+		getListenPortService("team-a", "app", 30200, "18080"),
+		getListenPortService("team-b", "app", 30201, "18080"),
+		getListenPortService("team-b", "ssh", 30202, "22"),
+		getListenPortService("team-b", "invalid", 30203, "http"),
+	} {
+		_, err := clientSet.CoreV1().Services(service.Namespace).Create(ctx, service, metav1.CreateOptions{})
+		assert.Nil(t, err)
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	listens := func() map[int32]int32 {
+		cluster.Mu.Lock()
+		defer cluster.Mu.Unlock()
+		result := make(map[int32]int32)
+		for _, nodePort := range cluster.NodePorts {
+			result[nodePort.Port] = nodePort.Listen()
+		}
+		return result
+	}
+
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual(map[int32]int32{30200: 18080}, listens())
+	}, 5*time.Second, 100*time.Millisecond)
+
+	reasons := func() map[string]bool {
+		result := make(map[string]bool)
+		events, err := clientSet.CoreV1().Events("team-b").List(ctx, metav1.ListOptions{})
+		assert.Nil(t, err)
+		for _, event := range events.Items {
+			result[event.InvolvedObject.Name+"/"+event.Reason] = true
+		}
+		return result
+	}
+
+	assert.Eventually(t, func() bool {
+		r := reasons()
+		return r["app/"+EventReasonListenPortConflict] && r["ssh/"+EventReasonReservedListenPort] &&
+			r["invalid/"+EventReasonInvalidAnnotation]
+	}, 5*time.Second, 100*time.Millisecond)
+
+	skipped := func() []string {
+		var result []string
+		for _, service := range cluster.Snapshot().Skipped {
+			result = append(result, service.Namespace+"/"+service.Name)
+		}
+		return result
+	}
+	assert.Equal(t, []string{"team-b/app", "team-b/invalid", "team-b/ssh"}, skipped())
+
+	// the waiting service takes over the listen port when it is released
+	assert.Nil(t, clientSet.CoreV1().Services("team-a").Delete(ctx, "app", metav1.DeleteOptions{}))
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual(map[int32]int32{30201: 18080}, listens())
+	}, 5*time.Second, 100*time.Millisecond)
+	assert.Equal(t, []string{"team-b/invalid", "team-b/ssh"}, skipped())
+}
+
+func TestRunServiceInformerListenPortChange(t *testing.T) {
+	m := newTestManager(t, func(ncgo *options.NginxConfGeneratorOptions) {
+		ncgo.ReservedListenPorts = "22"
+	})
+
+	ctx := context.Background()
+	clientSet := fake.NewSimpleClientset()
+	worker := types.NewWorker("10.0.0.2", "10.0.0.44", v1.ConditionTrue)
+	cluster := types.NewCluster("10.0.0.2", []*types.Worker{worker})
+	m.nginxConf.Clusters = []*types.Cluster{cluster}
+	_, err := m.RunServiceInformer(parentCtx, cluster, &options.ClusterOptions{}, clientSet,
+		NewEventRecorder(clientSet, testLogger, m.elector.IsLeader), testLogger)
+	assert.Nil(t, err)
+
+	listens := func() map[int32]int32 {
+		cluster.Mu.Lock()
+		defer cluster.Mu.Unlock()
+		result := make(map[int32]int32)
+		for _, nodePort := range cluster.NodePorts {
+			result[nodePort.Port] = nodePort.Listen()
+		}
+		return result
+	}
+
+	for _, service := range []*v1.Service{
+		getListenPortService("team-a", "app", 30200, "18080"),
+		getListenPortService("team-b", "app", 30201, "18080"),
+	} {
+		_, err := clientSet.CoreV1().Services(service.Namespace).Create(ctx, service, metav1.CreateOptions{})
+		assert.Nil(t, err)
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual(map[int32]int32{30200: 18080}, listens())
+	}, 5*time.Second, 100*time.Millisecond)
+
+	setListenPort := func(listenPort, version string) {
+		service := getListenPortService("team-a", "app", 30200, listenPort)
+		service.ResourceVersion = version
+		_, err := clientSet.CoreV1().Services("team-a").Update(ctx, service, metav1.UpdateOptions{})
+		assert.Nil(t, err)
+	}
+
+	// the rejected service is not rendered anymore and the waiting service takes over its listen port
+	setListenPort("22", "2")
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual(map[int32]int32{30201: 18080}, listens())
+	}, 5*time.Second, 100*time.Millisecond)
+
+	setListenPort("18081", "3")
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual(map[int32]int32{30200: 18081, 30201: 18080}, listens())
+	}, 5*time.Second, 100*time.Millisecond)
+
+	// the previous listen port is not rendered while the service waits for the claimed one
+	setListenPort("18080", "4")
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual(map[int32]int32{30201: 18080}, listens())
+	}, 5*time.Second, 100*time.Millisecond)
+
+	assert.Nil(t, clientSet.CoreV1().Services("team-b").Delete(ctx, "app", metav1.DeleteOptions{}))
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual(map[int32]int32{30200: 18080}, listens())
+	}, 5*time.Second, 100*time.Millisecond)
+}
@@ -0,0 +1,285 @@
+package informers
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/health"
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/k8s/types"
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/leader"
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/metrics"
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/options"
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/tracing"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"go.uber.org/zap"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Manager runs the informers of the clusters and renders their state into the Nginx configuration. Each Manager
+// has its own state, so that multiple of them can run in the same process
+type Manager struct {
+	ncgo      *options.NginxConfGeneratorOptions
+	logger    *zap.Logger
+	metrics   *metrics.Metrics
+	health    *health.Registry
+	tracer    trace.Tracer
+	elector   *leader.Elector
+	nginxConf *types.NginxConf
+	// listenPorts is shared between clusters since all of them are rendered to the same Nginx
+	listenPorts *listenPortRegistry
+	// mu is held while the configuration is rendered, nginxConf and its clusters are guarded by their own locks
+	mu sync.Mutex
+	// statusMu guards the fields below, which are read by the health checks and the API while mu is held by a render
+	statusMu       sync.Mutex
+	applied        bool
+	renderingSince time.Time
+	hash           string
+	appliedAt      time.Time
+	// history contains the last applies of the configuration
+	history *historyStore
+	// triggers are the changes which are received since the previous apply, they are guarded by triggersMu
+	triggers        []pendingTrigger
+	droppedTriggers int
+	triggersMu      sync.Mutex
+	// unapplied are the changes which are taken by the applies but not served by Nginx yet, they are guarded by mu
+	unapplied []change
+	// postApplyHooks are called with the hash of the configuration after each successful apply
+	postApplyHooks   []postApplyHook
+	postApplyHooksMu sync.Mutex
+	// caches are the stores of the informers of the running clusters by their kinds, they are guarded by cachesMu
+	caches   map[string]map[string][]cache.Store
+	cachesMu sync.Mutex
+	// running tracks the informer factories and controllers which must be stopped before the shutdown completes
+	running      sync.WaitGroup
+	shuttingDown bool
+	runningMu    sync.Mutex
+	// hooks tracks the post-apply hooks which are in progress, it is guarded by mu
+	hooks sync.WaitGroup
+	// stopped is set under mu once the shutdown is completed, no configuration is rendered after that
+	stopped bool
+	// failed is closed with err when the configuration can not be applied, the Manager can not recover from it
+	failed   chan struct{}
+	err      error
+	failOnce sync.Once
+}
+
+// postApplyHook is called with the hash of the configuration after each successful apply until its ctx is done
+type postApplyHook struct {
+	ctx context.Context
+	fn  func(hash string, appliedAt time.Time)
+}
+
+// NewManager creates a Manager which renders the configuration with ncgo, reports its state through m and
+// healthRegistry and writes to the clusters only while elector is the leader
+func NewManager(ncgo *options.NginxConfGeneratorOptions, logger *zap.Logger, m *metrics.Metrics,
+	healthRegistry *health.Registry, elector *leader.Elector) *Manager {
+	return &Manager{
+		ncgo:        ncgo,
+		logger:      logger.Named("informers"),
+		metrics:     m,
+		health:      healthRegistry,
+		tracer:      noop.NewTracerProvider().Tracer(tracing.TracerName),
+		elector:     elector,
+		nginxConf:   types.NewNginxConf(make([]*types.Cluster, 0)),
+		listenPorts: newListenPortRegistry(),
+		history:     newHistoryStore(ncgo.HistorySize),
+		caches:      make(map[string]map[string][]cache.Store),
+		failed:      make(chan struct{}),
+	}
+}
+
+// Failed returns a channel which is closed when the Manager can not apply the configuration anymore, e.g. when the
+// template can not be rendered or Nginx can not be reloaded. Err returns the reason after it is closed
+func (m *Manager) Failed() <-chan struct{} {
+	return m.failed
+}
+
+// Err returns the error which the Manager is failed with, it is nil until Failed is closed
+func (m *Manager) Err() error {
+	select {
+	case <-m.failed:
+		return m.err
+	default:
+		return nil
+	}
+}
+
+// fail closes Failed with err, the errors after the first one are only logged
+func (m *Manager) fail(err error, logger *zap.Logger) {
+	logger.Error(ErrApplyChanges, zap.String("error", err.Error()))
+	m.failOnce.Do(func() {
+		m.err = errors.Wrap(err, ErrApplyChanges)
+		close(m.failed)
+	})
+}
+
+// applyOrFail applies the changes and fails the Manager if they can not be applied
+func (m *Manager) applyOrFail(logger *zap.Logger) {
+	if err := m.applyChanges(); err != nil {
+		m.fail(err, logger)
+	}
+}
+
+// SetTracerProvider makes the Manager create its spans with provider, it must be called before the clusters are run
+func (m *Manager) SetTracerProvider(provider trace.TracerProvider) {
+	m.tracer = provider.Tracer(tracing.TracerName)
+}
+
+// ApplyStatus is the progress of the configuration applies of a Manager
+type ApplyStatus struct {
+	// Applied is true after the configuration is rendered and validated by Nginx successfully once
+	Applied bool
+	// RenderingSince is the start time of the in-progress apply, it is zero when no apply is in progress
+	RenderingSince time.Time
+	// Hash is the sha256 hash of the configuration which is applied last
+	Hash string
+	// AppliedAt is the time of the last successful apply
+	AppliedAt time.Time
+}
+
+// ApplyStatus returns the progress of the configuration applies
+func (m *Manager) ApplyStatus() ApplyStatus {
+	m.statusMu.Lock()
+	defer m.statusMu.Unlock()
+	return ApplyStatus{Applied: m.applied, RenderingSince: m.renderingSince, Hash: m.hash, AppliedAt: m.appliedAt}
+}
+
+// RenderedFile is a rendered configuration file
+type RenderedFile struct {
+	Path    string `json:"path"`
+	Content string `json:"content"`
+}
+
+// Render is the configuration which is applied last
+type Render struct {
+	Hash      string         `json:"hash"`
+	AppliedAt time.Time      `json:"appliedAt"`
+	Files     []RenderedFile `json:"files"`
+}
+
+// CurrentRender returns the configuration which is applied last, it is nil if no configuration is applied yet. The
+// files are read while holding the lock of the renders, so that they match the hash
+func (m *Manager) CurrentRender() (*Render, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	status := m.ApplyStatus()
+	if !status.Applied {
+		return nil, nil
+	}
+
+	render := &Render{Hash: status.Hash, AppliedAt: status.AppliedAt}
+	for _, path := range []string{m.ncgo.TemplateOutputFile, m.ncgo.StreamTemplateOutputFile} {
+		if path == "" {
+			continue
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read rendered configuration")
+		}
+		render.Files = append(render.Files, RenderedFile{Path: path, Content: string(content)})
+	}
+
+	return render, nil
+}
+
+// eventHandler handles the informer events of a kind, each of them is passed with the event which it is called for
+type eventHandler struct {
+	add    func(event *informerEvent, obj interface{})
+	update func(event *informerEvent, oldObj, newObj interface{})
+	delete func(event *informerEvent, obj interface{})
+}
+
+// informerEvent is an informer event which is being handled. It is added to the triggers of the next apply once the
+// handler changes the rendered state with it, so that the ignored events are neither recorded nor measured
+type informerEvent struct {
+	manager *Manager
+	ctx     context.Context
+	span    trace.Span
+	trigger Trigger
+	// changedAt returns the time which the object of the event is changed at
+	changedAt func() time.Time
+	queued    bool
+}
+
+// changed adds event to the triggers of the next apply, it must be called before the change is applied. It is a no-op
+// on a nil event, e.g. for the changes which are not caused by an informer event
+func (event *informerEvent) changed() {
+	if event == nil || event.queued {
+		return
+	}
+
+	event.queued = true
+	event.manager.addTrigger(event.ctx, event.trigger, event.changedAt())
+}
+
+// countEvents returns a handler which counts the events of kind on cluster and passes them to handler. Resyncs are
+// counted but they are neither traced nor added to the triggers
+func (m *Manager) countEvents(cluster *types.Cluster, kind string, handler eventHandler) cache.ResourceEventHandler {
+	counter := m.metrics.InformerEventsCounter
+	return cache.ResourceEventHandlerDetailedFuncs{
+		AddFunc: func(obj interface{}, isInInitialList bool) {
+			counter.WithLabelValues(cluster.Name, kind, TriggerAdd).Inc()
+			received := time.Now()
+			event := m.newEvent(newTrigger(cluster, kind, TriggerAdd, obj), func() time.Time {
+				// the objects of the initial list are not changed recently, their propagation is not measured
+				if isInInitialList {
+					return time.Time{}
+				}
+				return changeTime(obj, TriggerAdd, received)
+			})
+			defer event.span.End()
+			handler.add(event, obj)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			counter.WithLabelValues(cluster.Name, kind, TriggerUpdate).Inc()
+			if isResync(oldObj, newObj) {
+				handler.update(nil, oldObj, newObj)
+				return
+			}
+
+			received := time.Now()
+			event := m.newEvent(newTrigger(cluster, kind, TriggerUpdate, newObj), func() time.Time {
+				return changeTime(newObj, TriggerUpdate, received)
+			})
+			defer event.span.End()
+			handler.update(event, oldObj, newObj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			counter.WithLabelValues(cluster.Name, kind, TriggerDelete).Inc()
+			received := time.Now()
+			event := m.newEvent(newTrigger(cluster, kind, TriggerDelete, obj), func() time.Time {
+				return changeTime(obj, TriggerDelete, received)
+			})
+			defer event.span.End()
+			handler.delete(event, obj)
+		},
+	}
+}
+
+// newEvent starts the span of the informer event of trigger, changedAt returns the time which its object is changed at
+func (m *Manager) newEvent(trigger Trigger, changedAt func() time.Time) *informerEvent {
+	attributes := []attribute.KeyValue{tracing.ClusterKey.String(trigger.Cluster),
+		tracing.KindKey.String(trigger.Kind), tracing.EventTypeKey.String(trigger.Type),
+		tracing.ObjectKey.String(trigger.Name)}
+	if trigger.Namespace != "" {
+		attributes = append(attributes, tracing.NamespaceKey.String(trigger.Namespace))
+	}
+
+	switch trigger.Kind {
+	case "node":
+		attributes = append(attributes, tracing.NodeKey.String(trigger.Name))
+	case "service":
+		attributes = append(attributes, tracing.ServiceKey.String(trigger.Name))
+	}
+
+	ctx, span := m.tracer.Start(context.Background(), tracing.SpanEvent, trace.WithAttributes(attributes...))
+	return &informerEvent{manager: m, ctx: ctx, span: span, trigger: trigger, changedAt: changedAt}
+}
@@ -0,0 +1,299 @@
+package informers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/health"
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/k8s/types"
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/leader"
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/logging"
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/metrics"
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/options"
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/tracing"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+var testLogger = logging.New(zap.NewAtomicLevelAt(zap.InfoLevel))
+
+// newTestManager returns a Manager which renders to a temporary file with the options in opts, configure is called
+// with a copy of them before the Manager is created
+func newTestManager(t *testing.T, configure func(ncgo *options.NginxConfGeneratorOptions)) *Manager {
+	ncgo := *opts
+	ncgo.TemplateOutputFile = filepath.Join(t.TempDir(), "ncg.conf")
+	if configure != nil {
+		configure(&ncgo)
+	}
+
+	healthRegistry := health.NewRegistry()
+	m, err := metrics.New(prometheus.NewRegistry(), healthRegistry)
+	assert.Nil(t, err)
+	return NewManager(&ncgo, testLogger, m, healthRegistry, leader.NewElector(m, ncgo.EnableLeaderElection))
+}
+
+func TestNewManager(t *testing.T) {
+	first := newTestManager(t, nil)
+	second := newTestManager(t, nil)
+
+	// listen ports are claimed per manager
+	_, ok := first.listenPorts.claim(8080, "first", nil)
+	assert.True(t, ok)
+	_, ok = second.listenPorts.claim(8080, "second", nil)
+	assert.True(t, ok)
+
+	assert.Empty(t, first.nginxConf.Clusters)
+	assert.NotEqual(t, first.ncgo.TemplateOutputFile, second.ncgo.TemplateOutputFile)
+}
+
+func TestApplyChangesMetrics(t *testing.T) {
+	m := newTestManager(t, nil)
+	worker := types.NewWorker("10.0.0.1", "10.0.0.44", v1.ConditionTrue)
+	cluster := types.NewCluster("10.0.0.1", []*types.Worker{worker})
+	cluster.Name = "cluster-a"
+	cluster.NodePorts = []*types.NodePort{{MasterIP: "10.0.0.1", Port: 30444, Workers: []*types.Worker{worker}}}
+	m.nginxConf.Clusters = []*types.Cluster{cluster}
+
+	assert.Nil(t, m.applyChanges())
+	// the configuration is not changed, so that Nginx is reloaded once
+	assert.Nil(t, m.applyChanges())
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.metrics.WorkersGauge.WithLabelValues("cluster-a")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.metrics.ExposedServicesGauge.WithLabelValues("cluster-a")))
+	assert.Equal(t, 1, testutil.CollectAndCount(m.metrics.ConfigInfoGauge))
+	assert.NotZero(t, testutil.ToFloat64(m.metrics.LastApplyTimestampGauge))
+
+	assert.Equal(t, uint64(2), sampleCount(t, m.metrics.RenderDurationHistogram, metrics.ResultSuccess))
+	assert.Equal(t, uint64(1), sampleCount(t, m.metrics.ReloadDurationHistogram, metrics.ResultSuccess))
+}
+
+// sampleCount returns the number of the observations of histogram with the result label
+func sampleCount(t *testing.T, histogram *prometheus.HistogramVec, labels ...string) uint64 {
+	metric := &dto.Metric{}
+	assert.Nil(t, histogram.WithLabelValues(labels...).(prometheus.Histogram).Write(metric))
+	return metric.GetHistogram().GetSampleCount()
+}
+
+func TestCountEvents(t *testing.T) {
+	m := newTestManager(t, nil)
+	cluster := types.NewCluster("10.0.0.1", nil)
+	cluster.Name = "cluster-a"
+
+	// the updates are ignored by the handler
+	var received int
+	handler := m.countEvents(cluster, "node", eventHandler{
+		add:    func(event *informerEvent, obj interface{}) { received++; event.changed() },
+		update: func(event *informerEvent, oldObj, newObj interface{}) { received++ },
+		delete: func(event *informerEvent, obj interface{}) { received++; event.changed(); event.changed() },
+	})
+	node := func(resourceVersion string) *v1.Node {
+		return &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node01", ResourceVersion: resourceVersion}}
+	}
+	handler.OnAdd(node("1"), false)
+	handler.OnUpdate(node("1"), node("2"))
+	handler.OnUpdate(node("2"), node("2"))
+	handler.OnDelete(cache.DeletedFinalStateUnknown{Key: "node01", Obj: node("2")})
+
+	assert.Equal(t, 4, received)
+	counter := m.metrics.InformerEventsCounter
+	assert.Equal(t, float64(1), testutil.ToFloat64(counter.WithLabelValues("cluster-a", "node", "add")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(counter.WithLabelValues("cluster-a", "node", "update")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(counter.WithLabelValues("cluster-a", "node", "delete")))
+
+	// the ignored events are not added to the triggers, the changed ones are added once
+	pending, dropped := m.takeTriggers()
+	assert.Equal(t, []Trigger{
+		{Cluster: "cluster-a", Kind: "node", Name: "node01", Type: TriggerAdd},
+		{Cluster: "cluster-a", Kind: "node", Name: "node01", Type: TriggerDelete},
+	}, triggerList(pending))
+	assert.Zero(t, dropped)
+}
+
+func TestApplyStatus(t *testing.T) {
+	m := newTestManager(t, nil)
+	assert.False(t, m.ApplyStatus().Applied)
+
+	assert.Nil(t, m.applyChanges())
+	status := m.ApplyStatus()
+	assert.True(t, status.Applied)
+	assert.True(t, status.RenderingSince.IsZero())
+
+	// a failing render does not reset the status of the previous applies
+	m.ncgo.TemplateInputFile = filepath.Join(t.TempDir(), "missing.tmpl")
+	assert.NotNil(t, m.applyChanges())
+	assert.True(t, m.ApplyStatus().Applied)
+}
+
+func TestCurrentRender(t *testing.T) {
+	m := newTestManager(t, nil)
+	render, err := m.CurrentRender()
+	assert.Nil(t, err)
+	assert.Nil(t, render)
+
+	assert.Nil(t, m.applyChanges())
+	render, err = m.CurrentRender()
+	assert.Nil(t, err)
+	assert.Equal(t, m.ApplyStatus().Hash, render.Hash)
+	assert.Len(t, render.Files, 1)
+	assert.Equal(t, m.ncgo.TemplateOutputFile, render.Files[0].Path)
+
+	hash, err := configHash(m.ncgo.TemplateOutputFile)
+	assert.Nil(t, err)
+	assert.Equal(t, hash, render.Hash)
+}
+
+// newNginxStub creates an nginx binary which fails the validation of the configuration if invalid is true
+func newNginxStub(t *testing.T, invalid bool) string {
+	script := "#!/bin/sh\nexit 0\n"
+	if invalid {
+		script = "#!/bin/sh\nif [ \"$1\" = \"-t\" ]; then echo 'nginx: [emerg] unknown directive'; exit 1; fi\nexit 0\n"
+	}
+
+	path := filepath.Join(t.TempDir(), "nginx")
+	assert.Nil(t, os.WriteFile(path, []byte(script), 0700))
+	return path
+}
+
+func TestApplyChangesHistory(t *testing.T) {
+	m := newTestManager(t, func(ncgo *options.NginxConfGeneratorOptions) {
+		ncgo.NginxBinary = newNginxStub(t, false)
+	})
+	worker := types.NewWorker("10.0.0.1", "10.0.0.44", v1.ConditionTrue)
+	cluster := types.NewCluster("10.0.0.1", []*types.Worker{worker})
+	m.nginxConf.Clusters = []*types.Cluster{cluster}
+
+	m.addTrigger(context.Background(), Trigger{Kind: "leader", Type: TriggerElected}, time.Time{})
+	assert.Nil(t, m.applyChanges())
+	history := m.History()
+	assert.Len(t, history, 1)
+	assert.Equal(t, ApplyReloaded, history[0].Result)
+	assert.Equal(t, m.ApplyStatus().Hash, history[0].Hash)
+	assert.Empty(t, history[0].PreviousHash)
+	assert.Equal(t, []Trigger{{Kind: "leader", Type: TriggerElected}}, history[0].Triggers)
+	assert.Contains(t, history[0].Diff, "+++ "+m.ncgo.TemplateOutputFile)
+	valid, err := os.ReadFile(m.ncgo.TemplateOutputFile)
+	assert.Nil(t, err)
+
+	// an invalid configuration is not reloaded and the previous one is restored
+	m.ncgo.NginxBinary = newNginxStub(t, true)
+	cluster.NodePorts = []*types.NodePort{{MasterIP: "10.0.0.1", Port: 30444, Workers: []*types.Worker{worker}}}
+	assert.Nil(t, m.applyChanges())
+	history = m.History()
+	assert.Len(t, history, 2)
+	assert.Equal(t, ApplyInvalid, history[0].Result)
+	assert.Contains(t, history[0].Error, "unknown directive")
+	assert.Contains(t, history[0].Validation, "unknown directive")
+	assert.Equal(t, history[1].Hash, history[0].PreviousHash)
+	assert.NotEqual(t, history[0].PreviousHash, history[0].Hash)
+	assert.Contains(t, history[0].Diff, "+    server 10.0.0.44:30444")
+	assert.Empty(t, history[0].Triggers)
+	content, err := os.ReadFile(m.ncgo.TemplateOutputFile)
+	assert.Nil(t, err)
+	assert.Equal(t, string(valid), string(content))
+}
+
+func TestApplyChangesHistoryUnchanged(t *testing.T) {
+	m := newTestManager(t, func(ncgo *options.NginxConfGeneratorOptions) {
+		ncgo.NginxBinary = newNginxStub(t, false)
+	})
+	// the configuration is rendered by the previous run of the process, it is validated on startup although it is not
+	// changed
+	_, err := renderTemplate(m.ncgo.TemplateInputFile, m.ncgo.TemplateOutputFile, "main", m.nginxConf.Snapshot())
+	assert.Nil(t, err)
+
+	assert.Nil(t, m.applyChanges())
+	history := m.History()
+	assert.Len(t, history, 1)
+	assert.Equal(t, ApplyUnchanged, history[0].Result)
+	assert.Equal(t, history[0].Hash, history[0].PreviousHash)
+	assert.Empty(t, history[0].Diff)
+
+	// the reconcile on election is recorded with its trigger
+	assert.Nil(t, m.Reconcile())
+	history = m.History()
+	assert.Len(t, history, 2)
+	assert.Equal(t, ApplyUnchanged, history[0].Result)
+	assert.Equal(t, []Trigger{{Kind: "leader", Type: TriggerElected}}, history[0].Triggers)
+	assert.Empty(t, history[0].Validation)
+}
+
+func TestApplyChangesInvalidOnStartup(t *testing.T) {
+	m := newTestManager(t, func(ncgo *options.NginxConfGeneratorOptions) {
+		ncgo.NginxBinary = newNginxStub(t, true)
+	})
+
+	// the output file does not exist before, so it is removed
+	assert.Nil(t, m.applyChanges())
+	assert.False(t, m.ApplyStatus().Applied)
+	_, err := os.Stat(m.ncgo.TemplateOutputFile)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestApplyOrFail(t *testing.T) {
+	m := newTestManager(t, func(ncgo *options.NginxConfGeneratorOptions) {
+		ncgo.TemplateInputFile = filepath.Join(t.TempDir(), "missing.tmpl")
+	})
+
+	assert.Nil(t, m.Err())
+	m.applyOrFail(testLogger)
+	select {
+	case <-m.Failed():
+	default:
+		t.Fatal("manager is not failed")
+	}
+	assert.Contains(t, m.Err().Error(), ErrApplyChanges)
+
+	// the first error is kept
+	m.fail(errors.New("second"), testLogger)
+	assert.NotContains(t, m.Err().Error(), "second")
+}
+
+func TestApplyChangesTracing(t *testing.T) {
+	m := newTestManager(t, func(ncgo *options.NginxConfGeneratorOptions) {
+		ncgo.NginxBinary = newNginxStub(t, false)
+	})
+	exporter := tracetest.NewInMemoryExporter()
+	m.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	cluster := types.NewCluster("10.0.0.1", nil)
+	cluster.Name = "cluster-a"
+
+	// the handler applies the changes synchronously, like the node and the service informers
+	handler := m.countEvents(cluster, "node", eventHandler{
+		add: func(event *informerEvent, obj interface{}) {
+			event.changed()
+			assert.Nil(t, m.applyChanges())
+		},
+	})
+	handler.OnAdd(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node01"}}, false)
+
+	spans := make(map[string]tracetest.SpanStub)
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+	}
+	assert.Contains(t, spans, tracing.SpanSnapshot)
+	assert.Contains(t, spans, tracing.SpanRender)
+	assert.Contains(t, spans, tracing.SpanValidate)
+	assert.Contains(t, spans, tracing.SpanReload)
+
+	event, queue, apply := spans[tracing.SpanEvent], spans[tracing.SpanQueue], spans[tracing.SpanApply]
+	assert.Contains(t, event.Attributes, tracing.NodeKey.String("node01"))
+	assert.Contains(t, event.Attributes, tracing.ClusterKey.String("cluster-a"))
+	assert.Equal(t, event.SpanContext.SpanID(), queue.Parent.SpanID())
+	assert.Len(t, apply.Links, 1)
+	assert.Equal(t, queue.SpanContext, apply.Links[0].SpanContext)
+	assert.Contains(t, apply.Attributes, tracing.ResultKey.String(ApplyReloaded))
+	assert.Equal(t, apply.SpanContext.SpanID(), spans[tracing.SpanReload].Parent.SpanID())
+}
@@ -0,0 +1,165 @@
+package informers
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/k8s/types"
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/options"
+
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// namespaceFilter decides which namespaces services are discovered from
+type namespaceFilter struct {
+	include  map[string]bool
+	exclude  map[string]bool
+	selector labels.Selector
+	// selected contains the namespaces which match selector, it is filled by the namespace informer
+	selected map[string]bool
+	mu       sync.RWMutex
+}
+
+func newNamespaceFilter(clusterOpts *options.ClusterOptions) (*namespaceFilter, error) {
+	filter := &namespaceFilter{
+		include:  make(map[string]bool),
+		exclude:  make(map[string]bool),
+		selected: make(map[string]bool),
+	}
+
+	for _, namespace := range clusterOpts.IncludeNamespaces {
+		filter.include[namespace] = true
+	}
+
+	for _, namespace := range clusterOpts.ExcludeNamespaces {
+		filter.exclude[namespace] = true
+	}
+
+	if clusterOpts.NamespaceSelector != "" {
+		selector, err := labels.Parse(clusterOpts.NamespaceSelector)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to parse namespace selector")
+		}
+		filter.selector = selector
+	}
+
+	return filter, nil
+}
+
+// watchedNamespaces returns the namespaces which must be watched through namespaced informers, nil means all
+// namespaces must be watched through a single informer
+func (filter *namespaceFilter) watchedNamespaces() []string {
+	if len(filter.include) == 0 {
+		return nil
+	}
+
+	namespaces := make([]string, 0, len(filter.include))
+	for namespace := range filter.include {
+		if !filter.exclude[namespace] {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+// allowed returns true if services in namespace can be discovered
+func (filter *namespaceFilter) allowed(namespace string) bool {
+	if filter.exclude[namespace] {
+		return false
+	}
+
+	if len(filter.include) > 0 && !filter.include[namespace] {
+		return false
+	}
+
+	if filter.selector == nil {
+		return true
+	}
+
+	filter.mu.RLock()
+	defer filter.mu.RUnlock()
+	return filter.selected[namespace]
+}
+
+// setSelected marks namespace as matching the namespace selector or not, returns true if it is changed
+func (filter *namespaceFilter) setSelected(namespace string, selected bool) bool {
+	filter.mu.Lock()
+	defer filter.mu.Unlock()
+
+	if filter.selected[namespace] == selected {
+		return false
+	}
+
+	if selected {
+		filter.selected[namespace] = true
+	} else {
+		delete(filter.selected, namespace)
+	}
+
+	return true
+}
+
+// runNamespaceInformer watches the namespaces which match the namespace selector of filter and calls onChange with the
+// namespace event when a namespace starts or stops matching it until ctx is done. It returns after the namespace cache
+// is synced
+func (m *Manager) runNamespaceInformer(ctx context.Context, cluster *types.Cluster, filter *namespaceFilter,
+	clientSet kubernetes.Interface, logger *zap.Logger,
+	onChange func(event *informerEvent, namespace string, selected bool)) error {
+	// namespaces are filtered on the API server side, a namespace which stops matching is received as deleted
+	informerFactory := informers.NewSharedInformerFactoryWithOptions(clientSet, time.Second*30,
+		informers.WithTweakListOptions(func(listOptions *metav1.ListOptions) {
+			listOptions.LabelSelector = filter.selector.String()
+		}))
+
+	setSelected := func(event *informerEvent, namespace *v1.Namespace, selected bool) {
+		if filter.setSelected(namespace.Name, selected) {
+			logger.Info("namespace selection is changed", zap.String("namespace", namespace.Name),
+				zap.Bool("selected", selected))
+			onChange(event, namespace.Name, selected)
+		}
+	}
+
+	namespaceInformer := informerFactory.Core().V1().Namespaces()
+	handler := eventHandler{
+		add: func(event *informerEvent, obj interface{}) {
+			namespace := obj.(*v1.Namespace)
+			setSelected(event, namespace, filter.selector.Matches(labels.Set(namespace.Labels)))
+		},
+		update: func(event *informerEvent, oldObj interface{}, newObj interface{}) {
+			namespace := newObj.(*v1.Namespace)
+			setSelected(event, namespace, filter.selector.Matches(labels.Set(namespace.Labels)))
+		},
+		delete: func(event *informerEvent, obj interface{}) {
+			namespace, ok := obj.(*v1.Namespace)
+			if !ok {
+				tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+				if !ok {
+					return
+				}
+
+				if namespace, ok = tombstone.Obj.(*v1.Namespace); !ok {
+					return
+				}
+			}
+			setSelected(event, namespace, false)
+		},
+	}
+
+	if err := m.addEventHandler(cluster, "namespace", namespaceInformer.Informer(), handler); err != nil {
+		return errors.Wrap(err, "unable to run namespace informer")
+	}
+
+	return errors.Wrap(m.startInformerFactory(ctx, informerFactory), "unable to run namespace informer")
+}
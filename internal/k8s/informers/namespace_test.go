@@ -0,0 +1,118 @@
+package informers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/k8s/types"
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/options"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNamespaceFilter(t *testing.T) {
+	filter, err := newNamespaceFilter(&options.ClusterOptions{
+		IncludeNamespaces: []string{"team-b", "team-a", "kube-system"},
+		ExcludeNamespaces: []string{"kube-system"},
+		NamespaceSelector: "edge-exposure=allowed",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"team-a", "team-b"}, filter.watchedNamespaces())
+
+	assert.False(t, filter.allowed("team-a"))
+	assert.True(t, filter.setSelected("team-a", true))
+	assert.False(t, filter.setSelected("team-a", true))
+	assert.True(t, filter.allowed("team-a"))
+	assert.False(t, filter.allowed("team-c"))
+
+	assert.True(t, filter.setSelected("kube-system", true))
+	assert.False(t, filter.allowed("kube-system"))
+
+	assert.True(t, filter.setSelected("team-a", false))
+	assert.False(t, filter.allowed("team-a"))
+
+	filter, err = newNamespaceFilter(&options.ClusterOptions{ExcludeNamespaces: []string{"kube-system"}})
+	assert.Nil(t, err)
+	assert.Nil(t, filter.watchedNamespaces())
+	assert.True(t, filter.allowed("team-a"))
+	assert.False(t, filter.allowed("kube-system"))
+
+	filter, err = newNamespaceFilter(&options.ClusterOptions{NamespaceSelector: "edge-exposure in (allowed"})
+	assert.NotNil(t, err)
+	assert.Nil(t, filter)
+}
+
+func createNamespacedService(t *testing.T, clientSet *fake.Clientset, namespace string, nodePort int32) {
+	service := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: namespace,
+			Annotations: map[string]string{opts.CustomAnnotation: "true"}},
+		Spec: v1.ServiceSpec{Type: v1.ServiceTypeNodePort, Ports: []v1.ServicePort{{Port: 8080, NodePort: nodePort}}},
+	}
+	_, err := clientSet.CoreV1().Services(namespace).Create(context.Background(), service, metav1.CreateOptions{})
+	assert.Nil(t, err)
+}
+
+func TestRunServiceInformerNamespaces(t *testing.T) {
+	m := newTestManager(t, nil)
+
+	clientSet := fake.NewSimpleClientset(
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a",
+			Labels: map[string]string{"edge-exposure": "allowed"}}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-b"}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-c",
+			Labels: map[string]string{"edge-exposure": "allowed"}}},
+	)
+	createNamespacedService(t, clientSet, "team-a", 30100)
+	createNamespacedService(t, clientSet, "team-b", 30101)
+	createNamespacedService(t, clientSet, "team-c", 30102)
+
+	worker := types.NewWorker("", "10.0.0.44", v1.ConditionTrue)
+	cluster := types.NewCluster("", []*types.Worker{worker})
+	m.nginxConf.Clusters = []*types.Cluster{cluster}
+
+	namespaceOpts := &options.ClusterOptions{
+		IncludeNamespaces: []string{"team-a", "team-b"},
+		NamespaceSelector: "edge-exposure=allowed",
+	}
+	_, err := m.RunServiceInformer(parentCtx, cluster, namespaceOpts, clientSet,
+		NewEventRecorder(clientSet, testLogger, m.elector.IsLeader), testLogger)
+	assert.Nil(t, err)
+
+	nodePorts := func() []int32 {
+		cluster.Mu.Lock()
+		defer cluster.Mu.Unlock()
+		var ports []int32
+		for _, nodePort := range cluster.NodePorts {
+			ports = append(ports, nodePort.Port)
+		}
+		return ports
+	}
+
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]int32{30100}, nodePorts())
+	}, 5*time.Second, 100*time.Millisecond)
+
+	// team-b is labelled, so its services must be discovered
+	namespace, err := clientSet.CoreV1().Namespaces().Get(context.Background(), "team-b", metav1.GetOptions{})
+	assert.Nil(t, err)
+	namespace.Labels = map[string]string{"edge-exposure": "allowed"}
+	_, err = clientSet.CoreV1().Namespaces().Update(context.Background(), namespace, metav1.UpdateOptions{})
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]int32{30100, 30101}, nodePorts())
+	}, 5*time.Second, 100*time.Millisecond)
+
+	// team-a is not labelled anymore, so its services must be removed
+	namespace, err = clientSet.CoreV1().Namespaces().Get(context.Background(), "team-a", metav1.GetOptions{})
+	assert.Nil(t, err)
+	namespace.Labels = nil
+	_, err = clientSet.CoreV1().Namespaces().Update(context.Background(), namespace, metav1.UpdateOptions{})
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]int32{30101}, nodePorts())
+	}, 5*time.Second, 100*time.Millisecond)
+}
@@ -1,55 +1,93 @@
 package informers
 
 import (
+	"context"
 	"time"
 
 	"github.com/pkg/errors"
 
 	"github.com/bilalcaliskan/nginx-conf-generator/internal/k8s/types"
-	"github.com/bilalcaliskan/nginx-conf-generator/internal/metrics"
 	"github.com/bilalcaliskan/nginx-conf-generator/internal/options"
 
 	"go.uber.org/zap"
 	v1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/util/wait"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 )
 
-// RunNodeInformer spins up a shared informer factory and fetch Kubernetes node events
-func RunNodeInformer(cluster *types.Cluster, clientSet kubernetes.Interface, logger *zap.Logger, nginxConf *types.NginxConf) error {
-	ncgo := options.GetNginxConfGeneratorOptions()
-	informerFactory := informers.NewSharedInformerFactory(clientSet, time.Second*30)
+// RunNodeInformer spins up a shared informer factory and fetch Kubernetes node events until ctx is done
+func (m *Manager) RunNodeInformer(ctx context.Context, cluster *types.Cluster, clusterOpts *options.ClusterOptions,
+	clientSet kubernetes.Interface, recorder record.EventRecorder, logger *zap.Logger) error {
+	logger = logger.Named("node")
+	ncgo := m.ncgo
+	selector, err := labels.Parse(clusterOpts.WorkerNodeSelector)
+	if err != nil {
+		return errors.Wrap(err, "unable to parse worker node selector")
+	}
+
+	eligibility, err := newNodeEligibility(ncgo)
+	if err != nil {
+		return errors.Wrap(err, "unable to parse node eligibility rules")
+	}
+
+	// nodes are filtered on the API server side, so that unrelated nodes are not cached at all
+	informerFactory := informers.NewSharedInformerFactoryWithOptions(clientSet, time.Second*30,
+		informers.WithTweakListOptions(func(listOptions *metav1.ListOptions) {
+			listOptions.LabelSelector = selector.String()
+		}))
 	nodeInformer := informerFactory.Core().V1().Nodes()
-	if _, err := nodeInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc: func(obj interface{}) {
+	// apply applies the change which event made to the workers
+	apply := func(event *informerEvent) {
+		event.changed()
+		m.applyOrFail(logger)
+	}
+	drainer := newWorkerDrainer(cluster, ncgo.NodeDrainGracePeriod, recorder, logger, func(worker *types.Worker) {
+		m.addTrigger(context.Background(), Trigger{Cluster: cluster.Name, Kind: "node", Name: worker.NodeName,
+			Type: TriggerDrained}, time.Time{})
+		apply(nil)
+	})
+
+	handler := eventHandler{
+		add: func(event *informerEvent, obj interface{}) {
 			node := obj.(*v1.Node)
-			if val, ok := node.Labels[ncgo.WorkerNodeLabel]; !ok || val != "true" {
-				logger.Debug("node is not properly annotated, skipping...")
+			if !selector.Matches(labels.Set(node.Labels)) {
+				logger.Debug("node does not match the worker node selector, skipping...")
+				return
+			}
+
+			if eligible, reason := eligibility.check(node); !eligible {
+				logger.Debug("node is not eligible, skipping...", zap.String("node", node.Name),
+					zap.String("reason", reason))
+				return
+			}
+
+			worker, ok := workerFromNode(cluster, clusterOpts, node, logger)
+			if !ok {
 				return
 			}
 
-			if nodeReady := isNodeReady(node); nodeReady != v1.ConditionTrue {
-				logger.Debug("node is not in Ready status, skipping...")
+			if drainer.restore(worker) {
+				apply(event)
 				return
 			}
 
-			logger.Info("adding node to the cluster.Workers", zap.String("node", node.Status.Addresses[0].Address))
-			worker := types.NewWorker(cluster.MasterIP, node.Status.Addresses[0].Address, v1.ConditionTrue)
+			logger.Info("adding node to the cluster.Workers", zap.String("node", worker.HostIP))
 
 			// add Worker to each nodePort.Workers in the cluster.NodePorts slice
 			cluster.Mu.Lock()
 			addWorker(cluster, worker)
-			metrics.TargetNodeCounter.Inc()
 			addWorkerToNodePorts(cluster.NodePorts, worker)
 			cluster.Mu.Unlock()
+			recordNodeEvent(recorder, worker, v1.EventTypeNormal, EventReasonNodeAdded,
+				"added to the upstreams with address %s", worker.HostIP)
 
-			if err := applyChanges(ncgo, nginxConf); err != nil {
-				logger.Fatal(ErrApplyChanges, zap.String("error", err.Error()))
-			}
+			apply(event)
 		},
-		UpdateFunc: func(oldObj interface{}, newObj interface{}) {
+		update: func(event *informerEvent, oldObj interface{}, newObj interface{}) {
 			oldNode := oldObj.(*v1.Node)
 			newNode := newObj.(*v1.Node)
 
@@ -59,74 +97,101 @@ func RunNodeInformer(cluster *types.Cluster, clientSet kubernetes.Interface, log
 				return
 			}
 
-			_, newOk := newNode.Labels[ncgo.WorkerNodeLabel]
-			oldWorker := types.NewWorker(cluster.MasterIP, oldNode.Status.Addresses[0].Address, isNodeReady(oldNode))
-			newWorker := types.NewWorker(cluster.MasterIP, newNode.Status.Addresses[0].Address, isNodeReady(newNode))
-
-			if newWorker.NodeCondition != v1.ConditionTrue || !newOk {
-				logger.Debug("updated node is either not Ready or not annotated")
-				if i, found := findWorker(cluster.Workers, oldWorker); found {
-					logger.Info("node is not healthy or is not labelled, removing from cluster.Workers!",
-						zap.String("node", oldNode.Name))
-					removeWorkerFromCluster(cluster, i)
-					removeWorkerFromNodePorts(cluster.NodePorts, oldWorker)
-
-					logger.Info("successfully removed node from each nodePort in the cluster.NodePorts",
-						zap.String("node", oldNode.Name))
-					metrics.TargetNodeCounter.Desc()
-					if err := applyChanges(ncgo, nginxConf); err != nil {
-						logger.Fatal(ErrApplyChanges, zap.String("error", err.Error()))
-					}
+			newOk := selector.Matches(labels.Set(newNode.Labels))
+			oldWorker, oldAddressOk := workerFromNode(cluster, clusterOpts, oldNode, logger)
+			newWorker, newAddressOk := workerFromNode(cluster, clusterOpts, newNode, logger)
+
+			eligible, reason := eligibility.check(newNode)
+			if !newOk {
+				eligible, reason = false, "node does not match the worker node selector"
+			}
+
+			if !newAddressOk || !eligible {
+				logger.Debug("updated node is either not eligible, not selected or has no usable address",
+					zap.String("node", newNode.Name), zap.String("reason", reason))
+				if oldAddressOk && drainer.remove(oldWorker) {
+					logger.Info("node is not eligible anymore, removing from cluster.Workers!",
+						zap.String("node", oldNode.Name), zap.String("reason", reason))
+					apply(event)
 				}
 				return
 			}
 
-			if _, found := findWorker(cluster.Workers, oldWorker); !found {
-				nodeReady := isNodeReady(newNode)
-				logger.Info("adding node to the cluster.Workers", zap.String("node", newNode.Status.Addresses[0].Address))
-				worker := types.NewWorker(cluster.MasterIP, newNode.Status.Addresses[0].Address, nodeReady)
+			if oldAddressOk && !oldWorker.Equals(newWorker) && drainer.remove(oldWorker) {
+				logger.Info("address of the node is changed, removing the old address from cluster.Workers",
+					zap.String("node", newNode.Name), zap.String("oldAddress", oldWorker.HostIP),
+					zap.String("newAddress", newWorker.HostIP))
+			}
 
-				cluster.Mu.Lock()
-				addWorker(cluster, worker)
-				metrics.TargetNodeCounter.Inc()
-				cluster.Mu.Unlock()
+			if drainer.restore(newWorker) {
+				apply(event)
+				return
+			}
 
+			cluster.Mu.Lock()
+			_, found := findWorker(cluster.Workers, newWorker)
+			cluster.Mu.Unlock()
+			if !found {
+				logger.Info("adding node to the cluster.Workers", zap.String("node", newWorker.HostIP))
 				// add Worker to each nodePort.Workers in the cluster.NodePorts slice
 				cluster.Mu.Lock()
-				addWorkerToNodePorts(cluster.NodePorts, worker)
+				addWorker(cluster, newWorker)
+				addWorkerToNodePorts(cluster.NodePorts, newWorker)
 				cluster.Mu.Unlock()
-				if err := applyChanges(ncgo, nginxConf); err != nil {
-					logger.Fatal(ErrApplyChanges, zap.String("error", err.Error()))
-				}
+				recordNodeEvent(recorder, newWorker, v1.EventTypeNormal, EventReasonNodeAdded,
+					"added to the upstreams with address %s", newWorker.HostIP)
+				apply(event)
 			}
 		},
-		DeleteFunc: func(obj interface{}) {
-			node := obj.(*v1.Node)
-			nodeReady := isNodeReady(node)
-			worker := types.NewWorker(cluster.MasterIP, node.Status.Addresses[0].Address, nodeReady)
-			logger.Info("delete event fetched for node", zap.String("node", node.Name))
-			index, found := findWorker(cluster.Workers, worker)
-			if found {
-				logger.Info("node found in the cluster.Workers, removing...", zap.String("node", node.Name))
-				removeWorkerFromCluster(cluster, index)
-				logger.Info("successfully removed node from cluster.Workers slice!", zap.String("node", node.Name))
-
-				removeWorkerFromNodePorts(cluster.NodePorts, worker)
-				logger.Info("successfully removed node from each nodePort in the cluster.NodePorts", zap.String("node", node.Name))
-				metrics.TargetNodeCounter.Desc()
+		delete: func(event *informerEvent, obj interface{}) {
+			node, ok := obj.(*v1.Node)
+			if !ok {
+				tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+				if !ok {
+					return
+				}
 
-				if err := applyChanges(ncgo, nginxConf); err != nil {
-					logger.Fatal(ErrApplyChanges, zap.String("error", err.Error()))
+				if node, ok = tombstone.Obj.(*v1.Node); !ok {
+					return
 				}
+			}
+
+			worker, ok := workerFromNode(cluster, clusterOpts, node, logger)
+			if !ok {
+				return
+			}
+
+			logger.Info("delete event fetched for node", zap.String("node", node.Name))
+			if drainer.remove(worker) {
+				logger.Info("node found in the cluster.Workers, removing...", zap.String("node", node.Name))
+				apply(event)
 			} else {
 				logger.Debug("node not found in the cluster.workers, skipping remove operation",
 					zap.String("node", node.Name))
 			}
 		},
-	}); err != nil {
+	}
+
+	if err := m.addEventHandler(cluster, "node", nodeInformer.Informer(), handler); err != nil {
+		return errors.Wrap(err, "unable to run node informer")
+	}
+	if err := m.startInformerFactory(ctx, informerFactory); err != nil {
 		return errors.Wrap(err, "unable to run node informer")
 	}
-	informerFactory.Start(wait.NeverStop)
-	informerFactory.WaitForCacheSync(wait.NeverStop)
 	return nil
 }
+
+// workerFromNode builds a types.Worker from node, nodes without a usable address are skipped with a warning
+func workerFromNode(cluster *types.Cluster, clusterOpts *options.ClusterOptions, node *v1.Node,
+	logger *zap.Logger) (*types.Worker, bool) {
+	address, ok := nodeAddress(node, clusterOpts)
+	if !ok {
+		logger.Warn("node has no usable address, skipping...", zap.String("node", node.Name),
+			zap.Strings("addressTypes", clusterOpts.NodeAddressTypes))
+		return nil, false
+	}
+
+	worker := types.NewWorker(cluster.MasterIP, address, isNodeReady(node))
+	worker.NodeName = node.Name
+	return worker, true
+}
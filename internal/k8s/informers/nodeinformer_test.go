@@ -9,7 +9,6 @@ import (
 	"time"
 
 	"github.com/bilalcaliskan/nginx-conf-generator/internal/k8s/types"
-	"github.com/bilalcaliskan/nginx-conf-generator/internal/logging"
 	"github.com/bilalcaliskan/nginx-conf-generator/internal/options"
 
 	"github.com/stretchr/testify/assert"
@@ -22,7 +21,15 @@ import (
 
 var (
 	parentCtx = context.Background()
-	opts      = options.GetNginxConfGeneratorOptions()
+	// opts are the defaults of the options which the tests are run with
+	opts = &options.NginxConfGeneratorOptions{
+		TemplateInputFile:    "../../../resources/ncg.conf.tmpl",
+		WorkerNodeLabel:      "worker",
+		CustomAnnotation:     "nginx-conf-generator/enabled",
+		ListenPortAnnotation: "nginx-conf-generator/listen-port",
+	}
+	clusterOpts = &options.ClusterOptions{NodeAddressTypes: options.DefaultNodeAddressTypes,
+		WorkerNodeSelector: "worker=true"}
 )
 
 type FakeAPI struct {
@@ -53,7 +60,7 @@ func (fAPI *FakeAPI) updateNode(name string, status v1.ConditionStatus, isLabell
 	if !isLabelled {
 		delete(node.Labels, opts.WorkerNodeLabel)
 	} else {
-		node.Labels[opts.WorkerNodeLabel] = ""
+		node.Labels[opts.WorkerNodeLabel] = "true"
 	}
 
 	ctx, cancel := context.WithTimeout(parentCtx, 60*time.Second)
@@ -143,18 +150,14 @@ func TestRunNodeInformer(t *testing.T) {
 	api := getFakeAPI()
 	assert.NotNil(t, api)
 
-	opts.Mu.Lock()
-	opts.TemplateInputFile = "../../../resources/ncg.conf.tmpl"
-	opts.TemplateOutputFile = "/etc/nginx/conf.d/ncg_test.conf"
-	opts.Mu.Unlock()
+	m := newTestManager(t, nil)
 
-	var clusters []*types.Cluster
-	nginxConf := types.NewNginxConf(clusters)
 	cluster := types.NewCluster("", make([]*types.Worker, 0))
-	nginxConf.Clusters = append(nginxConf.Clusters, cluster)
+	m.nginxConf.Clusters = []*types.Cluster{cluster}
 
 	go func() {
-		err := RunNodeInformer(cluster, api.ClientSet, logging.GetLogger(), nginxConf)
+		err := m.RunNodeInformer(parentCtx, cluster, clusterOpts, api.ClientSet,
+			NewEventRecorder(api.ClientSet, testLogger, m.elector.IsLeader), testLogger)
 		assert.Nil(t, err)
 	}()
 
@@ -254,3 +257,60 @@ func TestRunNodeInformer(t *testing.T) {
 		})
 	}
 }
+
+func TestRunNodeInformerSelector(t *testing.T) {
+	api := getFakeAPI()
+	m := newTestManager(t, nil)
+
+	cluster := types.NewCluster("", make([]*types.Worker, 0))
+	m.nginxConf.Clusters = []*types.Cluster{cluster}
+	selectorOpts := &options.ClusterOptions{NodeAddressTypes: options.DefaultNodeAddressTypes,
+		WorkerNodeSelector: "node-role.kubernetes.io/worker,zone in (a,b),!excluded"}
+
+	cases := []struct {
+		name, ip       string
+		labels         map[string]string
+		expectedWorker bool
+	}{
+		{"node01", "10.0.0.51", map[string]string{"node-role.kubernetes.io/worker": "", "zone": "a"}, true},
+		{"node02", "10.0.0.52", map[string]string{"node-role.kubernetes.io/worker": "", "zone": "c"}, false},
+		{"node03", "10.0.0.53", map[string]string{"node-role.kubernetes.io/worker": "", "zone": "b",
+			"excluded": "true"}, false},
+		{"node04", "10.0.0.54", map[string]string{"zone": "a"}, false},
+	}
+
+	for _, tc := range cases {
+		node, err := api.createNode(tc.name, tc.ip, v1.ConditionTrue, false)
+		assert.Nil(t, err)
+		for key, value := range tc.labels {
+			node.Labels[key] = value
+		}
+		_, err = api.ClientSet.CoreV1().Nodes().Update(parentCtx, node, metav1.UpdateOptions{})
+		assert.Nil(t, err)
+	}
+
+	assert.Nil(t, m.RunNodeInformer(parentCtx, cluster, selectorOpts, api.ClientSet,
+		NewEventRecorder(api.ClientSet, testLogger, m.elector.IsLeader), testLogger))
+
+	for _, tc := range cases {
+		cluster.Mu.Lock()
+		_, found := findWorker(cluster.Workers, types.NewWorker("", tc.ip, v1.ConditionTrue))
+		cluster.Mu.Unlock()
+		assert.Equal(t, tc.expectedWorker, found, tc.name)
+	}
+
+	// removing the label takes the node out of the selection
+	node, err := api.getNode("node01")
+	assert.Nil(t, err)
+	node.ResourceVersion = "2"
+	delete(node.Labels, "zone")
+	_, err = api.ClientSet.CoreV1().Nodes().Update(parentCtx, node, metav1.UpdateOptions{})
+	assert.Nil(t, err)
+
+	assert.Eventually(t, func() bool {
+		cluster.Mu.Lock()
+		defer cluster.Mu.Unlock()
+		_, found := findWorker(cluster.Workers, types.NewWorker("", "10.0.0.51", v1.ConditionTrue))
+		return !found
+	}, 10*time.Second, 100*time.Millisecond)
+}
@@ -0,0 +1,86 @@
+package informers
+
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/tools/cache"
+)
+
+// maxUnapplied is the number of the changes which are kept until Nginx is reloaded successfully, the oldest are dropped
+const maxUnapplied = 1000
+
+// change is a change of a Kubernetes object which is not served by Nginx yet
+type change struct {
+	cluster   string
+	kind      string
+	changedAt time.Time
+}
+
+// changeTime returns the time which obj is changed at by the informer event of eventType, which is received at
+// received. It is the latest time of the managed fields or the creation of obj for the adds and the updates, and the
+// deletion time for the deletes. received is returned when obj has no such time, or it is after received due to a
+// clock skew between the API server and the replica. The times of the managed fields are truncated to seconds
+func changeTime(obj interface{}, eventType string, received time.Time) time.Time {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	object, err := meta.Accessor(obj)
+	if err != nil {
+		return received
+	}
+
+	var changedAt time.Time
+	if eventType == TriggerDelete {
+		// the managed fields of a deleted object are not changed by the deletion
+		if deletedAt := object.GetDeletionTimestamp(); deletedAt != nil {
+			changedAt = deletedAt.Time
+		}
+	} else {
+		changedAt = object.GetCreationTimestamp().Time
+		for _, entry := range object.GetManagedFields() {
+			if entry.Time != nil && entry.Time.After(changedAt) {
+				changedAt = entry.Time.Time
+			}
+		}
+	}
+
+	if changedAt.IsZero() || changedAt.After(received) {
+		return received
+	}
+
+	return changedAt
+}
+
+// addUnapplied adds the changes of the informer events in pending to the changes which are not served yet, it must
+// be called while holding mu
+func (m *Manager) addUnapplied(pending []pendingTrigger) {
+	for _, trigger := range pending {
+		if !trigger.changedAt.IsZero() {
+			m.unapplied = append(m.unapplied, change{cluster: trigger.Cluster, kind: trigger.Kind,
+				changedAt: trigger.changedAt})
+		}
+	}
+
+	if len(m.unapplied) > maxUnapplied {
+		m.unapplied = m.unapplied[len(m.unapplied)-maxUnapplied:]
+	}
+}
+
+// observePropagation observes the propagation durations of the changes which are not served yet if Nginx is reloaded
+// at appliedAt, and resets them. The changes which did not change the configuration are not observed
+func (m *Manager) observePropagation(appliedAt time.Time, reloaded bool) {
+	if reloaded {
+		for _, change := range m.unapplied {
+			duration := appliedAt.Sub(change.changedAt)
+			if duration < 0 {
+				duration = 0
+			}
+			m.metrics.PropagationDurationHistogram.WithLabelValues(change.cluster, change.kind).
+				Observe(duration.Seconds())
+		}
+	}
+
+	m.unapplied = nil
+}
@@ -0,0 +1,85 @@
+package informers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/k8s/types"
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/options"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestChangeTime(t *testing.T) {
+	received := time.Now()
+	created := metav1.NewTime(received.Add(-time.Hour))
+	updated := metav1.NewTime(received.Add(-2 * time.Second))
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node01", CreationTimestamp: created,
+		ManagedFields: []metav1.ManagedFieldsEntry{{Manager: "kubelet", Time: &updated}, {Manager: "kubectl"}}}}
+
+	assert.Equal(t, updated.Time, changeTime(node, TriggerUpdate, received))
+	assert.Equal(t, created.Time, changeTime(&v1.Node{ObjectMeta: metav1.ObjectMeta{CreationTimestamp: created}},
+		TriggerAdd, received))
+
+	// the managed fields are not changed by the deletion
+	assert.Equal(t, received, changeTime(node, TriggerDelete, received))
+	deleted := metav1.NewTime(received.Add(-time.Second))
+	node.DeletionTimestamp = &deleted
+	assert.Equal(t, deleted.Time, changeTime(cache.DeletedFinalStateUnknown{Key: "node01", Obj: node}, TriggerDelete,
+		received))
+
+	// the times after the receipt are not trusted
+	skewed := metav1.NewTime(received.Add(time.Minute))
+	node.ManagedFields = []metav1.ManagedFieldsEntry{{Manager: "kubelet", Time: &skewed}}
+	assert.Equal(t, received, changeTime(node, TriggerUpdate, received))
+	assert.Equal(t, received, changeTime("not an object", TriggerUpdate, received))
+}
+
+func TestApplyChangesPropagation(t *testing.T) {
+	m := newTestManager(t, func(ncgo *options.NginxConfGeneratorOptions) {
+		ncgo.NginxBinary = newNginxStub(t, true)
+	})
+	worker := types.NewWorker("10.0.0.1", "10.0.0.44", v1.ConditionTrue)
+	cluster := types.NewCluster("10.0.0.1", []*types.Worker{worker})
+	cluster.Name = "cluster-a"
+	m.nginxConf.Clusters = []*types.Cluster{cluster}
+
+	apply := func(event *informerEvent, obj interface{}) {
+		event.changed()
+		assert.Nil(t, m.applyChanges())
+	}
+	handler := m.countEvents(cluster, "service", eventHandler{
+		add:    apply,
+		update: func(event *informerEvent, oldObj, newObj interface{}) { apply(event, newObj) },
+	})
+	histogram := m.metrics.PropagationDurationHistogram
+
+	// the objects of the initial list are not measured
+	handler.OnAdd(&v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "old"}}, true)
+	assert.Zero(t, sampleCount(t, histogram, "cluster-a", "service"))
+
+	// the changes of an invalid configuration are measured when a later reload succeeds
+	updated := metav1.NewTime(time.Now().Add(-3 * time.Second))
+	cluster.NodePorts = []*types.NodePort{{MasterIP: "10.0.0.1", Port: 30444, Workers: []*types.Worker{worker}}}
+	handler.OnUpdate(&v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "app", ResourceVersion: "1"}},
+		&v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "app", ResourceVersion: "2",
+			ManagedFields: []metav1.ManagedFieldsEntry{{Manager: "kubectl", Time: &updated}}}})
+	assert.Zero(t, sampleCount(t, histogram, "cluster-a", "service"))
+
+	m.ncgo.NginxBinary = newNginxStub(t, false)
+	handler.OnAdd(&v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "other"}}, false)
+	assert.Equal(t, uint64(2), sampleCount(t, histogram, "cluster-a", "service"))
+	metric := &dto.Metric{}
+	assert.Nil(t, histogram.WithLabelValues("cluster-a", "service").(prometheus.Histogram).Write(metric))
+	assert.GreaterOrEqual(t, metric.GetHistogram().GetSampleSum(), 3.0)
+
+	// the changes which do not change the configuration are not measured
+	handler.OnAdd(&v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "unrelated"}}, false)
+	assert.Equal(t, uint64(2), sampleCount(t, histogram, "cluster-a", "service"))
+	assert.Empty(t, m.unapplied)
+}
@@ -1,6 +1,8 @@
 package informers
 
 import (
+	"context"
+	"fmt"
 	"time"
 
 	"github.com/pkg/errors"
@@ -10,72 +12,233 @@ import (
 
 	"go.uber.org/zap"
 	v1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 )
 
-// RunServiceInformer spins up a shared informer factory and fetch Kubernetes service events
-func RunServiceInformer(cluster *types.Cluster, clientSet kubernetes.Interface, logger *zap.Logger, nginxConf *types.NginxConf) error {
-	ncgo := options.GetNginxConfGeneratorOptions()
-	informerFactory := informers.NewSharedInformerFactory(clientSet, time.Second*30)
-	serviceInformer := informerFactory.Core().V1().Services()
-	if _, err := serviceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc: func(obj interface{}) {
-			cluster.Mu.Lock()
-			if len(cluster.Workers) == 0 {
-				logger.Warn(WarnWorkerLength)
-				return
+// RunServiceInformer spins up shared informer factories and fetch Kubernetes service events until ctx is done.
+// Services are watched through namespaced informers when clusterOpts.IncludeNamespaces is set. The synced informers
+// are returned, so that the services are not watched again by the gateway controller of the cluster
+func (m *Manager) RunServiceInformer(ctx context.Context, cluster *types.Cluster, clusterOpts *options.ClusterOptions,
+	clientSet kubernetes.Interface, recorder record.EventRecorder,
+	logger *zap.Logger) ([]cache.SharedIndexInformer, error) {
+	logger = logger.Named("service")
+	ncgo := m.ncgo
+	filter, err := newNamespaceFilter(clusterOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	var serviceListers []corelisters.ServiceLister
+	var handlers eventHandler
+
+	var writer *statusWriter
+	if ncgo.EnableStatusAnnotations {
+		writer = newStatusWriter(clientSet, logger, m.elector.IsLeader)
+		m.registerPostApplyHook(ctx, func(hash string, appliedAt time.Time) {
+			writer.write(ctx, hash, appliedAt)
+		})
+	}
+
+	// reject records why the annotated service is not exposed, so that it is served by the state API
+	reject := func(service *v1.Service, eventReason, reason string) {
+		recorder.Event(service, v1.EventTypeWarning, eventReason, reason)
+		writer.set(service, StatusRejected, 0)
+		cluster.Mu.Lock()
+		cluster.Skip(service.Namespace, service.Name, reason)
+		cluster.Mu.Unlock()
+	}
+
+	unskip := func(service *v1.Service) {
+		cluster.Mu.Lock()
+		cluster.Unskip(service.Namespace, service.Name)
+		cluster.Mu.Unlock()
+	}
+
+	// isValid returns true if service must be exposed through Nginx
+	isValid := func(service *v1.Service) bool {
+		if val, ok := service.Annotations[ncgo.CustomAnnotation]; !ok || val != "true" {
+			logger.Debug("service is not properly annotated, skipping...")
+			writer.clear(service)
+			unskip(service)
+			return false
+		}
+
+		if service.Spec.Type != v1.ServiceTypeNodePort {
+			logger.Debug("not a NodePort type service, skipping...")
+			reject(service, EventReasonRejected, fmt.Sprintf("service type %s is not %s", service.Spec.Type,
+				v1.ServiceTypeNodePort))
+			return false
+		}
+
+		if !filter.allowed(service.Namespace) {
+			logger.Debug("namespace of the service is not allowed, skipping...",
+				zap.String("name", service.Name), zap.String("namespace", service.Namespace))
+			reject(service, EventReasonRejected, fmt.Sprintf("services of namespace %s are not allowed to be exposed",
+				service.Namespace))
+			return false
+		}
+
+		return true
+	}
+
+	serviceOwner := func(service *v1.Service) string {
+		return clusterOwnerPrefix(cluster) + service.Namespace + "/" + service.Name
+	}
+
+	// retry adds the service again when the listen port it is waiting for is released
+	retry := func(namespace, name string) func() {
+		return func() {
+			for _, serviceLister := range serviceListers {
+				if service, err := serviceLister.Services(namespace).Get(name); err == nil {
+					handlers.add(nil, service)
+					return
+				}
 			}
-			cluster.Mu.Unlock()
+		}
+	}
 
-			service := obj.(*v1.Service)
-			if val, ok := service.Annotations[ncgo.CustomAnnotation]; !ok || val != "true" {
-				logger.Debug("service is not properly annotated, skipping...")
-				return
+	// removeService removes service from cluster.NodePorts, returns true if the changes need to be applied. event is
+	// the informer event which removes it, if any
+	removeService := func(event *informerEvent, service *v1.Service) bool {
+		nodePort := types.NewNodePort(cluster.MasterIP, service.Spec.Ports[0].NodePort)
+		cluster.Mu.Lock()
+		index, found := findNodePort(cluster.NodePorts, nodePort)
+		if found {
+			logger.Info("removing service from cluster.NodePorts", zap.String("masterIP", cluster.MasterIP),
+				zap.String("name", service.Name), zap.String("namespace", service.Namespace),
+				zap.Int32("nodePort", nodePort.Port))
+			removeNodePort(&cluster.NodePorts, index)
+		}
+		cluster.Mu.Unlock()
+
+		if found {
+			event.changed()
+		}
+		return found
+	}
+
+	// removeMovedService removes service from cluster.NodePorts if it is exposed on another listen port than
+	// listenPort, so that its previous port is not rendered anymore when it is released
+	removeMovedService := func(event *informerEvent, service *v1.Service, listenPort int32) bool {
+		nodePort := types.NewNodePort(cluster.MasterIP, service.Spec.Ports[0].NodePort)
+		cluster.Mu.Lock()
+		index, found := findNodePort(cluster.NodePorts, nodePort)
+		moved := found && cluster.NodePorts[index].Listen() != listenPort
+		cluster.Mu.Unlock()
+		if !moved {
+			return false
+		}
+
+		return removeService(event, service)
+	}
+
+	// rejectService rejects service, removes it from cluster.NodePorts and releases its listen port. The service is
+	// removed first, so that a service waiting for the port does not render it twice. It returns true if the changes
+	// need to be applied
+	rejectService := func(event *informerEvent, service *v1.Service, eventReason, reason string) bool {
+		reject(service, eventReason, reason)
+		removed := removeService(event, service)
+		m.listenPorts.release(serviceOwner(service))
+		return removed
+	}
+
+	// addService adds a valid service to cluster.NodePorts, returns true if the changes need to be applied. event is
+	// the informer event which adds it, if any
+	addService := func(event *informerEvent, service *v1.Service) bool {
+		owner := serviceOwner(service)
+		listenPort, err := serviceListenPort(ncgo, service)
+		if err != nil {
+			logger.Warn("service has an invalid listen port annotation, skipping...", zap.String("name", service.Name),
+				zap.String("namespace", service.Namespace), zap.String("error", err.Error()))
+			return rejectService(event, service, EventReasonInvalidAnnotation, err.Error())
+		}
+
+		if isReservedListenPort(ncgo, listenPort) {
+			logger.Warn("listen port of the service is reserved, skipping...", zap.String("name", service.Name),
+				zap.String("namespace", service.Namespace), zap.Int32("listenPort", listenPort))
+			return rejectService(event, service, EventReasonReservedListenPort,
+				fmt.Sprintf("listen port %d is reserved", listenPort))
+		}
+
+		// the claim releases the previous listen port of the service, which must not be rendered anymore by then
+		moved := removeMovedService(event, service, listenPort)
+		if current, ok := m.listenPorts.claim(listenPort, owner, retry(service.Namespace, service.Name)); !ok {
+			logger.Warn("listen port of the service is already claimed, skipping...",
+				zap.String("name", service.Name), zap.String("namespace", service.Namespace),
+				zap.Int32("listenPort", listenPort), zap.String("owner", current))
+			reject(service, EventReasonListenPortConflict, fmt.Sprintf("listen port %d is already claimed by %s, "+
+				"it will be retried when the port is released", listenPort, current))
+			return removeService(event, service) || moved
+		}
+
+		nodePort := types.NewNodePort(cluster.MasterIP, service.Spec.Ports[0].NodePort)
+		nodePort.ListenPort = listenPort
+		nodePort.Namespace, nodePort.Name = service.Namespace, service.Name
+		writer.set(service, StatusServing, listenPort)
+
+		cluster.Mu.Lock()
+		defer cluster.Mu.Unlock()
+		cluster.Unskip(service.Namespace, service.Name)
+		if index, found := findNodePort(cluster.NodePorts, nodePort); found {
+			if cluster.NodePorts[index].Listen() == nodePort.Listen() {
+				return false
 			}
+			removeNodePort(&cluster.NodePorts, index)
+		}
 
-			if service.Spec.Type != v1.ServiceTypeNodePort {
-				logger.Debug("not a NodePort type service, skipping...")
+		logger.Info("adding nodePort to cluster.NodePorts", zap.String("name", service.Name),
+			zap.String("namespace", service.Namespace), zap.Int32("nodePort", nodePort.Port),
+			zap.Int32("listenPort", nodePort.Listen()))
+		event.changed()
+		addWorkersToNodePort(cluster.Workers, nodePort)
+		addNodePort(&cluster.NodePorts, nodePort)
+		recorder.Eventf(service, v1.EventTypeNormal, EventReasonAccepted, "exposed on listen port %d of Nginx",
+			nodePort.Listen())
+		return true
+	}
+
+	hasWorkers := func() bool {
+		cluster.Mu.Lock()
+		defer cluster.Mu.Unlock()
+		if len(cluster.Workers) == 0 {
+			logger.Warn(WarnWorkerLength)
+			return false
+		}
+
+		return true
+	}
+
+	apply := func() {
+		m.applyOrFail(logger)
+	}
+
+	handlers = eventHandler{
+		add: func(event *informerEvent, obj interface{}) {
+			if !hasWorkers() {
 				return
 			}
 
-			logger.Info("valid service added", zap.String("name", service.Name),
-				zap.String("namespace", service.Namespace), zap.Int32("nodePort", service.Spec.Ports[0].NodePort))
-
-			nodePort := types.NewNodePort(cluster.MasterIP, service.Spec.Ports[0].NodePort)
-			_, found := findNodePort(cluster.NodePorts, nodePort)
-			if !found {
-				logger.Info("adding nodePort to backend.NodePorts", zap.Int32("nodePort", nodePort.Port))
-				cluster.Mu.Lock()
-				addNodePort(&cluster.NodePorts, nodePort)
-				cluster.Mu.Unlock()
-				cluster.Mu.Lock()
-				addWorkersToNodePort(cluster.Workers, nodePort)
-				cluster.Mu.Unlock()
+			service := obj.(*v1.Service)
+			if !isValid(service) {
+				return
 			}
 
-			if err := applyChanges(ncgo, nginxConf); err != nil {
-				logger.Fatal(ErrApplyChanges, zap.String("error", err.Error()))
+			if addService(event, service) || writer.pending() {
+				apply()
 			}
 		},
-		UpdateFunc: func(oldObj interface{}, newObj interface{}) {
-			cluster.Mu.Lock()
-			if len(cluster.Workers) == 0 {
-				logger.Warn(WarnWorkerLength)
+		update: func(event *informerEvent, oldObj interface{}, newObj interface{}) {
+			if !hasWorkers() {
 				return
 			}
-			cluster.Mu.Unlock()
-
-			var applyRequired bool
 
 			oldService := oldObj.(*v1.Service)
 			newService := newObj.(*v1.Service)
-			oldVal, oldOk := oldService.Annotations[ncgo.CustomAnnotation]
-			oldOk = oldOk && oldVal == "true"
-			newVal, newOk := newService.Annotations[ncgo.CustomAnnotation]
-			newOk = newOk && newVal == "true"
 
 			// check if it's a real update
 			if oldService.ResourceVersion == newService.ResourceVersion {
@@ -83,96 +246,125 @@ func RunServiceInformer(cluster *types.Cluster, clientSet kubernetes.Interface,
 				return
 			}
 
-			if oldOk && oldService.Spec.Type == v1.ServiceTypeNodePort {
-				if newOk && newService.Spec.Type == v1.ServiceTypeNodePort {
-					if newService.Spec.Ports[0].NodePort != oldService.Spec.Ports[0].NodePort {
-						oldNodePort := types.NewNodePort(cluster.MasterIP, oldService.Spec.Ports[0].NodePort)
-						newNodePort := types.NewNodePort(cluster.MasterIP, newService.Spec.Ports[0].NodePort)
-						logger.Info("nodePort changed on the valid service, updating",
-							zap.String("masterIP", cluster.MasterIP), zap.String("name", oldService.Name),
-							zap.String("namespace", oldService.Namespace), zap.Int32("oldNodePort", oldNodePort.Port),
-							zap.Int32("newNodePort", newNodePort.Port))
-						updateNodePort(&cluster.NodePorts, cluster.Workers, oldNodePort, newNodePort)
-						applyRequired = true
-					} else {
-						logger.Info("nodePort ports are the same on the updated service, nothing to do",
-							zap.String("masterIP", cluster.MasterIP), zap.String("name", oldService.Name),
-							zap.String("namespace", oldService.Namespace))
-					}
-				} else {
-					oldNodePort := types.NewNodePort(cluster.MasterIP, oldService.Spec.Ports[0].NodePort)
-					oldIndex, oldFound := findNodePort(cluster.NodePorts, oldNodePort)
-					if oldFound {
-						logger.Info("removing service from cluster.NodePorts because it is no more nodePort "+
-							"type or no more labelled!", zap.String("masterIP", cluster.MasterIP),
-							zap.String("name", oldService.Name), zap.String("namespace", oldService.Namespace))
-						removeNodePort(&cluster.NodePorts, oldIndex)
-						logger.Info("successfully removed service from cluster.NodePorts",
-							zap.String("name", oldService.Name),
-							zap.String("namespace", oldService.Namespace))
-						applyRequired = true
-					}
-				}
-			} else if newOk && newService.Spec.Type == v1.ServiceTypeNodePort {
-				newNodePort := types.NewNodePort(cluster.MasterIP, newService.Spec.Ports[0].NodePort)
-				_, newFound := findNodePort(cluster.NodePorts, newNodePort)
-				if !newFound {
-					logger.Info("adding service to cluster.NodePorts because it is labelled and nodePort "+
-						"type service", zap.String("name", newService.Name),
-						zap.String("namespace", newService.Namespace), zap.Int32("nodePort", newNodePort.Port))
-					addNodePort(&cluster.NodePorts, newNodePort)
-					addWorkersToNodePort(cluster.Workers, newNodePort)
-					logger.Info("successfully added service to cluster.NodePorts",
-						zap.String("name", newService.Name), zap.String("namespace", newService.Namespace),
-						zap.Int32("nodePort", newNodePort.Port))
-					applyRequired = true
-				}
+			// the status annotations are written by the generator itself after the applies
+			if isStatusUpdate(oldService, newService) {
+				logger.Debug("only the status annotations are updated, skipping")
+				return
 			}
 
-			if applyRequired {
-				if err := applyChanges(ncgo, nginxConf); err != nil {
-					logger.Fatal(ErrApplyChanges, zap.String("error", err.Error()))
+			var applyRequired bool
+			if oldService.Spec.Type == v1.ServiceTypeNodePort &&
+				(newService.Spec.Type != v1.ServiceTypeNodePort ||
+					oldService.Spec.Ports[0].NodePort != newService.Spec.Ports[0].NodePort) {
+				applyRequired = removeService(event, oldService)
+			}
+
+			if isValid(newService) {
+				applyRequired = addService(event, newService) || applyRequired
+			} else {
+				if newService.Spec.Type == v1.ServiceTypeNodePort {
+					applyRequired = removeService(event, newService) || applyRequired
 				}
+				m.listenPorts.release(serviceOwner(newService))
 			}
-		},
-		DeleteFunc: func(obj interface{}) {
-			cluster.Mu.Lock()
-			if len(cluster.Workers) == 0 {
-				logger.Warn(WarnWorkerLength)
-				return
+
+			if applyRequired || writer.pending() {
+				apply()
 			}
-			cluster.Mu.Unlock()
+		},
+		delete: func(event *informerEvent, obj interface{}) {
+			service, ok := obj.(*v1.Service)
+			if !ok {
+				tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+				if !ok {
+					return
+				}
 
-			service := obj.(*v1.Service)
-			if val, ok := service.Annotations[ncgo.CustomAnnotation]; !ok || val != "true" {
-				logger.Debug("service is not properly annotated, skipping...")
-				return
+				if service, ok = tombstone.Obj.(*v1.Service); !ok {
+					return
+				}
 			}
 
+			unskip(service)
+
+			// namespace filter is not checked, services must be removed when their namespace is not allowed anymore
 			if service.Spec.Type != v1.ServiceTypeNodePort {
 				logger.Debug("not a NodePort type service, skipping...")
 				return
 			}
 
-			nodePort := types.NewNodePort(cluster.MasterIP, service.Spec.Ports[0].NodePort)
-			index, found := findNodePort(cluster.NodePorts, nodePort)
-			if found {
-				logger.Info("valid service deleted, removing from cluster.NodePorts",
-					zap.String("name", service.Name), zap.String("namespace", service.Namespace))
-				removeNodePort(&cluster.NodePorts, index)
-				logger.Info("successfully removed deleted service from cluster.NodePorts",
-					zap.String("name", service.Name), zap.String("namespace", service.Namespace))
-			}
-
-			if err := applyChanges(ncgo, nginxConf); err != nil {
-				logger.Fatal(ErrApplyChanges, zap.String("error", err.Error()))
+			applyRequired := removeService(event, service)
+			m.listenPorts.release(serviceOwner(service))
+			writer.forget(service)
+			if applyRequired {
+				apply()
 			}
 		},
-	}); err != nil {
-		return errors.Wrap(err, "unable to run service informer")
 	}
 
-	informerFactory.Start(wait.NeverStop)
-	informerFactory.WaitForCacheSync(wait.NeverStop)
-	return nil
+	var informerFactories []informers.SharedInformerFactory
+	if namespaces := filter.watchedNamespaces(); namespaces != nil {
+		logger.Info("watching services through namespaced informers", zap.Strings("namespaces", namespaces))
+		for _, namespace := range namespaces {
+			informerFactories = append(informerFactories, informers.NewSharedInformerFactoryWithOptions(clientSet,
+				time.Second*30, informers.WithNamespace(namespace)))
+		}
+	} else {
+		informerFactories = append(informerFactories, informers.NewSharedInformerFactory(clientSet, time.Second*30))
+	}
+
+	serviceInformers := make([]cache.SharedIndexInformer, 0, len(informerFactories))
+	for _, informerFactory := range informerFactories {
+		serviceInformer := informerFactory.Core().V1().Services()
+		if err := m.addEventHandler(cluster, "service", serviceInformer.Informer(), handlers); err != nil {
+			return nil, errors.Wrap(err, "unable to run service informer")
+		}
+		serviceInformers = append(serviceInformers, serviceInformer.Informer())
+		serviceListers = append(serviceListers, serviceInformer.Lister())
+	}
+
+	// namespaces must be synced first, otherwise services of the selected namespaces are skipped on startup
+	if filter.selector != nil {
+		if err := m.runNamespaceInformer(ctx, cluster, filter, clientSet, logger,
+			func(event *informerEvent, namespace string, selected bool) {
+				resyncNamespaceServices(event, serviceListers, namespace, selected, handlers, logger)
+			}); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, informerFactory := range informerFactories {
+		if err := m.startInformerFactory(ctx, informerFactory); err != nil {
+			return nil, errors.Wrap(err, "unable to run service informer")
+		}
+	}
+
+	return serviceInformers, nil
+}
+
+// clusterOwnerPrefix is the prefix of the listen port owners of the services of cluster
+func clusterOwnerPrefix(cluster *types.Cluster) string {
+	return cluster.MasterIP + "/"
+}
+
+// resyncNamespaceServices adds or removes the services in namespace when the namespace selector starts or stops
+// matching it with the namespace event which changes it
+func resyncNamespaceServices(event *informerEvent, serviceListers []corelisters.ServiceLister, namespace string,
+	selected bool, handlers eventHandler, logger *zap.Logger) {
+	for _, serviceLister := range serviceListers {
+		services, err := serviceLister.Services(namespace).List(labels.Everything())
+		if err != nil {
+			logger.Error("unable to list services of the namespace", zap.String("namespace", namespace),
+				zap.String("error", err.Error()))
+			continue
+		}
+
+		for _, service := range services {
+			if selected {
+				handlers.add(event, service)
+			} else {
+				handlers.delete(event, service)
+			}
+		}
+	}
 }
@@ -8,7 +8,6 @@ import (
 	"time"
 
 	"github.com/bilalcaliskan/nginx-conf-generator/internal/k8s/types"
-	"github.com/bilalcaliskan/nginx-conf-generator/internal/logging"
 
 	"github.com/stretchr/testify/assert"
 	v1 "k8s.io/api/core/v1"
@@ -98,24 +97,21 @@ func TestRunServiceInformer(t *testing.T) {
 	api := getFakeAPI()
 	assert.NotNil(t, api)
 
-	opts.Mu.Lock()
-	opts.TemplateInputFile = "../../../resources/ncg.conf.tmpl"
-	opts.TemplateOutputFile = "/etc/nginx/conf.d/ncg_test.conf"
-	opts.Mu.Unlock()
+	m := newTestManager(t, nil)
 
-	var clusters []*types.Cluster
-	nginxConf := types.NewNginxConf(clusters)
 	cluster := types.NewCluster("", make([]*types.Worker, 0))
-	nginxConf.Clusters = append(nginxConf.Clusters, cluster)
+	m.nginxConf.Clusters = []*types.Cluster{cluster}
 	t.Logf(opts.CustomAnnotation)
 
 	go func() {
-		err := RunServiceInformer(cluster, api.ClientSet, logging.GetLogger(), nginxConf)
+		_, err := m.RunServiceInformer(parentCtx, cluster, clusterOpts, api.ClientSet,
+			NewEventRecorder(api.ClientSet, testLogger, m.elector.IsLeader), testLogger)
 		assert.Nil(t, err)
 	}()
 
 	go func() {
-		err := RunNodeInformer(cluster, api.ClientSet, logging.GetLogger(), nginxConf)
+		err := m.RunNodeInformer(parentCtx, cluster, clusterOpts, api.ClientSet,
+			NewEventRecorder(api.ClientSet, testLogger, m.elector.IsLeader), testLogger)
 		assert.Nil(t, err)
 	}()
 
@@ -0,0 +1,100 @@
+package informers
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// cacheSyncTimeout is the duration which the caches of an informer factory are waited to be synced for
+var cacheSyncTimeout = 5 * time.Minute
+
+// sharedInformerFactory is implemented by both of the Kubernetes and the Gateway API shared informer factories
+type sharedInformerFactory interface {
+	Start(stopCh <-chan struct{})
+	WaitForCacheSync(stopCh <-chan struct{}) map[reflect.Type]bool
+	Shutdown()
+}
+
+// track adds a goroutine to running and returns it, so that the goroutine marks itself done on the same
+// sync.WaitGroup. Returns nil if the shutdown is already started
+func (m *Manager) track() *sync.WaitGroup {
+	m.runningMu.Lock()
+	defer m.runningMu.Unlock()
+
+	if m.shuttingDown {
+		return nil
+	}
+
+	m.running.Add(1)
+	return &m.running
+}
+
+// startInformerFactory starts factory until ctx is done and waits for its caches to be synced
+func (m *Manager) startInformerFactory(ctx context.Context, factory sharedInformerFactory) error {
+	wg := m.track()
+	if wg == nil {
+		return errors.New("unable to start informer factory, shutting down")
+	}
+
+	factory.Start(ctx.Done())
+	go func() {
+		defer wg.Done()
+		<-ctx.Done()
+		// blocks until the running informers and their handlers are returned
+		factory.Shutdown()
+	}()
+
+	// the caches are not synced while the objects can not be listed, e.g. without the permissions to list them
+	syncCtx, cancel := context.WithTimeout(ctx, cacheSyncTimeout)
+	defer cancel()
+	for informerType, synced := range factory.WaitForCacheSync(syncCtx.Done()) {
+		if !synced {
+			return errors.Errorf("unable to sync the cache of %v", informerType)
+		}
+	}
+
+	return nil
+}
+
+// Shutdown waits for the informer factories and controllers to stop after their context is canceled, then waits
+// for the in-progress render and post-apply hooks. No configuration is rendered after Shutdown returns. An error is
+// returned if ctx is done before a clean shutdown
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.runningMu.Lock()
+	m.shuttingDown = true
+	m.runningMu.Unlock()
+
+	if err := waitGroup(ctx, &m.running); err != nil {
+		return errors.Wrap(err, "informers are not stopped")
+	}
+
+	// waits for the in-progress render, the rendered files are replaced atomically so they are never half written
+	m.mu.Lock()
+	m.stopped = true
+	m.mu.Unlock()
+
+	if err := waitGroup(ctx, &m.hooks); err != nil {
+		return errors.Wrap(err, "post-apply hooks are not finished")
+	}
+
+	return nil
+}
+
+func waitGroup(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,72 @@
+package informers
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/k8s/types"
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/options"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestShutdown(t *testing.T) {
+	m := newTestManager(t, nil)
+
+	clientSet := fake.NewSimpleClientset()
+	worker := types.NewWorker("10.0.0.7", "10.0.0.71", v1.ConditionTrue)
+	cluster := types.NewCluster("10.0.0.7", []*types.Worker{worker})
+	m.nginxConf.Clusters = []*types.Cluster{cluster}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, err := m.RunServiceInformer(ctx, cluster, &options.ClusterOptions{}, clientSet,
+		NewEventRecorder(clientSet, testLogger, m.elector.IsLeader), testLogger)
+	assert.Nil(t, err)
+
+	service := getListenPortService("team-s", "app", 30700, "")
+	delete(service.Annotations, opts.ListenPortAnnotation)
+	_, err = clientSet.CoreV1().Services(service.Namespace).Create(context.Background(), service,
+		metav1.CreateOptions{})
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		content, _ := os.ReadFile(m.ncgo.TemplateOutputFile)
+		return containsAll(string(content), "server 10.0.0.71:30700")
+	}, 5*time.Second, 100*time.Millisecond)
+
+	cancel()
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+	assert.Nil(t, m.Shutdown(shutdownCtx))
+
+	// nothing is started or rendered after the shutdown
+	assert.Nil(t, m.track())
+	_, err = m.RunServiceInformer(context.Background(), cluster, &options.ClusterOptions{}, clientSet,
+		NewEventRecorder(clientSet, testLogger, m.elector.IsLeader), testLogger)
+	assert.NotNil(t, err)
+
+	cluster.Mu.Lock()
+	cluster.Dropped = true
+	cluster.Mu.Unlock()
+	assert.Nil(t, m.applyChanges())
+	content, err := os.ReadFile(m.ncgo.TemplateOutputFile)
+	assert.Nil(t, err)
+	assert.Contains(t, string(content), "server 10.0.0.71:30700")
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	m := newTestManager(t, nil)
+	// a tracked goroutine which never stops
+	wg := m.track()
+	assert.NotNil(t, wg)
+	defer wg.Done()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.NotNil(t, m.Shutdown(ctx))
+}
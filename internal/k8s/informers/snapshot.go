@@ -0,0 +1,194 @@
+package informers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/k8s/types"
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/options"
+	"github.com/bilalcaliskan/nginx-conf-generator/pkg/model"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ClusterSource is a cluster which a snapshot is built from
+type ClusterSource struct {
+	Options   *options.ClusterOptions
+	MasterIP  string
+	ClientSet kubernetes.Interface
+}
+
+// Snapshot returns the current state of the clusters which are run by m. It does not wait for an in-progress render,
+// the clusters are copied while holding their own locks only
+func (m *Manager) Snapshot() *model.Snapshot {
+	return m.nginxConf.Snapshot()
+}
+
+// BuildSnapshot lists the nodes and services of sources once and builds their state with the same rules as the
+// informers. Listen ports are claimed in the order of the sources and the creation of the services. Gateway API
+// resources are not included
+func BuildSnapshot(ctx context.Context, ncgo *options.NginxConfGeneratorOptions,
+	sources []ClusterSource) (*model.Snapshot, error) {
+	eligibility, err := newNodeEligibility(ncgo)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse node eligibility rules")
+	}
+
+	nginxConf := types.NewNginxConf(make([]*types.Cluster, 0, len(sources)))
+	listenPorts := newListenPortRegistry()
+	for _, source := range sources {
+		cluster := types.NewCluster(source.MasterIP, make([]*types.Worker, 0))
+		cluster.Name = source.Options.Name
+		if err := buildWorkers(ctx, cluster, source, eligibility); err != nil {
+			return nil, errors.Wrapf(err, "unable to build workers of cluster %s", cluster.Name)
+		}
+
+		if err := buildNodePorts(ctx, cluster, source, ncgo, listenPorts); err != nil {
+			return nil, errors.Wrapf(err, "unable to build nodePorts of cluster %s", cluster.Name)
+		}
+		nginxConf.Clusters = append(nginxConf.Clusters, cluster)
+	}
+
+	return nginxConf.Snapshot(), nil
+}
+
+// buildWorkers adds the eligible nodes which match the worker node selector of source to cluster
+func buildWorkers(ctx context.Context, cluster *types.Cluster, source ClusterSource,
+	eligibility *nodeEligibility) error {
+	selector, err := labels.Parse(source.Options.WorkerNodeSelector)
+	if err != nil {
+		return errors.Wrap(err, "unable to parse worker node selector")
+	}
+
+	nodes, err := source.ClientSet.CoreV1().Nodes().List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return errors.Wrap(err, "unable to list nodes")
+	}
+
+	sort.Slice(nodes.Items, func(i, j int) bool {
+		return nodes.Items[i].Name < nodes.Items[j].Name
+	})
+
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		if !selector.Matches(labels.Set(node.Labels)) {
+			continue
+		}
+
+		if eligible, _ := eligibility.check(node); !eligible {
+			continue
+		}
+
+		address, ok := nodeAddress(node, source.Options)
+		if !ok {
+			continue
+		}
+
+		worker := types.NewWorker(cluster.MasterIP, address, isNodeReady(node))
+		worker.NodeName = node.Name
+		addWorker(cluster, worker)
+	}
+
+	return nil
+}
+
+// buildNodePorts adds the services of source which must be exposed through Nginx to cluster. Services are skipped
+// when the cluster has no workers, the same as the service informer does
+func buildNodePorts(ctx context.Context, cluster *types.Cluster, source ClusterSource,
+	ncgo *options.NginxConfGeneratorOptions, listenPorts *listenPortRegistry) error {
+	if len(cluster.Workers) == 0 {
+		return nil
+	}
+
+	filter, err := newNamespaceFilter(source.Options)
+	if err != nil {
+		return err
+	}
+
+	if filter.selector != nil {
+		namespaces, err := source.ClientSet.CoreV1().Namespaces().List(ctx,
+			metav1.ListOptions{LabelSelector: filter.selector.String()})
+		if err != nil {
+			return errors.Wrap(err, "unable to list namespaces")
+		}
+
+		for _, namespace := range namespaces.Items {
+			filter.setSelected(namespace.Name, filter.selector.Matches(labels.Set(namespace.Labels)))
+		}
+	}
+
+	// an empty namespace lists the services of all namespaces
+	namespaces := filter.watchedNamespaces()
+	if namespaces == nil {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+
+	var services []v1.Service
+	for _, namespace := range namespaces {
+		list, err := source.ClientSet.CoreV1().Services(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return errors.Wrap(err, "unable to list services")
+		}
+		services = append(services, list.Items...)
+	}
+
+	// the oldest service wins a listen port, the same as it does on a long running generator
+	sort.SliceStable(services, func(i, j int) bool {
+		if !services[i].CreationTimestamp.Equal(&services[j].CreationTimestamp) {
+			return services[i].CreationTimestamp.Before(&services[j].CreationTimestamp)
+		}
+		return fmt.Sprintf("%s/%s", services[i].Namespace, services[i].Name) <
+			fmt.Sprintf("%s/%s", services[j].Namespace, services[j].Name)
+	})
+
+	for i := range services {
+		service := &services[i]
+		if service.Annotations[ncgo.CustomAnnotation] != "true" {
+			continue
+		}
+
+		if service.Spec.Type != v1.ServiceTypeNodePort {
+			cluster.Skip(service.Namespace, service.Name, fmt.Sprintf("service type %s is not %s",
+				service.Spec.Type, v1.ServiceTypeNodePort))
+			continue
+		}
+
+		if !filter.allowed(service.Namespace) {
+			cluster.Skip(service.Namespace, service.Name, fmt.Sprintf(
+				"services of namespace %s are not allowed to be exposed", service.Namespace))
+			continue
+		}
+
+		listenPort, err := serviceListenPort(ncgo, service)
+		if err != nil {
+			cluster.Skip(service.Namespace, service.Name, err.Error())
+			continue
+		}
+
+		if isReservedListenPort(ncgo, listenPort) {
+			cluster.Skip(service.Namespace, service.Name, fmt.Sprintf("listen port %d is reserved", listenPort))
+			continue
+		}
+
+		owner := fmt.Sprintf("%s/%s/%s", cluster.MasterIP, service.Namespace, service.Name)
+		if current, ok := listenPorts.claim(listenPort, owner, nil); !ok {
+			cluster.Skip(service.Namespace, service.Name, fmt.Sprintf("listen port %d is already claimed by %s",
+				listenPort, current))
+			continue
+		}
+
+		nodePort := types.NewNodePort(cluster.MasterIP, service.Spec.Ports[0].NodePort)
+		nodePort.ListenPort = listenPort
+		nodePort.Namespace, nodePort.Name = service.Namespace, service.Name
+		addWorkersToNodePort(cluster.Workers, nodePort)
+		addNodePort(&cluster.NodePorts, nodePort)
+	}
+
+	return nil
+}
@@ -0,0 +1,111 @@
+package informers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/k8s/types"
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/options"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestBuildSnapshot(t *testing.T) {
+	first, second := getFakeAPI(), getFakeAPI()
+	_, err := first.createNode("node01", "10.0.0.81", v1.ConditionTrue, true)
+	assert.Nil(t, err)
+	_, err = first.createNode("node02", "10.0.0.82", v1.ConditionFalse, true)
+	assert.Nil(t, err)
+	_, err = first.createNode("node03", "10.0.0.83", v1.ConditionTrue, false)
+	assert.Nil(t, err)
+	_, err = second.createNode("node01", "10.0.1.81", v1.ConditionTrue, true)
+	assert.Nil(t, err)
+
+	_, err = first.createService("app", 30800, v1.ServiceTypeNodePort, true)
+	assert.Nil(t, err)
+	_, err = first.createService("disabled", 30801, v1.ServiceTypeNodePort, false)
+	assert.Nil(t, err)
+
+	// the service of the second cluster is created later, so the listen port is owned by the first cluster
+	conflicting := getListenPortService("default", "conflicting", 30802, "30800")
+	conflicting.CreationTimestamp = metav1.NewTime(time.Now().Add(time.Minute))
+	_, err = second.ClientSet.CoreV1().Services("default").Create(context.Background(), conflicting,
+		metav1.CreateOptions{})
+	assert.Nil(t, err)
+	_, err = second.createService("other", 30803, v1.ServiceTypeNodePort, true)
+	assert.Nil(t, err)
+
+	snapshot, err := BuildSnapshot(context.Background(), opts, []ClusterSource{
+		{Options: &options.ClusterOptions{Name: "first", NodeAddressTypes: options.DefaultNodeAddressTypes,
+			WorkerNodeSelector: "worker=true"}, MasterIP: "10.0.0.80", ClientSet: first.ClientSet},
+		{Options: &options.ClusterOptions{Name: "second", NodeAddressTypes: options.DefaultNodeAddressTypes,
+			WorkerNodeSelector: "worker=true"}, MasterIP: "10.0.1.80", ClientSet: second.ClientSet},
+	})
+	assert.Nil(t, err)
+	assert.Len(t, snapshot.Clusters, 2)
+
+	cluster := snapshot.Clusters[0]
+	assert.Equal(t, "first", cluster.Name)
+	assert.Len(t, cluster.Workers, 1)
+	assert.Equal(t, "10.0.0.81", cluster.Workers[0].HostIP)
+	assert.Equal(t, "node01", cluster.Workers[0].NodeName)
+	assert.True(t, cluster.Workers[0].Ready)
+	assert.Len(t, cluster.NodePorts, 1)
+	assert.Equal(t, int32(30800), cluster.NodePorts[0].Listen())
+	assert.Equal(t, "default", cluster.NodePorts[0].Namespace)
+	assert.Equal(t, "app", cluster.NodePorts[0].Name)
+	// services which are not annotated are not reported as skipped
+	assert.Empty(t, cluster.Skipped)
+	assert.Len(t, cluster.NodePorts[0].Workers, 1)
+
+	cluster = snapshot.Clusters[1]
+	assert.Equal(t, "second", cluster.Name)
+	assert.Len(t, cluster.NodePorts, 1)
+	assert.Equal(t, int32(30803), cluster.NodePorts[0].Port)
+	assert.Len(t, cluster.Skipped, 1)
+	assert.Equal(t, "conflicting", cluster.Skipped[0].Name)
+	assert.Contains(t, cluster.Skipped[0].Reason, "listen port 30800 is already claimed by 10.0.0.80/default/app")
+}
+
+func TestBuildSnapshotInvalid(t *testing.T) {
+	api := getFakeAPI()
+	_, err := BuildSnapshot(context.Background(), opts, []ClusterSource{
+		{Options: &options.ClusterOptions{Name: "invalid", WorkerNodeSelector: "worker in ("},
+			ClientSet: api.ClientSet},
+	})
+	assert.NotNil(t, err)
+}
+
+func TestManagerSnapshot(t *testing.T) {
+	m := newTestManager(t, nil)
+	worker := types.NewWorker("10.0.0.9", "10.0.0.91", v1.ConditionTrue)
+	cluster := types.NewCluster("10.0.0.9", []*types.Worker{worker})
+	m.nginxConf.Clusters = []*types.Cluster{cluster}
+
+	snapshot := m.Snapshot()
+	assert.Len(t, snapshot.Clusters, 1)
+	assert.Equal(t, "10.0.0.91", snapshot.Clusters[0].Workers[0].HostIP)
+
+	// the snapshot is a copy, later changes of the cluster are not reflected
+	worker.Draining = true
+	assert.False(t, snapshot.Clusters[0].Workers[0].Draining)
+
+	// the snapshot does not wait for an in-progress render
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.nginxConf.AddCluster(types.NewCluster("10.0.0.10", nil))
+		assert.Len(t, m.Snapshot().Clusters, 2)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("snapshot is blocked by the render")
+	}
+}
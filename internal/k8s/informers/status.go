@@ -0,0 +1,330 @@
+package informers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// StatusAnnotation is written onto services with either StatusServing or StatusRejected
+	StatusAnnotation = "nginx-conf-generator/status"
+	// ListenAnnotation is written onto serving services with the port which Nginx listens on
+	ListenAnnotation = "nginx-conf-generator/listen"
+	// LastAppliedAnnotation is written onto serving services with the time and the hash of the latest applied
+	// configuration, it is patched at most once a minute when only the configuration is changed
+	LastAppliedAnnotation = "nginx-conf-generator/last-applied"
+	// StatusServing means the service is exposed through Nginx
+	StatusServing = "Serving"
+	// StatusRejected means the service is annotated but can not be exposed through Nginx
+	StatusRejected = "Rejected"
+	// statusPatchTimeout bounds each patch of the status annotations
+	statusPatchTimeout = 30 * time.Second
+	// lastAppliedInterval is the minimum interval between the LastAppliedAnnotation patches of a single service, so
+	// that frequent applies do not flood the API server
+	lastAppliedInterval = time.Minute
+)
+
+// LastApplied is the value of LastAppliedAnnotation
+type LastApplied struct {
+	Time time.Time `json:"time"`
+	Hash string    `json:"hash"`
+}
+
+// statusAnnotations are the annotations which are written by the statusWriter
+var statusAnnotations = []string{StatusAnnotation, ListenAnnotation, LastAppliedAnnotation}
+
+// isStatusUpdate returns true if newService differs from oldService only by the status annotations, e.g. the update
+// which is caused by a patch of the statusWriter
+func isStatusUpdate(oldService, newService *v1.Service) bool {
+	if !equality.Semantic.DeepEqual(oldService.Spec, newService.Spec) ||
+		!equality.Semantic.DeepEqual(oldService.Labels, newService.Labels) {
+		return false
+	}
+
+	withoutStatus := func(annotations map[string]string) map[string]string {
+		result := make(map[string]string, len(annotations))
+		for key, value := range annotations {
+			result[key] = value
+		}
+		for _, key := range statusAnnotations {
+			delete(result, key)
+		}
+		return result
+	}
+
+	return equality.Semantic.DeepEqual(withoutStatus(oldService.Annotations), withoutStatus(newService.Annotations))
+}
+
+// serviceStatus is the status which should be written onto a service, nil means the annotations must be removed
+type serviceStatus struct {
+	status string
+	listen int32
+}
+
+// writtenStatus is the state of the annotations which are written onto a service
+type writtenStatus struct {
+	fingerprint string
+	// hash is the hash of LastAppliedAnnotation and patchedAt is the time it is patched at
+	hash      string
+	patchedAt time.Time
+}
+
+// statusWriter patches the status annotations of services after the configuration is applied. Only the keys of
+// nginx-conf-generator are touched
+type statusWriter struct {
+	clientSet kubernetes.Interface
+	logger    *zap.Logger
+	isLeader  func() bool
+	desired   map[k8stypes.NamespacedName]*serviceStatus
+	// written contains the annotations which are written onto services
+	written map[k8stypes.NamespacedName]*writtenStatus
+	// appliedAt and hash are the time and the hash of the latest written configuration, since hooks of successive
+	// applies may run out of order
+	appliedAt time.Time
+	hash      string
+	// lastAppliedInterval is the minimum interval between the LastAppliedAnnotation patches of a service, retry
+	// sends the patches which are limited by it once it elapses
+	lastAppliedInterval time.Duration
+	retry               *time.Timer
+	mu                  sync.Mutex
+	// writeMu is held while the patches of an apply are sent
+	writeMu sync.Mutex
+}
+
+func newStatusWriter(clientSet kubernetes.Interface, logger *zap.Logger, isLeader func() bool) *statusWriter {
+	return &statusWriter{
+		clientSet: clientSet,
+		logger:    logger,
+		isLeader:  isLeader,
+		desired:   make(map[k8stypes.NamespacedName]*serviceStatus),
+		written:   make(map[k8stypes.NamespacedName]*writtenStatus),
+
+		lastAppliedInterval: lastAppliedInterval,
+	}
+}
+
+// set marks the status of service to be written with the next apply. It is a no-op on a nil statusWriter, so that
+// callers do not need to check if status annotations are enabled
+func (writer *statusWriter) set(service *v1.Service, status string, listen int32) {
+	if writer == nil {
+		return
+	}
+
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+	writer.desired[serviceName(service)] = &serviceStatus{status: status, listen: listen}
+}
+
+// clear marks the status annotations of service to be removed with the next apply, if they are written before
+func (writer *statusWriter) clear(service *v1.Service) {
+	if writer == nil {
+		return
+	}
+
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+	name := serviceName(service)
+	if _, ok := writer.written[name]; ok {
+		writer.desired[name] = nil
+	} else {
+		delete(writer.desired, name)
+	}
+}
+
+// forget drops service without touching it, which is used when the service is deleted
+func (writer *statusWriter) forget(service *v1.Service) {
+	if writer == nil {
+		return
+	}
+
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+	name := serviceName(service)
+	delete(writer.desired, name)
+	delete(writer.written, name)
+}
+
+// pending returns true if there are status changes which are not written yet
+func (writer *statusWriter) pending() bool {
+	if writer == nil {
+		return false
+	}
+
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+	for name, status := range writer.desired {
+		if status == nil {
+			return true
+		}
+
+		if written := writer.written[name]; written == nil || written.fingerprint != status.fingerprint() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// statusPatch is a patch of the status annotations of a service, which is taken by write while holding mu
+type statusPatch struct {
+	name        k8stypes.NamespacedName
+	status      *serviceStatus
+	fingerprint string
+	hash        string
+	annotations map[string]interface{}
+}
+
+// write patches the services whose status annotations are outdated for the configuration with hash. The patches are
+// taken while holding mu and sent without it, so that the handlers are not blocked by a slow API server. Each patch
+// is bounded by statusPatchTimeout and canceled with ctx
+func (writer *statusWriter) write(ctx context.Context, hash string, appliedAt time.Time) {
+	// the writes of successive applies are serialized, so that an older one does not overwrite a newer one
+	writer.writeMu.Lock()
+	defer writer.writeMu.Unlock()
+
+	patches, retryAfter := writer.take(hash, appliedAt)
+	if retryAfter > 0 {
+		writer.scheduleRetry(ctx, retryAfter)
+	}
+
+	for _, patch := range patches {
+		patchCtx, cancel := context.WithTimeout(ctx, statusPatchTimeout)
+		err := writer.patch(patchCtx, patch.name, patch.annotations)
+		cancel()
+		if err != nil && !apierrors.IsNotFound(err) {
+			// the service is patched again with the next apply
+			writer.logger.Warn("unable to patch status annotations of the service", zap.String("name", patch.name.Name),
+				zap.String("namespace", patch.name.Namespace), zap.String("error", err.Error()))
+			continue
+		}
+
+		writer.mu.Lock()
+		switch {
+		case err != nil:
+			delete(writer.desired, patch.name)
+			delete(writer.written, patch.name)
+		case patch.status == nil:
+			// the service may be annotated again while the annotations are removed
+			if status, ok := writer.desired[patch.name]; ok && status == nil {
+				delete(writer.desired, patch.name)
+			}
+			delete(writer.written, patch.name)
+		default:
+			writer.written[patch.name] = &writtenStatus{fingerprint: patch.fingerprint, hash: patch.hash,
+				patchedAt: time.Now()}
+		}
+		writer.mu.Unlock()
+	}
+}
+
+// take returns the patches of the services whose status annotations are outdated for the configuration with hash,
+// which is applied at appliedAt. retryAfter is the duration after which the LastAppliedAnnotation patches which are
+// limited by lastAppliedInterval can be sent, it is 0 if none of them is limited
+func (writer *statusWriter) take(hash string, appliedAt time.Time) (patches []statusPatch, retryAfter time.Duration) {
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+
+	// annotations are written by the leader only, they are written again when the replica becomes the leader
+	if !writer.isLeader() || appliedAt.Before(writer.appliedAt) {
+		return nil, 0
+	}
+	writer.appliedAt = appliedAt
+	writer.hash = hash
+
+	now := time.Now()
+	lastApplied, _ := json.Marshal(LastApplied{Time: appliedAt.UTC(), Hash: hash})
+	for name, status := range writer.desired {
+		patch := statusPatch{name: name, status: status, annotations: map[string]interface{}{StatusAnnotation: nil,
+			ListenAnnotation: nil, LastAppliedAnnotation: nil}}
+		if status != nil {
+			patch.fingerprint = status.fingerprint()
+			if status.status == StatusServing {
+				patch.hash = hash
+			}
+
+			if written := writer.written[name]; written != nil && written.fingerprint == patch.fingerprint {
+				// only LastAppliedAnnotation is patched when only the configuration is changed
+				if written.hash == patch.hash {
+					continue
+				}
+
+				if wait := written.patchedAt.Add(writer.lastAppliedInterval).Sub(now); wait > 0 {
+					if retryAfter == 0 || wait < retryAfter {
+						retryAfter = wait
+					}
+					continue
+				}
+
+				patch.annotations = map[string]interface{}{LastAppliedAnnotation: string(lastApplied)}
+				patches = append(patches, patch)
+				continue
+			}
+
+			patch.annotations[StatusAnnotation] = status.status
+			if status.status == StatusServing {
+				patch.annotations[ListenAnnotation] = fmt.Sprint(status.listen)
+				patch.annotations[LastAppliedAnnotation] = string(lastApplied)
+			}
+		}
+		patches = append(patches, patch)
+	}
+
+	return patches, retryAfter
+}
+
+// scheduleRetry writes the latest configuration again after retryAfter, unless a retry is already scheduled. Later
+// retries are scheduled by that write if some patches are still limited
+func (writer *statusWriter) scheduleRetry(ctx context.Context, retryAfter time.Duration) {
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+
+	if writer.retry != nil {
+		return
+	}
+
+	writer.retry = time.AfterFunc(retryAfter, func() {
+		writer.mu.Lock()
+		writer.retry = nil
+		hash, appliedAt := writer.hash, writer.appliedAt
+		writer.mu.Unlock()
+
+		if ctx.Err() == nil {
+			writer.write(ctx, hash, appliedAt)
+		}
+	})
+}
+
+// patch merges annotations into the annotations of the service, nil values remove the keys
+func (writer *statusWriter) patch(ctx context.Context, name k8stypes.NamespacedName,
+	annotations map[string]interface{}) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": annotations},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = writer.clientSet.CoreV1().Services(name.Namespace).Patch(ctx, name.Name, k8stypes.MergePatchType, patch,
+		metav1.PatchOptions{})
+	return err
+}
+
+// fingerprint identifies the annotations of status which are written onto a service
+func (status *serviceStatus) fingerprint() string {
+	return fmt.Sprintf("%s/%d", status.status, status.listen)
+}
+
+func serviceName(service *v1.Service) k8stypes.NamespacedName {
+	return k8stypes.NamespacedName{Namespace: service.Namespace, Name: service.Name}
+}
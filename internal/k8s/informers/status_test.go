@@ -0,0 +1,211 @@
+package informers
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/k8s/types"
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/options"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func getServiceAnnotations(t *testing.T, clientSet *fake.Clientset, service *v1.Service) map[string]string {
+	current, err := clientSet.CoreV1().Services(service.Namespace).Get(context.Background(), service.Name,
+		metav1.GetOptions{})
+	assert.Nil(t, err)
+	return current.Annotations
+}
+
+func TestStatusWriter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	service := getNodePortService("app", 8080, 30100)
+	service.Annotations = map[string]string{"team": "edge"}
+	clientSet := fake.NewSimpleClientset(service)
+	writer := newStatusWriter(clientSet, testLogger, func() bool { return true })
+	writer.lastAppliedInterval = 500 * time.Millisecond
+	appliedAt := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	writer.set(service, StatusServing, 8080)
+	assert.True(t, writer.pending())
+	writer.write(ctx, "sha256:1", appliedAt)
+	assert.False(t, writer.pending())
+
+	annotations := getServiceAnnotations(t, clientSet, service)
+	assert.Equal(t, "edge", annotations["team"])
+	assert.Equal(t, StatusServing, annotations[StatusAnnotation])
+	assert.Equal(t, "8080", annotations[ListenAnnotation])
+	var lastApplied LastApplied
+	assert.Nil(t, json.Unmarshal([]byte(annotations[LastAppliedAnnotation]), &lastApplied))
+	assert.Equal(t, LastApplied{Time: appliedAt, Hash: "sha256:1"}, lastApplied)
+
+	// hooks of an older apply are ignored
+	writer.write(ctx, "sha256:0", appliedAt.Add(-time.Second))
+	assert.Contains(t, getServiceAnnotations(t, clientSet, service)[LastAppliedAnnotation], "sha256:1")
+
+	// last-applied is patched again when only the configuration is changed, once lastAppliedInterval elapses
+	writer.write(ctx, "sha256:2", appliedAt.Add(time.Second))
+	assert.Contains(t, getServiceAnnotations(t, clientSet, service)[LastAppliedAnnotation], "sha256:1")
+	assert.Eventually(t, func() bool {
+		var current LastApplied
+		annotations := getServiceAnnotations(t, clientSet, service)
+		return json.Unmarshal([]byte(annotations[LastAppliedAnnotation]), &current) == nil &&
+			current.Hash == "sha256:2" && current.Time.Equal(appliedAt.Add(time.Second))
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.Equal(t, "8080", getServiceAnnotations(t, clientSet, service)[ListenAnnotation])
+
+	// only last-applied is patched, and it is not patched again for the same configuration
+	patches := 0
+	for _, action := range clientSet.Actions() {
+		if patch, ok := action.(k8stesting.PatchAction); ok {
+			patches++
+			if patches == 2 {
+				assert.NotContains(t, string(patch.GetPatch()), StatusAnnotation)
+			}
+		}
+	}
+	assert.Equal(t, 2, patches)
+	writer.mu.Lock()
+	writer.lastAppliedInterval = 0
+	writer.mu.Unlock()
+	actions := len(clientSet.Actions())
+	writer.write(ctx, "sha256:2", appliedAt.Add(time.Second))
+	assert.Len(t, clientSet.Actions(), actions)
+	writer.write(ctx, "sha256:3", appliedAt.Add(time.Second))
+	assert.Contains(t, getServiceAnnotations(t, clientSet, service)[LastAppliedAnnotation], "sha256:3")
+
+	writer.set(service, StatusRejected, 0)
+	writer.write(ctx, "sha256:2", appliedAt.Add(time.Second))
+	annotations = getServiceAnnotations(t, clientSet, service)
+	assert.Equal(t, StatusRejected, annotations[StatusAnnotation])
+	assert.NotContains(t, annotations, ListenAnnotation)
+	assert.NotContains(t, annotations, LastAppliedAnnotation)
+
+	writer.clear(service)
+	assert.True(t, writer.pending())
+	writer.write(ctx, "sha256:2", appliedAt.Add(2*time.Second))
+	assert.Equal(t, map[string]string{"team": "edge"}, getServiceAnnotations(t, clientSet, service))
+	assert.False(t, writer.pending())
+
+	// deleted services are dropped
+	missing := getNodePortService("missing", 8080, 30101)
+	writer.set(missing, StatusServing, 30101)
+	writer.write(ctx, "sha256:3", appliedAt.Add(3*time.Second))
+	assert.False(t, writer.pending())
+}
+
+func TestStatusWriterSlowAPIServer(t *testing.T) {
+	service := getNodePortService("app", 8080, 30100)
+	clientSet := fake.NewSimpleClientset(service)
+	blocked := make(chan struct{})
+	clientSet.PrependReactor("patch", "services", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		<-blocked
+		return false, nil, nil
+	})
+	writer := newStatusWriter(clientSet, testLogger, func() bool { return true })
+	writer.set(service, StatusServing, 8080)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		writer.write(context.Background(), "sha256:1", time.Now())
+	}()
+
+	// the handlers are not blocked while the patch is in progress
+	time.Sleep(100 * time.Millisecond)
+	writer.set(getNodePortService("other", 8080, 30101), StatusRejected, 0)
+	assert.True(t, writer.pending())
+
+	close(blocked)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("write is not returned")
+	}
+}
+
+func TestStatusWriterNil(t *testing.T) {
+	var writer *statusWriter
+	service := getNodePortService("app", 8080, 30100)
+	writer.set(service, StatusServing, 8080)
+	writer.clear(service)
+	writer.forget(service)
+	assert.False(t, writer.pending())
+}
+
+func TestRunServiceInformerStatusAnnotations(t *testing.T) {
+	m := newTestManager(t, func(ncgo *options.NginxConfGeneratorOptions) {
+		ncgo.EnableStatusAnnotations = true
+	})
+
+	ctx := context.Background()
+	clientSet := fake.NewSimpleClientset()
+	worker := types.NewWorker("10.0.0.4", "10.0.0.44", v1.ConditionTrue)
+	cluster := types.NewCluster("10.0.0.4", []*types.Worker{worker})
+	m.nginxConf.Clusters = []*types.Cluster{cluster}
+	_, err := m.RunServiceInformer(parentCtx, cluster, &options.ClusterOptions{}, clientSet,
+		NewEventRecorder(clientSet, testLogger, m.elector.IsLeader), testLogger)
+	assert.Nil(t, err)
+
+	serving := getListenPortService("team-d", "serving", 30400, "18400")
+	rejected := getListenPortService("team-d", "rejected", 30401, "http")
+	for _, service := range []*v1.Service{serving, rejected} {
+		_, err := clientSet.CoreV1().Services(service.Namespace).Create(ctx, service, metav1.CreateOptions{})
+		assert.Nil(t, err)
+	}
+
+	assert.Eventually(t, func() bool {
+		annotations := getServiceAnnotations(t, clientSet, serving)
+		return annotations[StatusAnnotation] == StatusServing && annotations[ListenAnnotation] == "18400" &&
+			annotations[LastAppliedAnnotation] != ""
+	}, 5*time.Second, 100*time.Millisecond)
+
+	assert.Eventually(t, func() bool {
+		return getServiceAnnotations(t, clientSet, rejected)[StatusAnnotation] == StatusRejected
+	}, 5*time.Second, 100*time.Millisecond)
+
+	// the updates which are caused by the patches of the status annotations are not added to the triggers
+	assert.Never(t, func() bool {
+		m.triggersMu.Lock()
+		defer m.triggersMu.Unlock()
+		return len(m.triggers) > 0
+	}, time.Second, 50*time.Millisecond)
+
+	// status annotations are removed when the service is not annotated anymore
+	current, err := clientSet.CoreV1().Services(serving.Namespace).Get(ctx, serving.Name, metav1.GetOptions{})
+	assert.Nil(t, err)
+	current.Annotations[opts.CustomAnnotation] = "false"
+	current.ResourceVersion = "updated"
+	_, err = clientSet.CoreV1().Services(serving.Namespace).Update(ctx, current, metav1.UpdateOptions{})
+	assert.Nil(t, err)
+
+	assert.Eventually(t, func() bool {
+		annotations := getServiceAnnotations(t, clientSet, serving)
+		_, ok := annotations[StatusAnnotation]
+		return !ok && annotations[opts.ListenPortAnnotation] == "18400"
+	}, 5*time.Second, 100*time.Millisecond)
+}
+
+func TestIsStatusUpdate(t *testing.T) {
+	service := getListenPortService("team-d", "app", 30400, "18400")
+	patched := service.DeepCopy()
+	patched.Annotations[StatusAnnotation] = StatusServing
+	patched.Annotations[LastAppliedAnnotation] = `{"hash":"abc"}`
+	assert.True(t, isStatusUpdate(service, patched))
+
+	updated := patched.DeepCopy()
+	updated.Annotations[opts.ListenPortAnnotation] = "18401"
+	assert.False(t, isStatusUpdate(patched, updated))
+
+	updated = patched.DeepCopy()
+	updated.Spec.Ports[0].NodePort = 30401
+	assert.False(t, isStatusUpdate(patched, updated))
+}
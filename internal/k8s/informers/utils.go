@@ -1,18 +1,31 @@
 package informers
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"fmt"
-	"html/template"
+	"net"
+	"net/url"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
 
 	"github.com/bilalcaliskan/nginx-conf-generator/internal/k8s/types"
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/metrics"
 	"github.com/bilalcaliskan/nginx-conf-generator/internal/options"
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/tracing"
+	"github.com/bilalcaliskan/nginx-conf-generator/pkg/model"
 
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
+	gatewayclient "sigs.k8s.io/gateway-api/pkg/client/clientset/versioned"
 )
 
 func addWorkerToNodePorts(nodePorts []*types.NodePort, worker *types.Worker) {
@@ -95,28 +108,70 @@ func updateNodePort(nodePorts *[]*types.NodePort, workers []*types.Worker, oldNo
 	newNodePort.Mu.Unlock()
 }
 
-// GetConfig creates a rest.Config and returns it
-func GetConfig(kubeConfigPath string) (*rest.Config, error) {
-	var config *rest.Config
-	var err error
-
-	config, err = clientcmd.BuildConfigFromFlags("", kubeConfigPath)
+// GetClientSet creates a kubernetes.Clientset and returns it
+func GetClientSet(config *rest.Config) (*kubernetes.Clientset, error) {
+	clientSet, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		return nil, err
 	}
-
-	return config, nil
+	return clientSet, nil
 }
 
-// GetClientSet creates a kubernetes.Clientset and returns it
-func GetClientSet(config *rest.Config) (*kubernetes.Clientset, error) {
-	clientSet, err := kubernetes.NewForConfig(config)
+// GetGatewayClientSet creates a Gateway API clientset and returns it
+func GetGatewayClientSet(config *rest.Config) (*gatewayclient.Clientset, error) {
+	clientSet, err := gatewayclient.NewForConfig(config)
 	if err != nil {
 		return nil, err
 	}
 	return clientSet, nil
 }
 
+// GetMasterIP returns the host of the API server which is used to distinguish clusters from each other
+func GetMasterIP(config *rest.Config) string {
+	host := config.Host
+	if !strings.Contains(host, "//") {
+		host = "https://" + host
+	}
+
+	u, err := url.Parse(host)
+	if err != nil || u.Hostname() == "" {
+		return config.Host
+	}
+
+	return u.Hostname()
+}
+
+// nodeAddress picks the address of node according to the preferred address types and IP family of the cluster.
+// Addresses of the preferred IP family win over the order of address types, other addresses are used as fallback
+func nodeAddress(node *v1.Node, clusterOpts *options.ClusterOptions) (string, bool) {
+	var candidates []string
+	for _, addressType := range clusterOpts.NodeAddressTypes {
+		for _, address := range node.Status.Addresses {
+			if string(address.Type) == addressType && address.Address != "" {
+				candidates = append(candidates, address.Address)
+			}
+		}
+	}
+
+	if len(candidates) == 0 {
+		return "", false
+	}
+
+	if clusterOpts.IPFamily != "" {
+		for _, candidate := range candidates {
+			if ip := net.ParseIP(candidate); ip != nil && isIPv6(ip) == (clusterOpts.IPFamily == options.IPFamilyIPv6) {
+				return candidate, true
+			}
+		}
+	}
+
+	return candidates[0], true
+}
+
+func isIPv6(ip net.IP) bool {
+	return ip.To4() == nil
+}
+
 func isNodeReady(node *v1.Node) v1.ConditionStatus {
 	for _, v := range node.Status.Conditions {
 		if v.Type == v1.NodeReady {
@@ -126,8 +181,28 @@ func isNodeReady(node *v1.Node) v1.ConditionStatus {
 	return v1.ConditionFalse
 }
 
-func reloadNginx() error {
-	cmd := exec.Command("nginx", "-s", "reload")
+// nginxBinary returns the path of the nginx binary in ncgo
+func nginxBinary(ncgo *options.NginxConfGeneratorOptions) string {
+	if ncgo.NginxBinary != "" {
+		return ncgo.NginxBinary
+	}
+
+	return "nginx"
+}
+
+// validateNginx validates the configuration with binary and returns its output
+func validateNginx(binary string) (string, error) {
+	output, err := exec.Command(binary, "-t").CombinedOutput()
+	trimmed := strings.TrimSpace(string(output))
+	if err != nil {
+		return trimmed, fmt.Errorf("%s: %s", err.Error(), trimmed)
+	}
+
+	return trimmed, nil
+}
+
+func reloadNginx(binary string) error {
+	cmd := exec.Command(binary, "-s", "reload")
 	err := cmd.Run()
 	if err != nil {
 		return err
@@ -136,38 +211,325 @@ func reloadNginx() error {
 	return nil
 }
 
-func applyChanges(ncgo *options.NginxConfGeneratorOptions, conf *types.NginxConf) error {
+// Reconcile renders the current state of conf and reloads Nginx if it is changed, which is used when the replica
+// becomes the leader
+func (m *Manager) Reconcile() error {
+	m.addTrigger(context.Background(), Trigger{Kind: "leader", Type: TriggerElected}, time.Time{})
+	return m.applyChanges()
+}
+
+func (m *Manager) applyChanges() error {
+	_, err := m.apply()
+	return err
+}
+
+// apply renders the current state of conf, validates it and reloads Nginx if it is changed. It returns the result of
+// the apply, which is ApplyReloaded or ApplyUnchanged when the current state is served by Nginx
+func (m *Manager) apply() (result string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ncgo := m.ncgo
+	if m.stopped {
+		return ApplySkipped, nil
+	}
+
+	// the apply is linked to the changes which it includes, each of them is a trace started by an informer event
+	pending, droppedTriggers := m.takeTriggers()
+	m.addUnapplied(pending)
+	ctx, span := m.tracer.Start(context.Background(), tracing.SpanApply, trace.WithLinks(queueLinks(pending)...))
+	defer func() {
+		tracing.End(span, err)
+	}()
+
+	// the clusters are copied while holding their locks, so that they are not modified while the template is rendered
+	_, snapshotSpan := m.tracer.Start(ctx, tracing.SpanSnapshot)
+	snapshot := m.nginxConf.Snapshot()
+	snapshotSpan.End()
+	m.metrics.ObserveSnapshot(snapshot)
+
+	// the configuration is rendered by the leader only on shared filesystem setups
+	if ncgo.LeaderOnlyRender && !m.elector.IsLeader() {
+		span.SetAttributes(tracing.ResultKey.String(ApplySkipped))
+		m.unapplied = nil
+		return ApplySkipped, nil
+	}
+
+	m.statusMu.Lock()
+	m.renderingSince = time.Now()
+	applied := m.applied
+	m.statusMu.Unlock()
+	defer func() {
+		m.statusMu.Lock()
+		m.renderingSince = time.Time{}
+		m.statusMu.Unlock()
+	}()
+
+	// the previous files are kept, so that they can be restored when the rendered configuration is invalid
+	previous := readOutputFiles(ncgo)
+
 	// Apply changes to the template
-	ncgo.Mu.Lock()
-	if err := renderTemplate(ncgo.TemplateInputFile, ncgo.TemplateOutputFile, conf); err != nil {
-		return fmt.Errorf("%s, %s", ErrRenderTemplate, err.Error())
+	changed, err := m.render(ctx, snapshot)
+	if err != nil {
+		return ApplyFailed, fmt.Errorf("%s, %s", ErrRenderTemplate, err.Error())
+	}
+
+	hash, err := configHash(outputFiles(ncgo)...)
+	if err != nil {
+		return ApplyFailed, fmt.Errorf("%s, %s", ErrRenderTemplate, err.Error())
+	}
+
+	span.SetAttributes(tracing.ChangedKey.Bool(changed), tracing.HashKey.String(hash))
+	record := ApplyRecord{Triggers: triggerList(pending), DroppedTriggers: droppedTriggers, Hash: hash}
+
+	// the configuration is validated before it is reloaded, and once on startup even if the files are not changed
+	if changed || !applied {
+		record.PreviousHash = contentHash(outputFiles(ncgo), previous)
+		record.Diff = diffOutputFiles(outputFiles(ncgo), previous, readOutputFiles(ncgo))
+		_, validateSpan := m.tracer.Start(ctx, tracing.SpanValidate)
+		output, err := validateNginx(nginxBinary(ncgo))
+		tracing.End(validateSpan, err)
+		record.Validation = output
+		if err != nil {
+			// Nginx keeps serving the previous configuration, which must also survive a restart of Nginx
+			m.logger.Error(ErrValidateNginx, zap.String("error", err.Error()))
+			record.Time, record.Result, record.Error = time.Now(), ApplyInvalid, err.Error()
+			span.SetAttributes(tracing.ResultKey.String(ApplyInvalid))
+			m.recordApply(record)
+			if err := restoreOutputFiles(previous); err != nil {
+				return ApplyInvalid, fmt.Errorf("%s, %s", ErrRenderTemplate, err.Error())
+			}
+			return ApplyInvalid, nil
+		}
+	}
+
+	// skip the reload when the rendered configuration is the same, e.g. an update which is not rendered
+	if changed {
+		// Reload Nginx service
+		_, reloadSpan := m.tracer.Start(ctx, tracing.SpanReload)
+		start := time.Now()
+		err := reloadNginx(nginxBinary(ncgo))
+		record.ReloadDurationSeconds = time.Since(start).Seconds()
+		tracing.End(reloadSpan, err)
+		m.metrics.ReloadDurationHistogram.WithLabelValues(metrics.Result(err)).Observe(record.ReloadDurationSeconds)
+		record.Time, record.Result = time.Now(), ApplyReloaded
+		if err != nil {
+			record.Result, record.Error = ApplyFailed, err.Error()
+		}
+		span.SetAttributes(tracing.ResultKey.String(record.Result))
+		m.recordApply(record)
+		if err != nil {
+			return ApplyFailed, fmt.Errorf("%s, %s", ErrReloadNginx, err.Error())
+		}
+	} else {
+		// the no-op applies are also recorded, e.g. the validation on startup and the reconcile on election
+		if record.PreviousHash == "" {
+			record.PreviousHash = hash
+		}
+		record.Time, record.Result = time.Now(), ApplyUnchanged
+		span.SetAttributes(tracing.ResultKey.String(ApplyUnchanged))
+		m.recordApply(record)
+	}
+
+	appliedAt := time.Now()
+	m.metrics.ObserveApply(hash, appliedAt)
+	m.observePropagation(appliedAt, changed)
+	m.statusMu.Lock()
+	m.applied, m.hash, m.appliedAt = true, hash, appliedAt
+	m.statusMu.Unlock()
+
+	m.postApplyHooksMu.Lock()
+	hooks := m.postApplyHooks[:0]
+	for _, hook := range m.postApplyHooks {
+		// the hooks of the stopped informers are dropped, e.g. the ones of a cluster which is restarted
+		if hook.ctx.Err() != nil {
+			continue
+		}
+		hooks = append(hooks, hook)
+
+		m.hooks.Add(1)
+		go func(fn func(hash string, appliedAt time.Time)) {
+			defer m.hooks.Done()
+			fn(hash, appliedAt)
+		}(hook.fn)
+	}
+	m.postApplyHooks = hooks
+	m.postApplyHooksMu.Unlock()
+
+	if changed {
+		return ApplyReloaded, nil
+	}
+
+	return ApplyUnchanged, nil
+}
+
+// render renders snapshot to the output files and returns true if any of them is changed
+func (m *Manager) render(ctx context.Context, snapshot *model.Snapshot) (changed bool, err error) {
+	start := time.Now()
+	defer func() {
+		m.metrics.RenderDurationHistogram.WithLabelValues(metrics.Result(err)).Observe(time.Since(start).Seconds())
+	}()
+
+	ncgo := m.ncgo
+	if changed, err = m.renderTemplate(ctx, ncgo.TemplateOutputFile, model.TemplateMain, snapshot); err != nil {
+		return false, err
+	}
+
+	// stream context can not be included from the http context, so it is rendered to a separate file
+	if ncgo.StreamTemplateOutputFile != "" {
+		streamChanged, err := m.renderTemplate(ctx, ncgo.StreamTemplateOutputFile, model.TemplateStream, snapshot)
+		if err != nil {
+			return false, err
+		}
+		changed = changed || streamChanged
+	}
+
+	return changed, nil
+}
+
+// renderTemplate renders the template called name to templateOutputFile in its own span
+func (m *Manager) renderTemplate(ctx context.Context, templateOutputFile, name string,
+	snapshot *model.Snapshot) (bool, error) {
+	_, span := m.tracer.Start(ctx, tracing.SpanRender, trace.WithAttributes(tracing.FileKey.String(templateOutputFile)))
+	changed, err := renderTemplate(m.ncgo.TemplateInputFile, templateOutputFile, name, snapshot)
+	tracing.End(span, err)
+	return changed, err
+}
+
+// registerPostApplyHook registers fn to be called with the hash of the configuration after each successful apply
+// until ctx is done
+func (m *Manager) registerPostApplyHook(ctx context.Context, fn func(hash string, appliedAt time.Time)) {
+	m.postApplyHooksMu.Lock()
+	defer m.postApplyHooksMu.Unlock()
+	m.postApplyHooks = append(m.postApplyHooks, postApplyHook{ctx: ctx, fn: fn})
+}
+
+// outputFiles returns the paths of the output files of ncgo which are rendered
+func outputFiles(ncgo *options.NginxConfGeneratorOptions) []string {
+	files := []string{ncgo.TemplateOutputFile}
+	if ncgo.StreamTemplateOutputFile != "" {
+		files = append(files, ncgo.StreamTemplateOutputFile)
+	}
+
+	return files
+}
+
+// readOutputFiles returns the contents of the output files of ncgo, the content of a missing file is nil
+func readOutputFiles(ncgo *options.NginxConfGeneratorOptions) map[string][]byte {
+	contents := make(map[string][]byte)
+	for _, file := range outputFiles(ncgo) {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			content = nil
+		}
+		contents[file] = content
 	}
-	ncgo.Mu.Unlock()
 
-	// Reload Nginx service
-	if err := reloadNginx(); err != nil {
-		return fmt.Errorf("%s, %s", ErrReloadNginx, err.Error())
+	return contents
+}
+
+// restoreOutputFiles writes back the contents which are returned by readOutputFiles, missing files are removed
+func restoreOutputFiles(contents map[string][]byte) error {
+	for file, content := range contents {
+		if content == nil {
+			if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+
+		if err := writeFileAtomic(file, content); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func renderTemplate(templateInputFile, templateOutputFile string, data interface{}) error {
-	tpl := template.Must(template.ParseFiles(templateInputFile))
-	f, err := os.Create(templateOutputFile)
+// configHash returns the sha256 hash of the rendered configuration files, empty file paths are skipped
+func configHash(files ...string) (string, error) {
+	hash := sha256.New()
+	for _, file := range files {
+		if file == "" {
+			continue
+		}
+
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		hash.Write(content)
+	}
+
+	return fmt.Sprintf("sha256:%x", hash.Sum(nil)), nil
+}
+
+// contentHash returns the hash of contents in the same format as configHash, contents are returned by
+// readOutputFiles. It is empty when none of files exists
+func contentHash(files []string, contents map[string][]byte) string {
+	hash := sha256.New()
+	found := false
+	for _, file := range files {
+		if content := contents[file]; content != nil {
+			found = true
+			hash.Write(content)
+		}
+	}
+
+	if !found {
+		return ""
+	}
+
+	return fmt.Sprintf("sha256:%x", hash.Sum(nil))
+}
+
+// renderTemplate renders the template called name to templateOutputFile, returns false if the content of
+// templateOutputFile is the same already. The file is replaced atomically, so Nginx never reads a half written file
+func renderTemplate(templateInputFile, templateOutputFile, name string, snapshot *model.Snapshot) (bool, error) {
+	tpl, err := template.ParseFiles(templateInputFile)
 	if err != nil {
-		return err
+		return false, err
+	}
+
+	var buf bytes.Buffer
+	if err := model.Render(&buf, tpl, name, snapshot); err != nil {
+		return false, err
+	}
+
+	if current, err := os.ReadFile(templateOutputFile); err == nil && bytes.Equal(current, buf.Bytes()) {
+		return false, nil
+	}
+
+	if err := writeFileAtomic(templateOutputFile, buf.Bytes()); err != nil {
+		return false, err
 	}
 
-	err = tpl.ExecuteTemplate(f, "main", data)
+	return true, nil
+}
+
+// writeFileAtomic writes content to a temporary file in the directory of name and renames it to name
+func writeFileAtomic(name string, content []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*")
 	if err != nil {
 		return err
 	}
 
-	err = f.Close()
-	if err != nil {
+	// removes the temporary file if it is not renamed
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(content); err != nil {
+		_ = file.Close()
 		return err
 	}
 
-	return nil
+	if err := file.Chmod(0644); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), name)
 }
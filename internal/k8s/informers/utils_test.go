@@ -1,13 +1,21 @@
 package informers
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/k8s/types"
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/options"
+	"github.com/bilalcaliskan/nginx-conf-generator/pkg/model"
+
 	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/rest"
 )
 
 func TestGetClientSet(t *testing.T) {
-	restConfig, err := GetConfig("../../../test/kubeconfig")
+	restConfig, err := GetConfig(&options.ClusterOptions{KubeConfigPath: "../../../test/kubeconfig"})
 	assert.Nil(t, err)
 	assert.NotNil(t, restConfig)
 
@@ -15,7 +23,140 @@ func TestGetClientSet(t *testing.T) {
 	assert.Nil(t, err)
 	assert.NotNil(t, clientSet)
 
-	restConfig, err = GetConfig("../../../test/broken_kubeconfig")
+	restConfig, err = GetConfig(&options.ClusterOptions{KubeConfigPath: "../../../test/broken_kubeconfig"})
 	assert.NotNil(t, err)
 	assert.Nil(t, restConfig)
 }
+
+func TestGetMasterIP(t *testing.T) {
+	cases := []struct {
+		caseName, host, expected string
+	}{
+		{"ipv4", "https://10.0.0.1:6443", "10.0.0.1"},
+		{"ipv6", "https://[fd00::1]:6443", "fd00::1"},
+		{"hostname", "https://api.example.com", "api.example.com"},
+		{"withoutScheme", "10.0.0.1:6443", "10.0.0.1"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.caseName, func(t *testing.T) {
+			assert.Equal(t, tc.expected, GetMasterIP(&rest.Config{Host: tc.host}))
+		})
+	}
+}
+
+func TestNodeAddress(t *testing.T) {
+	node := &v1.Node{Status: v1.NodeStatus{Addresses: []v1.NodeAddress{
+		{Type: v1.NodeHostName, Address: "node01"},
+		{Type: v1.NodeExternalIP, Address: "203.0.113.10"},
+		{Type: v1.NodeInternalIP, Address: "10.0.0.44"},
+		{Type: v1.NodeInternalIP, Address: "fd00::44"},
+	}}}
+
+	cases := []struct {
+		caseName     string
+		addressTypes []string
+		ipFamily     string
+		expected     string
+		expectedOk   bool
+	}{
+		{"default", options.DefaultNodeAddressTypes, "", "10.0.0.44", true},
+		{"externalFirst", []string{"ExternalIP", "InternalIP"}, "", "203.0.113.10", true},
+		{"ipv6", options.DefaultNodeAddressTypes, options.IPFamilyIPv6, "fd00::44", true},
+		{"ipv6Fallback", []string{"ExternalIP"}, options.IPFamilyIPv6, "203.0.113.10", true},
+		{"hostname", []string{"Hostname"}, "", "node01", true},
+		{"missing", []string{"InternalDNS"}, "", "", false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.caseName, func(t *testing.T) {
+			address, ok := nodeAddress(node, &options.ClusterOptions{NodeAddressTypes: tc.addressTypes,
+				IPFamily: tc.ipFamily})
+			assert.Equal(t, tc.expectedOk, ok)
+			assert.Equal(t, tc.expected, address)
+		})
+	}
+
+	_, ok := nodeAddress(&v1.Node{}, clusterOpts)
+	assert.False(t, ok)
+}
+
+func TestRenderIPv6Template(t *testing.T) {
+	worker := types.NewWorker("fd00::1", "fd00::44", v1.ConditionTrue)
+	cluster := types.NewCluster("fd00::1", []*types.Worker{worker})
+	cluster.NodePorts = []*types.NodePort{{MasterIP: "fd00::1", Port: 30444, Workers: []*types.Worker{worker}}}
+
+	outputFile := filepath.Join(t.TempDir(), "ncg.conf")
+	_, err := renderTemplate("../../../resources/ncg.conf.tmpl", outputFile, model.TemplateMain,
+		types.NewNginxConf([]*types.Cluster{cluster}).Snapshot())
+	assert.Nil(t, err)
+
+	content, err := os.ReadFile(outputFile)
+	assert.Nil(t, err)
+	assert.Contains(t, string(content), "server [fd00::44]:30444;")
+	assert.Contains(t, string(content), "upstream fd00__1_30444 {")
+	assert.Contains(t, string(content), "proxy_pass http://fd00__1_30444;")
+}
+
+func TestRenderTemplateDrainingWorker(t *testing.T) {
+	worker := types.NewWorker("10.0.0.1", "10.0.0.44", v1.ConditionTrue)
+	worker.Draining = true
+	cluster := types.NewCluster("10.0.0.1", []*types.Worker{worker})
+	cluster.NodePorts = []*types.NodePort{{MasterIP: "10.0.0.1", Port: 30444, Workers: []*types.Worker{worker}}}
+	snapshot := types.NewNginxConf([]*types.Cluster{cluster}).Snapshot()
+
+	outputFile := filepath.Join(t.TempDir(), "ncg.conf")
+	changed, err := renderTemplate("../../../resources/ncg.conf.tmpl", outputFile, model.TemplateMain, snapshot)
+	assert.Nil(t, err)
+	assert.True(t, changed)
+
+	content, err := os.ReadFile(outputFile)
+	assert.Nil(t, err)
+	assert.Contains(t, string(content), "server 10.0.0.44:30444 down;")
+
+	// rendering the same state again must not report a change, so that Nginx is not reloaded
+	changed, err = renderTemplate("../../../resources/ncg.conf.tmpl", outputFile, model.TemplateMain, snapshot)
+	assert.Nil(t, err)
+	assert.False(t, changed)
+}
+
+func TestConfigHash(t *testing.T) {
+	dir := t.TempDir()
+	httpFile := filepath.Join(dir, "ncg.conf")
+	streamFile := filepath.Join(dir, "ncg-stream.conf")
+	assert.Nil(t, os.WriteFile(httpFile, []byte("http"), 0600))
+	assert.Nil(t, os.WriteFile(streamFile, []byte("stream"), 0600))
+
+	httpHash, err := configHash(httpFile, "")
+	assert.Nil(t, err)
+	assert.Regexp(t, "^sha256:[0-9a-f]{64}$", httpHash)
+
+	bothHash, err := configHash(httpFile, streamFile)
+	assert.Nil(t, err)
+	assert.NotEqual(t, httpHash, bothHash)
+
+	_, err = configHash(filepath.Join(dir, "missing.conf"))
+	assert.NotNil(t, err)
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "ncg.conf")
+	assert.Nil(t, writeFileAtomic(name, []byte("first")))
+	assert.Nil(t, writeFileAtomic(name, []byte("second")))
+
+	content, err := os.ReadFile(name)
+	assert.Nil(t, err)
+	assert.Equal(t, "second", string(content))
+
+	info, err := os.Stat(name)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+
+	// temporary files are not left behind
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+
+	assert.NotNil(t, writeFileAtomic(filepath.Join(dir, "missing", "ncg.conf"), []byte("content")))
+}
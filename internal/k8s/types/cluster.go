@@ -1,13 +1,31 @@
 package types
 
-import "sync"
+import (
+	"sort"
+	"sync"
+)
 
 // Cluster is the logical representation of k8s clusters
 type Cluster struct {
+	// Name is the name of the cluster in the cluster options
+	Name      string
 	MasterIP  string
 	Workers   []*Worker
 	NodePorts []*NodePort
-	Mu        sync.Mutex
+	// Gateway is the configuration generated from Gateway API resources, nil when Gateway API support is disabled
+	Gateway *GatewayConf
+	// Dropped is true when the cluster is disconnected and its upstreams must not be rendered
+	Dropped bool
+	// Skipped contains the reasons of the annotated services which are not exposed, keyed by namespace/name
+	Skipped map[string]*SkippedService
+	Mu      sync.Mutex
+}
+
+// SkippedService is a service which is annotated to be exposed but skipped
+type SkippedService struct {
+	Namespace string
+	Name      string
+	Reason    string
 }
 
 // NewCluster creates a Cluster struct with specified parameters and returns it
@@ -17,3 +35,34 @@ func NewCluster(masterIP string, workers []*Worker) *Cluster {
 		Workers:  workers,
 	}
 }
+
+// Skip records that the service called namespace/name is skipped because of reason, cluster.Mu must be held by the
+// caller
+func (cluster *Cluster) Skip(namespace, name, reason string) {
+	if cluster.Skipped == nil {
+		cluster.Skipped = make(map[string]*SkippedService)
+	}
+	cluster.Skipped[namespace+"/"+name] = &SkippedService{Namespace: namespace, Name: name, Reason: reason}
+}
+
+// Unskip forgets the skip reason of the service called namespace/name, cluster.Mu must be held by the caller
+func (cluster *Cluster) Unskip(namespace, name string) {
+	delete(cluster.Skipped, namespace+"/"+name)
+}
+
+// skippedServices returns the skipped services sorted by namespace and name
+func (cluster *Cluster) skippedServices() []*SkippedService {
+	skipped := make([]*SkippedService, 0, len(cluster.Skipped))
+	for _, service := range cluster.Skipped {
+		skipped = append(skipped, service)
+	}
+
+	sort.Slice(skipped, func(i, j int) bool {
+		if skipped[i].Namespace != skipped[j].Namespace {
+			return skipped[i].Namespace < skipped[j].Namespace
+		}
+		return skipped[i].Name < skipped[j].Name
+	})
+
+	return skipped
+}
@@ -0,0 +1,18 @@
+package types
+
+import "github.com/bilalcaliskan/nginx-conf-generator/pkg/model"
+
+// the Gateway API configuration is immutable once it is built, so it is shared with the public model as is
+type (
+	GatewayConf     = model.GatewayConf
+	GatewayServer   = model.GatewayServer
+	GatewayLocation = model.GatewayLocation
+	GatewayMatch    = model.GatewayMatch
+	GatewayUpstream = model.GatewayUpstream
+	GatewayBackend  = model.GatewayBackend
+)
+
+// NewGatewayConf creates an empty GatewayConf and returns it
+func NewGatewayConf() *GatewayConf {
+	return &GatewayConf{}
+}
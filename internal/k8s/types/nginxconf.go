@@ -1,7 +1,11 @@
 package types
 
+import "sync"
+
 // NginxConf is the biggest struct in app, keeps track of k8s clusters
 type NginxConf struct {
+	// Mu guards Clusters, the state of each cluster is guarded by its own lock
+	Mu       sync.Mutex
 	Clusters []*Cluster
 }
 
@@ -11,3 +15,31 @@ func NewNginxConf(cluster []*Cluster) *NginxConf {
 		Clusters: cluster,
 	}
 }
+
+// AddCluster appends cluster to the clusters of nginxConf
+func (nginxConf *NginxConf) AddCluster(cluster *Cluster) {
+	nginxConf.Mu.Lock()
+	defer nginxConf.Mu.Unlock()
+	nginxConf.Clusters = append(nginxConf.Clusters, cluster)
+}
+
+// RemoveCluster removes cluster from the clusters of nginxConf, it returns false if cluster is not found
+func (nginxConf *NginxConf) RemoveCluster(cluster *Cluster) bool {
+	nginxConf.Mu.Lock()
+	defer nginxConf.Mu.Unlock()
+	for i, item := range nginxConf.Clusters {
+		if item == cluster {
+			nginxConf.Clusters = append(nginxConf.Clusters[:i], nginxConf.Clusters[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// ListClusters returns a copy of the clusters of nginxConf
+func (nginxConf *NginxConf) ListClusters() []*Cluster {
+	nginxConf.Mu.Lock()
+	defer nginxConf.Mu.Unlock()
+	return append([]*Cluster(nil), nginxConf.Clusters...)
+}
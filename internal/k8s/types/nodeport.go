@@ -1,12 +1,21 @@
 package types
 
-import "sync"
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
 
 type NodePort struct {
 	MasterIP string
 	Port     int32
-	Workers  []*Worker
-	Mu       sync.Mutex
+	// Namespace and Name are of the service which the nodePort belongs to
+	Namespace string
+	Name      string
+	// ListenPort is the port which Nginx listens on, Port is used when it is 0
+	ListenPort int32
+	Workers    []*Worker
+	Mu         sync.Mutex
 }
 
 func NewNodePort(masterIP string, port int32) *NodePort {
@@ -22,3 +31,18 @@ func (nodePort *NodePort) Equals(other *NodePort) bool {
 	isPortEquals := nodePort.Port == other.Port
 	return isMasterIPEquals && isPortEquals
 }
+
+// UpstreamName returns the name of the nginx upstream of the nodePort, colons of IPv6 master addresses are replaced
+// since nginx would parse them as a port in proxy_pass
+func (nodePort *NodePort) UpstreamName() string {
+	return fmt.Sprintf("%s_%d", strings.ReplaceAll(nodePort.MasterIP, ":", "_"), nodePort.Port)
+}
+
+// Listen returns the port which Nginx listens on for the nodePort
+func (nodePort *NodePort) Listen() int32 {
+	if nodePort.ListenPort != 0 {
+		return nodePort.ListenPort
+	}
+
+	return nodePort.Port
+}
@@ -0,0 +1,67 @@
+package types
+
+import (
+	"github.com/bilalcaliskan/nginx-conf-generator/pkg/model"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// Snapshot copies the state of the clusters into the public model, so that it can be rendered and served without
+// holding the locks of the clusters. Each cluster is copied while holding its own lock only
+func (nginxConf *NginxConf) Snapshot() *model.Snapshot {
+	clusters := nginxConf.ListClusters()
+	snapshot := &model.Snapshot{Clusters: make([]*model.Cluster, 0, len(clusters))}
+	for _, cluster := range clusters {
+		snapshot.Clusters = append(snapshot.Clusters, cluster.Snapshot())
+	}
+
+	return snapshot
+}
+
+// Snapshot copies the state of the cluster into the public model while holding cluster.Mu
+func (cluster *Cluster) Snapshot() *model.Cluster {
+	cluster.Mu.Lock()
+	defer cluster.Mu.Unlock()
+
+	snapshot := &model.Cluster{
+		Name:      cluster.Name,
+		MasterIP:  cluster.MasterIP,
+		Workers:   snapshotWorkers(cluster.Workers),
+		NodePorts: make([]*model.NodePort, 0, len(cluster.NodePorts)),
+		Gateway:   cluster.Gateway,
+		Dropped:   cluster.Dropped,
+	}
+
+	for _, skipped := range cluster.skippedServices() {
+		snapshot.Skipped = append(snapshot.Skipped, &model.SkippedService{Namespace: skipped.Namespace,
+			Name: skipped.Name, Reason: skipped.Reason})
+	}
+
+	for _, nodePort := range cluster.NodePorts {
+		snapshot.NodePorts = append(snapshot.NodePorts, &model.NodePort{
+			MasterIP:   nodePort.MasterIP,
+			Namespace:  nodePort.Namespace,
+			Name:       nodePort.Name,
+			Port:       nodePort.Port,
+			ListenPort: nodePort.ListenPort,
+			Workers:    snapshotWorkers(nodePort.Workers),
+		})
+	}
+
+	return snapshot
+}
+
+func snapshotWorkers(workers []*Worker) []*model.Worker {
+	snapshot := make([]*model.Worker, 0, len(workers))
+	for _, worker := range workers {
+		snapshot = append(snapshot, &model.Worker{
+			MasterIP: worker.MasterIP,
+			HostIP:   worker.HostIP,
+			NodeName: worker.NodeName,
+			Ready:    worker.NodeCondition == v1.ConditionTrue,
+			Draining: worker.Draining,
+		})
+	}
+
+	return snapshot
+}
@@ -1,6 +1,8 @@
 package types
 
 import (
+	"net"
+	"strconv"
 	"sync"
 
 	v1 "k8s.io/api/core/v1"
@@ -10,7 +12,11 @@ import (
 type Worker struct {
 	MasterIP, HostIP string
 	NodeCondition    v1.ConditionStatus
-	Mu               sync.Mutex
+	// NodeName is the name of the node which the worker is built from
+	NodeName string
+	// Draining is true while the worker is rendered as down before it is removed from the upstreams
+	Draining bool
+	Mu       sync.Mutex
 }
 
 // NewWorker creates a Worker struct with specified parameters and returns it
@@ -28,3 +34,8 @@ func (worker *Worker) Equals(other *Worker) bool {
 	isHostIPEquals := worker.HostIP == other.HostIP
 	return isMasterIPEquals && isHostIPEquals
 }
+
+// HostPort returns the address of the worker for the given port, IPv6 addresses are enclosed in square brackets
+func (worker *Worker) HostPort(port int32) string {
+	return net.JoinHostPort(worker.HostIP, strconv.Itoa(int(port)))
+}
@@ -0,0 +1,159 @@
+package leader
+
+import (
+	"context"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/metrics"
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/options"
+
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// Elector takes part in leader election between the replicas of a generator
+type Elector struct {
+	metrics *metrics.Metrics
+	enabled bool
+	leading atomic.Bool
+	// callbacks are called each time the replica becomes the leader, they are pointers so that they can be removed
+	callbacks   []*func()
+	callbacksMu sync.Mutex
+	// running tracks the leader election loop, which releases the Lease when its context is done
+	running sync.WaitGroup
+}
+
+// NewElector creates an Elector which reports the leadership through m. If enabled, the replica is not the leader
+// until it is elected by Run, otherwise every replica is the leader
+func NewElector(m *metrics.Metrics, enabled bool) *Elector {
+	if enabled {
+		m.LeaderGauge.Set(0)
+	}
+
+	return &Elector{metrics: m, enabled: enabled}
+}
+
+// IsLeader returns true if the replica is the leader, every replica is the leader when leader election is disabled
+func (e *Elector) IsLeader() bool {
+	return !e.enabled || e.leading.Load()
+}
+
+// OnStartedLeading registers fn to be called each time the replica becomes the leader until the returned function is
+// called, e.g. when the component which registers it is stopped
+func (e *Elector) OnStartedLeading(fn func()) func() {
+	e.callbacksMu.Lock()
+	defer e.callbacksMu.Unlock()
+
+	callback := &fn
+	e.callbacks = append(e.callbacks, callback)
+	return func() {
+		e.callbacksMu.Lock()
+		defer e.callbacksMu.Unlock()
+
+		for i, registered := range e.callbacks {
+			if registered == callback {
+				e.callbacks = append(e.callbacks[:i:i], e.callbacks[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Run starts leader election with a coordination.k8s.io Lease through clientSet. It returns right after leader
+// election is started, the replica keeps taking part in leader election until ctx is done
+func (e *Elector) Run(ctx context.Context, clientSet kubernetes.Interface, ncgo *options.NginxConfGeneratorOptions,
+	logger *zap.Logger) error {
+	if !e.enabled {
+		return errors.New("leader election is not enabled")
+	}
+
+	identity := ncgo.LeaderElectionIdentity
+	if identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return errors.Wrap(err, "unable to get hostname as leader election identity")
+		}
+		identity = hostname
+	}
+
+	logger = logger.Named("leader").With(zap.String("identity", identity),
+		zap.String("lease", ncgo.LeaderElectionLeaseName), zap.String("namespace", ncgo.LeaderElectionNamespace))
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock: &resourcelock.LeaseLock{
+			LeaseMeta:  metav1.ObjectMeta{Name: ncgo.LeaderElectionLeaseName, Namespace: ncgo.LeaderElectionNamespace},
+			Client:     clientSet.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+		},
+		LeaseDuration:   ncgo.LeaderElectionLeaseDuration,
+		RenewDeadline:   ncgo.LeaderElectionRenewDeadline,
+		RetryPeriod:     ncgo.LeaderElectionRetryPeriod,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				logger.Info("started leading")
+				e.setLeading(true)
+
+				e.callbacksMu.Lock()
+				startedLeading := append([]*func(){}, e.callbacks...)
+				e.callbacksMu.Unlock()
+				for _, fn := range startedLeading {
+					(*fn)()
+				}
+			},
+			OnStoppedLeading: func() {
+				logger.Info("stopped leading")
+				e.setLeading(false)
+			},
+			OnNewLeader: func(current string) {
+				logger.Info("leader is elected", zap.String("leader", current))
+			},
+		},
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to create leader elector")
+	}
+
+	e.running.Add(1)
+	go func() {
+		defer e.running.Done()
+		// Run returns when the leadership is lost, the replica takes part in leader election again
+		for ctx.Err() == nil {
+			elector.Run(ctx)
+		}
+	}()
+
+	return nil
+}
+
+// Wait blocks until leader election is stopped and the Lease is released, returns an error if ctx is done before
+func (e *Elector) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		e.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "leader election is not stopped")
+	}
+}
+
+func (e *Elector) setLeading(value bool) {
+	e.leading.Store(value)
+	e.metrics.LeadershipTransitionsCounter.Inc()
+	if value {
+		e.metrics.LeaderGauge.Set(1)
+	} else {
+		e.metrics.LeaderGauge.Set(0)
+	}
+}
@@ -0,0 +1,91 @@
+package leader
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/health"
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/metrics"
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/options"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestElector(t *testing.T, enabled bool) *Elector {
+	m, err := metrics.New(prometheus.NewRegistry(), health.NewRegistry())
+	assert.Nil(t, err)
+	return NewElector(m, enabled)
+}
+
+func TestDisabled(t *testing.T) {
+	elector := newTestElector(t, false)
+	// every replica is the leader when leader election is disabled
+	assert.True(t, elector.IsLeader())
+	assert.NotNil(t, elector.Run(context.Background(), fake.NewSimpleClientset(),
+		&options.NginxConfGeneratorOptions{LeaderElectionIdentity: "replica-1"}, zap.NewNop()))
+}
+
+func TestRun(t *testing.T) {
+	elector := newTestElector(t, true)
+	// the replica is not the leader until it is elected, e.g. while the election cluster is unreachable
+	assert.False(t, elector.IsLeader())
+
+	var started, removed int32
+	elector.OnStartedLeading(func() {
+		atomic.AddInt32(&started, 1)
+	})
+	// the removed callbacks are not called anymore, removing them twice is a no-op
+	unregister := elector.OnStartedLeading(func() {
+		atomic.AddInt32(&removed, 1)
+	})
+	unregister()
+	unregister()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clientSet := fake.NewSimpleClientset()
+	assert.Nil(t, elector.Run(ctx, clientSet, &options.NginxConfGeneratorOptions{
+		LeaderElectionNamespace:     "default",
+		LeaderElectionLeaseName:     "nginx-conf-generator",
+		LeaderElectionIdentity:      "replica-1",
+		LeaderElectionLeaseDuration: 2 * time.Second,
+		LeaderElectionRenewDeadline: time.Second,
+		LeaderElectionRetryPeriod:   100 * time.Millisecond,
+	}, zap.NewNop()))
+
+	assert.Eventually(t, elector.IsLeader, 5*time.Second, 50*time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&started))
+	assert.Zero(t, atomic.LoadInt32(&removed))
+	assert.Equal(t, float64(1), testutil.ToFloat64(elector.metrics.LeaderGauge))
+	assert.Equal(t, float64(1), testutil.ToFloat64(elector.metrics.LeadershipTransitionsCounter))
+
+	lease, err := clientSet.CoordinationV1().Leases("default").Get(context.Background(), "nginx-conf-generator",
+		metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "replica-1", *lease.Spec.HolderIdentity)
+
+	// the leadership is released on cancel
+	cancel()
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer waitCancel()
+	assert.Nil(t, elector.Wait(waitCtx))
+	assert.False(t, elector.IsLeader())
+
+	lease, err = clientSet.CoordinationV1().Leases("default").Get(context.Background(), "nginx-conf-generator",
+		metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Empty(t, *lease.Spec.HolderIdentity)
+	assert.Equal(t, float64(0), testutil.ToFloat64(elector.metrics.LeaderGauge))
+}
+
+func TestRunInvalid(t *testing.T) {
+	assert.NotNil(t, newTestElector(t, true).Run(context.Background(), fake.NewSimpleClientset(),
+		&options.NginxConfGeneratorOptions{LeaderElectionIdentity: "replica-1"}, zap.NewNop()))
+}
@@ -0,0 +1,182 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// LevelPath is the path of the root level, the level of a component is served on LevelPath/<component>
+	LevelPath = "/log/level"
+	// LevelsPath is the path which lists the root level and the levels of the components
+	LevelsPath = "/log/levels"
+)
+
+// Levels are the levels of the root logger and the components. A component is the name of a logger, e.g.
+// informers.service, and it uses the level of its nearest ancestor which has one, e.g. informers, or the root level
+type Levels struct {
+	root       zap.AtomicLevel
+	components map[string]zap.AtomicLevel
+	mu         sync.RWMutex
+}
+
+// ParseLevels parses spec, which is a comma separated list of the root level and the levels of the components in the
+// form of component=level, e.g. info,informers.service=debug. The root level is info if it is not in spec
+func ParseLevels(spec string) (*Levels, error) {
+	levels := &Levels{root: zap.NewAtomicLevelAt(zap.InfoLevel), components: make(map[string]zap.AtomicLevel)}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		component, text, found := strings.Cut(item, "=")
+		if !found {
+			component, text = "", item
+		}
+
+		level, err := zapcore.ParseLevel(strings.TrimSpace(text))
+		if err != nil {
+			return nil, fmt.Errorf("log level %s is not valid: %s", item, err.Error())
+		}
+
+		if component = strings.TrimSpace(component); component == "" {
+			levels.root.SetLevel(level)
+		} else {
+			levels.components[component] = zap.NewAtomicLevelAt(level)
+		}
+	}
+
+	return levels, nil
+}
+
+// Level returns the level of component, the root level is returned for the empty component
+func (l *Levels) Level(component string) zapcore.Level {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.level(component).Level()
+}
+
+// Enabled returns true if level is enabled for component
+func (l *Levels) Enabled(component string, level zapcore.Level) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.level(component).Enabled(level)
+}
+
+// level returns the level of the nearest ancestor of component which has one, it must be called while holding mu
+func (l *Levels) level(component string) zap.AtomicLevel {
+	for component != "" {
+		if level, ok := l.components[component]; ok {
+			return level
+		}
+
+		index := strings.LastIndex(component, ".")
+		if index < 0 {
+			break
+		}
+		component = component[:index]
+	}
+
+	return l.root
+}
+
+// anyEnabled returns true if level is enabled for the root logger or any of the components
+func (l *Levels) anyEnabled(level zapcore.Level) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.root.Enabled(level) {
+		return true
+	}
+
+	for _, componentLevel := range l.components {
+		if componentLevel.Enabled(level) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Register registers the endpoints which get and set the levels on router. The root level is served on LevelPath
+// and the level of a component on LevelPath/<component> with zap.AtomicLevel, e.g. PUT {"level":"debug"} to
+// /log/level/informers.service. DELETE on a component makes it use the level of its ancestors again. The levels are
+// only read when mutable is false, PUT and DELETE are responded with 405 then
+func Register(router *mux.Router, levels *Levels, mutable bool) {
+	methods := []string{http.MethodGet}
+	if mutable {
+		methods = append(methods, http.MethodPut)
+	}
+	router.Handle(LevelPath, levels.root).Methods(methods...)
+
+	if mutable {
+		methods = append(methods, http.MethodDelete)
+	}
+	router.HandleFunc(LevelPath+"/{component}", levels.serveComponent).Methods(methods...)
+	router.HandleFunc(LevelsPath, levels.serveList).Methods(http.MethodGet)
+}
+
+// serveComponent gets, sets or deletes the level of a component
+func (l *Levels) serveComponent(w http.ResponseWriter, r *http.Request) {
+	component := mux.Vars(r)["component"]
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodPut:
+		// the level is stored only if the request is valid, it starts from the current level of the component
+		level := zap.NewAtomicLevelAt(l.level(component).Level())
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		level.ServeHTTP(recorder, r)
+		if recorder.status == http.StatusOK {
+			l.components[component] = level
+		}
+	case http.MethodDelete:
+		delete(l.components, component)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		l.level(component).ServeHTTP(w, r)
+	}
+}
+
+// levelList is the response of LevelsPath
+type levelList struct {
+	Level      zapcore.Level            `json:"level"`
+	Components map[string]zapcore.Level `json:"components"`
+}
+
+// serveList responds with the root level and the levels of the components which have one
+func (l *Levels) serveList(w http.ResponseWriter, _ *http.Request) {
+	l.mu.RLock()
+	list := levelList{Level: l.root.Level(), Components: make(map[string]zapcore.Level, len(l.components))}
+	for component, level := range l.components {
+		list.Components[component] = level.Level()
+	}
+	l.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(list)
+}
+
+// statusRecorder records the status code which is written to its http.ResponseWriter
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records status and writes it
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,111 @@
+package logging
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestParseLevels(t *testing.T) {
+	levels, err := ParseLevels("")
+	assert.Nil(t, err)
+	assert.Equal(t, zap.InfoLevel, levels.Level(""))
+
+	levels, err = ParseLevels("informers=debug, warn ,informers.service=error")
+	assert.Nil(t, err)
+	assert.Equal(t, zap.WarnLevel, levels.Level(""))
+	assert.Equal(t, zap.WarnLevel, levels.Level("leader"))
+	assert.Equal(t, zap.DebugLevel, levels.Level("informers.node"))
+	assert.Equal(t, zap.ErrorLevel, levels.Level("informers.service.namespace"))
+	assert.True(t, levels.anyEnabled(zap.DebugLevel))
+	assert.False(t, levels.Enabled("informers.service", zap.WarnLevel))
+
+	_, err = ParseLevels("informers=loud")
+	assert.NotNil(t, err)
+}
+
+func TestRegister(t *testing.T) {
+	levels, err := ParseLevels("info")
+	assert.Nil(t, err)
+	router := mux.NewRouter()
+	Register(router, levels, true)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	request := func(method, path, body string) (int, string) {
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		assert.Nil(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		defer resp.Body.Close()
+
+		response, err := io.ReadAll(resp.Body)
+		assert.Nil(t, err)
+		return resp.StatusCode, strings.TrimSpace(string(response))
+	}
+
+	// a component uses the root level until it has its own
+	status, body := request(http.MethodGet, LevelPath+"/informers.service", "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, `{"level":"info"}`, body)
+
+	status, _ = request(http.MethodPut, LevelPath+"/informers", `{"level":"debug"}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, zap.DebugLevel, levels.Level("informers.service"))
+
+	// an invalid level is not stored
+	status, _ = request(http.MethodPut, LevelPath+"/leader", `{"level":"loud"}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status, _ = request(http.MethodPut, LevelPath, `{"level":"error"}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, zap.ErrorLevel, levels.Level("leader"))
+
+	status, body = request(http.MethodGet, LevelsPath, "")
+	assert.Equal(t, http.StatusOK, status)
+	var list levelList
+	assert.Nil(t, json.Unmarshal([]byte(body), &list))
+	assert.Equal(t, zap.ErrorLevel, list.Level)
+	assert.Equal(t, zap.DebugLevel, list.Components["informers"])
+	assert.Len(t, list.Components, 1)
+
+	status, _ = request(http.MethodDelete, LevelPath+"/informers", "")
+	assert.Equal(t, http.StatusNoContent, status)
+	assert.Equal(t, zap.ErrorLevel, levels.Level("informers.service"))
+
+	status, _ = request(http.MethodPost, LevelPath, `{"level":"debug"}`)
+	assert.Equal(t, http.StatusMethodNotAllowed, status)
+}
+
+func TestRegisterReadOnly(t *testing.T) {
+	levels, err := ParseLevels("info")
+	assert.Nil(t, err)
+	router := mux.NewRouter()
+	Register(router, levels, false)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	for _, method := range []string{http.MethodPut, http.MethodDelete} {
+		for _, path := range []string{LevelPath, LevelPath + "/informers"} {
+			req, err := http.NewRequest(method, server.URL+path, strings.NewReader(`{"level":"debug"}`))
+			assert.Nil(t, err)
+			resp, err := http.DefaultClient.Do(req)
+			assert.Nil(t, err)
+			_ = resp.Body.Close()
+			assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode, method+" "+path)
+		}
+	}
+	assert.Equal(t, zap.InfoLevel, levels.Level("informers"))
+
+	resp, err := http.Get(server.URL + LevelPath + "/informers")
+	assert.Nil(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
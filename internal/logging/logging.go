@@ -1,32 +1,194 @@
+// Package logging builds the zap loggers of nginx-conf-generator. The loggers write in the configured format to stdout
+// or to a rotated file, and the levels of the components, which are the names of the loggers, can be changed at
+// runtime through Levels
 package logging
 
 import (
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"time"
 
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/options"
+
+	zaplogfmt "github.com/jsternberg/zap-logfmt"
+	"github.com/pkg/errors"
 	"go.uber.org/zap"
+	"go.uber.org/zap/buffer"
 	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
-var (
-	logger *zap.Logger
-	Atomic zap.AtomicLevel
+const (
+	// FormatJSON writes each log as a JSON object
+	FormatJSON = "json"
+	// FormatConsole writes each log as a human readable line
+	FormatConsole = "console"
+	// FormatLogfmt writes each log as a line of key=value pairs
+	FormatLogfmt = "logfmt"
 )
 
-func init() {
-	Atomic = zap.NewAtomicLevel()
-	Atomic.SetLevel(zap.InfoLevel)
-	logger = zap.New(zapcore.NewTee(zapcore.NewCore(zapcore.NewJSONEncoder(zapcore.EncoderConfig{
-		MessageKey:   "message",
-		LevelKey:     "severity",
-		EncodeLevel:  zapcore.LowercaseLevelEncoder,
-		TimeKey:      "time",
-		EncodeTime:   zapcore.RFC3339TimeEncoder,
-		CallerKey:    "caller",
-		EncodeCaller: zapcore.FullCallerEncoder,
-	}), zapcore.Lock(os.Stdout), Atomic)))
-}
-
-// GetLogger returns the shared *zap.Logger
-func GetLogger() *zap.Logger {
-	return logger
+// encoderConfig is shared by all formats, so that the keys are the same whichever format is used
+var encoderConfig = zapcore.EncoderConfig{
+	MessageKey:   "message",
+	LevelKey:     "severity",
+	EncodeLevel:  zapcore.LowercaseLevelEncoder,
+	TimeKey:      "time",
+	EncodeTime:   zapcore.RFC3339TimeEncoder,
+	NameKey:      "logger",
+	CallerKey:    "caller",
+	EncodeCaller: zapcore.FullCallerEncoder,
+}
+
+// New returns a *zap.Logger which writes JSON logs to stdout, the level can be changed at runtime through level
+func New(level zap.AtomicLevel) *zap.Logger {
+	return zap.New(newCore(zapcore.NewJSONEncoder(encoderConfig), zapcore.Lock(os.Stdout),
+		&Levels{root: level, components: make(map[string]zap.AtomicLevel)}, 0, 0))
+}
+
+// Build returns a *zap.Logger which is configured with the logging options of ncgo. Its Levels are returned by
+// LevelsOf
+func Build(ncgo *options.NginxConfGeneratorOptions) (*zap.Logger, error) {
+	levels, err := ParseLevels(ncgo.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+
+	if ncgo.VerboseLog {
+		levels.root.SetLevel(zap.DebugLevel)
+	}
+
+	var encoder zapcore.Encoder
+	switch ncgo.LogFormat {
+	case FormatJSON, "":
+		encoder = zapcore.NewJSONEncoder(encoderConfig)
+	case FormatConsole:
+		config := encoderConfig
+		config.EncodeLevel = zapcore.CapitalLevelEncoder
+		config.EncodeTime = zapcore.ISO8601TimeEncoder
+		encoder = zapcore.NewConsoleEncoder(config)
+	case FormatLogfmt:
+		// the logfmt encoder has no fallback for the durations
+		config := encoderConfig
+		config.EncodeDuration = zapcore.StringDurationEncoder
+		encoder = namedEncoder{zaplogfmt.NewEncoder(config)}
+	default:
+		return nil, fmt.Errorf("log format %s is not one of %s, %s or %s", ncgo.LogFormat, FormatJSON,
+			FormatConsole, FormatLogfmt)
+	}
+
+	writer, err := newWriter(ncgo)
+	if err != nil {
+		return nil, err
+	}
+
+	return zap.New(newCore(encoder, writer, levels, ncgo.LogSamplingInitial, ncgo.LogSamplingThereafter)), nil
+}
+
+// newWriter returns stdout, or a file which is rotated by its size if a log file is configured in ncgo
+func newWriter(ncgo *options.NginxConfGeneratorOptions) (zapcore.WriteSyncer, error) {
+	if ncgo.LogFile == "" {
+		return zapcore.Lock(os.Stdout), nil
+	}
+
+	// the file is opened lazily by the first log, so that it is checked here to fail on startup
+	if err := os.MkdirAll(filepath.Dir(ncgo.LogFile), 0755); err != nil {
+		return nil, errors.Wrap(err, "unable to create log directory")
+	}
+
+	file, err := os.OpenFile(ncgo.LogFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open log file")
+	}
+	_ = file.Close()
+
+	return zapcore.AddSync(io.Writer(&lumberjack.Logger{
+		Filename:   ncgo.LogFile,
+		MaxSize:    ncgo.LogFileMaxSize,
+		MaxBackups: ncgo.LogFileMaxBackups,
+		MaxAge:     ncgo.LogFileMaxAge,
+		Compress:   true,
+	})), nil
+}
+
+// namedEncoder adds the name of the logger to the fields of each log, for the encoders which ignore the NameKey of
+// their zapcore.EncoderConfig
+type namedEncoder struct {
+	zapcore.Encoder
+}
+
+// Clone clones the wrapped encoder
+func (e namedEncoder) Clone() zapcore.Encoder {
+	return namedEncoder{e.Encoder.Clone()}
+}
+
+// EncodeEntry adds the name of the logger of entry to fields and encodes them with the wrapped encoder
+func (e namedEncoder) EncodeEntry(entry zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
+	if entry.LoggerName != "" {
+		fields = append([]zapcore.Field{zap.String(encoderConfig.NameKey, entry.LoggerName)}, fields...)
+	}
+
+	return e.Encoder.EncodeEntry(entry, fields)
+}
+
+// core writes the logs which are enabled by the level of their logger. The debug logs are sampled by their message
+// if sampling is enabled, so that a flood of the same message, e.g. the resyncs of the informers, does not hide the
+// others
+type core struct {
+	zapcore.Core
+	// sampled writes through the same core as Core, it is nil when sampling is disabled
+	sampled zapcore.Core
+	levels  *Levels
+}
+
+// newCore returns a core which writes to writer with encoder. In each second, the first samplingInitial debug logs
+// with the same message are written, and every samplingThereafter-th after that. Sampling is disabled when
+// samplingInitial is 0
+func newCore(encoder zapcore.Encoder, writer zapcore.WriteSyncer, levels *Levels, samplingInitial,
+	samplingThereafter int) zapcore.Core {
+	// the levels are checked by the core itself, so that the wrapped core writes every level
+	c := &core{Core: zapcore.NewCore(encoder, writer, zapcore.DebugLevel), levels: levels}
+	if samplingInitial > 0 {
+		c.sampled = zapcore.NewSamplerWithOptions(c.Core, time.Second, samplingInitial, samplingThereafter)
+	}
+
+	return c
+}
+
+// Enabled returns true if level is enabled for any of the loggers, the logger of an entry is checked by Check
+func (c *core) Enabled(level zapcore.Level) bool {
+	return c.levels.anyEnabled(level)
+}
+
+// With adds fields to both the sampled and the unsampled cores
+func (c *core) With(fields []zapcore.Field) zapcore.Core {
+	clone := &core{Core: c.Core.With(fields), levels: c.levels}
+	if c.sampled != nil {
+		clone.sampled = c.sampled.With(fields)
+	}
+
+	return clone
+}
+
+// Check adds the core to ce if the level of entry is enabled for its logger
+func (c *core) Check(entry zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.levels.Enabled(entry.LoggerName, entry.Level) {
+		return ce
+	}
+
+	if entry.Level == zapcore.DebugLevel && c.sampled != nil {
+		return c.sampled.Check(entry, ce)
+	}
+
+	return c.Core.Check(entry, ce)
+}
+
+// LevelsOf returns the Levels of logger, it returns nil if logger is not created by this package
+func LevelsOf(logger *zap.Logger) *Levels {
+	if c, ok := logger.Core().(*core); ok {
+		return c.levels
+	}
+
+	return nil
 }
@@ -1,15 +1,117 @@
 package logging
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/options"
 
 	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
 )
 
-func TestGetLogger(t *testing.T) {
-	t.Log("getting logger")
-	logger := GetLogger()
+func TestNew(t *testing.T) {
+	t.Log("creating logger")
+	level := zap.NewAtomicLevelAt(zap.InfoLevel)
+	logger := New(level)
 	assert.NotNil(t, logger)
 	t.Log("will try logger for debugging")
 	logger.Info("this is a test log by *zap.Logger!")
+
+	assert.False(t, logger.Core().Enabled(zap.DebugLevel))
+	level.SetLevel(zap.DebugLevel)
+	assert.True(t, logger.Core().Enabled(zap.DebugLevel))
+	assert.NotNil(t, LevelsOf(logger))
+	assert.Nil(t, LevelsOf(zap.NewNop()))
+}
+
+// readLogs builds a logger with ncgo which writes to a temporary file, calls write with it and returns the lines
+func readLogs(t *testing.T, ncgo *options.NginxConfGeneratorOptions, write func(logger *zap.Logger)) []string {
+	ncgo.LogFile = filepath.Join(t.TempDir(), "logs", "ncg.log")
+	logger, err := Build(ncgo)
+	assert.Nil(t, err)
+	write(logger)
+	assert.Nil(t, logger.Sync())
+
+	content, err := os.ReadFile(ncgo.LogFile)
+	assert.Nil(t, err)
+
+	return strings.Split(strings.TrimSpace(string(content)), "\n")
+}
+
+func TestBuildFormats(t *testing.T) {
+	for format, expected := range map[string]string{
+		"":            `"message":"started","cluster":"cluster-a"`,
+		FormatJSON:    `"severity":"info"`,
+		FormatConsole: "INFO\tinformers\tstarted\t{\"cluster\": \"cluster-a\"",
+		FormatLogfmt:  "severity=info message=started logger=informers cluster=cluster-a timeout=1s",
+	} {
+		lines := readLogs(t, &options.NginxConfGeneratorOptions{LogFormat: format}, func(logger *zap.Logger) {
+			logger.Named("informers").Info("started", zap.String("cluster", "cluster-a"),
+				zap.Duration("timeout", time.Second))
+		})
+		assert.Len(t, lines, 1)
+		assert.Contains(t, lines[0], expected, format)
+	}
+}
+
+func TestBuildLevels(t *testing.T) {
+	lines := readLogs(t, &options.NginxConfGeneratorOptions{LogLevel: "warn,informers.service=debug"},
+		func(logger *zap.Logger) {
+			logger.Info("root info")
+			informers := logger.Named("informers")
+			informers.Named("node").Debug("node debug")
+			informers.Named("service").With(zap.String("cluster", "cluster-a")).Debug("service debug")
+			informers.Named("service").Named("namespace").Debug("namespace debug")
+		})
+	assert.Len(t, lines, 2)
+	assert.Contains(t, lines[0], `"logger":"informers.service"`)
+	assert.Contains(t, lines[0], "service debug")
+	assert.Contains(t, lines[1], "namespace debug")
+
+	// verbose overrides the root level
+	lines = readLogs(t, &options.NginxConfGeneratorOptions{LogLevel: "warn", VerboseLog: true},
+		func(logger *zap.Logger) {
+			logger.Debug("root debug")
+		})
+	assert.Len(t, lines, 1)
+}
+
+func TestBuildSampling(t *testing.T) {
+	lines := readLogs(t, &options.NginxConfGeneratorOptions{LogLevel: "debug", LogSamplingInitial: 2,
+		LogSamplingThereafter: 3}, func(logger *zap.Logger) {
+		for i := 0; i < 8; i++ {
+			logger.Debug("not a real update, skipping")
+			logger.Info("applied")
+		}
+	})
+
+	debug, info := 0, 0
+	for _, line := range lines {
+		if strings.Contains(line, "not a real update") {
+			debug++
+		} else {
+			info++
+		}
+	}
+
+	// the first 2 and every 3rd after them are written, the other levels are not sampled
+	assert.Equal(t, 4, debug)
+	assert.Equal(t, 8, info)
+}
+
+func TestBuildInvalid(t *testing.T) {
+	_, err := Build(&options.NginxConfGeneratorOptions{LogFormat: "xml"})
+	assert.NotNil(t, err)
+
+	_, err = Build(&options.NginxConfGeneratorOptions{LogLevel: "verbose"})
+	assert.NotNil(t, err)
+
+	file := filepath.Join(t.TempDir(), "file")
+	assert.Nil(t, os.WriteFile(file, nil, 0644))
+	_, err = Build(&options.NginxConfGeneratorOptions{LogFile: filepath.Join(file, "ncg.log")})
+	assert.NotNil(t, err)
 }
@@ -1,57 +1,242 @@
 package metrics
 
 import (
-	"fmt"
+	"net"
 	"net/http"
+	"strconv"
 	"time"
 
-	"github.com/bilalcaliskan/nginx-conf-generator/internal/logging"
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/health"
 	"github.com/bilalcaliskan/nginx-conf-generator/internal/options"
+	"github.com/bilalcaliskan/nginx-conf-generator/pkg/model"
 	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"go.uber.org/zap"
 )
 
 const (
-	ProcessedNodePortCounterName = "processed_nodeport_counter"
-	TargetNodePortCounterName    = "target_node_counter"
+	// Namespace prefixes the names of the metrics, so that they do not collide with the metrics of the binary which
+	// embeds the generator on the same registry
+	Namespace                 = "nginx_conf_generator"
+	WorkersName               = "workers"
+	ExposedServicesName       = "exposed_services"
+	RenderDurationName        = "render_duration_seconds"
+	ReloadDurationName        = "nginx_reload_duration_seconds"
+	LastApplyTimestampName    = "last_successful_apply_timestamp_seconds"
+	ConfigInfoName            = "config_info"
+	InformerEventsName        = "informer_events_total"
+	PropagationDurationName   = "propagation_duration_seconds"
+	LeaderGaugeName           = "leader_election_is_leader"
+	LeadershipTransitionsName = "leader_election_transitions_total"
+	ClusterStateName          = "cluster_state"
+	// ResultSuccess is the result label of the renders and reloads which succeeded
+	ResultSuccess = "success"
+	// ResultError is the result label of the renders and reloads which failed
+	ResultError = "error"
+	// StatusEndpoint is the endpoint which provides the health statuses of the clusters in JSON
+	StatusEndpoint = "/status"
+	// LivenessEndpoint is the endpoint which reports whether the process is alive and its renders are not stuck
+	LivenessEndpoint = "/healthz"
+	// ReadinessEndpoint is the endpoint which reports whether the required clusters are synced and the configuration
+	// is applied
+	ReadinessEndpoint = "/readyz"
 )
 
-var (
-	logger *zap.Logger
-	opts   *options.NginxConfGeneratorOptions
-	// ProcessedNodePortCounter keeps track of processed nodePort type service counter
-	ProcessedNodePortCounter prometheus.Counter
-	// TargetNodeCounter keeps track of the target nodes on the managed clusters
-	TargetNodeCounter prometheus.Counter
-)
+// Metrics contains the prometheus metrics of a generator
+type Metrics struct {
+	// WorkersGauge is the number of workers of each cluster
+	WorkersGauge *prometheus.GaugeVec
+	// ExposedServicesGauge is the number of services of each cluster which are rendered into the configuration
+	ExposedServicesGauge *prometheus.GaugeVec
+	// RenderDurationHistogram observes the durations of the template renders by their results
+	RenderDurationHistogram *prometheus.HistogramVec
+	// ReloadDurationHistogram observes the durations of the Nginx reloads by their results
+	ReloadDurationHistogram *prometheus.HistogramVec
+	// LastApplyTimestampGauge is the unix time of the last successful apply of the configuration
+	LastApplyTimestampGauge prometheus.Gauge
+	// ConfigInfoGauge is 1 for the hash of the applied configuration
+	ConfigInfoGauge *prometheus.GaugeVec
+	// InformerEventsCounter counts the events which are received from the informers of each cluster
+	InformerEventsCounter *prometheus.CounterVec
+	// PropagationDurationHistogram observes the durations from the changes of the Kubernetes objects until the reloads
+	// of Nginx which include them succeed
+	PropagationDurationHistogram *prometheus.HistogramVec
+	// LeaderGauge is 1 if the replica is the leader, 0 otherwise
+	LeaderGauge prometheus.Gauge
+	// LeadershipTransitionsCounter counts the times which the replica started or stopped leading
+	LeadershipTransitionsCounter prometheus.Counter
+}
+
+// New creates the metrics and registers them on registerer, together with a collector which exposes the health
+// state of each cluster in healthRegistry
+func New(registerer prometheus.Registerer, healthRegistry *health.Registry) (*Metrics, error) {
+	metrics := &Metrics{
+		WorkersGauge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      WorkersName,
+			Help:      "Number of workers of the cluster",
+		}, []string{"cluster"}),
+		ExposedServicesGauge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      ExposedServicesName,
+			Help:      "Number of services of the cluster which are exposed through Nginx",
+		}, []string{"cluster"}),
+		RenderDurationHistogram: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      RenderDurationName,
+			Help:      "Durations of the template renders by their results",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"result"}),
+		ReloadDurationHistogram: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      ReloadDurationName,
+			Help:      "Durations of the Nginx reloads by their results",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"result"}),
+		LastApplyTimestampGauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      LastApplyTimestampName,
+			Help:      "Unix time of the last successful apply of the configuration",
+		}),
+		ConfigInfoGauge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      ConfigInfoName,
+			Help:      "Is 1 for the hash of the applied configuration",
+		}, []string{"hash"}),
+		InformerEventsCounter: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      InformerEventsName,
+			Help:      "Counts the events which are received from the informers of the cluster",
+		}, []string{"cluster", "kind", "type"}),
+		PropagationDurationHistogram: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      PropagationDurationName,
+			Help:      "Durations from the changes of the Kubernetes objects until Nginx is reloaded with them",
+			Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+		}, []string{"cluster", "kind"}),
+		LeaderGauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      LeaderGaugeName,
+			Help:      "Is 1 if the replica is the leader, 0 otherwise",
+		}),
+		LeadershipTransitionsCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      LeadershipTransitionsName,
+			Help:      "Counts the times which the replica started or stopped leading",
+		}),
+	}
+
+	collectors := []prometheus.Collector{metrics.WorkersGauge, metrics.ExposedServicesGauge,
+		metrics.RenderDurationHistogram, metrics.ReloadDurationHistogram, metrics.LastApplyTimestampGauge,
+		metrics.ConfigInfoGauge, metrics.InformerEventsCounter, metrics.PropagationDurationHistogram, metrics.LeaderGauge,
+		metrics.LeadershipTransitionsCounter, newClusterStateCollector(healthRegistry)}
+	for _, collector := range collectors {
+		if err := registerer.Register(collector); err != nil {
+			return nil, errors.Wrap(err, "unable to register metrics")
+		}
+	}
+
+	return metrics, nil
+}
+
+// Result returns the result label of an operation which returned err
+func Result(err error) string {
+	if err != nil {
+		return ResultError
+	}
+
+	return ResultSuccess
+}
+
+// ObserveSnapshot sets the worker and exposed service gauges from snapshot, clusters which are not in snapshot are
+// removed. Services of the dropped clusters are not exposed
+func (metrics *Metrics) ObserveSnapshot(snapshot *model.Snapshot) {
+	metrics.WorkersGauge.Reset()
+	metrics.ExposedServicesGauge.Reset()
+	for _, cluster := range snapshot.Clusters {
+		exposed := len(cluster.NodePorts)
+		if cluster.Dropped {
+			exposed = 0
+		}
+
+		metrics.WorkersGauge.WithLabelValues(cluster.Name).Set(float64(len(cluster.Workers)))
+		metrics.ExposedServicesGauge.WithLabelValues(cluster.Name).Set(float64(exposed))
+	}
+}
+
+// ObserveApply sets the last successful apply time and the hash of the applied configuration
+func (metrics *Metrics) ObserveApply(hash string, appliedAt time.Time) {
+	metrics.LastApplyTimestampGauge.Set(float64(appliedAt.UnixNano()) / float64(time.Second))
+	metrics.ConfigInfoGauge.Reset()
+	metrics.ConfigInfoGauge.WithLabelValues(hash).Set(1)
+}
+
+// clusterStateCollector exposes the health state of each cluster, the series of the current state is 1
+type clusterStateCollector struct {
+	desc           *prometheus.Desc
+	healthRegistry *health.Registry
+}
+
+func newClusterStateCollector(healthRegistry *health.Registry) *clusterStateCollector {
+	return &clusterStateCollector{
+		desc: prometheus.NewDesc(prometheus.BuildFQName(Namespace, "", ClusterStateName),
+			"Is 1 for the current health state of the cluster, 0 otherwise", []string{"cluster", "state"}, nil),
+		healthRegistry: healthRegistry,
+	}
+}
+
+// Describe implements prometheus.Collector
+func (collector *clusterStateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- collector.desc
+}
+
+// Collect implements prometheus.Collector
+func (collector *clusterStateCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, status := range collector.healthRegistry.List() {
+		for _, state := range health.States {
+			value := 0.0
+			if status.State == state {
+				value = 1
+			}
+			ch <- prometheus.MustNewConstMetric(collector.desc, prometheus.GaugeValue, value, status.Name,
+				string(state))
+		}
+	}
+}
+
+// NewServer returns a http.Server which provides the prometheus metrics of gatherer, the health statuses of the
+// clusters in healthRegistry and the reports of the liveness and readiness checks. routes are called with the router
+// to register additional endpoints on the same server. The server has a TLS configuration if a certificate is
+// configured in ncgo, and the paths which are not public require the configured authentication
+func NewServer(ncgo *options.NginxConfGeneratorOptions, gatherer prometheus.Gatherer,
+	healthRegistry *health.Registry, liveness, readiness func() health.Report,
+	routes ...func(router *mux.Router)) (*http.Server, error) {
+	tlsConfig, err := newTLSConfig(ncgo)
+	if err != nil {
+		return nil, err
+	}
+
+	auth, err := newAuthenticator(ncgo)
+	if err != nil {
+		return nil, err
+	}
 
-func init() {
-	logger = logging.GetLogger()
-	opts = options.GetNginxConfGeneratorOptions()
-	ProcessedNodePortCounter = prometheus.NewCounter(prometheus.CounterOpts{
-		Name: ProcessedNodePortCounterName,
-		Help: "Counts processed nodeport type services",
-	})
-	TargetNodeCounter = prometheus.NewCounter(prometheus.CounterOpts{
-		Name: TargetNodePortCounterName,
-		Help: "Counts target nodes on the managed clusters",
-	})
-}
-
-// RunMetricsServer spins up a router to provide prometheus metrics
-func RunMetricsServer() error {
 	router := mux.NewRouter()
-	metricServer := &http.Server{
+	router.Use(auth.middleware)
+	router.Handle(ncgo.MetricsEndpoint, promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}))
+	router.Handle(StatusEndpoint, healthRegistry.Handler())
+	router.Handle(LivenessEndpoint, health.ReportHandler(liveness))
+	router.Handle(ReadinessEndpoint, health.ReportHandler(readiness))
+	for _, route := range routes {
+		route(router)
+	}
+
+	return &http.Server{
 		Handler:      router,
-		Addr:         fmt.Sprintf(":%d", opts.MetricsPort),
+		Addr:         net.JoinHostPort(ncgo.MetricsBindAddress, strconv.Itoa(ncgo.MetricsPort)),
+		TLSConfig:    tlsConfig,
 		WriteTimeout: 10 * time.Second,
 		ReadTimeout:  10 * time.Second,
-	}
-	router.Handle(opts.MetricsEndpoint, promhttp.Handler())
-	prometheus.MustRegister(ProcessedNodePortCounter)
-	prometheus.MustRegister(TargetNodeCounter)
-	logger.Info("metric server is up and running", zap.Int("port", opts.MetricsPort))
-	return metricServer.ListenAndServe()
+	}, nil
 }
@@ -3,46 +3,118 @@ package metrics
 import (
 	"fmt"
 	"io"
-	"net"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/health"
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/options"
+	"github.com/bilalcaliskan/nginx-conf-generator/pkg/model"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 )
 
-func TestRunMetricsServer(t *testing.T) {
-	opts.MetricsPort = 9090
-	opts.MetricsEndpoint = "/metrics"
-	var (
-		conn net.Conn
-		err  error
-	)
-
-	defer func() {
-		err := conn.Close()
-		assert.Nil(t, err)
-	}()
-
-	go func() {
-		err := RunMetricsServer()
-		assert.Nil(t, err)
-	}()
-
-	for {
-		time.Sleep(1 * time.Second)
-		conn, _ = net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", opts.MetricsPort), 10*time.Second)
-		if conn != nil {
-			break
-		}
+func TestNew(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics, err := New(registry, health.NewRegistry())
+	assert.Nil(t, err)
+	assert.NotNil(t, metrics)
+
+	// metrics can not be registered twice on the same registerer
+	_, err = New(registry, health.NewRegistry())
+	assert.NotNil(t, err)
+
+	// but they can be registered on another one
+	_, err = New(prometheus.NewRegistry(), health.NewRegistry())
+	assert.Nil(t, err)
+}
+
+func TestNewServer(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	healthRegistry := health.NewRegistry()
+	healthRegistry.Set("cluster-a", health.StateDegraded, fmt.Errorf("connection refused"))
+	metrics, err := New(registry, healthRegistry)
+	assert.Nil(t, err)
+	metrics.WorkersGauge.WithLabelValues("cluster-a").Set(3)
+
+	readiness := func() health.Report {
+		return health.NewReport([]health.Check{{Name: "clusters", Message: "cluster-a is not synced"}}, nil)
 	}
+	server, err := NewServer(&options.NginxConfGeneratorOptions{MetricsPort: 9090, MetricsEndpoint: "/metrics"},
+		registry, healthRegistry, func() health.Report { return health.NewReport(nil, nil) }, readiness)
+	assert.Nil(t, err)
+	assert.Equal(t, ":9090", server.Addr)
+	assert.Nil(t, server.TLSConfig)
+
+	testServer := httptest.NewServer(server.Handler)
+	defer testServer.Close()
 
-	resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d%s", opts.MetricsPort, opts.MetricsEndpoint))
+	resp, err := http.Get(testServer.URL + "/metrics")
 	assert.Nil(t, err)
 
 	body, err := io.ReadAll(resp.Body)
 	assert.Nil(t, err)
 
-	assert.Contains(t, string(body), ProcessedNodePortCounterName)
-	assert.Contains(t, string(body), TargetNodePortCounterName)
+	assert.Contains(t, string(body), `nginx_conf_generator_workers{cluster="cluster-a"} 3`)
+	assert.Contains(t, string(body), `nginx_conf_generator_cluster_state{cluster="cluster-a",state="degraded"} 1`)
+	assert.Contains(t, string(body), `nginx_conf_generator_cluster_state{cluster="cluster-a",state="synced"} 0`)
+
+	resp, err = http.Get(testServer.URL + StatusEndpoint)
+	assert.Nil(t, err)
+
+	body, err = io.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	assert.Contains(t, string(body), `"state":"degraded"`)
+	assert.Contains(t, string(body), `"error":"connection refused"`)
+
+	resp, err = http.Get(testServer.URL + LivenessEndpoint)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = http.Get(testServer.URL + ReadinessEndpoint)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	body, err = io.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	assert.Contains(t, string(body), `"message":"cluster-a is not synced"`)
+}
+
+func TestResult(t *testing.T) {
+	assert.Equal(t, ResultSuccess, Result(nil))
+	assert.Equal(t, ResultError, Result(fmt.Errorf("exit status 1")))
+}
+
+func TestObserveSnapshot(t *testing.T) {
+	metrics, err := New(prometheus.NewRegistry(), health.NewRegistry())
+	assert.Nil(t, err)
+
+	worker := &model.Worker{HostIP: "10.0.0.44"}
+	nodePorts := []*model.NodePort{{Port: 30444}, {Port: 30445}}
+	metrics.ObserveSnapshot(&model.Snapshot{Clusters: []*model.Cluster{
+		{Name: "cluster-a", Workers: []*model.Worker{worker}, NodePorts: nodePorts},
+		{Name: "cluster-b", Workers: []*model.Worker{worker}, NodePorts: nodePorts, Dropped: true},
+	}})
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.WorkersGauge.WithLabelValues("cluster-a")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.ExposedServicesGauge.WithLabelValues("cluster-a")))
+	// services of the dropped clusters are not rendered
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.ExposedServicesGauge.WithLabelValues("cluster-b")))
+
+	// clusters which are not in the snapshot anymore are removed
+	metrics.ObserveSnapshot(&model.Snapshot{})
+	assert.Equal(t, 0, testutil.CollectAndCount(metrics.WorkersGauge))
+}
+
+func TestObserveApply(t *testing.T) {
+	metrics, err := New(prometheus.NewRegistry(), health.NewRegistry())
+	assert.Nil(t, err)
+
+	appliedAt := time.Unix(1700000000, 0)
+	metrics.ObserveApply("sha256:first", appliedAt)
+	metrics.ObserveApply("sha256:second", appliedAt)
+	assert.Equal(t, float64(1700000000), testutil.ToFloat64(metrics.LastApplyTimestampGauge))
+	assert.Equal(t, 1, testutil.CollectAndCount(metrics.ConfigInfoGauge))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.ConfigInfoGauge.WithLabelValues("sha256:second")))
 }
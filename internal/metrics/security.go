@@ -0,0 +1,315 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/options"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// authRealm is the realm of the basic authentication challenge
+	authRealm = "nginx-conf-generator"
+	// unknownUserHash is the bcrypt hash which the passwords of the unknown users are compared with
+	unknownUserHash = "$2a$10$T3FaGh2vb4afBcU.95sKbe72Ehnquz/o6NpLtIrPODfo6KIiaxl.."
+)
+
+// fileReloader loads a value from files and loads it again when the modification time of any of them is changed,
+// e.g. when a certificate is rotated. The previous value is kept if loading the changed files fails, so that a file
+// which is being written does not break the server
+type fileReloader[T any] struct {
+	files    []string
+	load     func() (T, error)
+	modTimes []time.Time
+	value    T
+	mu       sync.Mutex
+}
+
+// newFileReloader loads the value of files with load, it returns an error if it can not be loaded
+func newFileReloader[T any](load func() (T, error), files ...string) (*fileReloader[T], error) {
+	r := &fileReloader[T]{files: files, load: load, modTimes: make([]time.Time, len(files))}
+	if _, err := r.reloadLocked(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// get returns the value, which is loaded again first if any of the files is changed
+func (r *fileReloader[T]) get() T {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, file := range r.files {
+		if info, err := os.Stat(file); err == nil && !info.ModTime().Equal(r.modTimes[i]) {
+			_, _ = r.reloadLocked()
+			break
+		}
+	}
+
+	return r.value
+}
+
+// reloadLocked loads the value from the files, it must be called while holding mu
+func (r *fileReloader[T]) reloadLocked() (T, error) {
+	modTimes := make([]time.Time, len(r.files))
+	for i, file := range r.files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return r.value, errors.Wrapf(err, "unable to stat %s", file)
+		}
+		modTimes[i] = info.ModTime()
+	}
+
+	value, err := r.load()
+	if err != nil {
+		return r.value, err
+	}
+
+	r.value, r.modTimes = value, modTimes
+	return value, nil
+}
+
+// newTLSConfig returns the TLS configuration of the metrics server, it is nil if no certificate is configured in ncgo.
+// The certificate and the key are loaded again when they are changed. Client certificates are verified with the
+// client CA if it is configured, they are required by the authenticator for the paths which are not public
+func newTLSConfig(ncgo *options.NginxConfGeneratorOptions) (*tls.Config, error) {
+	if ncgo.MetricsTLSCertFile == "" && ncgo.MetricsTLSKeyFile == "" {
+		if ncgo.MetricsTLSClientCAFile != "" {
+			return nil, errors.New("client CA file requires a TLS certificate and key")
+		}
+
+		return nil, nil
+	}
+
+	if ncgo.MetricsTLSCertFile == "" || ncgo.MetricsTLSKeyFile == "" {
+		return nil, errors.New("both of the TLS certificate and key files must be set")
+	}
+
+	certificate, err := newFileReloader(func() (*tls.Certificate, error) {
+		certificate, err := tls.LoadX509KeyPair(ncgo.MetricsTLSCertFile, ncgo.MetricsTLSKeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to load TLS certificate")
+		}
+
+		return &certificate, nil
+	}, ncgo.MetricsTLSCertFile, ncgo.MetricsTLSKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return certificate.get(), nil
+		},
+	}
+
+	if ncgo.MetricsTLSClientCAFile != "" {
+		content, err := os.ReadFile(ncgo.MetricsTLSClientCAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read client CA file")
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(content) {
+			return nil, fmt.Errorf("client CA file %s does not contain any certificate", ncgo.MetricsTLSClientCAFile)
+		}
+
+		// the certificates are not required by the TLS handshake, so that the public paths are reachable without them
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return config, nil
+}
+
+// authenticator authorizes the requests to the paths which are not public. A request is authorized if it is
+// authenticated by any of the configured methods, all requests are authorized if none is configured
+type authenticator struct {
+	publicPaths map[string]bool
+	clientCerts bool
+	token       *fileReloader[string]
+	credentials *fileReloader[map[string]string]
+}
+
+// newAuthenticator returns the authenticator of the authentication options in ncgo
+func newAuthenticator(ncgo *options.NginxConfGeneratorOptions) (*authenticator, error) {
+	a := &authenticator{publicPaths: make(map[string]bool), clientCerts: ncgo.MetricsTLSClientCAFile != ""}
+	for _, path := range strings.Split(ncgo.MetricsPublicPaths, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			a.publicPaths[path] = true
+		}
+	}
+
+	var err error
+	if ncgo.MetricsAuthTokenFile != "" {
+		if a.token, err = newFileReloader(func() (string, error) {
+			return loadToken(ncgo.MetricsAuthTokenFile)
+		}, ncgo.MetricsAuthTokenFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if ncgo.MetricsBasicAuthFile != "" {
+		if a.credentials, err = newFileReloader(func() (map[string]string, error) {
+			return loadCredentials(ncgo.MetricsBasicAuthFile)
+		}, ncgo.MetricsBasicAuthFile); err != nil {
+			return nil, err
+		}
+	}
+
+	return a, nil
+}
+
+// loadToken reads the bearer token from file
+func loadToken(file string) (string, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to read auth token file")
+	}
+
+	token := strings.TrimSpace(string(content))
+	if token == "" {
+		return "", fmt.Errorf("auth token file %s is empty", file)
+	}
+
+	return token, nil
+}
+
+// loadCredentials reads the username:password lines of file, the empty lines and the ones starting with # are
+// skipped
+func loadCredentials(file string) (map[string]string, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read basic auth file")
+	}
+
+	credentials := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		username, password, found := strings.Cut(line, ":")
+		if !found || username == "" || password == "" {
+			return nil, fmt.Errorf("line of basic auth file %s is not in the form of username:password", file)
+		}
+
+		if isBcryptHash(password) {
+			if _, err := bcrypt.Cost([]byte(password)); err != nil {
+				return nil, errors.Wrapf(err, "bcrypt hash of user %s in basic auth file %s is invalid", username, file)
+			}
+		}
+		credentials[username] = password
+	}
+
+	if len(credentials) == 0 {
+		return nil, fmt.Errorf("basic auth file %s does not contain any credentials", file)
+	}
+
+	return credentials, nil
+}
+
+// containsBcryptHash returns true if any password of credentials is a bcrypt hash
+func containsBcryptHash(credentials map[string]string) bool {
+	for _, password := range credentials {
+		if isBcryptHash(password) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isBcryptHash returns true if password is a bcrypt hash in the form of htpasswd -B, e.g. $2y$10$...
+func isBcryptHash(password string) bool {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$"} {
+		if strings.HasPrefix(password, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// passwordMatches returns true if password matches expected, which is either a bcrypt hash or a plain password
+func passwordMatches(password, expected string) bool {
+	if isBcryptHash(expected) {
+		return bcrypt.CompareHashAndPassword([]byte(expected), []byte(password)) == nil
+	}
+
+	return subtle.ConstantTimeCompare([]byte(password), []byte(expected)) == 1
+}
+
+// enabled returns true if any authentication method is configured
+func (a *authenticator) enabled() bool {
+	return a.clientCerts || a.token != nil || a.credentials != nil
+}
+
+// authorized returns true if r is sent to a public path or authenticated by any of the configured methods
+func (a *authenticator) authorized(r *http.Request) bool {
+	if !a.enabled() || a.publicPaths[r.URL.Path] {
+		return true
+	}
+
+	if a.clientCerts && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return true
+	}
+
+	if a.token != nil {
+		if token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); found &&
+			subtle.ConstantTimeCompare([]byte(token), []byte(a.token.get())) == 1 {
+			return true
+		}
+	}
+
+	if a.credentials != nil {
+		if username, password, ok := r.BasicAuth(); ok {
+			credentials := a.credentials.get()
+			expected, found := credentials[username]
+			// the passwords are compared also for the unknown users, so that the users can not be enumerated by time.
+			// A bcrypt hash is compared for them if the file contains any, since they are slow to compare
+			if !found && containsBcryptHash(credentials) {
+				expected = unknownUserHash
+			}
+			if passwordMatches(password, expected) && found {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// middleware responds with 401 to the requests which are not authorized
+func (a *authenticator) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.authorized(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if a.token != nil {
+			w.Header().Add("WWW-Authenticate", fmt.Sprintf("Bearer realm=%q", authRealm))
+		}
+		if a.credentials != nil {
+			w.Header().Add("WWW-Authenticate", fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", authRealm))
+		}
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	})
+}
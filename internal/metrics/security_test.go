@@ -0,0 +1,262 @@
+package metrics
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/health"
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/options"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+// testCA issues the certificates of the tests
+type testCA struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	serial      int64
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "test-ca"},
+		NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour), IsCA: true,
+		KeyUsage: x509.KeyUsageCertSign, BasicConstraintsValid: true}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	certificate, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+
+	return &testCA{certificate: certificate, key: key, serial: 1}
+}
+
+// issue writes a certificate called commonName and its key to dir, and returns their paths
+func (ca *testCA) issue(t *testing.T, dir, commonName string, usage x509.ExtKeyUsage) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	ca.serial++
+	template := &x509.Certificate{SerialNumber: big.NewInt(ca.serial), Subject: pkix.Name{CommonName: commonName},
+		NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour),
+		ExtKeyUsage: []x509.ExtKeyUsage{usage}, IPAddresses: []net.IP{net.ParseIP("127.0.0.1")}}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.certificate, &key.PublicKey, ca.key)
+	assert.Nil(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+
+	certFile, keyFile := filepath.Join(dir, commonName+".crt"), filepath.Join(dir, commonName+".key")
+	writeChanged(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	writeChanged(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+
+	return certFile, keyFile
+}
+
+// writeChanged writes content to file and moves its modification time forward, so that the change is detected even
+// if the file is written twice in the resolution of the file system
+func writeChanged(t *testing.T, file string, content []byte) {
+	modTime := time.Now()
+	if info, err := os.Stat(file); err == nil {
+		modTime = info.ModTime().Add(time.Second)
+	}
+
+	assert.Nil(t, os.WriteFile(file, content, 0600))
+	assert.Nil(t, os.Chtimes(file, modTime, modTime))
+}
+
+func TestFileReloader(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "token")
+	writeChanged(t, file, []byte("first"))
+	reloader, err := newFileReloader(func() (string, error) {
+		return loadToken(file)
+	}, file)
+	assert.Nil(t, err)
+	assert.Equal(t, "first", reloader.get())
+
+	writeChanged(t, file, []byte("second\n"))
+	assert.Equal(t, "second", reloader.get())
+
+	// the previous value is kept while the file is invalid
+	writeChanged(t, file, nil)
+	assert.Equal(t, "second", reloader.get())
+	writeChanged(t, file, []byte("third"))
+	assert.Equal(t, "third", reloader.get())
+
+	_, err = newFileReloader(func() (string, error) {
+		return "", errors.New("unreachable")
+	}, filepath.Join(t.TempDir(), "missing"))
+	assert.NotNil(t, err)
+}
+
+func TestLoadCredentials(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "credentials")
+	writeChanged(t, file, []byte("# prometheus\nprometheus:secret:with:colons\n\nadmin:admin\n"))
+	credentials, err := loadCredentials(file)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"prometheus": "secret:with:colons", "admin": "admin"}, credentials)
+
+	// the bcrypt hashes of htpasswd -B are kept as they are
+	hash := "$2y$04$x//oMHviJgUu/.TR/dIm2usDDW4xQokfLxhnOIffBm1F6E4H0DZSK"
+	writeChanged(t, file, []byte("prometheus:"+hash+"\n"))
+	credentials, err = loadCredentials(file)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"prometheus": hash}, credentials)
+
+	for _, content := range []string{"", "# nobody\n", "prometheus\n", ":secret\n", "prometheus:\n",
+		"prometheus:$2y$04$truncated\n"} {
+		writeChanged(t, file, []byte(content))
+		_, err = loadCredentials(file)
+		assert.NotNil(t, err, content)
+	}
+}
+
+func TestAuthorizedBcrypt(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "credentials")
+	// the hash is the output of htpasswd -B -C 4 for the password secret
+	writeChanged(t, file, []byte("prometheus:$2y$04$x//oMHviJgUu/.TR/dIm2usDDW4xQokfLxhnOIffBm1F6E4H0DZSK\n"+
+		"admin:plain\n"))
+	a, err := newAuthenticator(&options.NginxConfGeneratorOptions{MetricsBasicAuthFile: file})
+	assert.Nil(t, err)
+
+	authorized := func(username, password string) bool {
+		r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		r.SetBasicAuth(username, password)
+		return a.authorized(r)
+	}
+	assert.True(t, authorized("prometheus", "secret"))
+	assert.False(t, authorized("prometheus", "guess"))
+	// the hash itself is not accepted as the password
+	assert.False(t, authorized("prometheus", "$2y$04$x//oMHviJgUu/.TR/dIm2usDDW4xQokfLxhnOIffBm1F6E4H0DZSK"))
+	assert.True(t, authorized("admin", "plain"))
+	assert.False(t, authorized("nobody", "secret"))
+}
+
+func TestNewTLSConfigInvalid(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	certFile, keyFile := ca.issue(t, dir, "server", x509.ExtKeyUsageServerAuth)
+
+	for _, ncgo := range []*options.NginxConfGeneratorOptions{
+		{MetricsTLSCertFile: certFile},
+		{MetricsTLSClientCAFile: certFile},
+		{MetricsTLSCertFile: certFile, MetricsTLSKeyFile: filepath.Join(dir, "missing.key")},
+		{MetricsTLSCertFile: certFile, MetricsTLSKeyFile: certFile},
+		{MetricsTLSCertFile: certFile, MetricsTLSKeyFile: keyFile, MetricsTLSClientCAFile: keyFile},
+	} {
+		_, err := newTLSConfig(ncgo)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestNewServerSecurity(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	certFile, keyFile := ca.issue(t, dir, "server", x509.ExtKeyUsageServerAuth)
+	clientCertFile, clientKeyFile := ca.issue(t, dir, "client", x509.ExtKeyUsageClientAuth)
+	caFile := filepath.Join(dir, "ca.crt")
+	writeChanged(t, caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.certificate.Raw}))
+	tokenFile, credentialsFile := filepath.Join(dir, "token"), filepath.Join(dir, "credentials")
+	writeChanged(t, tokenFile, []byte("s3cr3t\n"))
+	writeChanged(t, credentialsFile, []byte("prometheus:scrape\n"))
+
+	ncgo := &options.NginxConfGeneratorOptions{MetricsBindAddress: "127.0.0.1", MetricsEndpoint: "/metrics",
+		MetricsTLSCertFile: certFile, MetricsTLSKeyFile: keyFile, MetricsTLSClientCAFile: caFile,
+		MetricsAuthTokenFile: tokenFile, MetricsBasicAuthFile: credentialsFile, MetricsPublicPaths: "/healthz"}
+	report := func() health.Report { return health.NewReport(nil, nil) }
+	server, err := NewServer(ncgo, prometheus.NewRegistry(), health.NewRegistry(), report, report)
+	assert.Nil(t, err)
+	assert.Equal(t, "127.0.0.1:0", server.Addr)
+
+	listener, err := net.Listen("tcp", server.Addr)
+	assert.Nil(t, err)
+	go func() {
+		_ = server.ServeTLS(listener, "", "")
+	}()
+	defer server.Close()
+	url := "https://" + listener.Addr().String()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.certificate)
+	// the connections are not reused, so that each request sees the current certificate of the server
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool},
+		DisableKeepAlives: true}}
+	get := func(client *http.Client, path string, authorize func(r *http.Request)) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, url+path, nil)
+		assert.Nil(t, err)
+		if authorize != nil {
+			authorize(req)
+		}
+		resp, err := client.Do(req)
+		assert.Nil(t, err)
+		_ = resp.Body.Close()
+		return resp
+	}
+	bearer := func(token string) func(r *http.Request) {
+		return func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+
+	// the public paths do not require authentication
+	assert.Equal(t, http.StatusOK, get(client, LivenessEndpoint, nil).StatusCode)
+	resp := get(client, "/metrics", nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Len(t, resp.Header.Values("WWW-Authenticate"), 2)
+	assert.Equal(t, http.StatusUnauthorized, get(client, ReadinessEndpoint, nil).StatusCode)
+
+	// any of the methods authenticates the requests
+	assert.Equal(t, http.StatusOK, get(client, "/metrics", bearer("s3cr3t")).StatusCode)
+	assert.Equal(t, http.StatusUnauthorized, get(client, "/metrics", bearer("guess")).StatusCode)
+	assert.Equal(t, http.StatusOK, get(client, "/metrics", func(r *http.Request) {
+		r.SetBasicAuth("prometheus", "scrape")
+	}).StatusCode)
+	assert.Equal(t, http.StatusUnauthorized, get(client, "/metrics", func(r *http.Request) {
+		r.SetBasicAuth("admin", "scrape")
+	}).StatusCode)
+
+	clientCertificate, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+	assert.Nil(t, err)
+	mtlsClient := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool,
+		Certificates: []tls.Certificate{clientCertificate}}}}
+	assert.Equal(t, http.StatusOK, get(mtlsClient, "/metrics", nil).StatusCode)
+
+	// the certificate and the token are loaded again when they are changed
+	assert.Equal(t, "server", get(client, LivenessEndpoint, nil).TLS.PeerCertificates[0].Subject.CommonName)
+	rotatedCertFile, rotatedKeyFile := ca.issue(t, t.TempDir(), "rotated", x509.ExtKeyUsageServerAuth)
+	for source, target := range map[string]string{rotatedCertFile: certFile, rotatedKeyFile: keyFile} {
+		content, err := os.ReadFile(source)
+		assert.Nil(t, err)
+		writeChanged(t, target, content)
+	}
+	assert.Equal(t, "rotated", get(client, LivenessEndpoint, nil).TLS.PeerCertificates[0].Subject.CommonName)
+
+	writeChanged(t, tokenFile, []byte("r0tated"))
+	assert.Equal(t, http.StatusUnauthorized, get(client, "/metrics", bearer("s3cr3t")).StatusCode)
+	assert.Equal(t, http.StatusOK, get(client, "/metrics", bearer("r0tated")).StatusCode)
+}
+
+func TestNewServerInvalid(t *testing.T) {
+	report := func() health.Report { return health.NewReport(nil, nil) }
+	for _, ncgo := range []*options.NginxConfGeneratorOptions{
+		{MetricsTLSKeyFile: "server.key"},
+		{MetricsAuthTokenFile: filepath.Join(t.TempDir(), "missing")},
+		{MetricsBasicAuthFile: filepath.Join(t.TempDir(), "missing")},
+	} {
+		_, err := NewServer(ncgo, prometheus.NewRegistry(), health.NewRegistry(), report, report)
+		assert.NotNil(t, err)
+	}
+}
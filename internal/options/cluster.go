@@ -0,0 +1,240 @@
+package options
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// IPFamilyIPv4 prefers IPv4 node addresses
+	IPFamilyIPv4 = "IPv4"
+	// IPFamilyIPv6 prefers IPv6 node addresses
+	IPFamilyIPv6 = "IPv6"
+)
+
+// DefaultNodeAddressTypes is the preferred order of node address types when nothing is configured
+var DefaultNodeAddressTypes = []string{"InternalIP", "ExternalIP", "Hostname"}
+
+var validNodeAddressTypes = map[string]bool{
+	"InternalIP":  true,
+	"ExternalIP":  true,
+	"Hostname":    true,
+	"InternalDNS": true,
+	"ExternalDNS": true,
+}
+
+// ClusterOptions contains the options of a single managed cluster, it is mapped from generator.ClusterConfig
+type ClusterOptions struct {
+	// Name is the unique name of the cluster, defaults to Context, the file name of KubeConfigPath, "in-cluster" or
+	// the host of Server
+	Name string `json:"name,omitempty"`
+	// KubeConfigPath is the kubeconfig file path to access with the cluster, exec and auth provider plugins of it
+	// are supported
+	KubeConfigPath string `json:"kubeConfigPath,omitempty"`
+	// Context is the context to use in KubeConfigPath, defaults to the current context
+	Context string `json:"context,omitempty"`
+	// InCluster makes the cluster accessed with the service account of the pod which nginx-conf-generator runs in
+	InCluster bool `json:"inCluster,omitempty"`
+	// Server is the URL of the API server, which overrides the server in KubeConfigPath if both are set
+	Server string `json:"server,omitempty"`
+	// CertificateAuthority is the path of the CA certificate file of the API server
+	CertificateAuthority string `json:"certificateAuthority,omitempty"`
+	// ClientCertificate is the path of the client certificate file for TLS authentication
+	ClientCertificate string `json:"clientCertificate,omitempty"`
+	// ClientKey is the path of the client key file for TLS authentication
+	ClientKey string `json:"clientKey,omitempty"`
+	// TokenFile is the path of the bearer token file, which is read periodically so rotated tokens are picked up
+	TokenFile string `json:"tokenFile,omitempty"`
+	// NodeAddressTypes is the preferred order of node address types which workers are built from
+	NodeAddressTypes []string `json:"nodeAddressTypes,omitempty"`
+	// IPFamily is the preferred IP family of worker addresses, either IPv4, IPv6 or empty for no preference
+	IPFamily string `json:"ipFamily,omitempty"`
+	// WorkerNodeSelector is the label selector to specify worker nodes, which is applied on the API server side
+	WorkerNodeSelector string `json:"workerNodeSelector,omitempty"`
+	// IncludeNamespaces is the list of namespaces which services are discovered from, they are watched through
+	// namespaced informers. All namespaces are allowed when it is empty
+	IncludeNamespaces []string `json:"includeNamespaces,omitempty"`
+	// ExcludeNamespaces is the list of namespaces which services are never discovered from
+	ExcludeNamespaces []string `json:"excludeNamespaces,omitempty"`
+	// NamespaceSelector is the label selector of the namespaces which services are discovered from
+	NamespaceSelector string `json:"namespaceSelector,omitempty"`
+	// Optional makes the readiness not depend on the cluster, clusters are required by default
+	Optional bool `json:"optional,omitempty"`
+}
+
+// clusterConfigFile is the layout of the file which is passed with --cluster-config-file
+type clusterConfigFile struct {
+	Clusters []*ClusterOptions `json:"clusters"`
+}
+
+// GetClusterOptions returns the options of each managed cluster. Clusters are read from ClusterConfigFile if it is
+// set, otherwise they are built from KubeConfigPaths. Cluster level settings which are not set default to the
+// command line options
+func (opts *NginxConfGeneratorOptions) GetClusterOptions() ([]*ClusterOptions, error) {
+	var clusters []*ClusterOptions
+	if opts.ClusterConfigFile != "" {
+		content, err := os.ReadFile(opts.ClusterConfigFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read cluster config file")
+		}
+
+		var file clusterConfigFile
+		if err := yaml.UnmarshalStrict(content, &file); err != nil {
+			return nil, errors.Wrap(err, "unable to parse cluster config file")
+		}
+		clusters = file.Clusters
+	} else if opts.InCluster {
+		clusters = append(clusters, &ClusterOptions{InCluster: true})
+	} else {
+		for _, path := range strings.Split(opts.KubeConfigPaths, ",") {
+			clusters = append(clusters, &ClusterOptions{KubeConfigPath: strings.TrimSpace(path)})
+		}
+	}
+
+	if len(clusters) == 0 {
+		return nil, errors.New("no cluster is configured")
+	}
+
+	names := make(map[string]bool)
+	for i, cluster := range clusters {
+		if cluster.Name == "" {
+			cluster.Name = cluster.defaultName()
+			if names[cluster.Name] {
+				cluster.Name = fmt.Sprintf("%s-%d", cluster.Name, i)
+			}
+		}
+
+		if names[cluster.Name] {
+			return nil, fmt.Errorf("cluster name %s is not unique", cluster.Name)
+		}
+		names[cluster.Name] = true
+
+		if len(cluster.NodeAddressTypes) == 0 {
+			cluster.NodeAddressTypes = splitList(opts.NodeAddressTypes)
+		}
+
+		if len(cluster.NodeAddressTypes) == 0 {
+			cluster.NodeAddressTypes = DefaultNodeAddressTypes
+		}
+
+		if cluster.IPFamily == "" {
+			cluster.IPFamily = opts.IPFamily
+		}
+
+		if cluster.WorkerNodeSelector == "" {
+			cluster.WorkerNodeSelector = opts.WorkerNodeSelector
+		}
+
+		if cluster.WorkerNodeSelector == "" && opts.WorkerNodeLabel != "" {
+			cluster.WorkerNodeSelector = fmt.Sprintf("%s=true", opts.WorkerNodeLabel)
+		}
+
+		if len(cluster.IncludeNamespaces) == 0 {
+			cluster.IncludeNamespaces = splitList(opts.IncludeNamespaces)
+		}
+
+		if len(cluster.ExcludeNamespaces) == 0 {
+			cluster.ExcludeNamespaces = splitList(opts.ExcludeNamespaces)
+		}
+
+		if cluster.NamespaceSelector == "" {
+			cluster.NamespaceSelector = opts.NamespaceSelector
+		}
+
+		if err := cluster.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return clusters, nil
+}
+
+// defaultName returns the name of the cluster which is derived from the source of its connection
+func (cluster *ClusterOptions) defaultName() string {
+	switch {
+	case cluster.Context != "":
+		return cluster.Context
+	case cluster.KubeConfigPath != "":
+		return strings.TrimSuffix(filepath.Base(cluster.KubeConfigPath), filepath.Ext(cluster.KubeConfigPath))
+	case cluster.InCluster:
+		return "in-cluster"
+	default:
+		if serverURL, err := url.Parse(cluster.Server); err == nil && serverURL.Hostname() != "" {
+			return serverURL.Hostname()
+		}
+		return cluster.Server
+	}
+}
+
+func (cluster *ClusterOptions) validate() error {
+	if cluster.InCluster && cluster.KubeConfigPath != "" {
+		return fmt.Errorf("cluster %s can not use both inCluster and kubeConfigPath", cluster.Name)
+	}
+
+	if !cluster.InCluster && cluster.KubeConfigPath == "" && cluster.Server == "" {
+		return fmt.Errorf("cluster %s has none of inCluster, kubeConfigPath or server", cluster.Name)
+	}
+
+	if cluster.Context != "" && cluster.KubeConfigPath == "" {
+		return fmt.Errorf("context of cluster %s requires kubeConfigPath", cluster.Name)
+	}
+
+	if (cluster.ClientCertificate == "") != (cluster.ClientKey == "") {
+		return fmt.Errorf("clientCertificate and clientKey of cluster %s must be set together", cluster.Name)
+	}
+
+	for _, addressType := range cluster.NodeAddressTypes {
+		if !validNodeAddressTypes[addressType] {
+			return fmt.Errorf("invalid node address type %q for cluster %s", addressType, cluster.Name)
+		}
+	}
+
+	if cluster.IPFamily != "" && cluster.IPFamily != IPFamilyIPv4 && cluster.IPFamily != IPFamilyIPv6 {
+		return fmt.Errorf("invalid ip family %q for cluster %s, must be one of %s or %s", cluster.IPFamily,
+			cluster.Name, IPFamilyIPv4, IPFamilyIPv6)
+	}
+
+	if _, err := labels.Parse(cluster.WorkerNodeSelector); err != nil {
+		return errors.Wrapf(err, "invalid worker node selector for cluster %s", cluster.Name)
+	}
+
+	if _, err := labels.Parse(cluster.NamespaceSelector); err != nil {
+		return errors.Wrapf(err, "invalid namespace selector for cluster %s", cluster.Name)
+	}
+
+	return nil
+}
+
+// splitList splits a comma separated list and drops the empty items
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// ParsePorts parses a comma separated list of ports
+func ParsePorts(list string) ([]int32, error) {
+	var ports []int32
+	for _, item := range splitList(list) {
+		port, err := strconv.ParseInt(item, 10, 32)
+		if err != nil || port < 1 || port > 65535 {
+			return nil, fmt.Errorf("%q is not a valid port", item)
+		}
+		ports = append(ports, int32(port))
+	}
+
+	return ports, nil
+}
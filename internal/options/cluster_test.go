@@ -0,0 +1,173 @@
+package options
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetClusterOptions(t *testing.T) {
+	opts := &NginxConfGeneratorOptions{
+		KubeConfigPaths:  "/tmp/config1, /tmp/config2",
+		NodeAddressTypes: "ExternalIP,InternalIP",
+		IPFamily:         IPFamilyIPv4,
+		WorkerNodeLabel:  "worker",
+	}
+
+	clusters, err := opts.GetClusterOptions()
+	assert.Nil(t, err)
+	assert.Len(t, clusters, 2)
+	assert.Equal(t, "/tmp/config2", clusters[1].KubeConfigPath)
+	assert.Equal(t, "config1", clusters[0].Name)
+	assert.Equal(t, "config2", clusters[1].Name)
+	assert.Equal(t, []string{"ExternalIP", "InternalIP"}, clusters[1].NodeAddressTypes)
+	assert.Equal(t, IPFamilyIPv4, clusters[1].IPFamily)
+	assert.Equal(t, "worker=true", clusters[1].WorkerNodeSelector)
+
+	opts.WorkerNodeSelector = "node-role.kubernetes.io/worker,zone in (a,b),!excluded"
+	clusters, err = opts.GetClusterOptions()
+	assert.Nil(t, err)
+	assert.Equal(t, opts.WorkerNodeSelector, clusters[0].WorkerNodeSelector)
+
+	opts.IncludeNamespaces = "team-a, team-b"
+	opts.ExcludeNamespaces = "kube-system"
+	opts.NamespaceSelector = "edge-exposure=allowed"
+	clusters, err = opts.GetClusterOptions()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"team-a", "team-b"}, clusters[0].IncludeNamespaces)
+	assert.Equal(t, []string{"kube-system"}, clusters[0].ExcludeNamespaces)
+	assert.Equal(t, "edge-exposure=allowed", clusters[0].NamespaceSelector)
+}
+
+func TestGetClusterOptionsFromFile(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "clusters.yaml")
+	assert.Nil(t, os.WriteFile(configFile, []byte(`
+clusters:
+  - kubeConfigPath: /tmp/config1
+    name: production
+    nodeAddressTypes: [Hostname]
+    ipFamily: IPv6
+    workerNodeSelector: node-role.kubernetes.io/worker=
+    includeNamespaces: [team-a]
+    namespaceSelector: edge-exposure=allowed
+  - kubeConfigPath: /tmp/config2
+`), 0600))
+
+	opts := &NginxConfGeneratorOptions{ClusterConfigFile: configFile, KubeConfigPaths: "/tmp/ignored"}
+	clusters, err := opts.GetClusterOptions()
+	assert.Nil(t, err)
+	assert.Len(t, clusters, 2)
+	assert.Equal(t, "production", clusters[0].Name)
+	assert.Equal(t, "config2", clusters[1].Name)
+	assert.Equal(t, []string{"Hostname"}, clusters[0].NodeAddressTypes)
+	assert.Equal(t, IPFamilyIPv6, clusters[0].IPFamily)
+	assert.Equal(t, "node-role.kubernetes.io/worker=", clusters[0].WorkerNodeSelector)
+	assert.Equal(t, []string{"team-a"}, clusters[0].IncludeNamespaces)
+	assert.Equal(t, "edge-exposure=allowed", clusters[0].NamespaceSelector)
+	assert.Empty(t, clusters[1].IncludeNamespaces)
+	assert.Equal(t, DefaultNodeAddressTypes, clusters[1].NodeAddressTypes)
+	assert.Equal(t, "", clusters[1].IPFamily)
+}
+
+func TestGetClusterOptionsInvalid(t *testing.T) {
+	cases := []struct {
+		caseName string
+		opts     *NginxConfGeneratorOptions
+	}{
+		{"invalidAddressType", &NginxConfGeneratorOptions{KubeConfigPaths: "/tmp/config", NodeAddressTypes: "PodIP"}},
+		{"invalidIPFamily", &NginxConfGeneratorOptions{KubeConfigPaths: "/tmp/config", IPFamily: "IPv5"}},
+		{"invalidSelector", &NginxConfGeneratorOptions{KubeConfigPaths: "/tmp/config",
+			WorkerNodeSelector: "zone in (a"}},
+		{"invalidNamespaceSelector", &NginxConfGeneratorOptions{KubeConfigPaths: "/tmp/config",
+			NamespaceSelector: "edge-exposure in (allowed"}},
+		{"missingFile", &NginxConfGeneratorOptions{ClusterConfigFile: "/tmp/nonexistent/clusters.yaml"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.caseName, func(t *testing.T) {
+			clusters, err := tc.opts.GetClusterOptions()
+			assert.NotNil(t, err)
+			assert.Nil(t, clusters)
+		})
+	}
+}
+
+func TestParsePorts(t *testing.T) {
+	ports, err := ParsePorts("22, 443,")
+	assert.Nil(t, err)
+	assert.Equal(t, []int32{22, 443}, ports)
+
+	for _, list := range []string{"ssh", "0", "65536"} {
+		ports, err = ParsePorts(list)
+		assert.NotNil(t, err)
+		assert.Nil(t, ports)
+	}
+}
+
+func TestGetClusterOptionsNames(t *testing.T) {
+	opts := &NginxConfGeneratorOptions{KubeConfigPaths: "/tmp/a/config.yaml,/tmp/b/config"}
+	clusters, err := opts.GetClusterOptions()
+	assert.Nil(t, err)
+	assert.Equal(t, "config", clusters[0].Name)
+	assert.Equal(t, "config-1", clusters[1].Name)
+
+	configFile := filepath.Join(t.TempDir(), "clusters.yaml")
+	assert.Nil(t, os.WriteFile(configFile, []byte(`
+clusters:
+  - kubeConfigPath: /tmp/config1
+    name: production
+  - kubeConfigPath: /tmp/config2
+    name: production
+`), 0600))
+
+	opts = &NginxConfGeneratorOptions{ClusterConfigFile: configFile}
+	clusters, err = opts.GetClusterOptions()
+	assert.NotNil(t, err)
+	assert.Nil(t, clusters)
+}
+
+func TestGetClusterOptionsSources(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "clusters.yaml")
+	assert.Nil(t, os.WriteFile(configFile, []byte(`
+clusters:
+  - kubeConfigPath: /tmp/config
+    context: staging
+  - inCluster: true
+  - server: https://api.example.com:6443
+    certificateAuthority: /etc/ncg/ca.crt
+    tokenFile: /etc/ncg/token
+`), 0600))
+
+	opts := &NginxConfGeneratorOptions{ClusterConfigFile: configFile}
+	clusters, err := opts.GetClusterOptions()
+	assert.Nil(t, err)
+	assert.Equal(t, "staging", clusters[0].Name)
+	assert.Equal(t, "in-cluster", clusters[1].Name)
+	assert.Equal(t, "api.example.com", clusters[2].Name)
+	assert.Equal(t, "/etc/ncg/token", clusters[2].TokenFile)
+
+	opts = &NginxConfGeneratorOptions{InCluster: true, KubeConfigPaths: "/tmp/ignored"}
+	clusters, err = opts.GetClusterOptions()
+	assert.Nil(t, err)
+	assert.Len(t, clusters, 1)
+	assert.True(t, clusters[0].InCluster)
+}
+
+func TestGetClusterOptionsInvalidSources(t *testing.T) {
+	for _, content := range []string{
+		"clusters: [{inCluster: true, kubeConfigPath: /tmp/config}]",
+		"clusters: [{name: empty}]",
+		"clusters: [{inCluster: true, context: staging}]",
+		"clusters: [{server: https://api.example.com, clientCertificate: /tmp/client.crt}]",
+	} {
+		configFile := filepath.Join(t.TempDir(), "clusters.yaml")
+		assert.Nil(t, os.WriteFile(configFile, []byte(content), 0600))
+
+		opts := &NginxConfGeneratorOptions{ClusterConfigFile: configFile}
+		clusters, err := opts.GetClusterOptions()
+		assert.NotNil(t, err, content)
+		assert.Nil(t, clusters)
+	}
+}
@@ -0,0 +1,110 @@
+// Package tracing builds the OpenTelemetry tracer provider of the generator and defines the names and the attributes
+// of its spans
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/options"
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/version"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName is the name of the tracer which creates the spans of the generator
+const TracerName = "github.com/bilalcaliskan/nginx-conf-generator"
+
+const (
+	// SpanEvent is the span of an informer event which is handled
+	SpanEvent = "informer.event"
+	// SpanQueue is the span of a change which waits for the next apply, it is a child of its SpanEvent
+	SpanQueue = "apply.queue"
+	// SpanApply is the span of an apply of the configuration, it is linked to the SpanQueue spans which it includes
+	SpanApply = "apply"
+	// SpanSnapshot is the span of building the state of the clusters which the configuration is rendered with
+	SpanSnapshot = "apply.snapshot"
+	// SpanRender is the span of the template execution of a configuration file
+	SpanRender = "apply.render"
+	// SpanValidate is the span of the validation of the configuration with nginx -t
+	SpanValidate = "apply.validate"
+	// SpanReload is the span of the reload of Nginx
+	SpanReload = "apply.reload"
+)
+
+const (
+	// ClusterKey is the name of the cluster which a span belongs to
+	ClusterKey = semconv.K8SClusterNameKey
+	// NamespaceKey is the namespace of the object of an informer event
+	NamespaceKey = semconv.K8SNamespaceNameKey
+	// NodeKey is the name of the node of an informer event
+	NodeKey = semconv.K8SNodeNameKey
+	// ServiceKey is the name of the service of an informer event
+	ServiceKey = attribute.Key("ncg.service.name")
+	// ObjectKey is the name of the object of an informer event, which is also set for the kinds other than nodes and
+	// services
+	ObjectKey = attribute.Key("ncg.object.name")
+	// KindKey is the kind of the object of an informer event, e.g. service
+	KindKey = attribute.Key("ncg.object.kind")
+	// EventTypeKey is the type of an informer event, e.g. update
+	EventTypeKey = attribute.Key("ncg.event.type")
+	// FileKey is the path of the configuration file which is rendered
+	FileKey = attribute.Key("ncg.file")
+	// ChangedKey is true if an apply changed the configuration
+	ChangedKey = attribute.Key("ncg.changed")
+	// ResultKey is the result of an apply, e.g. reloaded
+	ResultKey = attribute.Key("ncg.result")
+	// HashKey is the sha256 hash of the rendered configuration
+	HashKey = attribute.Key("ncg.hash")
+)
+
+// New creates a tracer provider which exports the spans to the OTLP/HTTP endpoint in ncgo, it returns nil if no
+// endpoint is configured. The OTEL_EXPORTER_OTLP_* environment variables, e.g. the headers, are also respected
+func New(ncgo *options.NginxConfGeneratorOptions) (*sdktrace.TracerProvider, error) {
+	if ncgo.TracingEndpoint == "" {
+		return nil, nil
+	}
+
+	// the exporter only logs an invalid endpoint, so that it is validated here to fail on startup
+	endpoint, err := url.Parse(ncgo.TracingEndpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("tracing endpoint %s is not a valid http or https URL", ncgo.TracingEndpoint)
+	}
+
+	exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(ncgo.TracingEndpoint))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create OTLP trace exporter")
+	}
+
+	return NewProvider(exporter, ncgo.TracingSampleRatio), nil
+}
+
+// NewProvider creates a tracer provider which exports the sampled spans to exporter in batches. The root spans are
+// sampled with sampleRatio, the others follow the sampling of their parent
+func NewProvider(exporter sdktrace.SpanExporter, sampleRatio float64) *sdktrace.TracerProvider {
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceName("nginx-conf-generator"),
+			semconv.ServiceVersion(version.Get().GitVersion),
+		)),
+	)
+}
+
+// End records err on span if it is not nil and ends span
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,76 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/options"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestNew(t *testing.T) {
+	provider, err := New(&options.NginxConfGeneratorOptions{})
+	assert.Nil(t, err)
+	assert.Nil(t, provider)
+
+	provider, err = New(&options.NginxConfGeneratorOptions{TracingEndpoint: "http://localhost:4318",
+		TracingSampleRatio: 1})
+	assert.Nil(t, err)
+	assert.NotNil(t, provider)
+	assert.Nil(t, provider.Shutdown(context.Background()))
+
+	_, err = New(&options.NginxConfGeneratorOptions{TracingEndpoint: "://invalid"})
+	assert.NotNil(t, err)
+}
+
+func TestNewProvider(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := NewProvider(exporter, 1)
+	_, span := provider.Tracer(TracerName).Start(context.Background(), SpanApply)
+	span.End()
+	assert.Nil(t, provider.ForceFlush(context.Background()))
+
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, SpanApply, spans[0].Name)
+	assert.Equal(t, "nginx-conf-generator", spanServiceName(spans[0]))
+
+	// no trace is sampled with ratio 0
+	exporter.Reset()
+	provider = NewProvider(exporter, 0)
+	_, span = provider.Tracer(TracerName).Start(context.Background(), SpanApply)
+	span.End()
+	assert.Nil(t, provider.ForceFlush(context.Background()))
+	assert.Empty(t, exporter.GetSpans())
+}
+
+func spanServiceName(span tracetest.SpanStub) string {
+	for _, attribute := range span.Resource.Attributes() {
+		if attribute.Key == "service.name" {
+			return attribute.Value.AsString()
+		}
+	}
+
+	return ""
+}
+
+func TestEnd(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := NewProvider(exporter, 1)
+	_, failed := provider.Tracer(TracerName).Start(context.Background(), SpanReload)
+	End(failed, errors.New("exit status 1"))
+	_, succeeded := provider.Tracer(TracerName).Start(context.Background(), SpanValidate)
+	End(succeeded, nil)
+	assert.Nil(t, provider.ForceFlush(context.Background()))
+
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 2)
+	assert.Equal(t, codes.Error, spans[0].Status.Code)
+	assert.Equal(t, "exit status 1", spans[0].Status.Description)
+	assert.Len(t, spans[0].Events, 1)
+	assert.Equal(t, codes.Unset, spans[1].Status.Code)
+}
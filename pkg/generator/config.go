@@ -0,0 +1,281 @@
+package generator
+
+import (
+	"time"
+
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/options"
+)
+
+// Config contains the options of a Generator, the command line flags of nginx-conf-generator are bound to it. It is
+// copied by New, so the changes after New are not applied
+type Config struct {
+	// KubeConfigPaths is the comma separated list of kubeconfig file paths to access with the cluster
+	KubeConfigPaths string
+	// InCluster makes the cluster accessed with the service account of the pod, overrides KubeConfigPaths
+	InCluster bool
+	// ClusterConfigFile is the path of the yaml file which contains per cluster settings, overrides KubeConfigPaths
+	ClusterConfigFile string
+	// NodeAddressTypes is the comma separated, preferred order of node address types which workers are built from
+	NodeAddressTypes string
+	// IPFamily is the preferred IP family of worker addresses, either IPv4, IPv6 or empty for no preference
+	IPFamily string
+	// WorkerNodeLabel is the label to specify worker nodes, deprecated in favor of WorkerNodeSelector
+	WorkerNodeLabel string
+	// WorkerNodeSelector is the label selector to specify worker nodes, defaults to WorkerNodeLabel=true
+	WorkerNodeSelector string
+	// ExcludeUnschedulableNodes takes cordoned nodes out of the upstreams
+	ExcludeUnschedulableNodes bool
+	// ExcludeNodeTaints is the comma separated list of taints in the form of key[=value][:effect], tainted nodes are
+	// taken out of the upstreams
+	ExcludeNodeTaints string
+	// ExcludeNodeConditions is the comma separated list of node condition types, nodes which have one of them with
+	// True status are taken out of the upstreams
+	ExcludeNodeConditions string
+	// NodeDrainGracePeriod is the duration which removed nodes are rendered as down before they are removed
+	NodeDrainGracePeriod time.Duration
+	// IncludeNamespaces is the comma separated list of namespaces which services are discovered from, all namespaces
+	// are allowed when it is empty
+	IncludeNamespaces string
+	// ExcludeNamespaces is the comma separated list of namespaces which services are never discovered from
+	ExcludeNamespaces string
+	// NamespaceSelector is the label selector of the namespaces which services are discovered from
+	NamespaceSelector string
+	// CustomAnnotation is the annotation to specify selectable services
+	CustomAnnotation string
+	// ListenPortAnnotation is the annotation to specify the port which Nginx listens on for a service, instead of the
+	// NodePort of the service
+	ListenPortAnnotation string
+	// ReservedListenPorts is the comma separated list of ports which can not be claimed by services and Gateway listeners
+	ReservedListenPorts string
+	// EnableStatusAnnotations enables patching the status annotations of services after the configuration is applied
+	EnableStatusAnnotations bool
+	// TemplateInputFile is the input path of the template file
+	TemplateInputFile string
+	// TemplateOutputFile is the output path of the template file
+	TemplateOutputFile string
+	// StreamTemplateOutputFile is the output path of the "stream" template, which must be included in the nginx
+	// stream context. TCPRoutes are only handled when it is set
+	StreamTemplateOutputFile string
+	// NginxBinary is the path of the nginx binary which validates and reloads the configuration, defaults to nginx
+	NginxBinary string
+	// HistoryDir is the directory which the reload history is persisted in, it is kept in memory only when empty
+	HistoryDir string
+	// HistorySize is the number of the applies which are kept in the reload history
+	HistorySize int
+	// AuditLogFile is the file which each apply record is appended to as a JSON line, disabled when empty
+	AuditLogFile string
+	// EnableGatewayAPI enables watching GatewayClass, Gateway, HTTPRoute and TCPRoute resources
+	EnableGatewayAPI bool
+	// GatewayControllerName is the controllerName of the GatewayClasses which are managed by nginx-conf-generator
+	GatewayControllerName string
+	// EnableLeaderElection enables leader election, only the leader writes Events, statuses and annotations
+	EnableLeaderElection bool
+	// LeaderElectionCluster is the name of the cluster which the Lease is created in, defaults to the first cluster
+	LeaderElectionCluster string
+	// LeaderElectionNamespace is the namespace of the Lease
+	LeaderElectionNamespace string
+	// LeaderElectionLeaseName is the name of the Lease
+	LeaderElectionLeaseName string
+	// LeaderElectionIdentity is the identity of the replica in leader election, defaults to the hostname
+	LeaderElectionIdentity string
+	// LeaderElectionLeaseDuration is the duration that non-leader replicas wait before taking over the leadership
+	LeaderElectionLeaseDuration time.Duration
+	// LeaderElectionRenewDeadline is the duration that the leader retries renewing the leadership before giving up
+	LeaderElectionRenewDeadline time.Duration
+	// LeaderElectionRetryPeriod is the duration between leader election attempts
+	LeaderElectionRetryPeriod time.Duration
+	// LeaderOnlyRender makes only the leader render the configuration and reload Nginx, for shared filesystem setups
+	LeaderOnlyRender bool
+	// ClusterHealthCheckInterval is the interval which the API servers of the synced clusters are probed in
+	ClusterHealthCheckInterval time.Duration
+	// DisconnectedClusterTimeout is the duration which an unreachable cluster stays degraded before it is disconnected
+	DisconnectedClusterTimeout time.Duration
+	// DropDisconnectedUpstreams removes the upstreams of disconnected clusters from the configuration, their last
+	// known upstreams are kept otherwise
+	DropDisconnectedUpstreams bool
+	// StuckRenderTimeout is the duration which an apply of the configuration may take before the liveness check fails
+	StuckRenderTimeout time.Duration
+	// TracingEndpoint is the OTLP/HTTP endpoint URL which the traces are exported to, tracing is disabled when empty
+	TracingEndpoint string
+	// TracingSampleRatio is the ratio of the traces which are sampled
+	TracingSampleRatio float64
+	// ShutdownTimeout is the deadline of the graceful shutdown after SIGINT or SIGTERM is received
+	ShutdownTimeout time.Duration
+	// EnablePprof serves the profiles of net/http/pprof and the runtime state on the metrics server
+	EnablePprof bool
+	// EnableLogLevelChanges allows the log levels to be changed on the metrics server, they are only read otherwise
+	EnableLogLevelChanges bool
+	// MetricsPort is the port of the metric server to expose prometheus metrics
+	MetricsPort int
+	// MetricsBindAddress is the address which the metrics server is bound to, all interfaces when empty
+	MetricsBindAddress string
+	// MetricsTLSCertFile and MetricsTLSKeyFile are the certificate and the key of the metrics server, which are
+	// loaded again when they are changed. The metrics server is served in plaintext when they are empty
+	MetricsTLSCertFile string
+	MetricsTLSKeyFile  string
+	// MetricsTLSClientCAFile is the CA bundle which the client certificates are verified with, a verified client
+	// certificate authenticates the requests
+	MetricsTLSClientCAFile string
+	// MetricsAuthTokenFile is the file of the bearer token which authenticates the requests
+	MetricsAuthTokenFile string
+	// MetricsBasicAuthFile is the file of the username:password lines which authenticate the requests, the passwords
+	// may be bcrypt hashes of htpasswd
+	MetricsBasicAuthFile string
+	// MetricsPublicPaths is the comma separated list of the paths which do not require authentication
+	MetricsPublicPaths string
+	// MetricsEndpoint is the endpoint to consume prometheus metrics
+	MetricsEndpoint string
+	// BannerFilePath is the relative path to the banner file
+	BannerFilePath string
+	// VerboseLog is the verbosity of the logging library, it sets the root level to debug
+	VerboseLog bool
+	// LogFormat is the format of the logs, either json, console or logfmt
+	LogFormat string
+	// LogLevel is the root level and the levels of the components, e.g. info,informers.service=debug
+	LogLevel string
+	// LogFile is the file which the logs are written to, they are written to stdout when empty
+	LogFile string
+	// LogFileMaxSize is the size in megabytes which LogFile is rotated at
+	LogFileMaxSize int
+	// LogFileMaxBackups is the number of the rotated log files which are kept, all are kept when 0
+	LogFileMaxBackups int
+	// LogFileMaxAge is the number of days which the rotated log files are kept for, they are not removed by their age
+	// when 0
+	LogFileMaxAge int
+	// LogSamplingInitial is the number of the debug logs with the same message which are written in each second
+	// before they are sampled, sampling is disabled when 0
+	LogSamplingInitial int
+	// LogSamplingThereafter is the interval of the debug logs which are written after LogSamplingInitial in a second
+	LogSamplingThereafter int
+}
+
+// ClusterConfig contains the options of a single cluster, it is the layout of the clusters in --cluster-config-file
+type ClusterConfig struct {
+	// Name is the unique name of the cluster, defaults to Context, the file name of KubeConfigPath, "in-cluster" or
+	// the host of Server
+	Name string `json:"name,omitempty"`
+	// KubeConfigPath is the kubeconfig file path to access with the cluster, exec and auth provider plugins of it
+	// are supported
+	KubeConfigPath string `json:"kubeConfigPath,omitempty"`
+	// Context is the context to use in KubeConfigPath, defaults to the current context
+	Context string `json:"context,omitempty"`
+	// InCluster makes the cluster accessed with the service account of the pod which nginx-conf-generator runs in
+	InCluster bool `json:"inCluster,omitempty"`
+	// Server is the URL of the API server, which overrides the server in KubeConfigPath if both are set
+	Server string `json:"server,omitempty"`
+	// CertificateAuthority is the path of the CA certificate file of the API server
+	CertificateAuthority string `json:"certificateAuthority,omitempty"`
+	// ClientCertificate is the path of the client certificate file for TLS authentication
+	ClientCertificate string `json:"clientCertificate,omitempty"`
+	// ClientKey is the path of the client key file for TLS authentication
+	ClientKey string `json:"clientKey,omitempty"`
+	// TokenFile is the path of the bearer token file, which is read periodically so rotated tokens are picked up
+	TokenFile string `json:"tokenFile,omitempty"`
+	// NodeAddressTypes is the preferred order of node address types which workers are built from
+	NodeAddressTypes []string `json:"nodeAddressTypes,omitempty"`
+	// IPFamily is the preferred IP family of worker addresses, either IPv4, IPv6 or empty for no preference
+	IPFamily string `json:"ipFamily,omitempty"`
+	// WorkerNodeSelector is the label selector to specify worker nodes, which is applied on the API server side
+	WorkerNodeSelector string `json:"workerNodeSelector,omitempty"`
+	// IncludeNamespaces is the list of namespaces which services are discovered from, they are watched through
+	// namespaced informers. All namespaces are allowed when it is empty
+	IncludeNamespaces []string `json:"includeNamespaces,omitempty"`
+	// ExcludeNamespaces is the list of namespaces which services are never discovered from
+	ExcludeNamespaces []string `json:"excludeNamespaces,omitempty"`
+	// NamespaceSelector is the label selector of the namespaces which services are discovered from
+	NamespaceSelector string `json:"namespaceSelector,omitempty"`
+	// Optional makes the readiness not depend on the cluster, clusters are required by default
+	Optional bool `json:"optional,omitempty"`
+}
+
+// options maps config to the options of the internal packages
+func (config *Config) options() *options.NginxConfGeneratorOptions {
+	return &options.NginxConfGeneratorOptions{
+		KubeConfigPaths:             config.KubeConfigPaths,
+		InCluster:                   config.InCluster,
+		ClusterConfigFile:           config.ClusterConfigFile,
+		NodeAddressTypes:            config.NodeAddressTypes,
+		IPFamily:                    config.IPFamily,
+		WorkerNodeLabel:             config.WorkerNodeLabel,
+		WorkerNodeSelector:          config.WorkerNodeSelector,
+		ExcludeUnschedulableNodes:   config.ExcludeUnschedulableNodes,
+		ExcludeNodeTaints:           config.ExcludeNodeTaints,
+		ExcludeNodeConditions:       config.ExcludeNodeConditions,
+		NodeDrainGracePeriod:        config.NodeDrainGracePeriod,
+		IncludeNamespaces:           config.IncludeNamespaces,
+		ExcludeNamespaces:           config.ExcludeNamespaces,
+		NamespaceSelector:           config.NamespaceSelector,
+		CustomAnnotation:            config.CustomAnnotation,
+		ListenPortAnnotation:        config.ListenPortAnnotation,
+		ReservedListenPorts:         config.ReservedListenPorts,
+		EnableStatusAnnotations:     config.EnableStatusAnnotations,
+		TemplateInputFile:           config.TemplateInputFile,
+		TemplateOutputFile:          config.TemplateOutputFile,
+		StreamTemplateOutputFile:    config.StreamTemplateOutputFile,
+		NginxBinary:                 config.NginxBinary,
+		HistoryDir:                  config.HistoryDir,
+		HistorySize:                 config.HistorySize,
+		AuditLogFile:                config.AuditLogFile,
+		EnableGatewayAPI:            config.EnableGatewayAPI,
+		GatewayControllerName:       config.GatewayControllerName,
+		EnableLeaderElection:        config.EnableLeaderElection,
+		LeaderElectionCluster:       config.LeaderElectionCluster,
+		LeaderElectionNamespace:     config.LeaderElectionNamespace,
+		LeaderElectionLeaseName:     config.LeaderElectionLeaseName,
+		LeaderElectionIdentity:      config.LeaderElectionIdentity,
+		LeaderElectionLeaseDuration: config.LeaderElectionLeaseDuration,
+		LeaderElectionRenewDeadline: config.LeaderElectionRenewDeadline,
+		LeaderElectionRetryPeriod:   config.LeaderElectionRetryPeriod,
+		LeaderOnlyRender:            config.LeaderOnlyRender,
+		ClusterHealthCheckInterval:  config.ClusterHealthCheckInterval,
+		DisconnectedClusterTimeout:  config.DisconnectedClusterTimeout,
+		DropDisconnectedUpstreams:   config.DropDisconnectedUpstreams,
+		StuckRenderTimeout:          config.StuckRenderTimeout,
+		TracingEndpoint:             config.TracingEndpoint,
+		TracingSampleRatio:          config.TracingSampleRatio,
+		ShutdownTimeout:             config.ShutdownTimeout,
+		EnablePprof:                 config.EnablePprof,
+		EnableLogLevelChanges:       config.EnableLogLevelChanges,
+		MetricsPort:                 config.MetricsPort,
+		MetricsBindAddress:          config.MetricsBindAddress,
+		MetricsTLSCertFile:          config.MetricsTLSCertFile,
+		MetricsTLSKeyFile:           config.MetricsTLSKeyFile,
+		MetricsTLSClientCAFile:      config.MetricsTLSClientCAFile,
+		MetricsAuthTokenFile:        config.MetricsAuthTokenFile,
+		MetricsBasicAuthFile:        config.MetricsBasicAuthFile,
+		MetricsPublicPaths:          config.MetricsPublicPaths,
+		MetricsEndpoint:             config.MetricsEndpoint,
+		BannerFilePath:              config.BannerFilePath,
+		VerboseLog:                  config.VerboseLog,
+		LogFormat:                   config.LogFormat,
+		LogLevel:                    config.LogLevel,
+		LogFile:                     config.LogFile,
+		LogFileMaxSize:              config.LogFileMaxSize,
+		LogFileMaxBackups:           config.LogFileMaxBackups,
+		LogFileMaxAge:               config.LogFileMaxAge,
+		LogSamplingInitial:          config.LogSamplingInitial,
+		LogSamplingThereafter:       config.LogSamplingThereafter,
+	}
+}
+
+// options maps cluster to the options of the internal packages
+func (cluster *ClusterConfig) options() *options.ClusterOptions {
+	return &options.ClusterOptions{
+		Name:                 cluster.Name,
+		KubeConfigPath:       cluster.KubeConfigPath,
+		Context:              cluster.Context,
+		InCluster:            cluster.InCluster,
+		Server:               cluster.Server,
+		CertificateAuthority: cluster.CertificateAuthority,
+		ClientCertificate:    cluster.ClientCertificate,
+		ClientKey:            cluster.ClientKey,
+		TokenFile:            cluster.TokenFile,
+		NodeAddressTypes:     cluster.NodeAddressTypes,
+		IPFamily:             cluster.IPFamily,
+		WorkerNodeSelector:   cluster.WorkerNodeSelector,
+		IncludeNamespaces:    cluster.IncludeNamespaces,
+		ExcludeNamespaces:    cluster.ExcludeNamespaces,
+		NamespaceSelector:    cluster.NamespaceSelector,
+		Optional:             cluster.Optional,
+	}
+}
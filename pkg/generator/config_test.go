@@ -0,0 +1,69 @@
+package generator
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fill sets each field of the struct which value points to a distinct, non zero value
+func fill(t *testing.T, value interface{}) {
+	t.Helper()
+
+	fields := reflect.ValueOf(value).Elem()
+	for i := 0; i < fields.NumField(); i++ {
+		field := fields.Field(i)
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(fields.Type().Field(i).Name)
+		case reflect.Bool:
+			field.SetBool(true)
+		case reflect.Int, reflect.Int64:
+			field.SetInt(int64(i + 1))
+		case reflect.Float64:
+			field.SetFloat(float64(i+1) / 100)
+		case reflect.Slice:
+			field.Set(reflect.ValueOf([]string{fields.Type().Field(i).Name}))
+		default:
+			t.Fatalf("field %s of kind %s is not filled", fields.Type().Field(i).Name, field.Kind())
+		}
+	}
+}
+
+// assertMapped asserts that mapped has the same fields as value with the same values
+func assertMapped(t *testing.T, value, mapped interface{}) {
+	t.Helper()
+
+	fields := reflect.ValueOf(value).Elem()
+	mappedFields := reflect.ValueOf(mapped).Elem()
+	assert.Equal(t, fields.NumField(), mappedFields.NumField())
+	for i := 0; i < mappedFields.NumField(); i++ {
+		name := mappedFields.Type().Field(i).Name
+		field := fields.FieldByName(name)
+		if assert.True(t, field.IsValid(), "field %s is not in %s", name, fields.Type()) {
+			assert.Equal(t, field.Interface(), mappedFields.Field(i).Interface(), "field %s is not mapped", name)
+		}
+	}
+}
+
+func TestConfigOptions(t *testing.T) {
+	config := &Config{}
+	fill(t, config)
+	assertMapped(t, config, config.options())
+}
+
+func TestClusterConfigOptions(t *testing.T) {
+	cluster := &ClusterConfig{}
+	fill(t, cluster)
+	assertMapped(t, cluster, cluster.options())
+}
+
+func TestNewLogger(t *testing.T) {
+	logger, err := NewLogger(&Config{LogFormat: "logfmt", LogLevel: "info,informers.service=debug"})
+	assert.Nil(t, err)
+	assert.NotNil(t, logger)
+
+	_, err = NewLogger(&Config{LogFormat: "xml"})
+	assert.NotNil(t, err)
+}
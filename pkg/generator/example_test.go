@@ -0,0 +1,51 @@
+package generator_test
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bilalcaliskan/nginx-conf-generator/pkg/generator"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func ExampleBuildSnapshot() {
+	// a fake clientset stands for the clientset of a real cluster, e.g. in a test or a CI pipeline
+	clientSet := fake.NewSimpleClientset(
+		&v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node01", Labels: map[string]string{"worker": "true"}},
+			Status: v1.NodeStatus{
+				Addresses:  []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "10.0.0.81"}},
+				Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}},
+			},
+		},
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default",
+				Annotations: map[string]string{"nginx-conf-generator/enabled": "true"}},
+			Spec: v1.ServiceSpec{Type: v1.ServiceTypeNodePort, Ports: []v1.ServicePort{{NodePort: 30800}}},
+		},
+	)
+
+	config := &generator.Config{CustomAnnotation: "nginx-conf-generator/enabled"}
+	snapshot, err := generator.BuildSnapshot(context.Background(), config, generator.Source{
+		Cluster:   &generator.ClusterConfig{Name: "prod", WorkerNodeSelector: "worker=true"},
+		MasterIP:  "10.0.0.80",
+		ClientSet: clientSet,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	for _, cluster := range snapshot.Clusters {
+		for _, nodePort := range cluster.NodePorts {
+			for _, worker := range nodePort.Workers {
+				fmt.Printf("%s/%s %s -> %s\n", nodePort.Namespace, nodePort.Name, nodePort.UpstreamName(),
+					worker.HostPort(nodePort.Port))
+			}
+		}
+	}
+	// Output:
+	// default/app 10.0.0.80_30800 -> 10.0.0.81:30800
+}
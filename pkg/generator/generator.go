@@ -0,0 +1,386 @@
+// Package generator runs nginx-conf-generator as a library, so that it can be embedded into other binaries. Each
+// Generator has its own state, metrics and health registry, multiple of them can run in the same process
+package generator
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/api"
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/dashboard"
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/diagnostics"
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/health"
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/k8s/informers"
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/leader"
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/logging"
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/metrics"
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/options"
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/tracing"
+	"github.com/bilalcaliskan/nginx-conf-generator/pkg/model"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ClusterStatus is the health status of a cluster
+type ClusterStatus = health.ClusterStatus
+
+// ClusterState is the health state of a cluster
+type ClusterState = health.State
+
+const (
+	// ClusterSyncing means the cluster is being connected and its informer caches are being synced
+	ClusterSyncing = health.StateSyncing
+	// ClusterSynced means the informer caches of the cluster are synced and its API server is reachable
+	ClusterSynced = health.StateSynced
+	// ClusterDegraded means the API server of a synced cluster is not reachable, its last known state is rendered
+	ClusterDegraded = health.StateDegraded
+	// ClusterDisconnected means the cluster can not be connected or has been degraded for too long
+	ClusterDisconnected = health.StateDisconnected
+)
+
+// State is the state of a Generator
+type State struct {
+	// Leader is true if the replica is the leader, every replica is the leader when leader election is disabled
+	Leader bool `json:"leader"`
+	// Clusters are the health statuses of the clusters, sorted by name
+	Clusters []ClusterStatus `json:"clusters"`
+}
+
+// Generator watches the clusters in its Config and renders them into the Nginx configuration
+type Generator struct {
+	config                *options.NginxConfGeneratorOptions
+	logger                *zap.Logger
+	clusterOptions        []*options.ClusterOptions
+	leaderElectionCluster string
+	health                *health.Registry
+	elector               *leader.Elector
+	manager               *informers.Manager
+	// tracerProvider is created from the tracing options of config, it is flushed and shut down by Stop
+	tracerProvider *sdktrace.TracerProvider
+	server         *http.Server
+	listener       net.Listener
+	// cancel stops the clusters, the leader election and the metrics server
+	cancel          context.CancelFunc
+	runningClusters sync.WaitGroup
+	serverErr       chan error
+	mu              sync.Mutex
+	// failed is closed with err when the Generator can not continue, e.g. the configuration can not be applied
+	failed   chan struct{}
+	err      error
+	failOnce sync.Once
+}
+
+// NewLogger creates a logger with the logging options of config, e.g. LogFormat, LogLevel and LogFile
+func NewLogger(config *Config) (*zap.Logger, error) {
+	return logging.Build(config.options())
+}
+
+// New validates config and creates a Generator. Metrics are registered on registerer, which are served on the
+// metrics server if registerer is also a prometheus.Gatherer. A nil logger discards the logs and a nil registerer
+// is replaced with a new prometheus.Registry. The log levels are served on the metrics server if logger is created by
+// NewLogger
+func New(config *Config, logger *zap.Logger, registerer prometheus.Registerer) (*Generator, error) {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	if registerer == nil {
+		registerer = prometheus.NewRegistry()
+	}
+
+	opts := config.options()
+	clusterOptions, err := opts.GetClusterOptions()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get cluster options")
+	}
+
+	if _, err := options.ParsePorts(opts.ReservedListenPorts); err != nil {
+		return nil, errors.Wrap(err, "unable to parse reserved listen ports")
+	}
+
+	if opts.TracingSampleRatio < 0 || opts.TracingSampleRatio > 1 {
+		return nil, fmt.Errorf("tracing sample ratio %v is not between 0 and 1", opts.TracingSampleRatio)
+	}
+
+	leaderElectionCluster := ""
+	if opts.EnableLeaderElection {
+		leaderElectionCluster = clusterOptions[0].Name
+		if opts.LeaderElectionCluster != "" {
+			leaderElectionCluster = opts.LeaderElectionCluster
+		}
+
+		found := false
+		for _, clusterOpts := range clusterOptions {
+			found = found || clusterOpts.Name == leaderElectionCluster
+		}
+
+		if !found {
+			return nil, fmt.Errorf("leader election cluster %s is not found", leaderElectionCluster)
+		}
+	}
+
+	healthRegistry := health.NewRegistry()
+	m, err := metrics.New(registerer, healthRegistry)
+	if err != nil {
+		return nil, err
+	}
+
+	gatherer, ok := registerer.(prometheus.Gatherer)
+	if !ok {
+		gatherer = prometheus.NewRegistry()
+	}
+
+	elector := leader.NewElector(m, opts.EnableLeaderElection)
+	g := &Generator{
+		config:                opts,
+		logger:                logger,
+		clusterOptions:        clusterOptions,
+		leaderElectionCluster: leaderElectionCluster,
+		health:                healthRegistry,
+		elector:               elector,
+		manager:               informers.NewManager(opts, logger, m, healthRegistry, elector),
+		failed:                make(chan struct{}),
+	}
+	if err := g.manager.OpenHistory(); err != nil {
+		return nil, errors.Wrap(err, "unable to open reload history")
+	}
+
+	g.server, err = metrics.NewServer(opts, gatherer, healthRegistry, g.liveness, g.readiness,
+		func(router *mux.Router) {
+			api.Register(router, g.manager, healthRegistry)
+			dashboard.Register(router)
+			if levels := logging.LevelsOf(logger); levels != nil {
+				logging.Register(router, levels, opts.EnableLogLevelChanges)
+			}
+			if opts.EnablePprof {
+				diagnostics.Register(router, g.manager)
+			}
+		})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create metrics server")
+	}
+
+	if g.tracerProvider, err = tracing.New(opts); err != nil {
+		return nil, err
+	}
+	if g.tracerProvider != nil {
+		g.manager.SetTracerProvider(g.tracerProvider)
+	}
+
+	return g, nil
+}
+
+// Start binds the metrics server and starts the clusters in the background, it returns right after that. The
+// Generator runs until ctx is done or Stop is called
+func (g *Generator) Start(ctx context.Context) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.cancel != nil {
+		return errors.New("generator is already started")
+	}
+
+	listener, err := net.Listen("tcp", g.server.Addr)
+	if err != nil {
+		return errors.Wrap(err, "unable to spin up metrics server")
+	}
+	g.listener = listener
+
+	ctx, g.cancel = context.WithCancel(ctx)
+	if g.leaderElectionCluster != "" {
+		g.elector.OnStartedLeading(func() {
+			if err := g.manager.Reconcile(); err != nil {
+				g.logger.Error("an error occurred while applying changes on leadership",
+					zap.String("error", err.Error()))
+				g.fail(errors.Wrap(err, "unable to apply changes on leadership"))
+			}
+		})
+	}
+
+	// the errors of the manager are logged by itself
+	go func() {
+		select {
+		case <-g.manager.Failed():
+			g.fail(g.manager.Err())
+		case <-ctx.Done():
+		}
+	}()
+
+	// clusters are started independently, so that an unreachable cluster does not block the others
+	for _, clusterOpts := range g.clusterOptions {
+		var onConnected func(clientSet kubernetes.Interface)
+		if clusterOpts.Name == g.leaderElectionCluster {
+			onConnected = func(clientSet kubernetes.Interface) {
+				g.runLeaderElection(ctx, clientSet)
+			}
+		}
+
+		g.runningClusters.Add(1)
+		go func(clusterOpts *options.ClusterOptions) {
+			defer g.runningClusters.Done()
+			g.manager.RunCluster(ctx, clusterOpts, onConnected)
+		}(clusterOpts)
+	}
+
+	g.serverErr = make(chan error, 1)
+	go func() {
+		var err error
+		if g.server.TLSConfig != nil {
+			// the certificate is served by the TLS configuration, so that it is loaded again when it is changed
+			err = g.server.ServeTLS(listener, "", "")
+		} else {
+			err = g.server.Serve(listener)
+		}
+
+		if err != nil && err != http.ErrServerClosed {
+			g.logger.Error("an error occurred while serving metrics", zap.String("error", err.Error()))
+			g.serverErr <- err
+		}
+		close(g.serverErr)
+	}()
+
+	g.logger.Info("metrics server is up and running", zap.String("address", listener.Addr().String()),
+		zap.Bool("tls", g.server.TLSConfig != nil))
+	return nil
+}
+
+// runLeaderElection starts leader election through the clientSet of the leader election cluster, the Generator is
+// failed if it can not be started
+func (g *Generator) runLeaderElection(ctx context.Context, clientSet kubernetes.Interface) {
+	if err := g.elector.Run(ctx, clientSet, g.config, g.logger); err != nil {
+		g.logger.Error("an error occurred while starting leader election", zap.String("error", err.Error()))
+		g.fail(errors.Wrap(err, "unable to start leader election"))
+	}
+}
+
+// Stop stops the Generator and waits for the running components until ctx is done. No configuration is rendered
+// after Stop returns. An error is returned if the shutdown is not clean
+func (g *Generator) Stop(ctx context.Context) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.cancel == nil {
+		return errors.New("generator is not started")
+	}
+	g.cancel()
+
+	clustersDone := make(chan struct{})
+	go func() {
+		g.runningClusters.Wait()
+		close(clustersDone)
+	}()
+
+	var errs []string
+	select {
+	case <-clustersDone:
+	case <-ctx.Done():
+		errs = append(errs, "clusters are not stopped")
+	}
+
+	if err := g.manager.Shutdown(ctx); err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	if err := g.elector.Wait(ctx); err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	if err := g.server.Shutdown(ctx); err != nil {
+		errs = append(errs, errors.Wrap(err, "metrics server is not shut down").Error())
+	} else if err := <-g.serverErr; err != nil {
+		errs = append(errs, errors.Wrap(err, "metrics server is failed").Error())
+	}
+
+	// the spans of the last applies are flushed to the exporter
+	if g.tracerProvider != nil {
+		if err := g.tracerProvider.Shutdown(ctx); err != nil {
+			errs = append(errs, errors.Wrap(err, "tracer provider is not shut down").Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("shutdown is not clean: %s", strings.Join(errs, ", "))
+	}
+
+	return nil
+}
+
+// Failed returns a channel which is closed when the Generator can not continue, e.g. the configuration can not be
+// applied or the leader election can not be started. The Generator must be stopped then, Err returns the reason
+func (g *Generator) Failed() <-chan struct{} {
+	return g.failed
+}
+
+// Err returns the error which the Generator is failed with, it is nil until Failed is closed
+func (g *Generator) Err() error {
+	select {
+	case <-g.failed:
+		return g.err
+	default:
+		return nil
+	}
+}
+
+// fail closes Failed with err, the errors after the first one are ignored
+func (g *Generator) fail(err error) {
+	g.failOnce.Do(func() {
+		g.err = err
+		close(g.failed)
+	})
+}
+
+// SetTracerProvider makes the Generator create its spans with provider instead of the one which is created from the
+// tracing options of its Config, e.g. to share the provider of the embedding binary. It must be called before Start,
+// provider is not shut down by Stop
+func (g *Generator) SetTracerProvider(provider trace.TracerProvider) {
+	g.manager.SetTracerProvider(provider)
+}
+
+// State returns the leadership and the health statuses of the clusters
+func (g *Generator) State() State {
+	return State{Leader: g.elector.IsLeader(), Clusters: g.health.List()}
+}
+
+// Snapshot returns the current state of the connected clusters, which is the data the template is rendered with
+func (g *Generator) Snapshot() *model.Snapshot {
+	return g.manager.Snapshot()
+}
+
+// Source is a cluster which a Snapshot is built from with BuildSnapshot
+type Source struct {
+	// Cluster contains the options of the cluster, e.g. its name, worker node selector and namespace filters
+	Cluster *ClusterConfig
+	// MasterIP is the host of the API server of the cluster, which distinguishes the clusters from each other
+	MasterIP  string
+	ClientSet kubernetes.Interface
+}
+
+// BuildSnapshot lists the nodes and services of sources once and builds a Snapshot with the same rules as a running
+// Generator, e.g. node eligibility, namespace filters and listen port claims. Listen ports are claimed in the order
+// of the sources and the creation of the services. Gateway API resources are not included. Workers are addressed by
+// their InternalIP, ExternalIP or Hostname in order when NodeAddressTypes of a cluster is empty
+func BuildSnapshot(ctx context.Context, config *Config, sources ...Source) (*model.Snapshot, error) {
+	clusterSources := make([]informers.ClusterSource, 0, len(sources))
+	for _, source := range sources {
+		clusterOpts := source.Cluster.options()
+		if len(clusterOpts.NodeAddressTypes) == 0 {
+			clusterOpts.NodeAddressTypes = options.DefaultNodeAddressTypes
+		}
+
+		clusterSources = append(clusterSources, informers.ClusterSource{Options: clusterOpts,
+			MasterIP: source.MasterIP, ClientSet: source.ClientSet})
+	}
+
+	return informers.BuildSnapshot(ctx, config.options(), clusterSources)
+}
@@ -0,0 +1,221 @@
+package generator
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/logging"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func getConfig(t *testing.T) *Config {
+	return &Config{
+		KubeConfigPaths:            "../../test/broken_kubeconfig",
+		NodeAddressTypes:           "InternalIP",
+		WorkerNodeLabel:            "worker",
+		CustomAnnotation:           "nginx-conf-generator/enabled",
+		ListenPortAnnotation:       "nginx-conf-generator/listen-port",
+		TemplateInputFile:          "../../resources/ncg.conf.tmpl",
+		TemplateOutputFile:         filepath.Join(t.TempDir(), "ncg.conf"),
+		ClusterHealthCheckInterval: time.Second,
+		DisconnectedClusterTimeout: time.Minute,
+		MetricsEndpoint:            "/metrics",
+	}
+}
+
+func TestGenerator(t *testing.T) {
+	// two generators run side by side in the same process, each of them with its own registry
+	// the log levels are served only for the loggers which are created by the logging package, the diagnostics
+	// only with EnablePprof
+	var generators []*Generator
+	for _, logger := range []*zap.Logger{nil, logging.New(zap.NewAtomicLevelAt(zap.WarnLevel))} {
+		config := getConfig(t)
+		config.EnablePprof = logger != nil
+		generator, err := New(config, logger, prometheus.NewRegistry())
+		assert.Nil(t, err)
+		assert.Nil(t, generator.Start(context.Background()))
+		generators = append(generators, generator)
+	}
+
+	for _, generator := range generators {
+		assert.Eventually(t, func() bool {
+			state := generator.State()
+			return len(state.Clusters) == 1 && state.Clusters[0].State == ClusterDisconnected
+		}, 5*time.Second, 10*time.Millisecond)
+		assert.True(t, generator.State().Leader)
+		assert.NotNil(t, generator.Start(context.Background()))
+	}
+
+	// the metrics server is bound on a random port with the port 0
+	addr := generators[0].listener.Addr().String()
+	resp, err := http.Get(fmt.Sprintf("http://%s/metrics", addr))
+	assert.Nil(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.Contains(t, string(body), `nginx_conf_generator_cluster_state{cluster=`)
+
+	// the state API is served on the same server
+	resp, err = http.Get(fmt.Sprintf("http://%s/api/v1/clusters", addr))
+	assert.Nil(t, err)
+	body, _ = io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.Contains(t, string(body), `"name":"broken_kubeconfig"`)
+
+	resp, err = http.Get(fmt.Sprintf("http://%s/log/level", addr))
+	assert.Nil(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp, err = http.Get(fmt.Sprintf("http://%s/log/level", generators[1].listener.Addr().String()))
+	assert.Nil(t, err)
+	body, _ = io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.Equal(t, "{\"level\":\"warn\"}\n", string(body))
+
+	// the log levels are read-only without EnableLogLevelChanges
+	req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("http://%s/log/level",
+		generators[1].listener.Addr().String()), strings.NewReader(`{"level":"debug"}`))
+	assert.Nil(t, err)
+	resp, err = http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+	resp, err = http.Get(fmt.Sprintf("http://%s/debug/runtime", addr))
+	assert.Nil(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp, err = http.Get(fmt.Sprintf("http://%s/debug/runtime", generators[1].listener.Addr().String()))
+	assert.Nil(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	for _, generator := range generators {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		assert.Nil(t, generator.Stop(ctx))
+		cancel()
+	}
+
+	_, err = http.Get(fmt.Sprintf("http://%s/metrics", addr))
+	assert.NotNil(t, err)
+}
+
+func TestNewInvalid(t *testing.T) {
+	config := getConfig(t)
+	config.ReservedListenPorts = "http"
+	_, err := New(config, nil, nil)
+	assert.NotNil(t, err)
+
+	config = getConfig(t)
+	config.TracingSampleRatio = 2
+	_, err = New(config, nil, nil)
+	assert.NotNil(t, err)
+
+	config = getConfig(t)
+	config.TracingEndpoint = "localhost:4318"
+	_, err = New(config, nil, nil)
+	assert.NotNil(t, err)
+
+	config = getConfig(t)
+	config.MetricsTLSCertFile = "missing.crt"
+	config.MetricsTLSKeyFile = "missing.key"
+	_, err = New(config, nil, nil)
+	assert.NotNil(t, err)
+
+	config = getConfig(t)
+	config.EnableLeaderElection = true
+	config.LeaderElectionCluster = "missing"
+	_, err = New(config, nil, nil)
+	assert.NotNil(t, err)
+
+	// metrics can not be registered twice on the same registry
+	registry := prometheus.NewRegistry()
+	_, err = New(getConfig(t), nil, registry)
+	assert.Nil(t, err)
+	_, err = New(getConfig(t), nil, registry)
+	assert.NotNil(t, err)
+}
+
+func TestLeaderElectionFailed(t *testing.T) {
+	// the lease duration must be longer than the renew deadline, so that leader election can not be started
+	config := getConfig(t)
+	config.EnableLeaderElection = true
+	generator, err := New(config, nil, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, generator.Start(context.Background()))
+	assert.Nil(t, generator.Err())
+
+	generator.runLeaderElection(context.Background(), fake.NewSimpleClientset())
+	select {
+	case <-generator.Failed():
+	case <-time.After(5 * time.Second):
+		t.Fatal("generator is not failed")
+	}
+	assert.NotNil(t, generator.Err())
+	assert.Contains(t, generator.Err().Error(), "unable to start leader election")
+	assert.False(t, generator.State().Leader)
+
+	// the generator keeps running until it is stopped
+	resp, err := http.Get(fmt.Sprintf("http://%s/metrics", generator.listener.Addr().String()))
+	assert.Nil(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.Nil(t, generator.Stop(ctx))
+}
+
+func TestStop(t *testing.T) {
+	generator, err := New(getConfig(t), nil, nil)
+	assert.Nil(t, err)
+	assert.NotNil(t, generator.Stop(context.Background()))
+}
+
+func TestBuildSnapshot(t *testing.T) {
+	clientSet := fake.NewSimpleClientset(
+		&v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node01", Labels: map[string]string{"worker": "true"}},
+			Status: v1.NodeStatus{
+				Addresses:  []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "10.0.0.81"}},
+				Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}},
+			},
+		},
+		&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default",
+				Annotations: map[string]string{"nginx-conf-generator/enabled": "true"}},
+			Spec: v1.ServiceSpec{Type: v1.ServiceTypeNodePort, Ports: []v1.ServicePort{{NodePort: 30800}}},
+		},
+	)
+
+	snapshot, err := BuildSnapshot(context.Background(), getConfig(t), Source{
+		Cluster:   &ClusterConfig{Name: "local", WorkerNodeSelector: "worker=true"},
+		MasterIP:  "10.0.0.80",
+		ClientSet: clientSet,
+	})
+	assert.Nil(t, err)
+	assert.Len(t, snapshot.Clusters, 1)
+	assert.Equal(t, "local", snapshot.Clusters[0].Name)
+	assert.Len(t, snapshot.Clusters[0].Workers, 1)
+	assert.Equal(t, "10.0.0.81:30800", snapshot.Clusters[0].Workers[0].HostPort(30800))
+	assert.Len(t, snapshot.Clusters[0].NodePorts, 1)
+	assert.Equal(t, "10.0.0.80_30800", snapshot.Clusters[0].NodePorts[0].UpstreamName())
+
+	_, err = BuildSnapshot(context.Background(), getConfig(t), Source{
+		Cluster:   &ClusterConfig{Name: "local", WorkerNodeSelector: "worker in ("},
+		MasterIP:  "10.0.0.80",
+		ClientSet: clientSet,
+	})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,73 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/health"
+)
+
+// liveness reports whether the Generator is alive, which fails when an apply of the configuration takes longer than
+// StuckRenderTimeout or the Generator is failed
+func (g *Generator) liveness() health.Report {
+	check := health.Check{Name: "render", OK: true}
+	status := g.manager.ApplyStatus()
+	if !status.RenderingSince.IsZero() {
+		elapsed := time.Since(status.RenderingSince)
+		check.Message = fmt.Sprintf("rendering for %s", elapsed.Round(time.Second))
+		check.OK = g.config.StuckRenderTimeout <= 0 || elapsed < g.config.StuckRenderTimeout
+	}
+
+	if err := g.Err(); err != nil {
+		check.OK, check.Message = false, err.Error()
+	}
+
+	return health.NewReport([]health.Check{check}, g.clusterChecks())
+}
+
+// readiness reports whether the Generator is ready, which requires the informer caches of the required clusters to
+// be synced and the configuration to be rendered and validated by Nginx once
+func (g *Generator) readiness() health.Report {
+	clusters := g.clusterChecks()
+	clustersCheck := health.Check{Name: "clusters", OK: true}
+	var notReady []string
+	for _, cluster := range clusters {
+		if cluster.Required && !cluster.Ready {
+			notReady = append(notReady, cluster.Name)
+		}
+	}
+
+	if len(notReady) > 0 {
+		clustersCheck.OK = false
+		clustersCheck.Message = fmt.Sprintf("informers of clusters %s are not synced", strings.Join(notReady, ", "))
+	}
+
+	renderCheck := health.Check{Name: "render", OK: true}
+	switch {
+	case g.config.LeaderOnlyRender && !g.elector.IsLeader():
+		renderCheck.Message = "configuration is rendered by the leader"
+	case !g.manager.ApplyStatus().Applied:
+		renderCheck.OK = false
+		renderCheck.Message = "configuration is not rendered and validated yet"
+	}
+
+	return health.NewReport([]health.Check{clustersCheck, renderCheck}, clusters)
+}
+
+// clusterChecks returns the readiness of each configured cluster, in the order of the cluster options
+func (g *Generator) clusterChecks() []health.ClusterCheck {
+	checks := make([]health.ClusterCheck, 0, len(g.clusterOptions))
+	for _, clusterOpts := range g.clusterOptions {
+		status, ok := g.health.Get(clusterOpts.Name)
+		if !ok {
+			// the cluster is not started yet
+			status = health.ClusterStatus{Name: clusterOpts.Name, State: health.StateSyncing}
+		}
+
+		checks = append(checks, health.ClusterCheck{ClusterStatus: status, Required: !clusterOpts.Optional,
+			Ready: status.State.Synced()})
+	}
+
+	return checks
+}
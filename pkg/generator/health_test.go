@@ -0,0 +1,55 @@
+package generator
+
+import (
+	"testing"
+
+	"github.com/bilalcaliskan/nginx-conf-generator/internal/health"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadiness(t *testing.T) {
+	g, err := New(getConfig(t), nil, prometheus.NewRegistry())
+	assert.Nil(t, err)
+
+	report := g.readiness()
+	assert.False(t, report.OK)
+	assert.Len(t, report.Clusters, 1)
+	assert.Equal(t, health.StateSyncing, report.Clusters[0].State)
+	assert.True(t, report.Clusters[0].Required)
+
+	g.health.Set("broken_kubeconfig", health.StateSynced, nil)
+	report = g.readiness()
+	assert.False(t, report.OK)
+	assert.True(t, report.Checks[0].OK)
+	assert.Equal(t, "configuration is not rendered and validated yet", report.Checks[1].Message)
+
+	assert.Nil(t, g.manager.Reconcile())
+	assert.True(t, g.readiness().OK)
+
+	// optional clusters are not required to be synced
+	g.health.Set("broken_kubeconfig", health.StateDisconnected, nil)
+	assert.False(t, g.readiness().OK)
+	g.clusterOptions[0].Optional = true
+	assert.True(t, g.readiness().OK)
+}
+
+func TestLiveness(t *testing.T) {
+	g, err := New(getConfig(t), nil, prometheus.NewRegistry())
+	assert.Nil(t, err)
+
+	report := g.liveness()
+	assert.True(t, report.OK)
+	assert.Equal(t, "render", report.Checks[0].Name)
+	assert.Len(t, report.Clusters, 1)
+
+	// a failed generator is not alive
+	assert.Nil(t, g.Err())
+	g.fail(errors.New("unable to apply changes"))
+	<-g.Failed()
+	report = g.liveness()
+	assert.False(t, report.OK)
+	assert.Equal(t, "unable to apply changes", report.Checks[0].Message)
+	assert.Equal(t, "unable to apply changes", g.Err().Error())
+}
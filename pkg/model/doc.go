@@ -0,0 +1,11 @@
+// Package model is the public state model of nginx-conf-generator. A Snapshot is the state of the managed clusters
+// at a point in time, which the Nginx configuration is rendered from with Render.
+//
+// # Compatibility
+//
+// The packages under pkg/ follow the semantic versioning of the module. Within a major version, exported identifiers
+// are neither removed nor changed in an incompatible way, new fields, methods and functions may be added. Fields are
+// only added with a zero value which keeps the previous behavior, so that Snapshots which are built by older code
+// render the same. Templates which are written against the Snapshot, Cluster, Worker and NodePort types keep working
+// across minor and patch releases. The packages under internal/ are not covered and may change at any time.
+package model
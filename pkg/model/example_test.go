@@ -0,0 +1,43 @@
+package model_test
+
+import (
+	"os"
+	"text/template"
+
+	"github.com/bilalcaliskan/nginx-conf-generator/pkg/model"
+)
+
+func ExampleRender() {
+	worker := &model.Worker{MasterIP: "10.0.0.1", HostIP: "10.0.0.44", Ready: true}
+	snapshot := &model.Snapshot{Clusters: []*model.Cluster{{
+		Name:      "prod",
+		MasterIP:  "10.0.0.1",
+		Workers:   []*model.Worker{worker},
+		NodePorts: []*model.NodePort{{MasterIP: "10.0.0.1", Port: 30444, ListenPort: 8080, Workers: []*model.Worker{worker}}},
+	}}}
+
+	// resources/ncg.conf.tmpl is the complete template, a template only has to define TemplateMain
+	tmpl := template.Must(template.New("").Parse(`{{define "main"}}
+{{- range .Clusters}}{{range .NodePorts}}{{$nodePort := .}}upstream {{.UpstreamName}} {
+{{- range .Workers}}
+    server {{.HostPort $nodePort.Port}};
+{{- end}}
+}
+server {
+    listen {{.Listen}};
+    proxy_pass {{.UpstreamName}};
+}
+{{end}}{{end}}{{end}}`))
+
+	if err := model.Render(os.Stdout, tmpl, model.TemplateMain, snapshot); err != nil {
+		panic(err)
+	}
+	// Output:
+	// upstream 10.0.0.1_30444 {
+	//     server 10.0.0.44:30444;
+	// }
+	// server {
+	//     listen 8080;
+	//     proxy_pass 10.0.0.1_30444;
+	// }
+}
@@ -0,0 +1,56 @@
+package model
+
+// GatewayConf is the nginx configuration generated from the Gateway API resources of a cluster
+type GatewayConf struct {
+	HTTPServers []*GatewayServer `json:"httpServers"`
+	TCPServers  []*GatewayServer `json:"tcpServers"`
+	Matches     []*GatewayMatch  `json:"matches"`
+	// Upstreams are rendered in the http context, StreamUpstreams are rendered in the stream context
+	Upstreams       []*GatewayUpstream `json:"upstreams"`
+	StreamUpstreams []*GatewayUpstream `json:"streamUpstreams"`
+}
+
+// GatewayServer is the logical representation of a nginx server block generated from a Gateway listener
+type GatewayServer struct {
+	Port       int32              `json:"port"`
+	ServerName string             `json:"serverName"`
+	Locations  []*GatewayLocation `json:"locations"`
+	// Upstream is only used by TCP servers, HTTP servers route through Locations
+	Upstream string `json:"upstream,omitempty"`
+}
+
+// GatewayLocation is the logical representation of a nginx location block generated from HTTPRoute matches
+type GatewayLocation struct {
+	// Path is the nginx location parameter including its modifier, e.g. "= /foo", "/foo/" or "~ ^/foo"
+	Path string `json:"path"`
+	// Matches are the conditional matches of the location, ordered by precedence
+	Matches []*GatewayMatch `json:"matches"`
+	// Upstream is the fallback upstream used when none of the Matches apply, empty means 404 unless InvalidBackends
+	Upstream string `json:"upstream"`
+	// InvalidBackends is true when the fallback rule has no valid backends, its requests are answered with 500
+	InvalidBackends bool `json:"invalidBackends,omitempty"`
+}
+
+// GatewayMatch is a header, query param and method match which is evaluated through a nginx map
+type GatewayMatch struct {
+	// Variable is the nginx variable which is set to 1 when the match applies
+	Variable string `json:"variable"`
+	// Source is the quoted nginx string which is compared against Pattern
+	Source string `json:"source"`
+	// Pattern is the quoted nginx regular expression
+	Pattern string `json:"pattern"`
+	// Upstream is empty when the match has no valid backends, its requests are answered with 500
+	Upstream string `json:"upstream"`
+}
+
+// GatewayUpstream is the logical representation of a nginx upstream block generated from backendRefs
+type GatewayUpstream struct {
+	Name     string            `json:"name"`
+	Backends []*GatewayBackend `json:"backends"`
+}
+
+// GatewayBackend is a weighted NodePort backend of a GatewayUpstream
+type GatewayBackend struct {
+	NodePort int32 `json:"nodePort"`
+	Weight   int32 `json:"weight"`
+}
@@ -0,0 +1,82 @@
+package model
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Snapshot is the state of the managed clusters at a point in time
+type Snapshot struct {
+	Clusters []*Cluster `json:"clusters"`
+}
+
+// Cluster is the state of a managed Kubernetes cluster
+type Cluster struct {
+	// Name is the name of the cluster in the cluster options
+	Name string `json:"name"`
+	// MasterIP is the host of the API server, which distinguishes the clusters from each other
+	MasterIP  string      `json:"masterIP"`
+	Workers   []*Worker   `json:"workers"`
+	NodePorts []*NodePort `json:"nodePorts"`
+	// Gateway is the configuration generated from Gateway API resources, nil when Gateway API support is disabled
+	Gateway *GatewayConf `json:"gateway,omitempty"`
+	// Dropped is true when the cluster is disconnected and its upstreams must not be rendered
+	Dropped bool `json:"dropped"`
+	// Skipped are the services which are annotated to be exposed but skipped, sorted by namespace and name
+	Skipped []*SkippedService `json:"skipped,omitempty"`
+}
+
+// SkippedService is a service which is annotated to be exposed but skipped, e.g. because its listen port is claimed
+// by another service
+type SkippedService struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Reason    string `json:"reason"`
+}
+
+// Worker is a node of a cluster which receives traffic from Nginx
+type Worker struct {
+	MasterIP string `json:"masterIP"`
+	// HostIP is the address of the node which Nginx connects to
+	HostIP string `json:"hostIP"`
+	// NodeName is the name of the node, empty if the worker is not built from a node
+	NodeName string `json:"nodeName,omitempty"`
+	// Ready is true when the Ready condition of the node is True
+	Ready bool `json:"ready"`
+	// Draining is true while the worker is rendered as down before it is removed from the upstreams
+	Draining bool `json:"draining"`
+}
+
+// HostPort returns the address of the worker for the given port, IPv6 addresses are enclosed in square brackets
+func (worker *Worker) HostPort(port int32) string {
+	return net.JoinHostPort(worker.HostIP, strconv.Itoa(int(port)))
+}
+
+// NodePort is a NodePort type service of a cluster which is exposed through Nginx
+type NodePort struct {
+	MasterIP string `json:"masterIP"`
+	// Namespace and Name are of the service which the nodePort belongs to, empty if it is not built from a service
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Port      int32  `json:"port"`
+	// ListenPort is the port which Nginx listens on, Port is used when it is 0
+	ListenPort int32     `json:"listenPort,omitempty"`
+	Workers    []*Worker `json:"workers"`
+}
+
+// UpstreamName returns the name of the nginx upstream of the nodePort, colons of IPv6 master addresses are replaced
+// since nginx would parse them as a port in proxy_pass
+func (nodePort *NodePort) UpstreamName() string {
+	return fmt.Sprintf("%s_%d", strings.ReplaceAll(nodePort.MasterIP, ":", "_"), nodePort.Port)
+}
+
+// Listen returns the port which Nginx listens on for the nodePort
+func (nodePort *NodePort) Listen() int32 {
+	if nodePort.ListenPort != 0 {
+		return nodePort.ListenPort
+	}
+
+	return nodePort.Port
+}
@@ -0,0 +1,41 @@
+package model
+
+import (
+	"bytes"
+	"testing"
+	"text/template"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkerHostPort(t *testing.T) {
+	assert.Equal(t, "10.0.0.44:30444", (&Worker{HostIP: "10.0.0.44"}).HostPort(30444))
+	assert.Equal(t, "[fd00::44]:30444", (&Worker{HostIP: "fd00::44"}).HostPort(30444))
+}
+
+func TestNodePort(t *testing.T) {
+	nodePort := &NodePort{MasterIP: "fd00::1", Port: 30444}
+	assert.Equal(t, "fd00__1_30444", nodePort.UpstreamName())
+	assert.Equal(t, int32(30444), nodePort.Listen())
+
+	nodePort.ListenPort = 8080
+	assert.Equal(t, int32(8080), nodePort.Listen())
+}
+
+func TestRender(t *testing.T) {
+	worker := &Worker{MasterIP: "10.0.0.1", HostIP: "10.0.0.44", Ready: true}
+	snapshot := &Snapshot{Clusters: []*Cluster{{
+		Name:      "local",
+		MasterIP:  "10.0.0.1",
+		Workers:   []*Worker{worker},
+		NodePorts: []*NodePort{{MasterIP: "10.0.0.1", Port: 30444, Workers: []*Worker{worker}}},
+	}}}
+
+	tmpl := template.Must(template.ParseFiles("../../resources/ncg.conf.tmpl"))
+	var buf bytes.Buffer
+	assert.Nil(t, Render(&buf, tmpl, TemplateMain, snapshot))
+	assert.Contains(t, buf.String(), "upstream 10.0.0.1_30444 {")
+	assert.Contains(t, buf.String(), "server 10.0.0.44:30444;")
+
+	assert.NotNil(t, Render(&buf, tmpl, "missing", snapshot))
+}
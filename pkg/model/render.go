@@ -0,0 +1,25 @@
+package model
+
+import (
+	"io"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// TemplateMain is the template which renders the http context of the Nginx configuration
+	TemplateMain = "main"
+	// TemplateStream is the template which renders the stream context of the Nginx configuration
+	TemplateStream = "stream"
+)
+
+// Render executes the template called name of tmpl with snapshot and writes the output to w. The template receives
+// the Snapshot as its data, e.g. resources/ncg.conf.tmpl defines both TemplateMain and TemplateStream
+func Render(w io.Writer, tmpl *template.Template, name string, snapshot *Snapshot) error {
+	if err := tmpl.ExecuteTemplate(w, name, snapshot); err != nil {
+		return errors.Wrapf(err, "unable to render template %s", name)
+	}
+
+	return nil
+}